@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// agendaTemplateAuditQuery reads the audited fields of an agenda template,
+// including its phases.
+const agendaTemplateAuditQuery = `
+    SELECT t.id, t.level, t.name, t.is_active,
+           (SELECT JSON_ARRAYAGG(JSON_OBJECT('order', p.phase_order, 'type', p.phase_type, 'label', p.label,
+                   'duration_seconds', p.duration_seconds, 'per_speaker_seconds', p.per_speaker_seconds))
+            FROM agenda_template_phases p WHERE p.template_id = t.id) AS phases
+    FROM agenda_templates t WHERE t.id = ?`
+
+type AgendaTemplate struct {
+	ID       string                `json:"id"`
+	Level    int                   `json:"level"`
+	Name     string                `json:"name"`
+	IsActive bool                  `json:"is_active"`
+	Phases   []student.AgendaPhase `json:"phases"`
+}
+
+func GetAgendaTemplates(w http.ResponseWriter, r *http.Request) {
+	query := "SELECT id, level, name, is_active FROM agenda_templates"
+	var args []interface{}
+	if level := r.URL.Query().Get("level"); level != "" {
+		query += " WHERE level = ?"
+		args = append(args, level)
+	}
+	query += " ORDER BY level, name"
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching agenda templates", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	templates := []AgendaTemplate{}
+	for rows.Next() {
+		var t AgendaTemplate
+		if err := rows.Scan(&t.ID, &t.Level, &t.Name, &t.IsActive); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning agenda template", "error", err)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	rows.Close()
+
+	for i := range templates {
+		phases, err := student.LoadAgendaTemplatePhases(database.GetDB(), templates[i].ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading phases for template", "template_id", templates[i].ID, "error", err)
+		}
+		templates[i].Phases = phases
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+func CreateAgendaTemplate(w http.ResponseWriter, r *http.Request) {
+	var t AgendaTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if err := validateAgendaTemplate(&t); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	adminID := r.Context().Value("userID").(string)
+	t.ID = uuid.New().String()
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO agenda_templates (id, level, name, is_active, created_by)
+        VALUES (?, ?, ?, ?, ?)`,
+		t.ID, t.Level, t.Name, t.IsActive, adminID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating agenda template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create template"})
+		return
+	}
+
+	if err := saveAgendaTemplatePhases(tx, &t); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving agenda template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create template"})
+		return
+	}
+
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: "agenda_template.create", EntityType: "agenda_template", EntityID: t.ID,
+		After: auditSnapshotIn(r, tx, agendaTemplateAuditQuery, t.ID),
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create template"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create template"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func UpdateAgendaTemplate(w http.ResponseWriter, r *http.Request) {
+	var t AgendaTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if t.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+	if err := validateAgendaTemplate(&t); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if inUse, err := agendaTemplateInUse(t.ID); err != nil || inUse {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Template is in use by a running session"})
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	before := auditSnapshotIn(r, tx, agendaTemplateAuditQuery, t.ID)
+	result, err := tx.Exec(`
+        UPDATE agenda_templates SET level = ?, name = ?, is_active = ?
+        WHERE id = ?`,
+		t.Level, t.Name, t.IsActive, t.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating agenda template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update template"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		var exists bool
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM agenda_templates WHERE id = ?)", t.ID).Scan(&exists)
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Template not found"})
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM agenda_template_phases WHERE template_id = ?", t.ID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update template"})
+		return
+	}
+	if err := saveAgendaTemplatePhases(tx, &t); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving agenda template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update template"})
+		return
+	}
+
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: "agenda_template.update", EntityType: "agenda_template", EntityID: t.ID,
+		Before: before, After: auditSnapshotIn(r, tx, agendaTemplateAuditQuery, t.ID),
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update template"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update template"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func DeleteAgendaTemplate(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+
+	if inUse, err := agendaTemplateInUse(id); err != nil || inUse {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Template is in use by a running session"})
+		return
+	}
+
+	before := auditSnapshot(r, agendaTemplateAuditQuery, id)
+	result, err := database.GetDB().Exec("DELETE FROM agenda_templates WHERE id = ?", id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting agenda template", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete template"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Template not found"})
+		return
+	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "agenda_template.delete", EntityType: "agenda_template", EntityID: id,
+		Before: before,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// validateAgendaTemplate checks the template and renumbers its phases in
+// the order given.
+func validateAgendaTemplate(t *AgendaTemplate) error {
+	if t.Level < 1 {
+		return fmt.Errorf("level must be at least 1")
+	}
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(t.Phases) == 0 {
+		return fmt.Errorf("at least one phase is required")
+	}
+
+	for i := range t.Phases {
+		p := &t.Phases[i]
+		if !student.AgendaPhaseTypes[p.Type] {
+			return fmt.Errorf("phase %d has unknown type %q", i+1, p.Type)
+		}
+		if p.DurationSeconds < 0 || p.PerSpeakerSeconds < 0 {
+			return fmt.Errorf("phase %d has a negative duration", i+1)
+		}
+		if p.DurationSeconds == 0 && p.PerSpeakerSeconds == 0 {
+			return fmt.Errorf("phase %d needs duration_seconds or per_speaker_seconds", i+1)
+		}
+		p.Order = i + 1
+	}
+
+	// Results are built from the survey, so every agenda has to end with one
+	if t.Phases[len(t.Phases)-1].Type != "survey" {
+		return fmt.Errorf("the last phase must be a survey")
+	}
+	return nil
+}
+
+func saveAgendaTemplatePhases(tx *sql.Tx, t *AgendaTemplate) error {
+	for _, p := range t.Phases {
+		var perSpeaker interface{}
+		if p.PerSpeakerSeconds > 0 {
+			perSpeaker = p.PerSpeakerSeconds
+		}
+		_, err := tx.Exec(`
+            INSERT INTO agenda_template_phases
+            (id, template_id, phase_order, phase_type, label, duration_seconds, per_speaker_seconds)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			uuid.New().String(), t.ID, p.Order, p.Type, p.Label, p.DurationSeconds, perSpeaker)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only one template per level can be active
+	if t.IsActive {
+		_, err := tx.Exec(`
+            UPDATE agenda_templates SET is_active = FALSE
+            WHERE level = ? AND id != ?`, t.Level, t.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func agendaTemplateInUse(id string) (bool, error) {
+	var inUse bool
+	err := database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM gd_sessions s
+            JOIN session_timers t ON t.session_id = s.id AND t.is_active = TRUE
+            WHERE s.agenda_template_id = ?
+        )`, id).Scan(&inUse)
+	return inUse, err
+}
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"encoding/json"
+	"gd/database"
+	"log/slog"
 	"net/http"
 )
 
@@ -14,3 +16,60 @@ func GetQualificationRates(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(data)
 }
 
+
+// GetSpeakingAnalytics reports speaking time and turns per student. With
+// session_id it covers one session, otherwise totals across sessions,
+// optionally filtered by level.
+func GetSpeakingAnalytics(w http.ResponseWriter, r *http.Request) {
+	query := `
+        SELECT ss.student_id, su.full_name, COUNT(DISTINCT ss.session_id),
+               SUM(ss.total_seconds), SUM(ss.turn_count)
+        FROM speaking_stats ss
+        JOIN student_users su ON ss.student_id = su.id
+        JOIN gd_sessions s ON ss.session_id = s.id
+        WHERE 1 = 1`
+	var args []interface{}
+	if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+		query += " AND ss.session_id = ?"
+		args = append(args, sessionID)
+	}
+	if level := r.URL.Query().Get("level"); level != "" {
+		query += " AND s.level = ?"
+		args = append(args, level)
+	}
+	query += " GROUP BY ss.student_id, su.full_name ORDER BY SUM(ss.total_seconds) DESC"
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching speaking analytics", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		var studentID, name string
+		var sessions, totalSeconds, turns int
+		if err := rows.Scan(&studentID, &name, &sessions, &totalSeconds, &turns); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning speaking analytics", "error", err)
+			continue
+		}
+		avgTurn := 0.0
+		if turns > 0 {
+			avgTurn = float64(totalSeconds) / float64(turns)
+		}
+		results = append(results, map[string]interface{}{
+			"student_id":           studentID,
+			"name":                 name,
+			"sessions":             sessions,
+			"total_seconds":        totalSeconds,
+			"turn_count":           turns,
+			"average_turn_seconds": avgTurn,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
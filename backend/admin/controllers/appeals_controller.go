@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gd/audit"
+	"gd/database"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type AppealRating struct {
+	ResultID    string  `json:"result_id"`
+	QuestionID  string  `json:"question_id"`
+	ResponderID string  `json:"responder_id"`
+	StudentID   string  `json:"student_id"`
+	Rank        int     `json:"rank"`
+	Score       float64 `json:"score"`
+	MedianScore float64 `json:"median_score"`
+	Deviation   float64 `json:"deviation"`
+	Penalty     float64 `json:"penalty_points"`
+	IsBiased    bool    `json:"is_biased"`
+}
+
+func GetAppeals(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	query := `
+        SELECT id, session_id, student_id, reason, status,
+               COALESCE(decision_notes, ''), COALESCE(excluded_responder_id, ''),
+               COALESCE(reviewed_by, ''), COALESCE(reviewed_at, ''), created_at
+        FROM result_appeals`
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at ASC"
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching appeals", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	appeals, err := student.ScanAppeals(rows)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error scanning appeals", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(appeals)
+}
+
+// GetAppealDetail returns everything a reviewer needs to decide an appeal:
+// the full rating matrix for the session, the appellant's penalty breakdown
+// and the current standings.
+func GetAppealDetail(w http.ResponseWriter, r *http.Request) {
+	appealID := r.URL.Query().Get("id")
+	if appealID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+
+	rows, err := database.GetDB().Query(`
+        SELECT id, session_id, student_id, reason, status,
+               COALESCE(decision_notes, ''), COALESCE(excluded_responder_id, ''),
+               COALESCE(reviewed_by, ''), COALESCE(reviewed_at, ''), created_at
+        FROM result_appeals
+        WHERE id = ?`, appealID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	appeals, err := student.ScanAppeals(rows)
+	rows.Close()
+	if err != nil || len(appeals) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Appeal not found"})
+		return
+	}
+	appeal := appeals[0]
+
+	rows, err = database.GetDB().Query(`
+        SELECT id, question_id, responder_id, student_id, ranks, score,
+               COALESCE(median_score, 0), COALESCE(deviation, 0),
+               penalty_points, is_biased
+        FROM survey_results
+        WHERE session_id = ? AND is_completed = 1
+        ORDER BY question_id, responder_id, ranks`, appeal.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching rating matrix", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	matrix := make(map[string][]AppealRating)
+	penalties := []AppealRating{}
+	for rows.Next() {
+		var rating AppealRating
+		if err := rows.Scan(&rating.ResultID, &rating.QuestionID, &rating.ResponderID, &rating.StudentID,
+			&rating.Rank, &rating.Score, &rating.MedianScore, &rating.Deviation,
+			&rating.Penalty, &rating.IsBiased); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning rating", "error", err)
+			continue
+		}
+		matrix[rating.QuestionID] = append(matrix[rating.QuestionID], rating)
+		if rating.StudentID == appeal.StudentID && rating.Penalty > 0 {
+			penalties = append(penalties, rating)
+		}
+	}
+
+	standings, err := student.RankSessionStudents(database.GetDB(), appeal.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error ranking session", "session_id", appeal.SessionID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"appeal":            appeal,
+		"rating_matrix":     matrix,
+		"penalty_breakdown": penalties,
+		"standings":         standings,
+	})
+}
+
+// DecideAppeal resolves a pending appeal. "uphold" leaves the results as they
+// are, "remove_penalties" clears the bias penalties on the listed ratings of
+// the appellant (every penalty on them with clear_all) and
+// "exclude_responder" drops one responder's ratings and rescores the session.
+// Promotions are recomputed whenever scores change.
+func DecideAppeal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminID := r.Context().Value("userID").(string)
+
+	var req struct {
+		AppealID    string   `json:"appeal_id"`
+		Decision    string   `json:"decision"`
+		ResultIDs   []string `json:"result_ids"`
+		ClearAll    bool     `json:"clear_all"`
+		ResponderID string   `json:"responder_id"`
+		Notes       string   `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	var newStatus string
+	switch req.Decision {
+	case "uphold":
+		newStatus = "upheld"
+	case "remove_penalties":
+		newStatus = "penalties_removed"
+		if len(req.ResultIDs) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "result_ids is required to remove penalties"})
+			return
+		}
+	case "exclude_responder":
+		newStatus = "rescored"
+		if req.ResponderID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "responder_id is required to exclude a responder"})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "decision must be uphold, remove_penalties or exclude_responder"})
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var sessionID, studentID, status string
+	err = tx.QueryRow(`
+        SELECT session_id, student_id, status
+        FROM result_appeals
+        WHERE id = ?
+        FOR UPDATE`, req.AppealID).Scan(&sessionID, &studentID, &status)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Appeal not found"})
+		return
+	}
+	if status != "pending" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Appeal has already been decided"})
+		return
+	}
+
+	details := map[string]interface{}{
+		"decision":   req.Decision,
+		"session_id": sessionID,
+		"notes":      req.Notes,
+	}
+	var changes []student.PromotionChange
+
+	switch req.Decision {
+	case "remove_penalties":
+		// Only the bias penalty is in question unless the admin clears
+		// incomplete-ranking penalties too
+		query := `
+            UPDATE survey_results
+            SET penalty_points = GREATEST(0, penalty_points - bias_penalty_points),
+                bias_penalty_points = 0, is_biased = FALSE
+            WHERE session_id = ? AND student_id = ? AND bias_penalty_points > 0`
+		if req.ClearAll {
+			query = `
+            UPDATE survey_results
+            SET penalty_points = 0, bias_penalty_points = 0, is_biased = FALSE
+            WHERE session_id = ? AND student_id = ? AND penalty_points > 0`
+		}
+		query += " AND id IN (?" + strings.Repeat(",?", len(req.ResultIDs)-1) + ")"
+		args := []interface{}{sessionID, studentID}
+		for _, id := range req.ResultIDs {
+			args = append(args, id)
+		}
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error removing penalties", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to remove penalties"})
+			return
+		}
+		cleared, _ := result.RowsAffected()
+		details["result_ids"] = req.ResultIDs
+		details["clear_all"] = req.ClearAll
+		details["penalties_cleared"] = cleared
+
+	case "exclude_responder":
+		var isParticipant bool
+		err := tx.QueryRow(`
+            SELECT EXISTS(
+                SELECT 1 FROM session_participants
+                WHERE session_id = ? AND student_id = ?
+            )`, sessionID, req.ResponderID).Scan(&isParticipant)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking responder", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+			return
+		}
+		if !isParticipant {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Responder did not take part in this session"})
+			return
+		}
+
+		removed, err := student.ExcludeResponder(tx, sessionID, req.ResponderID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error excluding responder", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to exclude responder"})
+			return
+		}
+		if err := student.RescoreSession(r.Context(), tx, sessionID); err != nil {
+			slog.ErrorContext(r.Context(), "Error rescoring session", "session_id", sessionID, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to rescore session"})
+			return
+		}
+		details["responder_id"] = req.ResponderID
+		details["ratings_removed"] = removed
+	}
+
+	if req.Decision != "uphold" {
+		changes, err = student.RecomputeSessionPromotions(r.Context(), tx, sessionID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error recomputing promotions", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to recompute promotions"})
+			return
+		}
+		details["promotion_changes"] = changes
+	}
+
+	_, err = tx.Exec(`
+        UPDATE result_appeals
+        SET status = ?, decision_notes = ?, excluded_responder_id = NULLIF(?, ''),
+            reviewed_by = ?, reviewed_at = NOW()
+        WHERE id = ?`,
+		newStatus, req.Notes, req.ResponderID, adminID, req.AppealID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error updating appeal", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to record decision"})
+		return
+	}
+
+	if err := student.RecordAppealAudit(tx, req.AppealID, adminID, "admin", req.Decision, details); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing appeal audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to record decision"})
+		return
+	}
+	details["status"] = newStatus
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: "appeal.decide", EntityType: "appeal", EntityID: req.AppealID,
+		Before: map[string]string{"status": status}, After: details,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to record decision"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to record decision"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            newStatus,
+		"appeal_id":         req.AppealID,
+		"promotion_changes": changes,
+	})
+}
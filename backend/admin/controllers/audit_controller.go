@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"gd/audit"
+	"gd/database"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AuditEntry is one row of audit_log.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	ActorID    *string         `json:"actor_id"`
+	ActorRole  string          `json:"actor_role"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   *string         `json:"entity_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         *string         `json:"ip"`
+	RequestID  *string         `json:"request_id"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+var auditCSVHeader = []string{"id", "created_at", "actor_role", "actor_id", "action",
+	"entity_type", "entity_id", "before", "after", "ip", "request_id"}
+
+// auditSnapshot reads a row for an audit entry's Before or After. A failure
+// is logged and leaves that side of the entry empty rather than failing
+// the change being audited.
+func auditSnapshot(r *http.Request, query string, args ...interface{}) map[string]interface{} {
+	return auditSnapshotIn(r, database.GetDB(), query, args...)
+}
+
+// auditSnapshotIn is auditSnapshot within a transaction.
+func auditSnapshotIn(r *http.Request, q audit.Queryer, query string, args ...interface{}) map[string]interface{} {
+	row, err := audit.Snapshot(r.Context(), q, query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading audit snapshot", "error", err)
+	}
+	return row
+}
+
+// auditLogQuery builds the audit_log query for the request's filters:
+// actor_id, actor_role, action, entity_type, entity_id, from and to (a date
+// or RFC 3339 time), and before_id to page backwards.
+func auditLogQuery(r *http.Request) (string, []interface{}, string) {
+	q := r.URL.Query()
+	query := `
+        SELECT id, actor_id, actor_role, action, entity_type, entity_id,
+               before_json, after_json, ip, request_id, created_at
+        FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	for _, column := range []string{"actor_id", "actor_role", "action", "entity_type", "entity_id"} {
+		if v := q.Get(column); v != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, v)
+		}
+	}
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		raw := q.Get(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseAuditTime(raw, bound.param == "to")
+		if err != nil {
+			return "", nil, bound.param + " must be a date (YYYY-MM-DD) or an RFC 3339 time"
+		}
+		query += " AND created_at " + bound.op + " ?"
+		args = append(args, t.Format("2006-01-02 15:04:05"))
+	}
+	if raw := q.Get("before_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", nil, "before_id must be a number"
+		}
+		query += " AND id < ?"
+		args = append(args, id)
+	}
+	return query, args, ""
+}
+
+// parseAuditTime reads a filter bound in the server's time zone. A bare
+// date as the upper bound includes the whole day.
+func parseAuditTime(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(time.Local), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+	if err == nil && endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, err
+}
+
+func scanAuditEntry(scan func(...interface{}) error) (AuditEntry, error) {
+	var e AuditEntry
+	var before, after []byte
+	err := scan(&e.ID, &e.ActorID, &e.ActorRole, &e.Action, &e.EntityType, &e.EntityID,
+		&before, &after, &e.IP, &e.RequestID, &e.CreatedAt)
+	if before != nil {
+		e.Before = before
+	}
+	if after != nil {
+		e.After = after
+	}
+	return e, err
+}
+
+// GetAuditLog lists audit entries, newest first. Pass next_before_id back
+// as before_id for the next page.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditLogLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxAuditLogLimit)
+	}
+	query, args, invalid := auditLogQuery(r)
+	if invalid != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": invalid})
+		return
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := database.GetDB().QueryContext(r.Context(), query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows.Scan)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning audit entry", "error", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	var nextBeforeID *int64
+	if len(entries) == limit {
+		nextBeforeID = &entries[len(entries)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":        entries,
+		"next_before_id": nextBeforeID,
+	})
+}
+
+// ExportAuditLog streams every audit entry matching the same filters as
+// GetAuditLog as CSV, oldest first.
+func ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	query, args, invalid := auditLogQuery(r)
+	if invalid != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": invalid})
+		return
+	}
+	query += " ORDER BY id"
+
+	rows, err := database.GetDB().QueryContext(r.Context(), query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export audit log"})
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit_log.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write(auditCSVHeader)
+	for rows.Next() {
+		e, err := scanAuditEntry(rows.Scan)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning audit entry", "error", err)
+			continue
+		}
+		writer.Write([]string{
+			strconv.FormatInt(e.ID, 10), e.CreatedAt, e.ActorRole, deref(e.ActorID), e.Action,
+			e.EntityType, deref(e.EntityID), string(e.Before), string(e.After),
+			deref(e.IP), deref(e.RequestID),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting audit log", "error", err)
+	}
+	writer.Flush()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
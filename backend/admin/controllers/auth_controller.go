@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"gd/admin/utils"
 	"gd/database"
+	"gd/ratelimit"
 	"golang.org/x/crypto/bcrypt"
+	"log/slog"
 )
 
 type LoginRequest struct {
@@ -24,6 +26,15 @@ func AdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	account := ratelimit.NormalizeAccount(req.Email)
+	if wait, err := ratelimit.LockedFor(r.Context(), ratelimit.AdminAccount, account); err != nil {
+		slog.ErrorContext(r.Context(), "Error checking login lockout", "error", err)
+	} else if wait > 0 {
+		ratelimit.LoginFailed(r, ratelimit.AdminAccount, account, ratelimit.ReasonLocked)
+		ratelimit.WriteLocked(w, wait)
+		return
+	}
+
 	// Query the database for admin user
 	var (
 		id           string
@@ -37,6 +48,7 @@ func AdminLogin(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
+			ratelimit.LoginFailed(r, ratelimit.AdminAccount, account, ratelimit.ReasonUnknownAccount)
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
 		} else {
@@ -48,10 +60,12 @@ func AdminLogin(w http.ResponseWriter, r *http.Request) {
 
 	// Compare the provided password with the hashed password
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		ratelimit.LoginFailed(r, ratelimit.AdminAccount, account, ratelimit.ReasonBadPassword)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
 		return
 	}
+	ratelimit.LoginSucceeded(r.Context(), ratelimit.AdminAccount, account)
 
 	// Generate JWT token
 	token, err := jwt.GenerateToken(id, "admin")
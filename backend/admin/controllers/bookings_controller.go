@@ -5,7 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"gd/database"
-	"log"
+	"gd/services"
+	"log/slog"
 	"net/http"
 )
 
@@ -51,16 +52,23 @@ func GetStudentBookings(w http.ResponseWriter, r *http.Request) {
     `
     
     // Add status filter if provided
+    var args []interface{}
     if statusFilter != "" && statusFilter != "all" {
-        baseQuery += " AND s.status = '" + statusFilter + "'"
+        if !services.SessionStatus(statusFilter).Valid() {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "Invalid status"})
+            return
+        }
+        baseQuery += " AND s.status = ?"
+        args = append(args, statusFilter)
     }
     
     baseQuery += " ORDER BY sp.joined_at DESC"
     
-    rows, err := database.GetDB().Query(baseQuery)
+    rows, err := database.GetDB().Query(baseQuery, args...)
     
     if err != nil {
-        log.Printf("Database error: %v", err)
+        slog.ErrorContext(r.Context(), "Database error", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode([]BookingInfo{})
         return
@@ -87,7 +95,7 @@ func GetStudentBookings(w http.ResponseWriter, r *http.Request) {
             &sessionEnd,
             &booking.BookedAt,
         ); err != nil {
-            log.Printf("Error scanning booking row: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning booking row", "error", err)
             continue
         }
         
@@ -107,7 +115,7 @@ func GetStudentBookings(w http.ResponseWriter, r *http.Request) {
 
     w.Header().Set("Content-Type", "application/json")
     if err := json.NewEncoder(w).Encode(bookings); err != nil {
-        log.Printf("Error encoding bookings: %v", err)
+        slog.ErrorContext(r.Context(), "Error encoding bookings", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode([]BookingInfo{})
     }
@@ -167,7 +175,7 @@ func GetStudentBookingDetails(w http.ResponseWriter, r *http.Request) {
             w.WriteHeader(http.StatusNotFound)
             json.NewEncoder(w).Encode(map[string]string{"error": "Student not found"})
         } else {
-            log.Printf("Database error: %v", err)
+            slog.ErrorContext(r.Context(), "Database error", "error", err)
             w.WriteHeader(http.StatusInternalServerError)
             json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         }
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gd/config"
+	"net/http"
+)
+
+// GetConfig shows the running configuration and where each setting came
+// from. Secrets are masked and the database password is stripped.
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config.Get().Redacted())
+}
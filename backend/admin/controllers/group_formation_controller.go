@@ -0,0 +1,510 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/services"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoBookings       = errors.New("no pending bookings for this venue")
+	ErrTooFewStudents   = errors.New("not enough booked students to form a group")
+	ErrInvalidGroupSize = errors.New("group sizes must satisfy 2 <= min_size <= max_size")
+	ErrUnevenGroups     = errors.New("booked students cannot be split into groups within min_size and max_size")
+)
+
+// GroupOptions controls how FormVenueGroups splits a venue's bookings.
+type GroupOptions struct {
+	MinSize int `json:"min_size"`
+	MaxSize int `json:"max_size"`
+	// Students who shared a session within this many days count as recent peers
+	LookbackDays int  `json:"lookback_days"`
+	DryRun       bool `json:"dry_run"`
+}
+
+type GroupMember struct {
+	StudentID  string `json:"student_id"`
+	Department string `json:"department"`
+	Year       int    `json:"year"`
+}
+
+type FormedGroup struct {
+	GroupNumber int           `json:"group_number"`
+	SessionID   string        `json:"session_id,omitempty"`
+	TableLabel  string        `json:"table_label"`
+	Members     []GroupMember `json:"members"`
+	RepeatPairs int           `json:"repeat_pairs"`
+}
+
+func FormGroups(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		VenueID string `json:"venue_id"`
+		GroupOptions
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if req.VenueID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "venue_id is required"})
+		return
+	}
+
+	adminID := r.Context().Value("userID").(string)
+	groups, err := FormVenueGroups(r.Context(), req.VenueID, adminID, req.GroupOptions)
+	if err != nil {
+		switch err {
+		case ErrNoBookings:
+			w.WriteHeader(http.StatusNotFound)
+		case ErrTooFewStudents, ErrInvalidGroupSize, ErrUnevenGroups:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			slog.ErrorContext(r.Context(), "Error forming groups for venue", "venue_id", req.VenueID, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to form groups"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"venue_id": req.VenueID,
+		"dry_run":  req.DryRun,
+		"groups":   groups,
+	})
+}
+
+// FormVenueGroups splits the students booked into a venue's scheduled
+// sessions into balanced groups and gives each group its own session and
+// table. Existing scheduled sessions are reused before new ones are
+// created, and any left over are cancelled on adminID's behalf.
+func FormVenueGroups(ctx context.Context, venueID, adminID string, opts GroupOptions) ([]FormedGroup, error) {
+	if opts.MinSize == 0 {
+		opts.MinSize = 6
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = 8
+	}
+	if opts.LookbackDays == 0 {
+		opts.LookbackDays = 30
+	}
+	if opts.MinSize < 2 || opts.MaxSize < opts.MinSize {
+		return nil, ErrInvalidGroupSize
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tableDetails string
+	err = tx.QueryRow(`
+        SELECT table_details FROM venues
+        WHERE id = ? AND is_active = TRUE
+        FOR UPDATE`, venueID).Scan(&tableDetails)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoBookings
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type pendingSession struct {
+		ID        string
+		Level     int
+		StartTime string
+		EndTime   string
+		Agenda    []byte
+		TopicID   sql.NullString
+		Pinned    bool
+	}
+	rows, err := tx.Query(`
+        SELECT id, level, start_time, end_time, agenda, topic_id, topic_pinned
+        FROM gd_sessions
+        WHERE venue_id = ? AND status = 'scheduled' AND end_time > NOW()
+        ORDER BY created_at
+        FOR UPDATE`, venueID)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []pendingSession
+	for rows.Next() {
+		var s pendingSession
+		if err := rows.Scan(&s.ID, &s.Level, &s.StartTime, &s.EndTime, &s.Agenda, &s.TopicID, &s.Pinned); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if len(sessions) == 0 {
+		return nil, ErrNoBookings
+	}
+
+	sessionIDs := make([]interface{}, len(sessions))
+	for i, s := range sessions {
+		sessionIDs[i] = s.ID
+	}
+	placeholders := "?" + strings.Repeat(",?", len(sessionIDs)-1)
+
+	rows, err = tx.Query(`
+        SELECT DISTINCT sp.student_id, su.department, su.year
+        FROM session_participants sp
+        JOIN student_users su ON sp.student_id = su.id
+        WHERE sp.is_dummy = FALSE AND sp.session_id IN (`+placeholders+`)`, sessionIDs...)
+	if err != nil {
+		return nil, err
+	}
+	var students []GroupMember
+	for rows.Next() {
+		var m GroupMember
+		if err := rows.Scan(&m.StudentID, &m.Department, &m.Year); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		students = append(students, m)
+	}
+	rows.Close()
+	if len(students) < opts.MinSize {
+		return nil, ErrTooFewStudents
+	}
+
+	recent, err := loadRecentPeers(tx, students, sessionIDs, opts.LookbackDays)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := groupSizes(len(students), opts.MinSize, opts.MaxSize)
+	if sizes == nil {
+		return nil, ErrUnevenGroups
+	}
+	members := balanceGroups(students, sizes)
+	reduceRepeatPeers(members, recent)
+
+	labels := parseTableLabels(tableDetails, len(members))
+	groups := make([]FormedGroup, len(members))
+	for i := range members {
+		groups[i] = FormedGroup{
+			GroupNumber: i + 1,
+			TableLabel:  labels[i],
+			Members:     members[i],
+			RepeatPairs: repeatPairs(members[i], recent),
+		}
+	}
+	if opts.DryRun {
+		return groups, nil
+	}
+
+	before, err := sessionSnapshots(ctx, tx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse the pending sessions in order, then copy the first for any extra groups
+	template := sessions[0]
+	for i := range groups {
+		if i < len(sessions) {
+			groups[i].SessionID = sessions[i].ID
+			continue
+		}
+		groups[i].SessionID = uuid.New().String()
+		// A topic pinned by an admin applies to every group at the slot
+		var topicID interface{}
+		if template.Pinned {
+			topicID = template.TopicID
+		}
+		_, err := tx.Exec(`
+            INSERT INTO gd_sessions
+            (id, venue_id, status, start_time, end_time, level, agenda, topic_id, topic_pinned)
+            VALUES (?, ?, 'scheduled', ?, ?, ?, ?, ?, ?)`,
+			groups[i].SessionID, venueID, template.StartTime, template.EndTime, template.Level, template.Agenda,
+			topicID, template.Pinned)
+		if err != nil {
+			return nil, fmt.Errorf("error creating group session: %v", err)
+		}
+		err = student.RecordStatusChange(tx, groups[i].SessionID, "", student.Transition{
+			To: services.StatusScheduled, ActorID: adminID, ActorRole: student.ActorAdmin, Reason: "group formation"})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range sessions[min(len(groups), len(sessions)):] {
+		err := student.TransitionSession(tx, s.ID, student.Transition{
+			To: services.StatusCancelled, ActorID: adminID, ActorRole: student.ActorAdmin, Reason: "group formation"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+        DELETE FROM session_participants
+        WHERE is_dummy = FALSE AND session_id IN (`+placeholders+`)`, sessionIDs...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+        DELETE FROM session_group_assignments
+        WHERE session_id IN (`+placeholders+`)`, sessionIDs...); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if _, err := tx.Exec("UPDATE gd_sessions SET max_capacity = ? WHERE id = ?", len(g.Members), g.SessionID); err != nil {
+			return nil, err
+		}
+		for _, m := range g.Members {
+			if _, err := tx.Exec(`
+                INSERT INTO session_participants (id, session_id, student_id, is_dummy)
+                VALUES (UUID(), ?, ?, FALSE)`, g.SessionID, m.StudentID); err != nil {
+				return nil, fmt.Errorf("error adding %s to group: %v", m.StudentID, err)
+			}
+			if _, err := tx.Exec(`
+                INSERT INTO session_group_assignments
+                (id, session_id, student_id, venue_id, group_number, table_label)
+                VALUES (UUID(), ?, ?, ?, ?, ?)`,
+				g.SessionID, m.StudentID, venueID, g.GroupNumber, g.TableLabel); err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec("UPDATE student_users SET current_booking = ? WHERE id = ?", g.SessionID, m.StudentID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	touched := append([]interface{}{}, sessionIDs...)
+	for _, g := range groups[min(len(groups), len(sessions)):] {
+		touched = append(touched, g.SessionID)
+	}
+	after, err := sessionSnapshots(ctx, tx, touched)
+	if err != nil {
+		return nil, err
+	}
+	err = audit.Record(ctx, tx, audit.Entry{
+		Action: "venue.form_groups", EntityType: "venue", EntityID: venueID,
+		Before: map[string]interface{}{"sessions": before},
+		After:  map[string]interface{}{"sessions": after, "groups": groups},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	slog.Info("Formed groups", "groups_count", len(groups), "students_count", len(students), "venue_id", venueID)
+	return groups, nil
+}
+
+// sessionSnapshots reads the audited fields of each session, by ID.
+func sessionSnapshots(ctx context.Context, tx *sql.Tx, sessionIDs []interface{}) (map[string]interface{}, error) {
+	snapshots := make(map[string]interface{}, len(sessionIDs))
+	for _, id := range sessionIDs {
+		row, err := audit.Snapshot(ctx, tx, sessionAuditQuery, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[id.(string)] = row
+	}
+	return snapshots, nil
+}
+
+// loadRecentPeers returns, for each student, the set of students they shared
+// a session with in the lookback window, ignoring the bookings being regrouped.
+func loadRecentPeers(tx *sql.Tx, students []GroupMember, excludeSessions []interface{}, lookbackDays int) (map[string]map[string]bool, error) {
+	ids := make([]interface{}, len(students))
+	for i, s := range students {
+		ids[i] = s.StudentID
+	}
+	studentPlaceholders := "?" + strings.Repeat(",?", len(ids)-1)
+	sessionPlaceholders := "?" + strings.Repeat(",?", len(excludeSessions)-1)
+
+	args := append([]interface{}{}, ids...)
+	args = append(args, lookbackDays)
+	args = append(args, excludeSessions...)
+	rows, err := tx.Query(`
+        SELECT DISTINCT a.student_id, b.student_id
+        FROM session_participants a
+        JOIN session_participants b ON a.session_id = b.session_id AND a.student_id <> b.student_id
+        JOIN gd_sessions s ON s.id = a.session_id
+        WHERE a.student_id IN (`+studentPlaceholders+`)
+          AND a.is_dummy = FALSE AND b.is_dummy = FALSE
+          AND s.start_time > DATE_SUB(NOW(), INTERVAL ? DAY)
+          AND s.id NOT IN (`+sessionPlaceholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recent := make(map[string]map[string]bool)
+	for rows.Next() {
+		var a, b string
+		if err := rows.Scan(&a, &b); err != nil {
+			return nil, err
+		}
+		if recent[a] == nil {
+			recent[a] = make(map[string]bool)
+		}
+		recent[a][b] = true
+	}
+	return recent, rows.Err()
+}
+
+// groupSizes splits n students into as few groups of at most maxSize as
+// possible, with sizes differing by at most one. It returns nil when no
+// split keeps every group within minSize and maxSize.
+func groupSizes(n, minSize, maxSize int) []int {
+	count := (n + maxSize - 1) / maxSize
+	if n/count < minSize {
+		return nil
+	}
+	sizes := make([]int, count)
+	for i := range sizes {
+		sizes[i] = n / count
+		if i < n%count {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
+// balanceGroups deals students sorted by department and year into groups in
+// snake order, so each department and year is spread across all groups.
+func balanceGroups(students []GroupMember, sizes []int) [][]GroupMember {
+	sorted := append([]GroupMember{}, students...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Department != sorted[j].Department {
+			return sorted[i].Department < sorted[j].Department
+		}
+		if sorted[i].Year != sorted[j].Year {
+			return sorted[i].Year < sorted[j].Year
+		}
+		return sorted[i].StudentID < sorted[j].StudentID
+	})
+
+	groups := make([][]GroupMember, len(sizes))
+	g, step := 0, 1
+	for _, s := range sorted {
+		// Skip groups that are already full
+		for len(groups[g]) >= sizes[g] {
+			g, step = nextSnake(g, step, len(groups))
+		}
+		groups[g] = append(groups[g], s)
+		g, step = nextSnake(g, step, len(groups))
+	}
+	return groups
+}
+
+func nextSnake(g, step, count int) (int, int) {
+	if count == 1 {
+		return 0, step
+	}
+	next := g + step
+	if next < 0 || next >= count {
+		return g, -step
+	}
+	return next, step
+}
+
+// reduceRepeatPeers swaps students of the same department between groups
+// while that lowers the number of recently-met pairs. Swapping like for like
+// keeps the department balance from balanceGroups intact.
+func reduceRepeatPeers(groups [][]GroupMember, recent map[string]map[string]bool) {
+	if len(recent) == 0 {
+		return
+	}
+	for pass := 0; pass < 50; pass++ {
+		improved := false
+		for a := range groups {
+			for b := a + 1; b < len(groups); b++ {
+				for i := range groups[a] {
+					for j := range groups[b] {
+						if groups[a][i].Department != groups[b][j].Department {
+							continue
+						}
+						before := repeatPairs(groups[a], recent) + repeatPairs(groups[b], recent)
+						groups[a][i], groups[b][j] = groups[b][j], groups[a][i]
+						after := repeatPairs(groups[a], recent) + repeatPairs(groups[b], recent)
+						if after < before {
+							improved = true
+							continue
+						}
+						groups[a][i], groups[b][j] = groups[b][j], groups[a][i]
+					}
+				}
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
+
+func repeatPairs(group []GroupMember, recent map[string]map[string]bool) int {
+	count := 0
+	for i := range group {
+		for j := i + 1; j < len(group); j++ {
+			if recent[group[i].StudentID][group[j].StudentID] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+var tableRangePattern = regexp.MustCompile(`^(.*?)(\d+)\s*-\s*(\d+)$`)
+var tableNumberPattern = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// parseTableLabels turns venues.table_details into one label per group. It
+// accepts a comma-separated list ("T1, T2"), a range ("Table 1-4") or a
+// single label, numbering on from the last label when there are more groups
+// than tables listed.
+func parseTableLabels(details string, count int) []string {
+	var labels []string
+	details = strings.TrimSpace(details)
+
+	if m := tableRangePattern.FindStringSubmatch(details); m != nil {
+		from, _ := strconv.Atoi(m[2])
+		to, _ := strconv.Atoi(m[3])
+		for n := from; n <= to && len(labels) < count; n++ {
+			labels = append(labels, m[1]+strconv.Itoa(n))
+		}
+	} else {
+		for _, part := range strings.Split(details, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				labels = append(labels, part)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		labels = []string{"Table 1"}
+	}
+
+	for len(labels) < count {
+		last := labels[len(labels)-1]
+		if m := tableNumberPattern.FindStringSubmatch(last); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			labels = append(labels, m[1]+strconv.Itoa(n+1))
+		} else {
+			labels = append(labels, fmt.Sprintf("%s %d", last, len(labels)+1))
+		}
+	}
+	return labels[:count]
+}
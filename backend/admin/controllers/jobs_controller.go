@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"log/slog"
+	"net/http"
+)
+
+// JobStatus is the latest state of a scheduled job, as recorded in
+// job_runs by whichever replica ran it.
+type JobStatus struct {
+	Name           string  `json:"name"`
+	Schedule       string  `json:"schedule"`
+	Running        bool    `json:"running"`
+	Holder         *string `json:"holder"`
+	NextRunAt      string  `json:"next_run_at"`
+	LastStartedAt  *string `json:"last_started_at"`
+	LastFinishedAt *string `json:"last_finished_at"`
+	LastDurationMs *int64  `json:"last_duration_ms"`
+	LastStatus     *string `json:"last_status"`
+	LastError      *string `json:"last_error"`
+	RunCount       int     `json:"run_count"`
+	FailureCount   int     `json:"failure_count"`
+}
+
+// GetJobs lists the background jobs with their last run, duration and
+// error.
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.GetDB().Query(`
+        SELECT job_name, schedule, COALESCE(lease_until > NOW(), FALSE), holder, next_run_at,
+               last_started_at, last_finished_at, last_duration_ms, last_status, last_error,
+               run_count, failure_count
+        FROM job_runs
+        ORDER BY job_name`)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching job runs", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	jobs := []JobStatus{}
+	for rows.Next() {
+		var j JobStatus
+		var holder, started, finished, status, lastError sql.NullString
+		var duration sql.NullInt64
+		if err := rows.Scan(&j.Name, &j.Schedule, &j.Running, &holder, &j.NextRunAt,
+			&started, &finished, &duration, &status, &lastError,
+			&j.RunCount, &j.FailureCount); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning job run", "error", err)
+			continue
+		}
+		j.Holder = nullStringPtr(holder)
+		j.LastStartedAt = nullStringPtr(started)
+		j.LastFinishedAt = nullStringPtr(finished)
+		j.LastStatus = nullStringPtr(status)
+		j.LastError = nullStringPtr(lastError)
+		if duration.Valid {
+			j.LastDurationMs = &duration.Int64
+		}
+		jobs = append(jobs, j)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// RunJobNow makes a job due immediately; the next replica to poll runs it.
+func RunJobNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "name is required"})
+		return
+	}
+
+	result, err := database.GetDB().Exec(`
+        UPDATE job_runs SET next_run_at = NOW() WHERE job_name = ?`, req.Name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error scheduling job", "job", req.Name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to schedule job"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Job not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "scheduled"})
+}
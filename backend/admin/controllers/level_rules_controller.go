@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/services"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// levelRulesAuditQuery reads the audited fields of a level's rules.
+const levelRulesAuditQuery = `
+    SELECT level, prep_time, discussion_time, survey_time, penalty_threshold, allow_override
+    FROM gd_rules WHERE level = ?`
+
+// GetLevelRules returns the rules for one level (?level=) or for every
+// level. Levels without a gd_rules row report the defaults.
+func GetLevelRules(w http.ResponseWriter, r *http.Request) {
+	levels := make([]int, 0, services.MaxLevel)
+	if levelStr := r.URL.Query().Get("level"); levelStr != "" {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < 1 || level > services.MaxLevel {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid level"})
+			return
+		}
+		levels = append(levels, level)
+	} else {
+		for level := 1; level <= services.MaxLevel; level++ {
+			levels = append(levels, level)
+		}
+	}
+
+	rules := make([]student.LevelRules, 0, len(levels))
+	for _, level := range levels {
+		levelRules, err := student.LoadLevelRules(database.GetDB(), level)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading rules for level", "level", level, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+			return
+		}
+		rules = append(rules, levelRules)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// UpdateLevelRules creates or replaces the rules for a level.
+func UpdateLevelRules(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level            int     `json:"level"`
+		PrepTime         int     `json:"prep_time"`
+		DiscussionTime   int     `json:"discussion_time"`
+		SurveyTime       int     `json:"survey_time"`
+		PenaltyThreshold float64 `json:"penalty_threshold"`
+		AllowOverride    *bool   `json:"allow_override"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	if req.Level < 1 || req.Level > services.MaxLevel {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("level must be between 1 and %d", services.MaxLevel)})
+		return
+	}
+	if req.PrepTime <= 0 || req.DiscussionTime <= 0 || req.SurveyTime <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "prep_time, discussion_time and survey_time must be positive minutes"})
+		return
+	}
+	// penalty_threshold is DECIMAL(3,1)
+	if req.PenaltyThreshold <= 0 || req.PenaltyThreshold > 99.9 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "penalty_threshold must be between 0.1 and 99.9"})
+		return
+	}
+	allowOverride := true
+	if req.AllowOverride != nil {
+		allowOverride = *req.AllowOverride
+	}
+
+	before := auditSnapshot(r, levelRulesAuditQuery, req.Level)
+	_, err := database.GetDB().Exec(`
+        INSERT INTO gd_rules (level, prep_time, discussion_time, survey_time, penalty_threshold, allow_override)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            prep_time = VALUES(prep_time),
+            discussion_time = VALUES(discussion_time),
+            survey_time = VALUES(survey_time),
+            penalty_threshold = VALUES(penalty_threshold),
+            allow_override = VALUES(allow_override),
+            updated_at = CURRENT_TIMESTAMP`,
+		req.Level, req.PrepTime, req.DiscussionTime, req.SurveyTime, req.PenaltyThreshold, allowOverride)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving rules for level", "level", req.Level, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save level rules"})
+		return
+	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "level_rules.update", EntityType: "level_rules", EntityID: strconv.Itoa(req.Level),
+		Before: before, After: auditSnapshot(r, levelRulesAuditQuery, req.Level),
+	})
+
+	rules, err := student.LoadLevelRules(database.GetDB(), req.Level)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// DeleteLevelRules removes a level's rules so it falls back to the
+// defaults.
+func DeleteLevelRules(w http.ResponseWriter, r *http.Request) {
+	level, err := strconv.Atoi(r.URL.Query().Get("level"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "level is required"})
+		return
+	}
+
+	before := auditSnapshot(r, levelRulesAuditQuery, level)
+	result, err := database.GetDB().Exec("DELETE FROM gd_rules WHERE level = ?", level)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting rules for level", "level", level, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete level rules"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Level has no rules of its own"})
+		return
+	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "level_rules.delete", EntityType: "level_rules", EntityID: strconv.Itoa(level),
+		Before: before,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
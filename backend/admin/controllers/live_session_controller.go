@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type LiveSession struct {
+	SessionID        string `json:"session_id"`
+	VenueID          string `json:"venue_id"`
+	VenueName        string `json:"venue_name"`
+	Level            int    `json:"level"`
+	Topic            string `json:"topic"`
+	ModeratorID      string `json:"moderator_id,omitempty"`
+	Phase            string `json:"phase"`
+	RemainingSeconds int    `json:"remaining_seconds"`
+	TotalSeconds     int    `json:"total_seconds"`
+	ExtensionSeconds int    `json:"extension_seconds"`
+	IsPaused         bool   `json:"is_paused"`
+	JoinedCount      int    `json:"joined_count"`
+	BookedCount      int    `json:"booked_count"`
+	ReadyCount       int    `json:"ready_count"`
+	SurveyCompleted  int    `json:"survey_completed"`
+}
+
+// liveHub wakes up every open live stream so admins see the effect of an
+// action straight away instead of waiting for the next poll.
+type liveHub struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+	// done is closed when the server shuts down, ending every stream.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var liveSessionsHub = &liveHub{
+	subscribers: make(map[chan struct{}]struct{}),
+	done:        make(chan struct{}),
+}
+
+// CloseLiveStreams ends every open live session stream. Streams never go
+// idle on their own, so a shutting-down server would otherwise wait on
+// them until its deadline.
+func CloseLiveStreams() {
+	liveSessionsHub.closeOnce.Do(func() { close(liveSessionsHub.done) })
+}
+
+func (h *liveHub) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *liveHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+func (h *liveHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A refresh is already pending for this subscriber
+		}
+	}
+}
+
+func fetchLiveSessions() ([]LiveSession, error) {
+	rows, err := database.GetDB().Query(`
+        SELECT s.id, COALESCE(s.venue_id, ''), COALESCE(v.name, ''), s.level, COALESCE(s.topic, ''),
+               COALESCE(s.moderator_id, ''),
+               COALESCE(t.phase, ''), COALESCE(t.duration_seconds + t.extension_seconds, 0),
+               COALESCE(t.extension_seconds, 0),
+               COALESCE(t.accumulated_seconds +
+                   CASE WHEN t.paused_at IS NULL THEN TIMESTAMPDIFF(SECOND, t.start_time, NOW()) ELSE 0 END, 0),
+               t.paused_at IS NOT NULL,
+               (SELECT COUNT(*) FROM session_participants sp
+                WHERE sp.session_id = s.id AND sp.is_dummy = FALSE),
+               (SELECT COUNT(*) FROM student_users su WHERE su.current_booking = s.id),
+               (SELECT COUNT(*) FROM session_ready_status rs
+                WHERE rs.session_id = s.id AND rs.is_ready = TRUE),
+               (SELECT COUNT(*) FROM survey_completion sc WHERE sc.session_id = s.id)
+        FROM gd_sessions s
+        LEFT JOIN venues v ON s.venue_id = v.id
+        LEFT JOIN session_timers t ON t.session_id = s.id AND t.is_active = TRUE
+        WHERE s.status IN `+student.LiveStatusesSQL+`
+        ORDER BY v.name, s.start_time`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []LiveSession{}
+	for rows.Next() {
+		var ls LiveSession
+		var elapsed int
+		if err := rows.Scan(&ls.SessionID, &ls.VenueID, &ls.VenueName, &ls.Level, &ls.Topic,
+			&ls.ModeratorID, &ls.Phase, &ls.TotalSeconds, &ls.ExtensionSeconds, &elapsed, &ls.IsPaused,
+			&ls.JoinedCount, &ls.BookedCount, &ls.ReadyCount, &ls.SurveyCompleted); err != nil {
+			return nil, err
+		}
+		if ls.Phase != "" && ls.TotalSeconds > elapsed {
+			ls.RemainingSeconds = ls.TotalSeconds - elapsed
+		}
+		sessions = append(sessions, ls)
+	}
+	return sessions, rows.Err()
+}
+
+func GetLiveSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := fetchLiveSessions()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching live sessions", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// StreamLiveSessions pushes the live session list as Server-Sent Events.
+// A snapshot is sent on connect, every few seconds, and after each admin
+// action.
+func StreamLiveSessions(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Streaming not supported"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// The stream outlives the server's write timeout by design
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Could not clear write deadline for stream", "error", err)
+	}
+
+	updates := liveSessionsHub.subscribe()
+	defer liveSessionsHub.unsubscribe(updates)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		sessions, err := fetchLiveSessions()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching live sessions for stream", "error", err)
+			fmt.Fprintf(w, "event: error\ndata: {\"error\":\"Database error\"}\n\n")
+		} else {
+			payload, _ := json.Marshal(sessions)
+			fmt.Fprintf(w, "event: sessions\ndata: %s\n\n", payload)
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-liveSessionsHub.done:
+			return
+		case <-updates:
+		case <-ticker.C:
+		}
+	}
+}
+
+// LiveSessionAction lets an admin intervene in a running session.
+func LiveSessionAction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID string `json:"session_id"`
+		Action    string `json:"action"`
+		StudentID string `json:"student_id"`
+		Seconds   int    `json:"seconds"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if req.SessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id is required"})
+		return
+	}
+
+	var live bool
+	err := database.GetDB().QueryRow(`
+        SELECT status IN `+student.LiveStatusesSQL+` FROM gd_sessions WHERE id = ?`, req.SessionID).Scan(&live)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+		return
+	}
+	if !live {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Session is not active"})
+		return
+	}
+
+	adminID := r.Context().Value("userID").(string)
+	response := map[string]interface{}{"status": "ok", "action": req.Action}
+	before := auditSnapshot(r, sessionAuditQuery, req.SessionID)
+
+	switch req.Action {
+	case "pause":
+		if err := student.PauseTimer(req.SessionID, adminID, "admin", req.Reason); err != nil {
+			student.WriteTimerError(w, err)
+			return
+		}
+
+	case "resume":
+		if err := student.ResumeTimer(req.SessionID, adminID, "admin", req.Reason); err != nil {
+			student.WriteTimerError(w, err)
+			return
+		}
+
+	case "extend":
+		if err := student.ExtendTimer(req.SessionID, adminID, "admin", req.Reason, req.Seconds); err != nil {
+			student.WriteTimerError(w, err)
+			return
+		}
+
+	case "assign_moderator":
+		if req.StudentID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "student_id is required"})
+			return
+		}
+		result, err := database.GetDB().Exec(`
+            UPDATE gd_sessions s
+            SET s.moderator_id = ?
+            WHERE s.id = ? AND EXISTS (
+                SELECT 1 FROM session_participants sp
+                WHERE sp.session_id = s.id AND sp.student_id = ? AND sp.is_dummy = FALSE
+            )`, req.StudentID, req.SessionID, req.StudentID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error assigning moderator", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to assign moderator"})
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Student is not in this session"})
+			return
+		}
+
+	case "skip_phase":
+		nextPhase, duration, err := student.AdvanceSessionPhase(req.SessionID, adminID, student.ActorAdmin)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"error": "No active timer found"})
+				return
+			}
+			student.WriteTransitionError(w, err, "Failed to skip phase")
+			return
+		}
+		response["next_phase"] = nextPhase
+		response["duration_seconds"] = duration
+
+	case "kick":
+		if req.StudentID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "student_id is required"})
+			return
+		}
+		if err := kickParticipant(req.SessionID, req.StudentID); err != nil {
+			if err == sql.ErrNoRows {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Student is not in this session"})
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error removing participant", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to remove participant"})
+			return
+		}
+
+	case "cancel":
+		changes, err := cancelSession(r, req.SessionID, adminID, req.Reason, false)
+		if err != nil {
+			student.WriteTransitionError(w, err, "Failed to cancel session")
+			return
+		}
+		response["students"] = changes
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "action must be pause, resume, extend, assign_moderator, skip_phase, kick or cancel"})
+		return
+	}
+
+	// cancelSession records its own entry
+	if req.Action != "cancel" {
+		fields := map[string]interface{}{}
+		if req.StudentID != "" {
+			fields["student_id"] = req.StudentID
+		}
+		if req.Seconds != 0 {
+			fields["seconds"] = req.Seconds
+		}
+		if req.Reason != "" {
+			fields["reason"] = req.Reason
+		}
+		audit.Log(r.Context(), audit.Entry{
+			Action: "session.live." + req.Action, EntityType: "session", EntityID: req.SessionID,
+			Before: before, After: withAuditFields(auditSnapshot(r, sessionAuditQuery, req.SessionID), fields),
+		})
+	}
+	slog.InfoContext(r.Context(), "Admin applied live session action", "action", req.Action, "session_id", req.SessionID)
+	liveSessionsHub.notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// kickParticipant removes a student from a session, freeing their seat
+// and QR code use. It returns sql.ErrNoRows if they are not in it.
+func kickParticipant(sessionID, studentID string) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var present int
+	if err := tx.QueryRow(`
+        SELECT 1 FROM session_participants
+        WHERE session_id = ? AND student_id = ?
+        LIMIT 1 FOR UPDATE`,
+		sessionID, studentID).Scan(&present); err != nil {
+		return err
+	}
+
+	if err := student.ReleaseSeat(tx, sessionID, studentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE gd_sessions SET moderator_id = NULL
+        WHERE id = ? AND moderator_id = ?`,
+		sessionID, studentID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTimerEvents lists the pause, resume and extend log for a session.
+func GetTimerEvents(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id is required"})
+		return
+	}
+
+	rows, err := database.GetDB().Query(`
+        SELECT phase, action, seconds, COALESCE(reason, ''), actor_id, actor_role, created_at
+        FROM session_timer_events
+        WHERE session_id = ?
+        ORDER BY created_at`, sessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching timer events", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	events := []map[string]interface{}{}
+	for rows.Next() {
+		var phase, action, reason, actorID, actorRole, createdAt string
+		var seconds int
+		if err := rows.Scan(&phase, &action, &seconds, &reason, &actorID, &actorRole, &createdAt); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning timer event", "error", err)
+			continue
+		}
+		events = append(events, map[string]interface{}{
+			"phase":      phase,
+			"action":     action,
+			"seconds":    seconds,
+			"reason":     reason,
+			"actor_id":   actorID,
+			"actor_role": actorRole,
+			"created_at": createdAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
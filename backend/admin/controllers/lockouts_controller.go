@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gd/audit"
+	"gd/database"
+	"gd/ratelimit"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// LoginLockout is an account's current run of failed logins.
+type LoginLockout struct {
+	AccountType      string  `json:"account_type"`
+	Account          string  `json:"account"`
+	FailedCount      int     `json:"failed_count"`
+	LastFailedAt     string  `json:"last_failed_at"`
+	LockedUntil      *string `json:"locked_until"`
+	RemainingSeconds int     `json:"remaining_seconds"`
+}
+
+// LoginAttempt is one failed login from login_attempts.
+type LoginAttempt struct {
+	ID          int64   `json:"id"`
+	AccountType string  `json:"account_type"`
+	Account     string  `json:"account"`
+	IP          string  `json:"ip"`
+	Reason      string  `json:"reason"`
+	RequestID   *string `json:"request_id"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+const (
+	defaultLoginAttemptsLimit = 100
+	maxLoginAttemptsLimit     = 500
+)
+
+// GetLoginLockouts lists accounts with recent failed logins, locked ones
+// first. ?locked=true keeps only those locked right now.
+func GetLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	query := `
+        SELECT account_type, account, failed_count, last_failed_at, locked_until,
+               GREATEST(COALESCE(TIMESTAMPDIFF(SECOND, NOW(), locked_until), 0), 0)
+        FROM login_lockouts`
+	if r.URL.Query().Get("locked") == "true" {
+		query += " WHERE locked_until > NOW()"
+	}
+	query += " ORDER BY locked_until > NOW() DESC, last_failed_at DESC"
+
+	rows, err := database.GetDB().Query(query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching login lockouts", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	lockouts := []LoginLockout{}
+	for rows.Next() {
+		var l LoginLockout
+		if err := rows.Scan(&l.AccountType, &l.Account, &l.FailedCount, &l.LastFailedAt,
+			&l.LockedUntil, &l.RemainingSeconds); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning login lockout", "error", err)
+			continue
+		}
+		lockouts = append(lockouts, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
+
+// UnlockAccount lifts a login lockout before it runs out.
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountType string `json:"account_type"`
+		Account     string `json:"account"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if req.AccountType != ratelimit.AdminAccount && req.AccountType != ratelimit.StudentAccount {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "account_type must be admin or student"})
+		return
+	}
+	account := ratelimit.NormalizeAccount(req.Account)
+	if account == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "account is required"})
+		return
+	}
+
+	before := auditSnapshot(r, `
+        SELECT failed_count, last_failed_at, locked_until
+        FROM login_lockouts WHERE account_type = ? AND account = ?`, req.AccountType, account)
+	found, err := ratelimit.Unlock(r.Context(), req.AccountType, account)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error unlocking account", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Account has no failed logins"})
+		return
+	}
+
+	audit.Log(r.Context(), audit.Entry{
+		Action: "login_lockout.unlock", EntityType: "login_lockout",
+		EntityID: req.AccountType + ":" + account, Before: before,
+	})
+
+	adminID := r.Context().Value("userID").(string)
+	slog.InfoContext(r.Context(), "Account unlocked", "account_type", req.AccountType,
+		"account", account, "admin_id", adminID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unlocked"})
+}
+
+// GetLoginAttempts lists failed logins, newest first, optionally for one
+// account_type, account or ip.
+func GetLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultLoginAttemptsLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxLoginAttemptsLimit)
+	}
+
+	query := `
+        SELECT id, account_type, account, ip, reason, request_id, created_at
+        FROM login_attempts WHERE 1 = 1`
+	var args []interface{}
+	if v := q.Get("account_type"); v != "" {
+		query += " AND account_type = ?"
+		args = append(args, v)
+	}
+	if v := q.Get("account"); v != "" {
+		query += " AND account = ?"
+		args = append(args, ratelimit.NormalizeAccount(v))
+	}
+	if v := q.Get("ip"); v != "" {
+		query += " AND ip = ?"
+		args = append(args, v)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching login attempts", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.ID, &a.AccountType, &a.Account, &a.IP, &a.Reason,
+			&a.RequestID, &a.CreatedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning login attempt", "error", err)
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
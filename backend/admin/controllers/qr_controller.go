@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"encoding/json"
-	"fmt"
 	qr "gd/admin/utils"
+	"gd/audit"
+	"gd/config"
 	"gd/database"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -130,8 +132,9 @@ adminID := r.Context().Value("userID").(string)
     }
 
     // Generate new QR code
-    expiresAt := time.Now().Add(240 * time.Minute)
-    qrData, err := qr.GenerateSecureQR(venueID, 240*time.Minute)
+    cfg := config.Get()
+    expiresAt := time.Now().Add(cfg.QRValidity)
+    qrData, err := qr.GenerateSecureQR(venueID, cfg.QRValidity)
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "failed to generate QR code"})
@@ -142,26 +145,32 @@ adminID := r.Context().Value("userID").(string)
     qrGroupID := uuid.New().String()
     qrID := uuid.New().String()
 
-    // Set max capacity to 2 (not 15) - keep 15 commented as requested
-    maxCapacity := 15 // 15 // Keep 15 commented near 2
+    maxCapacity := cfg.QRCapacity
 
-    // Store the new QR code with fixed capacity of 2
+    // Store the new QR code
    _, err = database.GetDB().Exec(`
         INSERT INTO venue_qr_codes 
         (id, venue_id, qr_data, expires_at, is_active, max_capacity, current_usage, qr_group_id, created_by) 
-        VALUES (?, ?, ?, NOW() + INTERVAL 240 MINUTE, TRUE, ?, 0, ?, ?)`, // Added created_by
-        qrID, venueID, qrData, maxCapacity, qrGroupID, adminID) // Added adminID
+        VALUES (?, ?, ?, NOW() + INTERVAL ? SECOND, TRUE, ?, 0, ?, ?)`, // Added created_by
+        qrID, venueID, qrData, int(cfg.QRValidity.Seconds()), maxCapacity, qrGroupID, adminID) // Added adminID
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "failed to store QR code"})
         return
     }
+    audit.Log(r.Context(), audit.Entry{
+        Action: "qr.create", EntityType: "qr", EntityID: qrID,
+        After: map[string]interface{}{
+            "venue_id": venueID, "qr_group_id": qrGroupID, "max_capacity": maxCapacity,
+            "expires_at": expiresAt.Format(time.RFC3339),
+        },
+    })
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
         "success":        true,
         "qr_string":      qrData,
-        "expires_in":     240,
+        "expires_in":     int(cfg.QRValidity.Minutes()),
         "expires_at":     expiresAt.Format(time.RFC3339),
         "qr_id":          qrID,
         "max_capacity":   maxCapacity,
@@ -197,7 +206,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
     }
 
     // Debug logging
-    fmt.Printf("GetQRHistory - venueID: %s, adminID: %s\n", venueID, adminID)
+    slog.DebugContext(r.Context(), "Fetching QR history", "venue_id", venueID, "admin_id", adminID)
 
     // Modified query: Remove created_by filter and handle expires_at as string first
     rows, err := database.GetDB().Query(`
@@ -208,7 +217,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
         venueID)
     
     if err != nil {
-        fmt.Printf("Database error: %v\n", err)
+        slog.ErrorContext(r.Context(), "Error fetching QR history", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]interface{}{
             "success": false,
@@ -236,7 +245,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
         // Scan into byte arrays first
         if err := rows.Scan(&qr.ID, &qr.QRData, &qr.ExpiresAt, &qr.IsActive, 
                           &qr.MaxCapacity, &qr.CurrentUsage, &qr.QRGroupID, &qr.CreatedAt, &qr.CreatedBy); err != nil {
-            fmt.Printf("Row scan error: %v\n", err)
+            slog.ErrorContext(r.Context(), "Error scanning QR code", "error", err)
             continue
         }
 
@@ -253,7 +262,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
         } else if t, err := time.Parse(time.RFC3339, expiresAtStr); err == nil {
             expiresAtTime = t
         } else {
-            fmt.Printf("Could not parse expires_at: %s\n", expiresAtStr)
+            slog.WarnContext(r.Context(), "Could not parse QR expires_at", "value", expiresAtStr)
             expiresAtTime = time.Now() // fallback
         }
         
@@ -263,7 +272,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
         } else if t, err := time.Parse(time.RFC3339, createdAtStr); err == nil {
             createdAtTime = t
         } else {
-            fmt.Printf("Could not parse created_at: %s\n", createdAtStr)
+            slog.WarnContext(r.Context(), "Could not parse QR created_at", "value", createdAtStr)
             createdAtTime = time.Now() // fallback
         }
 
@@ -283,7 +292,7 @@ func GetQRHistory(w http.ResponseWriter, r *http.Request) {
         })
     }
 
-    fmt.Printf("Found %d QR codes for venue %s\n", len(qrCodes), venueID)
+    slog.DebugContext(r.Context(), "Found QR codes", "count", len(qrCodes), "venue_id", venueID)
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3,6 +3,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"gd/audit"
 	"gd/database"
 	"net/http"
 )
@@ -89,7 +90,7 @@ func DeactivateQR(w http.ResponseWriter, r *http.Request) {
     }
 
 
-    _, err := database.GetDB().Exec(`
+    result, err := database.GetDB().Exec(`
         UPDATE venue_qr_codes 
         SET is_active = FALSE 
        WHERE id = ? AND created_by = ?`, 
@@ -100,6 +101,12 @@ func DeactivateQR(w http.ResponseWriter, r *http.Request) {
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to deactivate QR code"})
         return
     }
+    if affected, _ := result.RowsAffected(); affected > 0 {
+        audit.Log(r.Context(), audit.Entry{
+            Action: "qr.deactivate", EntityType: "qr", EntityID: qrID,
+            Before: map[string]bool{"is_active": true}, After: map[string]bool{"is_active": false},
+        })
+    }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]string{"status": "success"})
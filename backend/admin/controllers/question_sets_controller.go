@@ -0,0 +1,448 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	student "gd/student/controllers"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const questionSetTimeLayout = "2006-01-02 15:04:05"
+
+// questionSetAuditQuery reads the audited fields of a question set,
+// including its items.
+const questionSetAuditQuery = `
+    SELECT s.id, s.name, s.level, s.weight_target, s.active_from, s.active_until, s.is_active,
+           (SELECT JSON_ARRAYAGG(JSON_OBJECT('question_id', i.question_id, 'position', i.position, 'weight', i.weight))
+            FROM question_set_items i WHERE i.set_id = s.id) AS items
+    FROM question_sets s WHERE s.id = ?`
+
+type QuestionSet struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Level        int               `json:"level"`
+	WeightTarget float64           `json:"weight_target"`
+	ActiveFrom   *string           `json:"active_from"`
+	ActiveUntil  *string           `json:"active_until"`
+	IsActive     bool              `json:"is_active"`
+	Items        []QuestionSetItem `json:"items"`
+}
+
+// QuestionSetItem places a question in a set. Weight overrides the
+// question's own weight for this set when given.
+type QuestionSetItem struct {
+	QuestionID string   `json:"question_id"`
+	Position   int      `json:"position"`
+	Weight     *float64 `json:"weight,omitempty"`
+	Text       string   `json:"text,omitempty"`
+}
+
+func GetQuestionSets(w http.ResponseWriter, r *http.Request) {
+	query := `
+        SELECT id, name, level, weight_target, active_from, active_until, is_active
+        FROM question_sets`
+	var args []interface{}
+	if level := r.URL.Query().Get("level"); level != "" {
+		query += " WHERE level = ?"
+		args = append(args, level)
+	}
+	query += " ORDER BY level, name"
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching question sets", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	sets := []QuestionSet{}
+	for rows.Next() {
+		s, err := scanQuestionSet(rows)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning question set", "error", err)
+			continue
+		}
+		sets = append(sets, s)
+	}
+	rows.Close()
+
+	for i := range sets {
+		items, err := loadQuestionSetItems(sets[i].ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error loading items for question set", "question_set_id", sets[i].ID, "error", err)
+		}
+		sets[i].Items = items
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sets)
+}
+
+func CreateQuestionSet(w http.ResponseWriter, r *http.Request) {
+	var set QuestionSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request"})
+		return
+	}
+	set.ID = uuid.New().String()
+	saveQuestionSet(w, r, &set, false)
+}
+
+func UpdateQuestionSet(w http.ResponseWriter, r *http.Request) {
+	var set QuestionSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request"})
+		return
+	}
+	if set.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+	saveQuestionSet(w, r, &set, true)
+}
+
+func saveQuestionSet(w http.ResponseWriter, r *http.Request, set *QuestionSet, update bool) {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := validateQuestionSet(tx, set); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	var before map[string]interface{}
+	if update {
+		before = auditSnapshotIn(r, tx, questionSetAuditQuery, set.ID)
+		result, err := tx.Exec(`
+            UPDATE question_sets
+            SET name = ?, level = ?, weight_target = ?, active_from = ?, active_until = ?, is_active = ?
+            WHERE id = ?`,
+			set.Name, set.Level, set.WeightTarget, set.ActiveFrom, set.ActiveUntil, set.IsActive, set.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error updating question set", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update question set"})
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			var exists bool
+			tx.QueryRow("SELECT EXISTS(SELECT 1 FROM question_sets WHERE id = ?)", set.ID).Scan(&exists)
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Question set not found"})
+				return
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM question_set_items WHERE set_id = ?", set.ID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update question set"})
+			return
+		}
+	} else {
+		_, err := tx.Exec(`
+            INSERT INTO question_sets
+            (id, name, level, weight_target, active_from, active_until, is_active, created_by)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			set.ID, set.Name, set.Level, set.WeightTarget, set.ActiveFrom, set.ActiveUntil, set.IsActive,
+			r.Context().Value("userID").(string))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating question set", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create question set"})
+			return
+		}
+	}
+
+	for _, item := range set.Items {
+		_, err := tx.Exec(`
+            INSERT INTO question_set_items (set_id, question_id, position, weight)
+            VALUES (?, ?, ?, ?)`,
+			set.ID, item.QuestionID, item.Position, item.Weight)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving question set item", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question set"})
+			return
+		}
+	}
+
+	action := "question_set.create"
+	if update {
+		action = "question_set.update"
+	}
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: action, EntityType: "question_set", EntityID: set.ID,
+		Before: before, After: auditSnapshotIn(r, tx, questionSetAuditQuery, set.ID),
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question set"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question set"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !update {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func DeleteQuestionSet(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id is required"})
+		return
+	}
+
+	before := auditSnapshot(r, questionSetAuditQuery, id)
+	result, err := database.GetDB().Exec("DELETE FROM question_sets WHERE id = ?", id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting question set", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete question set"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question set not found"})
+		return
+	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "question_set.delete", EntityType: "question_set", EntityID: id,
+		Before: before,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// PreviewQuestionSet shows the questions a student at a level gets right
+// now, in that student's shuffled order when student_id is given.
+func PreviewQuestionSet(w http.ResponseWriter, r *http.Request) {
+	level, err := strconv.Atoi(r.URL.Query().Get("level"))
+	if err != nil || level < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid level"})
+		return
+	}
+	studentID := r.URL.Query().Get("student_id")
+	sessionID := r.URL.Query().Get("session_id")
+
+	questions, setID, err := student.PreviewStudentQuestions(database.GetDB(), level, studentID, sessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error previewing questions", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"level":      level,
+		"student_id": studentID,
+		"session_id": sessionID,
+		"source":     "level_questions",
+		"questions":  questions,
+	}
+	if setID != "" {
+		set, err := scanQuestionSet(database.GetDB().QueryRow(`
+            SELECT id, name, level, weight_target, active_from, active_until, is_active
+            FROM question_sets WHERE id = ?`, setID))
+		if err == nil {
+			response["source"] = "question_set"
+			response["question_set"] = set
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// validateQuestionSet checks a set before it is saved: its questions exist,
+// their effective weights add up to weight_target, and an active set's
+// window does not overlap another active set at the same level. Items are
+// renumbered in the order given.
+func validateQuestionSet(tx *sql.Tx, set *QuestionSet) error {
+	if set.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if set.Level < 1 || set.Level > 5 {
+		return fmt.Errorf("level must be between 1 and 5")
+	}
+	if set.WeightTarget <= 0 {
+		return fmt.Errorf("weight_target must be greater than 0")
+	}
+	if len(set.Items) == 0 {
+		return fmt.Errorf("at least one question is required")
+	}
+
+	from, err := parseQuestionSetTime(set.ActiveFrom)
+	if err != nil {
+		return fmt.Errorf("active_from: %v", err)
+	}
+	until, err := parseQuestionSetTime(set.ActiveUntil)
+	if err != nil {
+		return fmt.Errorf("active_until: %v", err)
+	}
+	if from != nil && until != nil && !until.After(*from) {
+		return fmt.Errorf("active_until must be after active_from")
+	}
+	set.ActiveFrom, set.ActiveUntil = formatQuestionSetTime(from), formatQuestionSetTime(until)
+
+	seen := make(map[string]bool)
+	total := 0.0
+	for i := range set.Items {
+		item := &set.Items[i]
+		if seen[item.QuestionID] {
+			return fmt.Errorf("question %s appears more than once", item.QuestionID)
+		}
+		seen[item.QuestionID] = true
+
+		var weight float64
+		err := tx.QueryRow(`
+            SELECT question_text, weight FROM survey_questions
+            WHERE id = ? AND deleted_at IS NULL`, item.QuestionID).Scan(&item.Text, &weight)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("question %s not found", item.QuestionID)
+		}
+		if err != nil {
+			return err
+		}
+		if item.Weight != nil {
+			if *item.Weight <= 0 {
+				return fmt.Errorf("question %s has a non-positive weight", item.QuestionID)
+			}
+			weight = *item.Weight
+		}
+		total += weight
+		item.Position = i + 1
+	}
+	if math.Abs(total-set.WeightTarget) > 0.05 {
+		return fmt.Errorf("weights add up to %.1f but weight_target is %.1f", total, set.WeightTarget)
+	}
+
+	if !set.IsActive {
+		return nil
+	}
+	rows, err := tx.Query(`
+        SELECT name, active_from, active_until FROM question_sets
+        WHERE level = ? AND is_active = TRUE AND id <> ?`, set.Level, set.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var otherFrom, otherUntil sql.NullString
+		if err := rows.Scan(&name, &otherFrom, &otherUntil); err != nil {
+			return err
+		}
+		of, _ := parseQuestionSetTime(nullStringPtr(otherFrom))
+		ou, _ := parseQuestionSetTime(nullStringPtr(otherUntil))
+		if windowsOverlap(from, until, of, ou) {
+			return fmt.Errorf("active window overlaps question set %q for level %d", name, set.Level)
+		}
+	}
+	return rows.Err()
+}
+
+// windowsOverlap treats a nil start or end as unbounded.
+func windowsOverlap(aFrom, aUntil, bFrom, bUntil *time.Time) bool {
+	startsBeforeBEnds := aFrom == nil || bUntil == nil || aFrom.Before(*bUntil)
+	bStartsBeforeAEnds := bFrom == nil || aUntil == nil || bFrom.Before(*aUntil)
+	return startsBeforeBEnds && bStartsBeforeAEnds
+}
+
+func parseQuestionSetTime(value *string) (*time.Time, error) {
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation(questionSetTimeLayout, *value, time.Local); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil, fmt.Errorf("use YYYY-MM-DD HH:MM:SS or RFC3339")
+	}
+	t = t.In(time.Local)
+	return &t, nil
+}
+
+func formatQuestionSetTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(questionSetTimeLayout)
+	return &s
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanQuestionSet(row rowScanner) (QuestionSet, error) {
+	var s QuestionSet
+	var from, until sql.NullString
+	err := row.Scan(&s.ID, &s.Name, &s.Level, &s.WeightTarget, &from, &until, &s.IsActive)
+	s.ActiveFrom, s.ActiveUntil = nullStringPtr(from), nullStringPtr(until)
+	return s, err
+}
+
+func loadQuestionSetItems(setID string) ([]QuestionSetItem, error) {
+	rows, err := database.GetDB().Query(`
+        SELECT i.question_id, i.position, i.weight, q.question_text
+        FROM question_set_items i
+        JOIN survey_questions q ON q.id = i.question_id
+        WHERE i.set_id = ?
+        ORDER BY i.position`, setID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []QuestionSetItem{}
+	for rows.Next() {
+		var item QuestionSetItem
+		var weight sql.NullFloat64
+		if err := rows.Scan(&item.QuestionID, &item.Position, &weight, &item.Text); err != nil {
+			return nil, err
+		}
+		if weight.Valid {
+			item.Weight = &weight.Float64
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
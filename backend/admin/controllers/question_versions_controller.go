@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+type QuestionVersion struct {
+	Version   int     `json:"version"`
+	Text      string  `json:"text"`
+	Weight    float64 `json:"weight"`
+	Levels    []int   `json:"levels"`
+	IsActive  bool    `json:"is_active"`
+	CreatedBy *string `json:"created_by"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type QuestionFieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// recordQuestionVersion stores the question's current state as its next
+// version and returns the new version number.
+func recordQuestionVersion(tx *sql.Tx, questionID, adminID string) (int, error) {
+	var text string
+	var weight float64
+	var isActive bool
+	err := tx.QueryRow(`
+        SELECT question_text, weight, is_active FROM survey_questions
+        WHERE id = ?
+        FOR UPDATE`, questionID).Scan(&text, &weight, &isActive)
+	if err != nil {
+		return 0, err
+	}
+
+	levels, err := questionLevels(tx, questionID)
+	if err != nil {
+		return 0, err
+	}
+	levelsJSON, _ := json.Marshal(levels)
+
+	var version int
+	err = tx.QueryRow(`
+        SELECT COALESCE(MAX(version), 0) + 1 FROM question_versions
+        WHERE question_id = ?`, questionID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO question_versions
+        (id, question_id, version, question_text, weight, levels, is_active, created_by)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), questionID, version, text, weight, levelsJSON, isActive, adminID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE survey_questions SET current_version = ? WHERE id = ?", version, questionID)
+	return version, err
+}
+
+func questionLevels(tx *sql.Tx, questionID string) ([]int, error) {
+	rows, err := tx.Query("SELECT level FROM question_levels WHERE question_id = ? ORDER BY level", questionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := []int{}
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return levels, rows.Err()
+}
+
+func GetQuestionVersions(w http.ResponseWriter, r *http.Request) {
+	questionID := r.URL.Query().Get("id")
+	if questionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question ID is required"})
+		return
+	}
+
+	versions, err := loadQuestionVersions(questionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching question versions", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if len(versions) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetQuestionDiff compares two versions of a question. Without from/to it
+// compares the latest version with the one before it.
+func GetQuestionDiff(w http.ResponseWriter, r *http.Request) {
+	questionID := r.URL.Query().Get("id")
+	if questionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question ID is required"})
+		return
+	}
+
+	versions, err := loadQuestionVersions(questionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching question versions", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if len(versions) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question not found"})
+		return
+	}
+
+	byNumber := make(map[int]QuestionVersion)
+	for _, v := range versions {
+		byNumber[v.Version] = v
+	}
+	to := versions[len(versions)-1].Version
+	if v, err := strconv.Atoi(r.URL.Query().Get("to")); err == nil {
+		to = v
+	}
+	from := to - 1
+	if v, err := strconv.Atoi(r.URL.Query().Get("from")); err == nil {
+		from = v
+	}
+
+	toVersion, okTo := byNumber[to]
+	fromVersion, okFrom := byNumber[from]
+	if !okTo || !okFrom {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Version not found"})
+		return
+	}
+
+	changes := []QuestionFieldChange{}
+	if fromVersion.Text != toVersion.Text {
+		changes = append(changes, QuestionFieldChange{"text", fromVersion.Text, toVersion.Text})
+	}
+	if fromVersion.Weight != toVersion.Weight {
+		changes = append(changes, QuestionFieldChange{"weight", fromVersion.Weight, toVersion.Weight})
+	}
+	if !reflect.DeepEqual(fromVersion.Levels, toVersion.Levels) {
+		changes = append(changes, QuestionFieldChange{"levels", fromVersion.Levels, toVersion.Levels})
+	}
+	if fromVersion.IsActive != toVersion.IsActive {
+		changes = append(changes, QuestionFieldChange{"is_active", fromVersion.IsActive, toVersion.IsActive})
+	}
+
+	var sessionsUsingFrom, sessionsUsingTo int
+	database.GetDB().QueryRow(`
+        SELECT COUNT(DISTINCT CASE WHEN question_version = ? THEN session_id END),
+               COUNT(DISTINCT CASE WHEN question_version = ? THEN session_id END)
+        FROM session_question_snapshots WHERE question_id = ?`,
+		from, to, questionID).Scan(&sessionsUsingFrom, &sessionsUsingTo)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"question_id":         questionID,
+		"from":                fromVersion,
+		"to":                  toVersion,
+		"changes":             changes,
+		"sessions_using_from": sessionsUsingFrom,
+		"sessions_using_to":   sessionsUsingTo,
+	})
+}
+
+func loadQuestionVersions(questionID string) ([]QuestionVersion, error) {
+	rows, err := database.GetDB().Query(`
+        SELECT version, question_text, weight, levels, is_active, created_by, created_at
+        FROM question_versions
+        WHERE question_id = ?
+        ORDER BY version`, questionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []QuestionVersion
+	for rows.Next() {
+		var v QuestionVersion
+		var levelsJSON []byte
+		var createdBy sql.NullString
+		if err := rows.Scan(&v.Version, &v.Text, &v.Weight, &levelsJSON, &v.IsActive, &createdBy, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		v.Levels = []int{}
+		if len(levelsJSON) > 0 {
+			json.Unmarshal(levelsJSON, &v.Levels)
+		}
+		sort.Ints(v.Levels)
+		if createdBy.Valid {
+			v.CreatedBy = &createdBy.String
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
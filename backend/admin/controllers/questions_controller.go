@@ -4,8 +4,9 @@ import (
 	// "database/sql"
 	"database/sql"
 	"encoding/json"
+	"gd/audit"
 	"gd/database"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,6 +16,13 @@ import (
 	"github.com/google/uuid"
 )
 
+// questionAuditQuery reads the audited fields of a question.
+const questionAuditQuery = `
+	SELECT q.id, q.question_text, q.weight, q.is_active, q.current_version, q.deleted_at,
+	       (SELECT GROUP_CONCAT(ql.level ORDER BY ql.level) FROM question_levels ql
+	        WHERE ql.question_id = q.id) AS levels
+	FROM survey_questions q WHERE q.id = ?`
+
 type Question struct {
 	ID      string  `json:"id"`
 	Text    string  `json:"text"`
@@ -35,14 +43,16 @@ func GetQuestions(w http.ResponseWriter, r *http.Request) {
             q.question_text,
             q.weight,
             q.is_active,
+            q.current_version,
             GROUP_CONCAT(ql.level) as levels
         FROM survey_questions q
         LEFT JOIN question_levels ql ON q.id = ql.question_id
+        WHERE q.deleted_at IS NULL
         GROUP BY q.id
         ORDER BY q.created_at DESC`)
     
     if err != nil {
-        log.Printf("Database error: %v", err)
+        slog.ErrorContext(r.Context(), "Database error", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -54,6 +64,7 @@ func GetQuestions(w http.ResponseWriter, r *http.Request) {
         Text     string  `json:"text"`
         Weight   float32 `json:"weight"`
         IsActive bool    `json:"is_active"`
+        Version  int     `json:"version"`
         Levels   []int   `json:"levels"`
     }
 
@@ -61,8 +72,8 @@ func GetQuestions(w http.ResponseWriter, r *http.Request) {
     for rows.Next() {
         var q Question
         var levelsStr sql.NullString
-        if err := rows.Scan(&q.ID, &q.Text, &q.Weight, &q.IsActive, &levelsStr); err != nil {
-            log.Printf("Error scanning question: %v", err)
+        if err := rows.Scan(&q.ID, &q.Text, &q.Weight, &q.IsActive, &q.Version, &levelsStr); err != nil {
+            slog.ErrorContext(r.Context(), "Error scanning question", "error", err)
             continue
         }
         
@@ -81,7 +92,7 @@ func GetQuestions(w http.ResponseWriter, r *http.Request) {
 
     w.Header().Set("Content-Type", "application/json")
     if err := json.NewEncoder(w).Encode(questions); err != nil {
-        log.Printf("Error encoding response: %v", err)
+        slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
     }
 }
 
@@ -131,6 +142,23 @@ func CreateQuestion(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if _, err := recordQuestionVersion(tx, questionID, r.Context().Value("userID").(string)); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording question version", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question"})
+		return
+	}
+
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: "question.create", EntityType: "question", EntityID: questionID,
+		After: auditSnapshotIn(r, tx, questionAuditQuery, questionID),
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question"})
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save question"})
@@ -173,6 +201,17 @@ func UpdateQuestion(w http.ResponseWriter, r *http.Request) {
     }
     defer tx.Rollback()
 
+    var exists bool
+    tx.QueryRow(`
+        SELECT EXISTS(SELECT 1 FROM survey_questions WHERE id = ? AND deleted_at IS NULL)`,
+        req.ID).Scan(&exists)
+    if !exists {
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Question not found"})
+        return
+    }
+    before := auditSnapshotIn(r, tx, questionAuditQuery, req.ID)
+
     // Update question fields if provided
     if req.Text != nil || req.Weight != nil || req.Active != nil {
         query := "UPDATE survey_questions SET "
@@ -226,6 +265,25 @@ func UpdateQuestion(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    // Each edit becomes a new version; sessions keep the version they snapshotted
+    version, err := recordQuestionVersion(tx, req.ID, r.Context().Value("userID").(string))
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error recording question version", "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update question"})
+        return
+    }
+
+    if err := audit.Record(r.Context(), tx, audit.Entry{
+        Action: "question.update", EntityType: "question", EntityID: req.ID,
+        Before: before, After: auditSnapshotIn(r, tx, questionAuditQuery, req.ID),
+    }); err != nil {
+        slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update question"})
+        return
+    }
+
     if err := tx.Commit(); err != nil {
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update question"})
@@ -233,9 +291,11 @@ func UpdateQuestion(w http.ResponseWriter, r *http.Request) {
     }
 
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+    json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "version": version})
 }
 
+// DeleteQuestion retires a question rather than removing it, so versions
+// and session snapshots that refer to it stay meaningful.
 func DeleteQuestion(w http.ResponseWriter, r *http.Request) {
 	questionID := r.URL.Query().Get("id")
 	if questionID == "" {
@@ -244,13 +304,52 @@ func DeleteQuestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := database.GetDB().Exec("DELETE FROM survey_questions WHERE id = ?", questionID)
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	before := auditSnapshotIn(r, tx, questionAuditQuery, questionID)
+	result, err := tx.Exec(`
+		UPDATE survey_questions SET is_active = FALSE, deleted_at = NOW()
+		WHERE id = ? AND deleted_at IS NULL`, questionID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete question"})
 		return
 	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Question not found"})
+		return
+	}
+
+	if _, err := recordQuestionVersion(tx, questionID, r.Context().Value("userID").(string)); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording question version", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete question"})
+		return
+	}
+
+	if err := audit.Record(r.Context(), tx, audit.Entry{
+		Action: "question.delete", EntityType: "question", EntityID: questionID,
+		Before: before, After: auditSnapshotIn(r, tx, questionAuditQuery, questionID),
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete question"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete question"})
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
\ No newline at end of file
+}
@@ -2,8 +2,9 @@ package controllers
 
 import (
 	"encoding/json"
+	"gd/audit"
 	"gd/database"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -17,8 +18,18 @@ type RankingPointsConfig struct {
 	ThirdPlacePoints  float64 `json:"third_place_points"`
 	Level             int     `json:"level"`
 	IsActive          bool    `json:"is_active"`
+
+	// Optional scoring factor for time spent holding the floor
+	SpeakingPointsPerMinute float64 `json:"speaking_points_per_minute"`
+	SpeakingPointsCap       float64 `json:"speaking_points_cap"`
 }
 
+// rankingPointsAuditQuery reads the audited fields of a points configuration.
+const rankingPointsAuditQuery = `
+	SELECT id, first_place_points, second_place_points, third_place_points,
+	       speaking_points_per_minute, speaking_points_cap, level, is_active
+	FROM ranking_points_config WHERE id = ?`
+
 // Get all configurations or specific level
 func GetRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 	levelStr := r.URL.Query().Get("level")
@@ -29,7 +40,7 @@ func GetRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 
 	if id != "" {
 		// Get specific config by ID
-		query = "SELECT id, first_place_points, second_place_points, third_place_points, level, is_active FROM ranking_points_config WHERE id = ?"
+		query = "SELECT id, first_place_points, second_place_points, third_place_points, speaking_points_per_minute, speaking_points_cap, level, is_active FROM ranking_points_config WHERE id = ?"
 		args = []interface{}{id}
 	} else if levelStr != "" {
 		// Get config for specific level
@@ -39,16 +50,16 @@ func GetRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid level"})
 			return
 		}
-		query = "SELECT id, first_place_points, second_place_points, third_place_points, level, is_active FROM ranking_points_config WHERE level = ? ORDER BY created_at DESC"
+		query = "SELECT id, first_place_points, second_place_points, third_place_points, speaking_points_per_minute, speaking_points_cap, level, is_active FROM ranking_points_config WHERE level = ? ORDER BY created_at DESC"
 		args = []interface{}{level}
 	} else {
 		// Get all configurations
-		query = "SELECT id, first_place_points, second_place_points, third_place_points, level, is_active FROM ranking_points_config ORDER BY level, created_at DESC"
+		query = "SELECT id, first_place_points, second_place_points, third_place_points, speaking_points_per_minute, speaking_points_cap, level, is_active FROM ranking_points_config ORDER BY level, created_at DESC"
 	}
 
 	rows, err := database.GetDB().Query(query, args...)
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
@@ -58,8 +69,8 @@ func GetRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 	var configs []RankingPointsConfig
 	for rows.Next() {
 		var config RankingPointsConfig
-		if err := rows.Scan(&config.ID, &config.FirstPlacePoints, &config.SecondPlacePoints, &config.ThirdPlacePoints, &config.Level, &config.IsActive); err != nil {
-			log.Printf("Error scanning config: %v", err)
+		if err := rows.Scan(&config.ID, &config.FirstPlacePoints, &config.SecondPlacePoints, &config.ThirdPlacePoints, &config.SpeakingPointsPerMinute, &config.SpeakingPointsCap, &config.Level, &config.IsActive); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning config", "error", err)
 			continue
 		}
 		configs = append(configs, config)
@@ -91,32 +102,50 @@ func UpdateRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if config.SpeakingPointsPerMinute < 0 || config.SpeakingPointsCap < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Speaking points cannot be negative"})
+		return
+	}
+
 	userID := r.Context().Value("userID").(string)
 	var err error
+	var before map[string]interface{}
+	action := "ranking_points.update"
 
 	if config.ID == "" {
 		// Create new config
 		config.ID = uuid.New().String()
+		action = "ranking_points.create"
 		_, err = database.GetDB().Exec(`
 			INSERT INTO ranking_points_config 
-			(id, first_place_points, second_place_points, third_place_points, level, is_active, created_by)
-			VALUES (?, ?, ?, ?, ?, TRUE, ?)`,
-			config.ID, config.FirstPlacePoints, config.SecondPlacePoints, config.ThirdPlacePoints, config.Level, userID)
+			(id, first_place_points, second_place_points, third_place_points,
+			 speaking_points_per_minute, speaking_points_cap, level, is_active, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, TRUE, ?)`,
+			config.ID, config.FirstPlacePoints, config.SecondPlacePoints, config.ThirdPlacePoints,
+			config.SpeakingPointsPerMinute, config.SpeakingPointsCap, config.Level, userID)
 	} else {
 		// Update existing config
+		before = auditSnapshot(r, rankingPointsAuditQuery, config.ID)
 		_, err = database.GetDB().Exec(`
 			UPDATE ranking_points_config 
-			SET first_place_points = ?, second_place_points = ?, third_place_points = ?, level = ?, updated_at = NOW()
+			SET first_place_points = ?, second_place_points = ?, third_place_points = ?,
+			    speaking_points_per_minute = ?, speaking_points_cap = ?, level = ?, updated_at = NOW()
 			WHERE id = ?`,
-			config.FirstPlacePoints, config.SecondPlacePoints, config.ThirdPlacePoints, config.Level, config.ID)
+			config.FirstPlacePoints, config.SecondPlacePoints, config.ThirdPlacePoints,
+			config.SpeakingPointsPerMinute, config.SpeakingPointsCap, config.Level, config.ID)
 	}
 
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save configuration"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: action, EntityType: "ranking_points", EntityID: config.ID,
+		Before: before, After: auditSnapshot(r, rankingPointsAuditQuery, config.ID),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -142,7 +171,7 @@ func DeleteRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 	).Scan(&exists)
 
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
@@ -154,14 +183,20 @@ func DeleteRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(r, rankingPointsAuditQuery, id)
+
 	// Delete the configuration
 	_, err = database.GetDB().Exec("DELETE FROM ranking_points_config WHERE id = ?", id)
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete configuration"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "ranking_points.delete", EntityType: "ranking_points", EntityID: id,
+		Before: before,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -183,7 +218,7 @@ func ToggleRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 	).Scan(&isActive)
 
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
@@ -196,11 +231,15 @@ func ToggleRankingPointsConfig(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(r.Context(), "Database error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update configuration"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "ranking_points.toggle", EntityType: "ranking_points", EntityID: id,
+		Before: map[string]bool{"is_active": isActive}, After: map[string]bool{"is_active": !isActive},
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
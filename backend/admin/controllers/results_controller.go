@@ -4,7 +4,7 @@ import (
 	// "database/sql"
 	"encoding/json"
 	"gd/database"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strconv"
@@ -62,7 +62,7 @@ query := `
 
     rows, err := database.GetDB().Query(query)
     if err != nil {
-        log.Printf("Error fetching top participants: %v", err)
+        slog.ErrorContext(r.Context(), "Error fetching top participants", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -87,23 +87,23 @@ type TopParticipant struct {
 
     var allResults []TopParticipant
     for rows.Next() {
-       var r TopParticipant
+       var p TopParticipant
     var dateStr string
-    if err := rows.Scan(&r.SessionID, &r.SessionLevel, &r.ID, &r.Name, 
-        &r.StudentLevel, &r.SessionCount, &r.TotalScore, &r.TotalPenalty, 
-        &r.FinalScore, &r.AvgScore, &dateStr, &r.VenueName, &r.FormattedTime); err != nil {
-        log.Printf("Error scanning result: %v", err)
+    if err := rows.Scan(&p.SessionID, &p.SessionLevel, &p.ID, &p.Name, 
+        &p.StudentLevel, &p.SessionCount, &p.TotalScore, &p.TotalPenalty, 
+        &p.FinalScore, &p.AvgScore, &dateStr, &p.VenueName, &p.FormattedTime); err != nil {
+        slog.ErrorContext(r.Context(), "Error scanning result", "error", err)
         continue
     }
         
         // Parse the date string
         if parsedTime, err := time.Parse("2006-01-02 15:04:05", dateStr); err == nil {
-            r.SessionDate = parsedTime
+            p.SessionDate = parsedTime
         } else {
-            r.SessionDate = time.Now()
+            p.SessionDate = time.Now()
         }
         
-        allResults = append(allResults, r)
+        allResults = append(allResults, p)
     }
 
     // Group results by session
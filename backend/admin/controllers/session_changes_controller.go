@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/services"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SeatChanges reports what happened to a session's students when it was
+// cancelled or rescheduled.
+type SeatChanges struct {
+	Moved          []string `json:"moved"`
+	Released       []string `json:"released"`
+	PriorityPasses int      `json:"priority_passes"`
+}
+
+var errSessionStarted = errors.New("only sessions that have not started can be rescheduled")
+
+// sessionAuditQuery reads the audited fields of a session, its timer and
+// its students.
+const sessionAuditQuery = `
+    SELECT s.id, s.venue_id, s.level, s.status, s.start_time, s.end_time, s.max_capacity, s.moderator_id,
+           t.phase AS timer_phase, t.phase_index, t.duration_seconds, t.extension_seconds,
+           t.paused_at, t.is_active AS timer_active,
+           (SELECT JSON_ARRAYAGG(sp.student_id) FROM session_participants sp
+            WHERE sp.session_id = s.id AND sp.is_dummy = FALSE) AS participants
+    FROM gd_sessions s
+    LEFT JOIN session_timers t ON t.session_id = s.id
+    WHERE s.id = ?`
+
+// withAuditFields adds fields describing a change to a snapshot, so the
+// entry records why it was made.
+func withAuditFields(snapshot map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	if snapshot == nil {
+		snapshot = map[string]interface{}{}
+	}
+	for k, v := range fields {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// CancelSession cancels a session for a reason. Its students lose their
+// seats and are notified, and with offer_priority they get priority for
+// their next booking.
+func CancelSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID     string `json:"session_id"`
+		Reason        string `json:"reason"`
+		OfferPriority bool   `json:"offer_priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if req.SessionID == "" || req.Reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id and reason are required"})
+		return
+	}
+
+	adminID := r.Context().Value("userID").(string)
+	changes, err := cancelSession(r, req.SessionID, adminID, req.Reason, req.OfferPriority)
+	if err != nil {
+		student.WriteTransitionError(w, err, "Failed to cancel session")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin cancelled session", "session_id", req.SessionID, "released_count", len(changes.Released))
+	liveSessionsHub.notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     services.StatusCancelled,
+		"session_id": req.SessionID,
+		"students":   changes,
+	})
+}
+
+func cancelSession(r *http.Request, sessionID, adminID, reason string, offerPriority bool) (SeatChanges, error) {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return SeatChanges{}, err
+	}
+	defer tx.Rollback()
+
+	before := auditSnapshotIn(r, tx, sessionAuditQuery, sessionID)
+	changes, err := cancelSessionTx(tx, sessionID, adminID, reason, offerPriority)
+	if err != nil {
+		return changes, err
+	}
+	err = audit.Record(r.Context(), tx, audit.Entry{
+		Action: "session.cancel", EntityType: "session", EntityID: sessionID,
+		Before: before,
+		After: withAuditFields(auditSnapshotIn(r, tx, sessionAuditQuery, sessionID), map[string]interface{}{
+			"reason": reason, "priority_passes": changes.PriorityPasses,
+		}),
+	})
+	if err != nil {
+		return changes, err
+	}
+	return changes, tx.Commit()
+}
+
+// cancelSessionTx cancels a session and releases everyone still in it.
+// Students of a session that had already finished its discussion keep
+// their participation but lose their booking.
+func cancelSessionTx(tx *sql.Tx, sessionID, adminID, reason string, offerPriority bool) (SeatChanges, error) {
+	changes := SeatChanges{Moved: []string{}, Released: []string{}}
+
+	var status services.SessionStatus
+	var level int
+	if err := tx.QueryRow(`
+        SELECT status, level FROM gd_sessions WHERE id = ? FOR UPDATE`,
+		sessionID).Scan(&status, &level); err != nil {
+		return changes, err
+	}
+	summary, err := sessionSummary(tx, sessionID)
+	if err != nil {
+		return changes, err
+	}
+	students, err := student.SessionParticipants(tx, sessionID)
+	if err != nil {
+		return changes, err
+	}
+
+	err = student.TransitionSession(tx, sessionID, student.Transition{
+		To: services.StatusCancelled, ActorID: adminID, ActorRole: student.ActorAdmin, Reason: reason})
+	if err != nil {
+		return changes, err
+	}
+	if err := student.StopSessionActivity(tx, sessionID); err != nil {
+		return changes, err
+	}
+
+	message := fmt.Sprintf("Your group discussion at %s was cancelled: %s.", summary, reason)
+	if !status.Open() {
+		for _, studentID := range students {
+			if err := student.Notify(tx, studentID, sessionID, "session_cancelled", message); err != nil {
+				return changes, err
+			}
+		}
+		_, err := tx.Exec("UPDATE student_users SET current_booking = NULL WHERE current_booking = ?", sessionID)
+		return changes, err
+	}
+
+	passes, err := releaseStudents(tx, sessionID, level, students, "session_cancelled", message, reason, offerPriority)
+	if err != nil {
+		return changes, err
+	}
+	changes.Released = students
+	changes.PriorityPasses = passes
+	// Dummy participants go with the session
+	_, err = tx.Exec("DELETE FROM session_participants WHERE session_id = ?", sessionID)
+	return changes, err
+}
+
+// releaseStudents takes students out of a session and tells them why,
+// giving each a priority pass if offered. It returns the number of passes
+// issued.
+func releaseStudents(tx *sql.Tx, sessionID string, level int, students []string,
+	kind, message, reason string, offerPriority bool) (int, error) {
+	if offerPriority {
+		message += " You have priority for your next booking at this level."
+	}
+	passes := 0
+	for _, studentID := range students {
+		if err := student.ReleaseSeat(tx, sessionID, studentID); err != nil {
+			return passes, err
+		}
+		if err := student.Notify(tx, studentID, sessionID, kind, message); err != nil {
+			return passes, err
+		}
+		if offerPriority {
+			if err := student.IssuePriorityPass(tx, studentID, level, sessionID, reason); err != nil {
+				return passes, err
+			}
+			passes++
+		}
+	}
+	return passes, nil
+}
+
+// sessionSummary describes a session for notifications, e.g.
+// "Room 101 on 12 Mar 14:00".
+func sessionSummary(tx *sql.Tx, sessionID string) (string, error) {
+	var summary string
+	err := tx.QueryRow(`
+        SELECT CONCAT(COALESCE(v.name, 'your venue'), ' on ', DATE_FORMAT(s.start_time, '%d %b %H:%i'))
+        FROM gd_sessions s
+        LEFT JOIN venues v ON s.venue_id = v.id
+        WHERE s.id = ?`, sessionID).Scan(&summary)
+	return summary, err
+}
+
+// RescheduleSession moves a session that has not started to another slot,
+// and optionally another venue of the same level. Students move to the new
+// session in the order they joined while seats last; the rest are released
+// as if the session had been cancelled. The old session is cancelled.
+func RescheduleSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID     string    `json:"session_id"`
+		VenueID       string    `json:"venue_id"`
+		StartTime     time.Time `json:"start_time"`
+		EndTime       time.Time `json:"end_time"`
+		Reason        string    `json:"reason"`
+		OfferPriority bool      `json:"offer_priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	if req.SessionID == "" || req.Reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id and reason are required"})
+		return
+	}
+	if !req.StartTime.After(time.Now()) || !req.EndTime.After(req.StartTime) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "start_time must be in the future and before end_time"})
+		return
+	}
+
+	adminID := r.Context().Value("userID").(string)
+	newSessionID, changes, err := rescheduleSession(r, req.SessionID, req.VenueID, req.StartTime, req.EndTime,
+		adminID, req.Reason, req.OfferPriority)
+	var levelErr *services.LevelMismatchError
+	switch {
+	case err == services.ErrVenueNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Venue not found or inactive"})
+		return
+	case errors.As(err, &levelErr):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf(
+			"Venue is for level %d but the session is level %d", levelErr.VenueLevel, levelErr.StudentLevel)})
+		return
+	case err == errSessionStarted:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Only sessions that have not started can be rescheduled"})
+		return
+	case err != nil:
+		student.WriteTransitionError(w, err, "Failed to reschedule session")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin rescheduled session", "session_id", req.SessionID, "new_session_id", newSessionID, "moved_count", len(changes.Moved), "released_count", len(changes.Released))
+	liveSessionsHub.notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "rescheduled",
+		"session_id":     req.SessionID,
+		"new_session_id": newSessionID,
+		"students":       changes,
+	})
+}
+
+func rescheduleSession(r *http.Request, sessionID, venueID string, start, end time.Time, adminID, reason string,
+	offerPriority bool) (string, SeatChanges, error) {
+	changes := SeatChanges{Moved: []string{}, Released: []string{}}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return "", changes, err
+	}
+	defer tx.Rollback()
+
+	var status services.SessionStatus
+	var level, maxCapacity int
+	var oldVenueID sql.NullString
+	if err := tx.QueryRow(`
+        SELECT status, level, venue_id, COALESCE(max_capacity, 0)
+        FROM gd_sessions WHERE id = ? FOR UPDATE`,
+		sessionID).Scan(&status, &level, &oldVenueID, &maxCapacity); err != nil {
+		return "", changes, err
+	}
+	if status != services.StatusScheduled && status != services.StatusLobby {
+		return "", changes, errSessionStarted
+	}
+	before := auditSnapshotIn(r, tx, sessionAuditQuery, sessionID)
+	if venueID == "" {
+		venueID = oldVenueID.String
+	}
+
+	var venueLevel, capacity int
+	err = tx.QueryRow(`
+        SELECT level, capacity FROM venues
+        WHERE id = ? AND is_active = TRUE
+        FOR UPDATE`, venueID).Scan(&venueLevel, &capacity)
+	if err == sql.ErrNoRows {
+		return "", changes, services.ErrVenueNotFound
+	}
+	if err != nil {
+		return "", changes, err
+	}
+	if venueLevel != level {
+		return "", changes, &services.LevelMismatchError{StudentLevel: level, VenueLevel: venueLevel}
+	}
+
+	// Seats at the venue held by other sessions overlapping the new slot
+	var taken int
+	if err := tx.QueryRow(`
+        SELECT COUNT(*)
+        FROM session_participants sp
+        JOIN gd_sessions s ON sp.session_id = s.id
+        WHERE s.venue_id = ? AND s.id <> ? AND s.status IN `+student.OpenStatusesSQL+`
+          AND sp.is_dummy = FALSE
+          AND s.start_time < ? AND s.end_time > ?`,
+		venueID, sessionID, end, start).Scan(&taken); err != nil {
+		return "", changes, err
+	}
+	seats := max(capacity-taken, 0)
+	if maxCapacity > 0 {
+		seats = min(seats, maxCapacity)
+	}
+
+	newSessionID := uuid.New().String()
+	if _, err := tx.Exec(`
+        INSERT INTO gd_sessions
+        (id, topic, venue_id, level, topic_id, topic_pinned, start_time, end_time, agenda, survey_weights,
+         max_capacity, speaking_mode, speaking_turn_cap_seconds, agenda_template_id, status, created_by)
+        SELECT ?, topic, ?, level, topic_id, topic_pinned, ?, ?, agenda, survey_weights,
+               max_capacity, speaking_mode, speaking_turn_cap_seconds, agenda_template_id, 'scheduled', ?
+        FROM gd_sessions WHERE id = ?`,
+		newSessionID, venueID, start, end, adminID, sessionID); err != nil {
+		return "", changes, err
+	}
+	err = student.RecordStatusChange(tx, newSessionID, "", student.Transition{
+		To: services.StatusScheduled, ActorID: adminID, ActorRole: student.ActorAdmin,
+		Reason: "Rescheduled from " + sessionID})
+	if err != nil {
+		return "", changes, err
+	}
+
+	students, err := student.SessionParticipants(tx, sessionID)
+	if err != nil {
+		return "", changes, err
+	}
+	moving := students[:min(seats, len(students))]
+	summary, err := sessionSummary(tx, newSessionID)
+	if err != nil {
+		return "", changes, err
+	}
+	message := fmt.Sprintf("Your group discussion has moved to %s: %s.", summary, reason)
+	for _, studentID := range moving {
+		if err := moveStudent(tx, sessionID, newSessionID, venueID, studentID); err != nil {
+			return "", changes, err
+		}
+		if err := student.Notify(tx, studentID, newSessionID, "session_rescheduled", message); err != nil {
+			return "", changes, err
+		}
+	}
+	changes.Moved = moving
+
+	if overflow := students[len(moving):]; len(overflow) > 0 {
+		oldSummary, err := sessionSummary(tx, sessionID)
+		if err != nil {
+			return "", changes, err
+		}
+		message := fmt.Sprintf("Your group discussion at %s was rescheduled and the new slot is full: %s.",
+			oldSummary, reason)
+		passes, err := releaseStudents(tx, sessionID, level, overflow, "session_cancelled", message, reason, offerPriority)
+		if err != nil {
+			return "", changes, err
+		}
+		changes.Released = overflow
+		changes.PriorityPasses = passes
+	}
+
+	err = student.TransitionSession(tx, sessionID, student.Transition{
+		To: services.StatusCancelled, ActorID: adminID, ActorRole: student.ActorAdmin,
+		Reason: fmt.Sprintf("Rescheduled to %s: %s", newSessionID, reason)})
+	if err != nil {
+		return "", changes, err
+	}
+	if err := student.StopSessionActivity(tx, sessionID); err != nil {
+		return "", changes, err
+	}
+	if _, err := tx.Exec("DELETE FROM session_participants WHERE session_id = ?", sessionID); err != nil {
+		return "", changes, err
+	}
+
+	err = audit.Record(r.Context(), tx, audit.Entry{
+		Action: "session.reschedule", EntityType: "session", EntityID: sessionID,
+		Before: before,
+		After: withAuditFields(auditSnapshotIn(r, tx, sessionAuditQuery, sessionID), map[string]interface{}{
+			"reason": reason, "priority_passes": changes.PriorityPasses,
+			"new_session": auditSnapshotIn(r, tx, sessionAuditQuery, newSessionID),
+		}),
+	})
+	if err != nil {
+		return "", changes, err
+	}
+
+	return newSessionID, changes, tx.Commit()
+}
+
+// moveStudent carries a student's seat, booking and group assignment over
+// to the rescheduled session. Ready flags and phase tracking start afresh.
+func moveStudent(tx *sql.Tx, fromSessionID, toSessionID, venueID, studentID string) error {
+	if _, err := tx.Exec(`
+        UPDATE session_participants SET session_id = ?
+        WHERE session_id = ? AND student_id = ?`,
+		toSessionID, fromSessionID, studentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE session_group_assignments SET session_id = ?, venue_id = ?
+        WHERE session_id = ? AND student_id = ?`,
+		toSessionID, venueID, fromSessionID, studentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE student_users SET current_booking = ?
+        WHERE id = ? AND current_booking = ?`,
+		toSessionID, studentID, fromSessionID); err != nil {
+		return err
+	}
+	for _, table := range []string{"session_ready_status", "session_phase_tracking"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE session_id = ? AND student_id = ?",
+			fromSessionID, studentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
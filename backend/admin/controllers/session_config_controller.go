@@ -4,13 +4,17 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	// "time"
 
+	"gd/audit"
 	"gd/database"
+	"gd/services"
+	student "gd/student/controllers"
 
 	"github.com/google/uuid"
 )
@@ -48,6 +52,7 @@ func CreateBulkSessions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	adminID := r.Context().Value("userID").(string)
 	tx, err := database.GetDB().Begin()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -78,7 +83,7 @@ func CreateBulkSessions(w http.ResponseWriter, r *http.Request) {
 		_, err = tx.Exec(`
 			INSERT INTO gd_sessions 
 			(id, venue_id, level, start_time, end_time, agenda, survey_weights, status) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, 'pending')`,
+			VALUES (?, ?, ?, ?, ?, ?, ?, 'scheduled')`,
 			sessionID,
 			session.VenueID,
 			session.Level,
@@ -94,6 +99,14 @@ func CreateBulkSessions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		err = student.RecordStatusChange(tx, sessionID, "", student.Transition{
+			To: services.StatusScheduled, ActorID: adminID, ActorRole: student.ActorAdmin})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create session"})
+			return
+		}
+
 		createdSessions = append(createdSessions, map[string]interface{}{
 			"id":         sessionID,
 			"venue_id":   session.VenueID,
@@ -116,6 +129,9 @@ func CreateBulkSessions(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// GetSessionRules returns the rules a session runs with: its level's rules
+// from gd_rules, with the session's own agenda times where the level allows
+// overrides.
 func GetSessionRules(w http.ResponseWriter, r *http.Request) {
     sessionID := r.URL.Query().Get("session_id")
     if sessionID == "" {
@@ -124,56 +140,23 @@ func GetSessionRules(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get session details including agenda
-    var agendaJSON []byte
-    err := database.GetDB().QueryRow(`
-        SELECT agenda FROM gd_sessions WHERE id = ?`,
-        sessionID,
-    ).Scan(&agendaJSON)
-
+    rules, err := student.ResolveSessionRules(database.GetDB(), sessionID)
     if err != nil {
         if err == sql.ErrNoRows {
             w.WriteHeader(http.StatusNotFound)
             json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
         } else {
+            slog.ErrorContext(r.Context(), "Error resolving rules for session", "session_id", sessionID, "error", err)
             w.WriteHeader(http.StatusInternalServerError)
             json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         }
         return
     }
 
-    // Parse agenda with defaults
-    var agenda struct {
-        PrepTime   int `json:"prep_time"`
-        Discussion int `json:"discussion"`
-        Survey     int `json:"survey"`
-    }
-    
-    // Set default values
-    agenda.PrepTime = 1
-    agenda.Discussion = 1
-    agenda.Survey = 5
-    
-    if len(agendaJSON) > 0 {
-        if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
-            log.Printf("Error parsing agenda JSON: %v", err)
-            // Use defaults if parsing fails
-        }
-    }
-
-    response := map[string]interface{}{
-        "prep_time":       agenda.PrepTime,
-        "discussion_time": agenda.Discussion,
-        "survey_time":      agenda.Survey,
-    }
-
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(response)
+    json.NewEncoder(w).Encode(rules)
 }
 
-
-
-
 func UpdateSessionRules(w http.ResponseWriter, r *http.Request) {
     var request struct {
         SessionID string `json:"session_id"`
@@ -188,6 +171,27 @@ func UpdateSessionRules(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // A session's own times are only used when its level allows overrides
+    rules, err := student.ResolveSessionRules(database.GetDB(), request.SessionID)
+    if err == sql.ErrNoRows {
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+        return
+    }
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error resolving rules for session", "session_id", request.SessionID, "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+        return
+    }
+    if !rules.AllowOverride {
+        w.WriteHeader(http.StatusConflict)
+        json.NewEncoder(w).Encode(map[string]string{
+            "error": fmt.Sprintf("Level %d rules do not allow per-session overrides", rules.Level),
+        })
+        return
+    }
+
     // Create new agenda
     newAgenda := map[string]int{
         "prep_time":  request.PrepTime,
@@ -205,6 +209,9 @@ func UpdateSessionRules(w http.ResponseWriter, r *http.Request) {
     // Calculate total duration
     totalMinutes := request.PrepTime + request.Discussion + request.Survey
 
+    sessionRulesQuery := "SELECT agenda, end_time FROM gd_sessions WHERE id = ?"
+    before := auditSnapshot(r, sessionRulesQuery, request.SessionID)
+
     // Update session with new agenda and recalculated end time
     _, err = database.GetDB().Exec(`
         UPDATE gd_sessions 
@@ -217,11 +224,15 @@ func UpdateSessionRules(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error updating session rules: %v", err)
+        slog.ErrorContext(r.Context(), "Error updating session rules", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update rules"})
         return
     }
+    audit.Log(r.Context(), audit.Entry{
+        Action: "session.rules_update", EntityType: "session", EntityID: request.SessionID,
+        Before: before, After: auditSnapshot(r, sessionRulesQuery, request.SessionID),
+    })
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -237,7 +248,7 @@ func GetSessions(w http.ResponseWriter, r *http.Request) {
     `)
     
     if err != nil {
-        log.Printf("Database error fetching sessions: %v", err)
+        slog.ErrorContext(r.Context(), "Database error fetching sessions", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -258,14 +269,14 @@ func GetSessions(w http.ResponseWriter, r *http.Request) {
         
         if err := rows.Scan(&session.ID, &session.VenueID, &session.Level, 
             &session.StartTime, &session.EndTime, &session.AgendaJSON, &session.Status); err != nil {
-            log.Printf("Error scanning session row: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning session row", "error", err)
             continue
         }
 
         var agenda map[string]interface{}
         if len(session.AgendaJSON) > 0 {
             if err := json.Unmarshal(session.AgendaJSON, &agenda); err != nil {
-                log.Printf("Error unmarshaling agenda JSON: %v", err)
+                slog.ErrorContext(r.Context(), "Error unmarshaling agenda JSON", "error", err)
                 agenda = map[string]interface{}{
                     "prep_time": 0,
                     "discussion": 0,
@@ -292,7 +303,7 @@ func GetSessions(w http.ResponseWriter, r *http.Request) {
     }
 
     if err := rows.Err(); err != nil {
-        log.Printf("Row iteration error: %v", err)
+        slog.ErrorContext(r.Context(), "Row iteration error", "error", err)
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"encoding/json"
+	"gd/database"
+	"gd/services"
+	student "gd/student/controllers"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// GetSessionStatusHistory returns session status changes. With
+// ?session_id= it returns that session's full history in order; otherwise
+// the latest changes, optionally narrowed by ?status= and ?actor_role=,
+// up to ?limit= (default 100, at most 500).
+func GetSessionStatusHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := student.StatusHistoryFilter{
+		SessionID: query.Get("session_id"),
+		ToStatus:  query.Get("status"),
+		ActorRole: query.Get("actor_role"),
+	}
+
+	if filter.ToStatus != "" && !services.SessionStatus(filter.ToStatus).Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid status"})
+		return
+	}
+	switch filter.ActorRole {
+	case "", student.ActorStudent, student.ActorAdmin, student.ActorSystem:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "actor_role must be student, admin or system"})
+		return
+	}
+
+	if filter.SessionID == "" {
+		filter.Limit = 100
+		if limitStr := query.Get("limit"); limitStr != "" {
+			limit, err := strconv.Atoi(limitStr)
+			if err != nil || limit < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid limit"})
+				return
+			}
+			filter.Limit = min(limit, 500)
+		}
+	}
+
+	history, err := student.SessionStatusHistory(database.GetDB(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching session status history", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,526 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxPrepFieldLength = 2000
+	maxTopicTags       = 10
+	maxImportBytes     = 5 << 20
+)
+
+var topicDifficulties = map[string]bool{"easy": true, "medium": true, "hard": true}
+
+// topicCSVHeader is the column layout used for both CSV import and export.
+// Tags are separated by semicolons inside their cell.
+var topicCSVHeader = []string{
+	"level", "topic_text", "category", "difficulty", "tags",
+	"key_points", "references", "discussion_angles", "is_active",
+}
+
+// PrepMaterials is the fixed shape of gd_topics.prep_materials. Unknown keys
+// are rejected on write so the student screens always know what to render.
+type PrepMaterials struct {
+	KeyPoints        string `json:"key_points"`
+	References       string `json:"references"`
+	DiscussionAngles string `json:"discussion_angles"`
+}
+
+func (p *PrepMaterials) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	type plain PrepMaterials
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode((*plain)(p)); err != nil {
+		return fmt.Errorf("prep_materials: %v", err)
+	}
+	return nil
+}
+
+// parseStoredPrepMaterials reads prep_materials as stored, tolerating extra
+// keys written before the schema was enforced.
+func parseStoredPrepMaterials(data []byte) PrepMaterials {
+	type plain PrepMaterials
+	var p plain
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &p); err != nil {
+			slog.Error("Error parsing prep materials", "error", err)
+		}
+	}
+	return PrepMaterials(p)
+}
+
+func parseStoredTags(data []byte) []string {
+	tags := []string{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tags); err != nil {
+			slog.Error("Error parsing topic tags", "error", err)
+		}
+	}
+	return tags
+}
+
+// validateTopic checks a topic before it is written and normalises its
+// category, difficulty and tags.
+func validateTopic(t *Topic) error {
+	t.TopicText = strings.TrimSpace(t.TopicText)
+	t.Category = strings.TrimSpace(t.Category)
+	t.Difficulty = strings.ToLower(strings.TrimSpace(t.Difficulty))
+
+	if t.Level < 1 || t.Level > 5 {
+		return fmt.Errorf("Level must be between 1 and 5")
+	}
+	if t.TopicText == "" {
+		return fmt.Errorf("Topic text is required")
+	}
+	if utf8.RuneCountInString(t.Category) > 50 {
+		return fmt.Errorf("category must be at most 50 characters")
+	}
+	if t.Difficulty != "" && !topicDifficulties[t.Difficulty] {
+		return fmt.Errorf("difficulty must be easy, medium or hard")
+	}
+
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range t.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > 30 {
+			return fmt.Errorf("tag %q must be at most 30 characters", tag)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTopicTags {
+		return fmt.Errorf("a topic can have at most %d tags", maxTopicTags)
+	}
+	t.Tags = tags
+
+	for name, value := range map[string]string{
+		"key_points":        t.PrepMaterials.KeyPoints,
+		"references":        t.PrepMaterials.References,
+		"discussion_angles": t.PrepMaterials.DiscussionAngles,
+	} {
+		if utf8.RuneCountInString(value) > maxPrepFieldLength {
+			return fmt.Errorf("prep_materials.%s must be at most %d characters", name, maxPrepFieldLength)
+		}
+	}
+	return nil
+}
+
+type topicWriter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// saveTopic inserts a validated topic, or updates it in place when update is
+// set. Updates leave is_active alone.
+func saveTopic(exec topicWriter, t *Topic, update bool) error {
+	prepJSON, err := json.Marshal(t.PrepMaterials)
+	if err != nil {
+		return err
+	}
+	tagsJSON, err := json.Marshal(t.Tags)
+	if err != nil {
+		return err
+	}
+
+	if update {
+		_, err = exec.Exec(`
+		UPDATE gd_topics
+		SET level = ?, topic_text = ?, prep_materials = ?, category = NULLIF(?, ''),
+		    difficulty = NULLIF(?, ''), tags = ?
+		WHERE id = ?`,
+			t.Level, t.TopicText, prepJSON, t.Category, t.Difficulty, tagsJSON, t.ID)
+		return err
+	}
+	_, err = exec.Exec(`
+		INSERT INTO gd_topics (id, level, topic_text, prep_materials, category, difficulty, tags, is_active)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?)`,
+		t.ID, t.Level, t.TopicText, prepJSON, t.Category, t.Difficulty, tagsJSON, t.IsActive)
+	return err
+}
+
+func isDuplicateTopic(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Duplicate entry")
+}
+
+// topicKey mirrors the unique_level_topic index: level plus the first 255
+// characters of the text, compared case-insensitively.
+func topicKey(level int, text string) string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if runes := []rune(text); len(runes) > 255 {
+		text = string(runes[:255])
+	}
+	return strconv.Itoa(level) + "|" + text
+}
+
+type TopicImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importedTopic is one parsed row of an import file.
+type importedTopic struct {
+	Row   int
+	Topic Topic
+}
+
+type TopicImportReport struct {
+	DryRun   bool               `json:"dry_run"`
+	Total    int                `json:"total"`
+	Created  int                `json:"created"`
+	Updated  int                `json:"updated"`
+	Skipped  int                `json:"skipped"`
+	Errors   []TopicImportError `json:"errors"`
+	Imported bool               `json:"imported"`
+}
+
+// ImportTopics loads topics from a CSV or JSON body. Nothing is written if
+// any row fails validation; dry_run=true reports what would happen.
+// on_duplicate decides what to do with topics that already exist: error
+// (default), skip or update.
+func ImportTopics(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	onDuplicate := r.URL.Query().Get("on_duplicate")
+	if onDuplicate == "" {
+		onDuplicate = "error"
+	}
+	if onDuplicate != "error" && onDuplicate != "skip" && onDuplicate != "update" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "on_duplicate must be error, skip or update"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxImportBytes+1))
+	if err != nil || len(body) > maxImportBytes {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Import file is missing or larger than 5MB"})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = "csv"
+		}
+	}
+
+	var topics []importedTopic
+	var errs []TopicImportError
+	switch format {
+	case "csv":
+		topics, errs, err = parseTopicsCSV(body)
+	case "json":
+		topics, errs, err = parseTopicsJSON(body)
+	default:
+		err = fmt.Errorf("format must be csv or json")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	existing, err := loadTopicKeys()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading existing topics", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	report := TopicImportReport{DryRun: dryRun, Total: len(topics) + len(errs), Errors: errs}
+	type plannedWrite struct {
+		topic  Topic
+		update bool
+	}
+	var writes []plannedWrite
+	inFile := make(map[string]int)
+
+	for i := range topics {
+		t, row := &topics[i].Topic, topics[i].Row
+		if err := validateTopic(t); err != nil {
+			report.Errors = append(report.Errors, TopicImportError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		key := topicKey(t.Level, t.TopicText)
+		if first, ok := inFile[key]; ok {
+			report.Errors = append(report.Errors, TopicImportError{
+				Row: row, Error: fmt.Sprintf("duplicates row %d (same level and topic text)", first),
+			})
+			continue
+		}
+		inFile[key] = row
+
+		if id, ok := existing[key]; ok {
+			switch onDuplicate {
+			case "skip":
+				report.Skipped++
+				continue
+			case "update":
+				t.ID = id
+				writes = append(writes, plannedWrite{*t, true})
+				report.Updated++
+				continue
+			default:
+				report.Errors = append(report.Errors, TopicImportError{
+					Row: row, Error: "a topic with this text already exists for this level",
+				})
+				continue
+			}
+		}
+		t.ID = uuid.New().String()
+		writes = append(writes, plannedWrite{*t, false})
+		report.Created++
+	}
+
+	if report.Errors == nil {
+		report.Errors = []TopicImportError{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Errors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	if dryRun {
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, pw := range writes {
+		t := pw.topic
+		var before map[string]interface{}
+		action := "topic.import.create"
+		if pw.update {
+			before = auditSnapshotIn(r, tx, topicAuditQuery, t.ID)
+			action = "topic.import.update"
+		}
+		if err := saveTopic(tx, &t, pw.update); err != nil {
+			slog.ErrorContext(r.Context(), "Error importing topic", "topic_text", t.TopicText, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to import topics"})
+			return
+		}
+		if err := audit.Record(r.Context(), tx, audit.Entry{
+			Action: action, EntityType: "topic", EntityID: t.ID,
+			Before: before, After: auditSnapshotIn(r, tx, topicAuditQuery, t.ID),
+		}); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing audit log", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to import topics"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to import topics"})
+		return
+	}
+
+	report.Imported = true
+	slog.InfoContext(r.Context(), "Imported topics", "created", report.Created, "updated", report.Updated, "skipped", report.Skipped)
+	json.NewEncoder(w).Encode(report)
+}
+
+func loadTopicKeys() (map[string]string, error) {
+	rows, err := database.GetDB().Query("SELECT id, level, topic_text FROM gd_topics")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]string)
+	for rows.Next() {
+		var id, text string
+		var level int
+		if err := rows.Scan(&id, &level, &text); err != nil {
+			return nil, err
+		}
+		keys[topicKey(level, text)] = id
+	}
+	return keys, rows.Err()
+}
+
+// parseTopicsCSV reads rows in topicCSVHeader layout. The header row is
+// required; columns may appear in any order and only level and topic_text
+// are mandatory. Row numbers are 1-based and count the header.
+func parseTopicsCSV(body []byte) ([]importedTopic, []TopicImportError, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("CSV header row is missing")
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"level", "topic_text"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("CSV is missing the %s column", required)
+		}
+	}
+
+	var topics []importedTopic
+	var errs []TopicImportError
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, TopicImportError{Row: row, Error: err.Error()})
+			continue
+		}
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		level, err := strconv.Atoi(cell("level"))
+		if err != nil {
+			errs = append(errs, TopicImportError{Row: row, Error: "level must be a number"})
+			continue
+		}
+		isActive := true
+		if v := cell("is_active"); v != "" {
+			if isActive, err = strconv.ParseBool(v); err != nil {
+				errs = append(errs, TopicImportError{Row: row, Error: "is_active must be true or false"})
+				continue
+			}
+		}
+		var tags []string
+		if v := cell("tags"); v != "" {
+			tags = strings.Split(v, ";")
+		}
+
+		topics = append(topics, importedTopic{Row: row, Topic: Topic{
+			Level:      level,
+			TopicText:  cell("topic_text"),
+			Category:   cell("category"),
+			Difficulty: cell("difficulty"),
+			Tags:       tags,
+			IsActive:   isActive,
+			PrepMaterials: PrepMaterials{
+				KeyPoints:        cell("key_points"),
+				References:       cell("references"),
+				DiscussionAngles: cell("discussion_angles"),
+			},
+		}})
+	}
+	return topics, errs, nil
+}
+
+// parseTopicsJSON reads an array of topics in the same shape GetTopics
+// returns. Row numbers are 1-based array positions.
+func parseTopicsJSON(body []byte) ([]importedTopic, []TopicImportError, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, fmt.Errorf("JSON import must be an array of topics")
+	}
+
+	var topics []importedTopic
+	var errs []TopicImportError
+	for i, item := range raw {
+		t := Topic{IsActive: true}
+		if err := json.Unmarshal(item, &t); err != nil {
+			errs = append(errs, TopicImportError{Row: i + 1, Error: err.Error()})
+			continue
+		}
+		t.Usage = nil
+		topics = append(topics, importedTopic{Row: i + 1, Topic: t})
+	}
+	return topics, errs, nil
+}
+
+// ExportTopics writes the topic bank as CSV or JSON in the layout
+// ImportTopics accepts.
+func ExportTopics(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT id, level, topic_text, prep_materials, COALESCE(category, ''),
+		       COALESCE(difficulty, ''), tags, is_active
+		FROM gd_topics`
+	var args []interface{}
+	if level := r.URL.Query().Get("level"); level != "" {
+		query += " WHERE level = ?"
+		args = append(args, level)
+	}
+	query += " ORDER BY level, topic_text"
+
+	rows, err := database.GetDB().Query(query, args...)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting topics", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export topics"})
+		return
+	}
+	defer rows.Close()
+
+	topics := []Topic{}
+	for rows.Next() {
+		var t Topic
+		var prepJSON, tagsJSON []byte
+		if err := rows.Scan(&t.ID, &t.Level, &t.TopicText, &prepJSON, &t.Category,
+			&t.Difficulty, &tagsJSON, &t.IsActive); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning topic", "error", err)
+			continue
+		}
+		t.PrepMaterials = parseStoredPrepMaterials(prepJSON)
+		t.Tags = parseStoredTags(tagsJSON)
+		topics = append(topics, t)
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="topics.json"`)
+		json.NewEncoder(w).Encode(topics)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="topics.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write(topicCSVHeader)
+	for _, t := range topics {
+		writer.Write([]string{
+			strconv.Itoa(t.Level), t.TopicText, t.Category, t.Difficulty, strings.Join(t.Tags, ";"),
+			t.PrepMaterials.KeyPoints, t.PrepMaterials.References, t.PrepMaterials.DiscussionAngles,
+			strconv.FormatBool(t.IsActive),
+		})
+	}
+	writer.Flush()
+}
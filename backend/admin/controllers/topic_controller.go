@@ -1,12 +1,15 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
+	"gd/audit"
 	"gd/database"
+	"gd/services"
 
 	"github.com/google/uuid"
 )
@@ -15,8 +18,23 @@ type Topic struct {
 	ID           string                 `json:"id"`
 	Level        int                    `json:"level"`
 	TopicText    string                 `json:"topic_text"`
-	PrepMaterials map[string]interface{} `json:"prep_materials"`
+	PrepMaterials PrepMaterials          `json:"prep_materials"`
+	Category     string                 `json:"category,omitempty"`
+	Difficulty   string                 `json:"difficulty,omitempty"`
+	Tags         []string               `json:"tags"`
 	IsActive     bool                   `json:"is_active"`
+	Usage        *TopicUsage            `json:"usage,omitempty"`
+}
+
+// topicAuditQuery reads the audited fields of a topic.
+const topicAuditQuery = `
+	SELECT id, level, topic_text, prep_materials, category, difficulty, tags, is_active
+	FROM gd_topics WHERE id = ?`
+
+type TopicUsage struct {
+	SessionCount int     `json:"session_count"`
+	StudentCount int     `json:"student_count"`
+	LastUsedAt   *string `json:"last_used_at"`
 }
 
 func GetTopics(w http.ResponseWriter, r *http.Request) {
@@ -25,8 +43,17 @@ func GetTopics(w http.ResponseWriter, r *http.Request) {
 	var query string
 	var args []interface{}
 	
+	// Usage counts every session the topic was assigned to and the distinct
+	// students who discussed it
+	query = `
+		SELECT t.id, t.level, t.topic_text, t.prep_materials, COALESCE(t.category, ''),
+		       COALESCE(t.difficulty, ''), t.tags, t.is_active,
+		       COUNT(DISTINCT s.id), COUNT(DISTINCT sp.student_id), MAX(s.start_time)
+		FROM gd_topics t
+		LEFT JOIN gd_sessions s ON s.topic_id = t.id
+		LEFT JOIN session_participants sp ON sp.session_id = s.id AND sp.is_dummy = FALSE`
 	if level != "" {
-		query = "SELECT id, level, topic_text, prep_materials, is_active FROM gd_topics WHERE level = ? ORDER BY level, created_at DESC"
+		query += " WHERE t.level = ?"
 		levelInt, err := strconv.Atoi(level)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -34,13 +61,12 @@ func GetTopics(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		args = []interface{}{levelInt}
-	} else {
-		query = "SELECT id, level, topic_text, prep_materials, is_active FROM gd_topics ORDER BY level, created_at DESC"
 	}
+	query += " GROUP BY t.id ORDER BY t.level, t.created_at DESC"
 
 	rows, err := database.GetDB().Query(query, args...)
 	if err != nil {
-		log.Printf("Error fetching topics: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching topics", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fetch topics"})
 		return
@@ -50,22 +76,24 @@ func GetTopics(w http.ResponseWriter, r *http.Request) {
 	var topics []Topic
 	for rows.Next() {
 		var topic Topic
-		var prepMaterialsJSON []byte
+		var prepMaterialsJSON, tagsJSON []byte
+		var usage TopicUsage
+		var lastUsed sql.NullString
 		
-		if err := rows.Scan(&topic.ID, &topic.Level, &topic.TopicText, &prepMaterialsJSON, &topic.IsActive); err != nil {
-			log.Printf("Error scanning topic: %v", err)
+		if err := rows.Scan(&topic.ID, &topic.Level, &topic.TopicText, &prepMaterialsJSON, &topic.Category,
+			&topic.Difficulty, &tagsJSON, &topic.IsActive,
+			&usage.SessionCount, &usage.StudentCount, &lastUsed); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning topic", "error", err)
 			continue
 		}
 		
-		if len(prepMaterialsJSON) > 0 {
-			if err := json.Unmarshal(prepMaterialsJSON, &topic.PrepMaterials); err != nil {
-				log.Printf("Error parsing prep materials: %v", err)
-				topic.PrepMaterials = make(map[string]interface{})
-			}
-		} else {
-			topic.PrepMaterials = make(map[string]interface{})
-		}
+		topic.PrepMaterials = parseStoredPrepMaterials(prepMaterialsJSON)
+		topic.Tags = parseStoredTags(tagsJSON)
 		
+		if lastUsed.Valid {
+			usage.LastUsedAt = &lastUsed.String
+		}
+		topic.Usage = &usage
 		topics = append(topics, topic)
 	}
 
@@ -77,48 +105,34 @@ func CreateTopic(w http.ResponseWriter, r *http.Request) {
 	var topic Topic
 	if err := json.NewDecoder(r.Body).Decode(&topic); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data"})
-		return
-	}
-
-	if topic.Level < 1 || topic.Level > 5 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Level must be between 1 and 5"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data: " + err.Error()})
 		return
 	}
 
-	if topic.TopicText == "" {
+	if err := validateTopic(&topic); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Topic text is required"})
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
 	topic.ID = uuid.New().String()
-	
-	// Ensure prep_materials is never nil
-	if topic.PrepMaterials == nil {
-		topic.PrepMaterials = make(map[string]interface{})
-	}
-	
-	prepMaterialsJSON, err := json.Marshal(topic.PrepMaterials)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to process preparation materials"})
-		return
-	}
+	topic.IsActive = true
 
-	_, err = database.GetDB().Exec(`
-		INSERT INTO gd_topics (id, level, topic_text, prep_materials, is_active)
-		VALUES (?, ?, ?, ?, TRUE)`,
-		topic.ID, topic.Level, topic.TopicText, prepMaterialsJSON,
-	)
-
-	if err != nil {
-		log.Printf("Error creating topic: %v", err)
+	if err := saveTopic(database.GetDB(), &topic, false); err != nil {
+		if isDuplicateTopic(err) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A topic with this text already exists for this level"})
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error creating topic", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create topic"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "topic.create", EntityType: "topic", EntityID: topic.ID,
+		After: auditSnapshot(r, topicAuditQuery, topic.ID),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -129,7 +143,7 @@ func UpdateTopic(w http.ResponseWriter, r *http.Request) {
 	var topic Topic
 	if err := json.NewDecoder(r.Body).Decode(&topic); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data: " + err.Error()})
 		return
 	}
 
@@ -139,31 +153,28 @@ func UpdateTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure prep_materials is never nil
-	if topic.PrepMaterials == nil {
-		topic.PrepMaterials = make(map[string]interface{})
-	}
-	
-	prepMaterialsJSON, err := json.Marshal(topic.PrepMaterials)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to process preparation materials"})
+	if err := validateTopic(&topic); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
 
-	_, err = database.GetDB().Exec(`
-		UPDATE gd_topics 
-		SET level = ?, topic_text = ?, prep_materials = ?
-		WHERE id = ?`,
-		topic.Level, topic.TopicText, prepMaterialsJSON, topic.ID,
-	)
-
-	if err != nil {
-		log.Printf("Error updating topic: %v", err)
+	before := auditSnapshot(r, topicAuditQuery, topic.ID)
+	if err := saveTopic(database.GetDB(), &topic, true); err != nil {
+		if isDuplicateTopic(err) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A topic with this text already exists for this level"})
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error updating topic", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update topic"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "topic.update", EntityType: "topic", EntityID: topic.ID,
+		Before: before, After: auditSnapshot(r, topicAuditQuery, topic.ID),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Topic updated successfully"})
@@ -177,14 +188,159 @@ func DeleteTopic(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := auditSnapshot(r, topicAuditQuery, topicID)
 	_, err := database.GetDB().Exec("DELETE FROM gd_topics WHERE id = ?", topicID)
 	if err != nil {
-		log.Printf("Error deleting topic: %v", err)
+		slog.ErrorContext(r.Context(), "Error deleting topic", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete topic"})
 		return
 	}
+	audit.Log(r.Context(), audit.Entry{
+		Action: "topic.delete", EntityType: "topic", EntityID: topicID,
+		Before: before,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Topic deleted successfully"})
-}
\ No newline at end of file
+}
+
+// PinSessionTopic fixes a session's topic so rotation won't replace it.
+func PinSessionTopic(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID string `json:"session_id"`
+		TopicID   string `json:"topic_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" || req.TopicID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id and topic_id are required"})
+		return
+	}
+
+	var sessionLevel, topicLevel int
+	var status string
+	err := database.GetDB().QueryRow("SELECT level, status FROM gd_sessions WHERE id = ?", req.SessionID).Scan(&sessionLevel, &status)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if !services.SessionStatus(status).Open() {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Session has already ended"})
+		return
+	}
+
+	err = database.GetDB().QueryRow("SELECT level FROM gd_topics WHERE id = ? AND is_active = TRUE", req.TopicID).Scan(&topicLevel)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Topic not found"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if topicLevel != sessionLevel {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Topic level does not match session level"})
+		return
+	}
+
+	_, err = database.GetDB().Exec(`
+		UPDATE gd_sessions SET topic_id = ?, topic_pinned = TRUE
+		WHERE id = ?`, req.TopicID, req.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error pinning topic", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to pin topic"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Topic pinned successfully"})
+}
+
+// GetTopicCategories lists the categories each level draws topics from. A
+// level with no categories uses all of its topics.
+func GetTopicCategories(w http.ResponseWriter, r *http.Request) {
+	rows, err := database.GetDB().Query("SELECT level, category FROM level_topic_categories ORDER BY level, category")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching topic categories", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fetch topic categories"})
+		return
+	}
+	defer rows.Close()
+
+	categories := make(map[int][]string)
+	for rows.Next() {
+		var level int
+		var category string
+		if err := rows.Scan(&level, &category); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning topic category", "error", err)
+			continue
+		}
+		categories[level] = append(categories[level], category)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// SetTopicCategories replaces the categories a level draws topics from.
+func SetTopicCategories(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level      int      `json:"level"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data"})
+		return
+	}
+	if req.Level < 1 || req.Level > 5 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Level must be between 1 and 5"})
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM level_topic_categories WHERE level = ?", req.Level); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update topic categories"})
+		return
+	}
+	for _, category := range req.Categories {
+		if category == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT IGNORE INTO level_topic_categories (level, category) VALUES (?, ?)", req.Level, category); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving topic category", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update topic categories"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update topic categories"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Topic categories updated successfully"})
+}
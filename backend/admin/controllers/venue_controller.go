@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"gd/admin/models"
 	qr "gd/admin/utils"
+	"gd/audit"
+	"gd/config"
 	"gd/database"
-	"log"
+	student "gd/student/controllers"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -32,6 +35,11 @@ type Venue struct {
 	TableDetails  string `json:"table_details"`
 }
 
+// venueAuditQuery reads the audited fields of a venue.
+const venueAuditQuery = `
+    SELECT id, name, capacity, level, session_timing, table_details, is_active
+    FROM venues WHERE id = ?`
+
 // var db *sql.DB // Make sure this is properly initialized in main.go
 // func SetDB(database *sql.DB) {
 //     db = database
@@ -40,7 +48,7 @@ func GetVenues(w http.ResponseWriter, r *http.Request) {
 	// Ensure db connection is available
 	db := database.GetDB()
 	if db == nil {
-		log.Println("Database connection is nil")
+		slog.ErrorContext(r.Context(), "Database connection is nil")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database connection error"})
 		return
@@ -48,7 +56,7 @@ func GetVenues(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.Query("SELECT id, name, capacity, level, session_timing, table_details FROM venues WHERE is_active = TRUE")
 	if err != nil {
-		log.Printf("Error fetching venues: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching venues", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fetch venues"})
 		return
@@ -59,7 +67,7 @@ func GetVenues(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var v models.Venue
 	if err := rows.Scan(&v.ID, &v.Name, &v.Capacity, &v.Level, &v.SessionTiming, &v.TableDetails); err != nil {
-			log.Printf("Error scanning venue: %v", err)
+			slog.ErrorContext(r.Context(), "Error scanning venue", "error", err)
 			continue
 		}
 		venues = append(venues, v)
@@ -77,22 +85,19 @@ func DeleteVenue(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get current time in IST
-    loc, err := time.LoadLocation("Asia/Kolkata")
-    if err != nil {
-        loc = time.Local
-    }
+    // Get current time in the venue time zone
+    loc := config.Get().Location
     now := time.Now().In(loc)
 
-    // Check if venue is expired (session timing is in the past using IST)
+    // Check if venue is expired (session timing is in the past)
     var sessionTiming string
-    err = database.GetDB().QueryRow(
+    err := database.GetDB().QueryRow(
         "SELECT session_timing FROM venues WHERE id = ?",
         venueID,
     ).Scan(&sessionTiming)
 
     if err != nil {
-        log.Printf("Error fetching venue timing: %v", err)
+        slog.ErrorContext(r.Context(), "Error fetching venue timing", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -126,13 +131,13 @@ func DeleteVenue(w http.ResponseWriter, r *http.Request) {
         err := database.GetDB().QueryRow(
             `SELECT COUNT(*) FROM gd_sessions 
              WHERE venue_id = ? 
-             AND status IN ('pending', 'active')
+             AND status IN `+student.OpenStatusesSQL+`
              AND end_time > CONVERT_TZ(NOW(), 'SYSTEM', '+05:30')`,  // Use IST
             venueID,
         ).Scan(&sessionCount)
 
         if err != nil {
-            log.Printf("Error checking venue sessions: %v", err)
+            slog.ErrorContext(r.Context(), "Error checking venue sessions", "error", err)
             w.WriteHeader(http.StatusInternalServerError)
             json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
             return
@@ -140,11 +145,13 @@ func DeleteVenue(w http.ResponseWriter, r *http.Request) {
 
         if sessionCount > 0 {
             w.WriteHeader(http.StatusConflict)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Cannot delete venue with active sessions"})
+            json.NewEncoder(w).Encode(map[string]string{"error": "Cannot delete venue with active sessions; cancel or reschedule them first"})
             return
         }
     }
 
+    before := auditSnapshot(r, venueAuditQuery, venueID)
+
     // Soft delete the venue
     result, err := database.GetDB().Exec(
         "UPDATE venues SET is_active = FALSE WHERE id = ?",
@@ -152,7 +159,7 @@ func DeleteVenue(w http.ResponseWriter, r *http.Request) {
     )
 
     if err != nil {
-        log.Printf("Error deleting venue: %v", err)
+        slog.ErrorContext(r.Context(), "Error deleting venue", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete venue"})
         return
@@ -164,6 +171,10 @@ func DeleteVenue(w http.ResponseWriter, r *http.Request) {
         json.NewEncoder(w).Encode(map[string]string{"error": "Venue not found"})
         return
     }
+    audit.Log(r.Context(), audit.Entry{
+        Action: "venue.delete", EntityType: "venue", EntityID: venueID,
+        Before: before, After: auditSnapshot(r, venueAuditQuery, venueID),
+    })
 
     w.Header().Set("Content-Type", "application/json")
     if isExpired {
@@ -217,6 +228,7 @@ func UpdateVenue(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    before := auditSnapshot(r, venueAuditQuery, venue.ID)
     _, err := database.GetDB().Exec(`
         UPDATE venues 
         SET name = ?, capacity = ?, level = ?, session_timing = ?, table_details = ?
@@ -224,11 +236,15 @@ func UpdateVenue(w http.ResponseWriter, r *http.Request) {
         venue.Name, venue.Capacity, venue.Level, venue.SessionTiming, venue.TableDetails, venue.ID)
 
     if err != nil {
-        log.Printf("Error updating venue: %v", err)
+        slog.ErrorContext(r.Context(), "Error updating venue", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update venue"})
         return
     }
+    audit.Log(r.Context(), audit.Entry{
+        Action: "venue.update", EntityType: "venue", EntityID: venue.ID,
+        Before: before, After: auditSnapshot(r, venueAuditQuery, venue.ID),
+    })
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -237,7 +253,7 @@ func UpdateVenue(w http.ResponseWriter, r *http.Request) {
 func CreateVenue(w http.ResponseWriter, r *http.Request) {
     db := database.GetDB()
     if db == nil {
-        log.Println("Database connection is nil")
+        slog.ErrorContext(r.Context(), "Database connection is nil")
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database connection error"})
         return
@@ -245,7 +261,7 @@ func CreateVenue(w http.ResponseWriter, r *http.Request) {
 
     var venue models.Venue
     if err := json.NewDecoder(r.Body).Decode(&venue); err != nil {
-        log.Printf("Error decoding venue data: %v", err)
+        slog.ErrorContext(r.Context(), "Error decoding venue data", "error", err)
         w.WriteHeader(http.StatusBadRequest)
         json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request data"})
         return
@@ -259,7 +275,7 @@ func CreateVenue(w http.ResponseWriter, r *http.Request) {
     // Generate secure QR payload (modified part)
     qrData, err := qr.GenerateSecureQR(venue.ID, 5*time.Minute)
     if err != nil {
-        log.Printf("Error generating QR secret: %v", err)
+        slog.ErrorContext(r.Context(), "Error generating QR secret", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate venue QR"})
         return
@@ -267,14 +283,18 @@ func CreateVenue(w http.ResponseWriter, r *http.Request) {
     venue.QRSecret = qrData
     
     venue.IsActive = true
-    venue.CreatedBy = "admin1"
+    venue.CreatedBy = r.Context().Value("userID").(string)
 
     if err := models.CreateVenue(db, venue); err != nil {
-        log.Printf("Error creating venue: %v", err)
+        slog.ErrorContext(r.Context(), "Error creating venue", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Venue creation failed: " + err.Error()})
         return
     }
+    audit.Log(r.Context(), audit.Entry{
+        Action: "venue.create", EntityType: "venue", EntityID: venue.ID,
+        After: auditSnapshot(r, venueAuditQuery, venue.ID),
+    })
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusCreated)
@@ -282,22 +302,32 @@ func CreateVenue(w http.ResponseWriter, r *http.Request) {
 }
 
 func CleanupExpiredVenues(w http.ResponseWriter, r *http.Request) {
-    db := database.GetDB()
-    if db == nil {
+    rowsAffected, err := DeactivatePastVenues()
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error cleaning up expired venues", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database connection error"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to cleanup expired venues"})
         return
     }
 
-    // Delete venues that have no active sessions and are past their session time
-    result, err := db.Exec(`
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "status": "cleanup_completed",
+        "venues_removed": fmt.Sprintf("%d", rowsAffected),
+    })
+}
+
+// DeactivatePastVenues deactivates venues whose session date has passed and
+// that have no pending or active sessions. It returns how many it changed.
+func DeactivatePastVenues() (int64, error) {
+    result, err := database.GetDB().Exec(`
         UPDATE venues 
         SET is_active = FALSE 
         WHERE is_active = TRUE 
         AND id NOT IN (
             SELECT DISTINCT venue_id 
             FROM gd_sessions 
-            WHERE status IN ('pending', 'active')
+            WHERE status IN `+student.OpenStatusesSQL+`
         )
         AND session_timing != ''
         AND STR_TO_DATE(
@@ -305,19 +335,8 @@ func CleanupExpiredVenues(w http.ResponseWriter, r *http.Request) {
             '%d/%m/%Y'
         ) < CURDATE()
     `)
-
     if err != nil {
-        log.Printf("Error cleaning up expired venues: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to cleanup expired venues"})
-        return
+        return 0, err
     }
-
-    rowsAffected, _ := result.RowsAffected()
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{
-        "status": "cleanup_completed",
-        "venues_removed": fmt.Sprintf("%d", rowsAffected),
-    })
+    return result.RowsAffected()
 }
\ No newline at end of file
@@ -5,7 +5,8 @@ import (
 	"context"
 	"encoding/json"
 	"gd/admin/utils"
-	"log"
+	"gd/logging"
+	"log/slog"
 	"net/http"
 	"strings"
 )
@@ -15,7 +16,7 @@ func AdminOnly(next http.Handler) http.Handler {
         // Get Authorization header
         authHeader := r.Header.Get("Authorization")
         if authHeader == "" {
-            log.Println("Authorization header is required")
+            slog.WarnContext(r.Context(), "Authorization header missing")
             w.WriteHeader(http.StatusUnauthorized)
             json.NewEncoder(w).Encode(map[string]string{"error": "Authorization header is required"})
             return
@@ -24,7 +25,7 @@ func AdminOnly(next http.Handler) http.Handler {
         // Check if it's Bearer token
         splitToken := strings.Split(authHeader, "Bearer ")
         if len(splitToken) != 2 {
-            log.Println("Invalid token format")
+            slog.WarnContext(r.Context(), "Invalid token format")
             w.WriteHeader(http.StatusUnauthorized)
             json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token format"})
             return
@@ -33,20 +34,21 @@ func AdminOnly(next http.Handler) http.Handler {
         token := splitToken[1]
         claims, err := jwt.VerifyToken(token)
         if err != nil {
-            log.Printf("Token verification failed: %v", err)
+            slog.WarnContext(r.Context(), "Token verification failed", "error", err)
             w.WriteHeader(http.StatusUnauthorized)
             json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
             return
         }
         
         if claims.Role != "admin" {
-            log.Printf("Unauthorized role: %s", claims.Role)
+            slog.WarnContext(r.Context(), "Invalid role for admin route", "role", claims.Role)
             w.WriteHeader(http.StatusForbidden)
             json.NewEncoder(w).Encode(map[string]string{"error": "Insufficient permissions"})
             return
         }
         
         // Add user ID to context for downstream handlers
+        logging.SetUser(r.Context(), "admin", claims.UserID)
         ctx := context.WithValue(r.Context(), "userID", claims.UserID)
         next.ServeHTTP(w, r.WithContext(ctx))
     })
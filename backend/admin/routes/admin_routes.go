@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"gd/admin/controllers"
 	"gd/admin/middleware"
-	"log"
+	"gd/config"
+	"gd/ratelimit"
 	"net/http"
 )
 
@@ -18,9 +19,17 @@ func StudentLogin(w http.ResponseWriter, r *http.Request) {
 
 func SetupAdminRoutes() *http.ServeMux {
 	router := http.NewServeMux()
+	cfg := config.Get()
+	loginLimit := ratelimit.Policy{
+		Name:       "admin_login",
+		PerIP:      cfg.LoginRateIP,
+		PerAccount: cfg.LoginRateAccount,
+		Account:    ratelimit.JSONField("email"),
+	}
 
 	// Auth routes
-	router.Handle(baseurl+"/login", http.HandlerFunc(controllers.AdminLogin))
+	router.Handle(baseurl+"/login", ratelimit.Throttle(loginLimit,
+		http.HandlerFunc(controllers.AdminLogin)))
 	// QR route
 	router.Handle(baseurl+"/qr", middleware.AdminOnly(http.HandlerFunc(controllers.GenerateQR)))
 	// Session routes
@@ -50,6 +59,14 @@ router.Handle(baseurl+"/venues", middleware.AdminOnly(http.HandlerFunc(func(w ht
     }
 })))
 
+	router.Handle(baseurl+"/venues/groups", middleware.AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			controllers.FormGroups(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
 	router.Handle(baseurl+"/venues/", middleware.AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPut {
 			controllers.UpdateVenue(w, r)
@@ -63,9 +80,65 @@ router.Handle(baseurl+"/venues", middleware.AdminOnly(http.HandlerFunc(func(w ht
 
 	router.Handle(baseurl+"/analytics/qualifications", middleware.AdminOnly(
 		http.HandlerFunc(controllers.GetQualificationRates)))
+	router.Handle(baseurl+"/analytics/speaking", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetSpeakingAnalytics)))
 
 	router.Handle(baseurl+"/sessions", middleware.AdminOnly(
 		http.HandlerFunc(controllers.GetSessions)))
+	router.Handle(baseurl+"/sessions/live", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetLiveSessions)))
+	router.Handle(baseurl+"/sessions/status-history", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetSessionStatusHistory)))
+	router.Handle(baseurl+"/sessions/live/stream", middleware.AdminOnly(
+		http.HandlerFunc(controllers.StreamLiveSessions)))
+	router.Handle(baseurl+"/sessions/live/timer-events", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetTimerEvents)))
+	router.Handle(baseurl+"/sessions/live/action", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.LiveSessionAction(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/sessions/cancel", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.CancelSession(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/sessions/reschedule", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.RescheduleSession(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/question-sets", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetQuestionSets(w, r)
+			case http.MethodPost:
+				controllers.CreateQuestionSet(w, r)
+			case http.MethodPut:
+				controllers.UpdateQuestionSet(w, r)
+			case http.MethodDelete:
+				controllers.DeleteQuestionSet(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
+	router.Handle(baseurl+"/question-sets/preview", middleware.AdminOnly(
+		http.HandlerFunc(controllers.PreviewQuestionSet)))
+	router.Handle(baseurl+"/questions/versions", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetQuestionVersions)))
+	router.Handle(baseurl+"/questions/diff", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetQuestionDiff)))
 	router.Handle(baseurl+"/questions", middleware.AdminOnly(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch r.Method {
@@ -98,6 +171,54 @@ router.Handle(baseurl+"/venues", middleware.AdminOnly(http.HandlerFunc(func(w ht
 			}
 		}),
 	))
+	router.Handle(baseurl+"/topics/import", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.ImportTopics(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
+	router.Handle(baseurl+"/topics/export", middleware.AdminOnly(
+		http.HandlerFunc(controllers.ExportTopics)))
+	router.Handle(baseurl+"/topics/categories", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetTopicCategories(w, r)
+			case http.MethodPut:
+				controllers.SetTopicCategories(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
+	router.Handle(baseurl+"/sessions/topic", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.PinSessionTopic(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
+	router.Handle(baseurl+"/agenda-templates", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetAgendaTemplates(w, r)
+			case http.MethodPost:
+				controllers.CreateAgendaTemplate(w, r)
+			case http.MethodPut:
+				controllers.UpdateAgendaTemplate(w, r)
+			case http.MethodDelete:
+				controllers.DeleteAgendaTemplate(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
 	router.Handle(baseurl+"/ranking-points", middleware.AdminOnly(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch r.Method {
@@ -120,7 +241,20 @@ router.Handle(baseurl+"/venues", middleware.AdminOnly(http.HandlerFunc(func(w ht
 		http.HandlerFunc(controllers.GetStudentBookings)))
 	router.Handle(baseurl+"/rules", middleware.AdminOnly(
 		http.HandlerFunc(controllers.UpdateSessionRules)))
-	log.Println(baseurl+"Venue routes setup complete")
+	router.Handle(baseurl+"/level-rules", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetLevelRules(w, r)
+			case http.MethodPut, http.MethodPost:
+				controllers.UpdateLevelRules(w, r)
+			case http.MethodDelete:
+				controllers.DeleteLevelRules(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}),
+	))
 	router.Handle(baseurl+"/qr/manage", middleware.AdminOnly(
 		http.HandlerFunc(controllers.GetVenueQRCodes)))
 	router.Handle(baseurl+"/qr/deactivate", middleware.AdminOnly(
@@ -131,6 +265,40 @@ router.Handle(baseurl+"/venues", middleware.AdminOnly(http.HandlerFunc(func(w ht
 		http.HandlerFunc(controllers.GetTopParticipants)))
 	router.Handle(baseurl+"/feedbacks", middleware.AdminOnly(
 		http.HandlerFunc(controllers.GetSessionFeedbacks)))
+	router.Handle(baseurl+"/appeals", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetAppeals)))
+	router.Handle(baseurl+"/appeals/detail", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetAppealDetail)))
+	router.Handle(baseurl+"/appeals/decide", middleware.AdminOnly(
+		http.HandlerFunc(controllers.DecideAppeal)))
+	router.Handle(baseurl+"/jobs", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetJobs)))
+	router.Handle(baseurl+"/jobs/run", middleware.AdminOnly(
+		http.HandlerFunc(controllers.RunJobNow)))
+	router.Handle(baseurl+"/config", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				controllers.GetConfig(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/lockouts", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetLoginLockouts)))
+	router.Handle(baseurl+"/lockouts/unlock", middleware.AdminOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				controllers.UnlockAccount(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/login-attempts", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetLoginAttempts)))
+	router.Handle(baseurl+"/audit-log", middleware.AdminOnly(
+		http.HandlerFunc(controllers.GetAuditLog)))
+	router.Handle(baseurl+"/audit-log/export", middleware.AdminOnly(
+		http.HandlerFunc(controllers.ExportAuditLog)))
 	return router
 
 }
\ No newline at end of file
@@ -1,14 +1,16 @@
 package jwt
 
 import (
-	"log"
-	"os"
+	"gd/config"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var secret = []byte(os.Getenv("JWT_SECRET"))
+// secret returns the admin signing key from the loaded configuration.
+func secret() []byte {
+	return []byte(config.Get().AdminJWTSecret)
+}
 
 type Claims struct {
 	UserID string `json:"user_id"`
@@ -26,21 +28,19 @@ func GenerateToken(userID, role string) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return token.SignedString(secret())
 }
 
 func VerifyToken(tokenString string) (*Claims, error) {
     if tokenString == "" {
-        log.Println("Empty token string provided")
         return nil, jwt.ErrInvalidKey
     }
 
     token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-        return secret, nil
+        return secret(), nil
     })
     
     if err != nil {
-        log.Printf("Token parsing error: %v", err)
         return nil, err
     }
     
@@ -0,0 +1,216 @@
+// Package audit keeps the append-only audit_log: who changed what, from
+// what to what, for every admin mutation and every automated scoring and
+// promotion step.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"gd/logging"
+	"log/slog"
+	"reflect"
+)
+
+// Actor roles.
+const (
+	RoleAdmin   = "admin"
+	RoleStudent = "student"
+	RoleSystem  = "system"
+)
+
+const redacted = "[REDACTED]"
+
+// Entry is one audited change. Before and After are anything that
+// marshals to JSON; when both are objects only the fields that differ are
+// kept. Leave Before nil for a creation and After nil for a deletion.
+type Entry struct {
+	Action     string
+	EntityType string
+	EntityID   string
+	Before     interface{}
+	After      interface{}
+	// ActorRole and ActorID default to the user making the request, or to
+	// the system outside one.
+	ActorRole string
+	ActorID   string
+}
+
+// Execer is a *sql.DB or *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Queryer is a *sql.DB or *sql.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Record writes e through exec, so a change and its audit entry can commit
+// together. The request ID and client address come from ctx.
+func Record(ctx context.Context, exec Execer, e Entry) error {
+	role, id := e.ActorRole, e.ActorID
+	userRole, userID := logging.User(ctx)
+	if role == "" {
+		role, id = userRole, userID
+	}
+	if role == "" {
+		role = RoleSystem
+	}
+
+	before, after := diff(e.Before, e.After)
+	beforeJSON, err := marshalNullable(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalNullable(after)
+	if err != nil {
+		return err
+	}
+
+	st := stateFrom(ctx)
+	var ip string
+	if st != nil {
+		ip = st.ip
+	}
+	_, err = exec.ExecContext(ctx, `
+        INSERT INTO audit_log
+        (actor_id, actor_role, action, entity_type, entity_id, before_json, after_json, ip, request_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullable(id), role, e.Action, e.EntityType, nullable(e.EntityID),
+		beforeJSON, afterJSON, nullable(ip), nullable(logging.RequestID(ctx)))
+	if err != nil {
+		return err
+	}
+	if st != nil && role == userRole && id == userID {
+		st.markRecorded()
+	}
+	return nil
+}
+
+// Log records e outside any transaction, after the change has been made,
+// logging rather than returning a failure.
+func Log(ctx context.Context, e Entry) {
+	if err := Record(ctx, database.GetDB(), e); err != nil {
+		slog.ErrorContext(ctx, "Error writing audit log", "action", e.Action,
+			"entity_type", e.EntityType, "entity_id", e.EntityID, "error", err)
+	}
+}
+
+// Snapshot reads the first row of query as a column-to-value map, for use
+// as an entry's Before or After. It returns nil if there is no row.
+func Snapshot(ctx context.Context, q Queryer, query string, args ...interface{}) (map[string]interface{}, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			row[column] = string(b)
+		} else {
+			row[column] = values[i]
+		}
+	}
+	return row, nil
+}
+
+// diff reduces before and after to the fields that changed when both are
+// objects, and redacts sensitive fields either way.
+func diff(before, after interface{}) (interface{}, interface{}) {
+	before, after = normalize(before), normalize(after)
+	b, bok := before.(map[string]interface{})
+	a, aok := after.(map[string]interface{})
+	if !bok || !aok {
+		return redact(before), redact(after)
+	}
+
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedBefore[k] = v
+		}
+	}
+	for k, w := range a {
+		if v, ok := b[k]; !ok || !reflect.DeepEqual(v, w) {
+			changedAfter[k] = w
+		}
+	}
+	return redact(changedBefore), redact(changedAfter)
+}
+
+// normalize round-trips v through JSON so structs, maps and scanned rows
+// compare alike.
+func normalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); (rv.Kind() == reflect.Map || rv.Kind() == reflect.Pointer) && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if json.Unmarshal(data, &out) != nil {
+		return nil
+	}
+	return out
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range v {
+			if logging.IsSensitiveKey(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redact(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = redact(inner)
+		}
+	}
+	return v
+}
+
+func marshalNullable(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
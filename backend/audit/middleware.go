@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gd/logging"
+	"gd/ratelimit"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxAuditedBody is the largest request body copied into a fallback entry.
+const maxAuditedBody = 64 << 10
+
+// AdminPrefix is stripped from paths in fallback entries.
+const AdminPrefix = "/api/gd/admin"
+
+// entityIDFields are the request fields, in order of preference, taken as
+// the entity a fallback entry is about.
+var entityIDFields = []string{"id", "session_id", "venue_id", "question_id", "qr_id", "appeal_id", "student_id", "template_id", "level"}
+
+type stateKey struct{}
+
+// state is shared by everything handling one request.
+type state struct {
+	ip       string
+	mu       sync.Mutex
+	recorded bool
+}
+
+func (s *state) markRecorded() {
+	s.mu.Lock()
+	s.recorded = true
+	s.mu.Unlock()
+}
+
+func (s *state) wasRecorded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recorded
+}
+
+func stateFrom(ctx context.Context) *state {
+	s, _ := ctx.Value(stateKey{}).(*state)
+	return s
+}
+
+// Attach makes the client address available to entries recorded while
+// handling a request, without auditing the request itself.
+func Attach(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &state{ip: ratelimit.ClientIP(r)}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), stateKey{}, st)))
+	})
+}
+
+// Middleware does what Attach does, and also audits every successful
+// authenticated mutation that its handler did not audit itself, recording
+// the request as the entry's After.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &state{ip: ratelimit.ClientIP(r)}
+		ctx := context.WithValue(r.Context(), stateKey{}, st)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditedBody+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status >= 400 || st.wasRecorded() {
+			return
+		}
+		if role, _ := logging.User(ctx); role == "" {
+			// Unauthenticated, such as a login
+			return
+		}
+		payload := requestPayload(r, body)
+		Log(ctx, Entry{
+			Action:     strings.ToLower(r.Method) + " " + strings.TrimPrefix(r.URL.Path, AdminPrefix),
+			EntityType: entityType(r.URL.Path),
+			EntityID:   entityID(r, payload["body"]),
+			After:      payload,
+		})
+	})
+}
+
+// entityType is the first path segment after the admin prefix, such as
+// "venues" or "sessions".
+func entityType(path string) string {
+	rest := strings.Trim(strings.TrimPrefix(path, AdminPrefix), "/")
+	first, _, _ := strings.Cut(rest, "/")
+	return first
+}
+
+func requestPayload(r *http.Request, body []byte) map[string]interface{} {
+	payload := map[string]interface{}{}
+	if len(r.URL.Query()) > 0 {
+		payload["query"] = r.URL.Query()
+	}
+	var decoded interface{}
+	switch {
+	case len(body) == 0:
+	case len(body) <= maxAuditedBody && json.Unmarshal(body, &decoded) == nil:
+		payload["body"] = decoded
+	default:
+		payload["body"] = map[string]interface{}{
+			"content_type": r.Header.Get("Content-Type"),
+			"bytes":        r.ContentLength,
+		}
+	}
+	return payload
+}
+
+func entityID(r *http.Request, body interface{}) string {
+	fields, _ := body.(map[string]interface{})
+	for _, name := range entityIDFields {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		switch v := fields[name].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	}
+	return ""
+}
+
+// statusRecorder remembers the response status.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,276 @@
+// Command loadtest hammers seat allocation with concurrent bookings and QR
+// joins against a local MySQL and fails if any venue, session or QR group
+// ends up over capacity.
+//
+// It seeds a throwaway venue, QR code and students, serves the student API
+// in-process, and removes what it created when done. Run it from the
+// backend directory so the settings in ../.env are found, or point
+// CONFIG_FILE at another:
+//
+//	go run ./cmd/loadtest -students 60 -venue-capacity 40 -qr-capacity 15
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gd/config"
+	"gd/database"
+	studentRoutes "gd/student/routes"
+	jwt "gd/student/utils"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type fixture struct {
+	runID      string
+	venueID    string
+	qrID       string
+	qrData     string
+	studentIDs []string
+	tokens     []string
+}
+
+// errFailed reports a run that completed but broke a capacity invariant.
+var errFailed = errors.New("FAIL")
+
+func main() {
+	students := flag.Int("students", 60, "number of students sending requests at once")
+	venueCapacity := flag.Int("venue-capacity", 40, "capacity of the test venue")
+	qrCapacity := flag.Int("qr-capacity", 15, "capacity of the test QR code")
+	keep := flag.Bool("keep", false, "keep the seeded rows for inspection")
+	flag.Parse()
+
+	// Exiting only once run returns lets its deferred cleanup remove the
+	// seeded rows, however the run ends
+	if err := run(*students, *venueCapacity, *qrCapacity, *keep); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	log.Printf("PASS: no venue, session or QR group over capacity")
+}
+
+func run(students, venueCapacity, qrCapacity int, keep bool) error {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	if err := database.Initialize(cfg.DBURL); err != nil {
+		return fmt.Errorf("database initialization failed: %v", err)
+	}
+	db := database.GetDB()
+	defer db.Close()
+
+	fx, err := seed(db, students, venueCapacity, qrCapacity)
+	// A partly seeded fixture is cleaned up too
+	if fx != nil && !keep {
+		defer cleanup(db, fx)
+	}
+	if err != nil {
+		return fmt.Errorf("seeding failed: %v", err)
+	}
+
+	server := httptest.NewServer(studentRoutes.SetupStudentRoutes())
+	defer server.Close()
+
+	failed := false
+
+	booked := hammer(fx, func(i int) (*http.Request, error) {
+		body, _ := json.Marshal(map[string]string{"venue_id": fx.venueID})
+		return newRequest(server.URL+"/api/gd/student/sessions/book", fx.tokens[i], body)
+	})
+	log.Printf("Bookings: %v", booked)
+	failed = !expect(booked[http.StatusOK] == min(students, venueCapacity),
+		"%d bookings succeeded, want %d", booked[http.StatusOK], min(students, venueCapacity)) || failed
+	failed = !checkCapacity(db, fx, venueCapacity) || failed
+
+	joined := hammer(fx, func(i int) (*http.Request, error) {
+		body, _ := json.Marshal(map[string]string{"qr_data": fx.qrData})
+		return newRequest(server.URL+"/api/gd/student/sessions/join", fx.tokens[i], body)
+	})
+	log.Printf("QR joins: %v", joined)
+	failed = !expect(joined[http.StatusOK] <= qrCapacity,
+		"%d QR joins succeeded, QR capacity is %d", joined[http.StatusOK], qrCapacity) || failed
+	failed = !checkCapacity(db, fx, venueCapacity) || failed
+
+	// A second scan by students already in the group must not use up capacity
+	var usageBefore, usageAfter int
+	db.QueryRow("SELECT current_usage FROM venue_qr_codes WHERE id = ?", fx.qrID).Scan(&usageBefore)
+	hammer(fx, func(i int) (*http.Request, error) {
+		body, _ := json.Marshal(map[string]string{"qr_data": fx.qrData})
+		return newRequest(server.URL+"/api/gd/student/sessions/join", fx.tokens[i], body)
+	})
+	db.QueryRow("SELECT current_usage FROM venue_qr_codes WHERE id = ?", fx.qrID).Scan(&usageAfter)
+	failed = !expect(usageAfter == usageBefore,
+		"repeat scans moved QR usage from %d to %d", usageBefore, usageAfter) || failed
+
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// hammer sends one request per student, all released at the same moment,
+// and counts the response codes.
+func hammer(fx *fixture, build func(i int) (*http.Request, error)) map[int]int {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		codes = make(map[int]int)
+		start = make(chan struct{})
+	)
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	for i := range fx.studentIDs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := build(i)
+			if err != nil {
+				log.Printf("Building request: %v", err)
+				return
+			}
+			<-start
+			code := 0
+			resp, err := client.Do(req)
+			if err != nil {
+				log.Printf("Request failed: %v", err)
+			} else {
+				code = resp.StatusCode
+				resp.Body.Close()
+			}
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return codes
+}
+
+func newRequest(url, token string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// checkCapacity asserts the invariants seat allocation must keep.
+func checkCapacity(db *sql.DB, fx *fixture, venueCapacity int) bool {
+	ok := true
+
+	var venueSeats int
+	db.QueryRow(`
+        SELECT COUNT(DISTINCT sp.student_id)
+        FROM session_participants sp
+        JOIN gd_sessions s ON sp.session_id = s.id
+        WHERE s.venue_id = ? AND sp.is_dummy = FALSE`, fx.venueID).Scan(&venueSeats)
+	ok = expect(venueSeats <= venueCapacity, "venue has %d students, capacity %d", venueSeats, venueCapacity) && ok
+
+	rows, err := db.Query(`
+        SELECT s.id, s.max_capacity, COUNT(sp.id)
+        FROM gd_sessions s
+        LEFT JOIN session_participants sp ON sp.session_id = s.id AND sp.is_dummy = FALSE
+        WHERE s.venue_id = ?
+        GROUP BY s.id, s.max_capacity`, fx.venueID)
+	if err != nil {
+		return expect(false, "listing sessions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var capacity, seats int
+		if err := rows.Scan(&id, &capacity, &seats); err != nil {
+			return expect(false, "scanning session: %v", err)
+		}
+		ok = expect(seats <= capacity, "session %s has %d participants, capacity %d", id, seats, capacity) && ok
+	}
+
+	var usage, qrCapacity, groupSeats int
+	db.QueryRow(`
+        SELECT q.current_usage, q.max_capacity,
+               (SELECT COUNT(*) FROM session_participants sp
+                JOIN gd_sessions s ON sp.session_id = s.id
+                WHERE s.qr_group_id = q.qr_group_id AND sp.is_dummy = FALSE)
+        FROM venue_qr_codes q WHERE q.id = ?`, fx.qrID).Scan(&usage, &qrCapacity, &groupSeats)
+	ok = expect(usage <= qrCapacity, "QR usage %d exceeds capacity %d", usage, qrCapacity) && ok
+	ok = expect(groupSeats == usage, "QR group has %d participants but usage is %d", groupSeats, usage) && ok
+	return ok
+}
+
+func expect(cond bool, format string, args ...interface{}) bool {
+	if !cond {
+		log.Printf("ASSERTION FAILED: "+format, args...)
+	}
+	return cond
+}
+
+func seed(db *sql.DB, students, venueCapacity, qrCapacity int) (*fixture, error) {
+	fx := &fixture{runID: uuid.New().String()[:8], venueID: uuid.New().String(), qrID: uuid.New().String()}
+
+	_, err := db.Exec(`
+        INSERT INTO venues (id, name, capacity, level, qr_secret, session_timing, table_details)
+        VALUES (?, ?, ?, 1, ?, '', 'loadtest')`,
+		fx.venueID, "loadtest-"+fx.runID, venueCapacity, uuid.New().String())
+	if err != nil {
+		return nil, fmt.Errorf("creating venue: %v", err)
+	}
+
+	qrPayload, _ := json.Marshal(map[string]string{
+		"venue_id": fx.venueID,
+		"expiry":   time.Now().Add(time.Hour).Format(time.RFC3339),
+		"nonce":    fx.runID,
+	})
+	fx.qrData = string(qrPayload)
+	_, err = db.Exec(`
+        INSERT INTO venue_qr_codes (id, venue_id, qr_data, expires_at, is_active, max_capacity, current_usage, qr_group_id)
+        VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL 1 HOUR), TRUE, ?, 0, ?)`,
+		fx.qrID, fx.venueID, fx.qrData, qrCapacity, uuid.New().String())
+	if err != nil {
+		return fx, fmt.Errorf("creating QR code: %v", err)
+	}
+
+	for i := 0; i < students; i++ {
+		id := uuid.New().String()
+		_, err := db.Exec(`
+            INSERT INTO student_users (id, email, password_hash, full_name, department, year, current_gd_level)
+            VALUES (?, ?, '-', ?, 'LOADTEST', 1, 1)`,
+			id, fmt.Sprintf("loadtest-%s-%d@example.invalid", fx.runID, i), fmt.Sprintf("Load Test %d", i))
+		if err != nil {
+			return fx, fmt.Errorf("creating student: %v", err)
+		}
+		token, err := jwt.GenerateStudentToken(id, 1)
+		if err != nil {
+			return fx, fmt.Errorf("creating token: %v", err)
+		}
+		fx.studentIDs = append(fx.studentIDs, id)
+		fx.tokens = append(fx.tokens, token)
+	}
+	return fx, nil
+}
+
+// cleanup deletes the seeded venue and students; their sessions,
+// participants and QR codes go with them.
+func cleanup(db *sql.DB, fx *fixture) {
+	if _, err := db.Exec("DELETE FROM venues WHERE id = ?", fx.venueID); err != nil {
+		log.Printf("Cleanup: deleting venue: %v", err)
+	}
+	for _, id := range fx.studentIDs {
+		if _, err := db.Exec("DELETE FROM student_users WHERE id = ?", id); err != nil {
+			log.Printf("Cleanup: deleting student %s: %v", id, err)
+		}
+	}
+}
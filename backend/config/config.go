@@ -0,0 +1,467 @@
+// Package config gathers the server's settings in one typed, validated
+// value. Each setting resolves from, in increasing precedence, its built-in
+// default, the config file, the environment and the command line.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Production is the APP_ENV value for a live deployment.
+const Production = "production"
+
+// developmentEnvs are the APP_ENV values under which the built-in secrets
+// are accepted. APP_ENV has no default, so they must be chosen explicitly.
+var developmentEnvs = []string{"development", "dev", "local", "test"}
+
+// Config is the resolved server configuration.
+type Config struct {
+	Env              string
+	Port             string
+	DBURL            string
+	AdminJWTSecret   string
+	StudentJWTSecret string
+	LogLevel         string
+	LogFormat        string
+	// QRValidity is how long a generated venue QR code can be scanned.
+	QRValidity time.Duration
+	// QRCapacity is how many students one QR code admits.
+	QRCapacity int
+	// QuestionTimer is the time reported for a survey question that has
+	// no timer of its own yet.
+	QuestionTimer time.Duration
+	// TimeZone names Location, the zone venue timings are written in.
+	TimeZone string
+	Location *time.Location
+
+	// HTTP server limits. WriteTimeout does not apply to streams, which
+	// clear their own deadline.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	MaxBodyBytes int64
+	// ShutdownTimeout bounds how long a stopping server waits for
+	// in-flight requests and jobs.
+	ShutdownTimeout time.Duration
+	// TLSCertFile and TLSKeyFile, when both set, serve HTTPS.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TrustProxy takes the client address from X-Forwarded-For, for
+	// servers behind a reverse proxy.
+	TrustProxy bool
+	// RateLimitStore is memory, or mysql to share buckets across replicas.
+	RateLimitStore   string
+	LoginRateIP      Rate
+	LoginRateAccount Rate
+	JoinRateIP       Rate
+	JoinRateAccount  Rate
+	// LoginMaxFailures consecutive failed logins lock an account for
+	// LoginLockout, doubling with each further failure up to
+	// LoginLockoutMax.
+	LoginMaxFailures int
+	LoginLockout     time.Duration
+	LoginLockoutMax  time.Duration
+
+	file    string
+	values  map[string]string
+	sources map[string]string
+}
+
+// Rate allows Burst requests at once, refilled evenly over Per. It is
+// written as "burst/per", as in 10/1m.
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (r Rate) String() string {
+	return strconv.Itoa(r.Burst) + "/" + r.Per.String()
+}
+
+// ParseRate reads a rate written as "burst/per".
+func ParseRate(s string) (Rate, error) {
+	burst, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("rate %q is not of the form burst/duration", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("rate %q needs a positive burst", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("rate %q needs a positive duration", s)
+	}
+	return Rate{Burst: n, Per: d}, nil
+}
+
+// setting describes one configuration key. Secrets have no flag, so they
+// never show up in a process listing.
+type setting struct {
+	key    string
+	flag   string
+	def    string
+	usage  string
+	secret bool
+}
+
+var settings = []setting{
+	{key: "APP_ENV", flag: "env", usage: "deployment environment; default secrets are refused unless it is " + strings.Join(developmentEnvs, ", ")},
+	{key: "PORT", flag: "port", def: "8090", usage: "HTTP listen port"},
+	{key: "DB_URL", flag: "db-url", usage: "MySQL DSN, optionally prefixed with mysql://"},
+	{key: "JWT_SECRET", def: "dev-admin-secret", usage: "admin token signing key", secret: true},
+	{key: "JWT_SECRET_STUDENT", def: "password123", usage: "student token signing key", secret: true},
+	{key: "LOG_LEVEL", flag: "log-level", def: "info", usage: "debug, info, warn or error"},
+	{key: "LOG_FORMAT", flag: "log-format", def: "json", usage: "json or text"},
+	{key: "QR_VALIDITY", flag: "qr-validity", def: "240m", usage: "how long a venue QR code stays valid"},
+	{key: "QR_CAPACITY", flag: "qr-capacity", def: "15", usage: "students admitted per venue QR code"},
+	{key: "QUESTION_TIMER", flag: "question-timer", def: "30s", usage: "default time shown for an untimed survey question"},
+	{key: "TIMEZONE", flag: "timezone", def: "Asia/Kolkata", usage: "IANA zone venue timings are written in"},
+	{key: "HTTP_READ_TIMEOUT", flag: "read-timeout", def: "30s", usage: "time allowed to read a whole request"},
+	{key: "HTTP_WRITE_TIMEOUT", flag: "write-timeout", def: "60s", usage: "time allowed to write a response"},
+	{key: "HTTP_IDLE_TIMEOUT", flag: "idle-timeout", def: "120s", usage: "how long an idle keep-alive connection stays open"},
+	{key: "MAX_BODY_BYTES", flag: "max-body-bytes", def: "1048576", usage: "largest request body accepted"},
+	{key: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", def: "30s", usage: "how long to wait for in-flight requests and jobs on SIGTERM"},
+	{key: "TLS_CERT_FILE", flag: "tls-cert", usage: "certificate file; with TLS_KEY_FILE, serve HTTPS"},
+	{key: "TLS_KEY_FILE", flag: "tls-key", usage: "private key file for TLS_CERT_FILE"},
+	{key: "TRUST_PROXY", flag: "trust-proxy", def: "false", usage: "take client addresses from X-Forwarded-For"},
+	{key: "RATE_LIMIT_STORE", flag: "rate-limit-store", def: "memory", usage: "memory, or mysql to share limits across replicas"},
+	{key: "LOGIN_RATE_IP", flag: "login-rate-ip", def: "60/1m", usage: "login attempts allowed per client address"},
+	{key: "LOGIN_RATE_ACCOUNT", flag: "login-rate-account", def: "10/1m", usage: "login attempts allowed per account"},
+	{key: "JOIN_RATE_IP", flag: "join-rate-ip", def: "300/1m", usage: "session joins allowed per client address"},
+	{key: "JOIN_RATE_ACCOUNT", flag: "join-rate-account", def: "10/1m", usage: "session joins allowed per student"},
+	{key: "LOGIN_MAX_FAILURES", flag: "login-max-failures", def: "5", usage: "consecutive failed logins before an account locks"},
+	{key: "LOGIN_LOCKOUT", flag: "login-lockout", def: "1m", usage: "first lockout; doubles with each further failure"},
+	{key: "LOGIN_LOCKOUT_MAX", flag: "login-lockout-max", def: "1h", usage: "longest lockout"},
+}
+
+// Source names, as reported by Redacted.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+var current atomic.Pointer[Config]
+
+// Get returns the configuration installed by Load, or the defaults if Load
+// has not run, as in tools that only borrow a package or two.
+func Get() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	return fallback()
+}
+
+var fallback = sync.OnceValue(func() *Config {
+	c, _ := resolve(defaults(), nil)
+	return c
+})
+
+// Load resolves the configuration from the config file, the environment and
+// args (normally os.Args[1:]), validates it and installs it for Get.
+//
+// The file is the one named by -config or CONFIG_FILE; without either,
+// ../.env or .env is used if present. It holds KEY=VALUE lines and is not
+// required.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("gd", flag.ContinueOnError)
+	file := fs.String("config", "", "config file of KEY=VALUE lines")
+	flags := make(map[string]*string)
+	for _, s := range settings {
+		if s.flag != "" {
+			flags[s.flag] = fs.String(s.flag, "", s.usage+" ("+s.key+")")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	values, sources := defaults(), make(map[string]string)
+	for key := range values {
+		sources[key] = SourceDefault
+	}
+
+	path := *file
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path = findFile("../.env", ".env")
+	}
+	if path != "" {
+		fileValues, err := godotenv.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		for _, s := range settings {
+			if v, ok := fileValues[s.key]; ok && v != "" {
+				values[s.key], sources[s.key] = v, SourceFile
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.key); ok && v != "" {
+			values[s.key], sources[s.key] = v, SourceEnv
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				values[s.key], sources[s.key] = *flags[f.Name], SourceFlag
+			}
+		}
+	})
+
+	c, err := resolve(values, sources)
+	if err != nil {
+		return nil, err
+	}
+	c.file = path
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	current.Store(c)
+	return c, nil
+}
+
+func defaults() map[string]string {
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.key] = s.def
+	}
+	return values
+}
+
+func findFile(paths ...string) string {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// resolve parses the raw values into a Config, reporting every malformed
+// value at once.
+func resolve(values, sources map[string]string) (*Config, error) {
+	c := &Config{
+		Env:              strings.ToLower(strings.TrimSpace(values["APP_ENV"])),
+		Port:             values["PORT"],
+		DBURL:            strings.TrimPrefix(values["DB_URL"], "mysql://"),
+		AdminJWTSecret:   values["JWT_SECRET"],
+		StudentJWTSecret: values["JWT_SECRET_STUDENT"],
+		LogLevel:         values["LOG_LEVEL"],
+		LogFormat:        values["LOG_FORMAT"],
+		TimeZone:         values["TIMEZONE"],
+		TLSCertFile:      values["TLS_CERT_FILE"],
+		TLSKeyFile:       values["TLS_KEY_FILE"],
+		RateLimitStore:   strings.ToLower(values["RATE_LIMIT_STORE"]),
+		values:           values,
+		sources:          sources,
+	}
+
+	var errs []error
+	duration := func(key string) time.Duration {
+		d, err := time.ParseDuration(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		return d
+	}
+	c.QRValidity = duration("QR_VALIDITY")
+	c.QuestionTimer = duration("QUESTION_TIMER")
+	c.ReadTimeout = duration("HTTP_READ_TIMEOUT")
+	c.WriteTimeout = duration("HTTP_WRITE_TIMEOUT")
+	c.IdleTimeout = duration("HTTP_IDLE_TIMEOUT")
+	c.ShutdownTimeout = duration("SHUTDOWN_TIMEOUT")
+	c.LoginLockout = duration("LOGIN_LOCKOUT")
+	c.LoginLockoutMax = duration("LOGIN_LOCKOUT_MAX")
+
+	rate := func(key string) Rate {
+		r, err := ParseRate(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		return r
+	}
+	c.LoginRateIP = rate("LOGIN_RATE_IP")
+	c.LoginRateAccount = rate("LOGIN_RATE_ACCOUNT")
+	c.JoinRateIP = rate("JOIN_RATE_IP")
+	c.JoinRateAccount = rate("JOIN_RATE_ACCOUNT")
+
+	var err error
+	if c.QRCapacity, err = strconv.Atoi(values["QR_CAPACITY"]); err != nil {
+		errs = append(errs, fmt.Errorf("QR_CAPACITY: %w", err))
+	}
+	if c.MaxBodyBytes, err = strconv.ParseInt(values["MAX_BODY_BYTES"], 10, 64); err != nil {
+		errs = append(errs, fmt.Errorf("MAX_BODY_BYTES: %w", err))
+	}
+	if c.TrustProxy, err = strconv.ParseBool(values["TRUST_PROXY"]); err != nil {
+		errs = append(errs, fmt.Errorf("TRUST_PROXY: %w", err))
+	}
+	if c.LoginMaxFailures, err = strconv.Atoi(values["LOGIN_MAX_FAILURES"]); err != nil {
+		errs = append(errs, fmt.Errorf("LOGIN_MAX_FAILURES: %w", err))
+	}
+	if c.Location, err = time.LoadLocation(c.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("TIMEZONE: %w", err))
+		c.Location = time.Local
+	}
+	return c, errors.Join(errs...)
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if c.DBURL == "" {
+		errs = append(errs, errors.New("DB_URL is required"))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT %q is not a valid port", c.Port))
+	}
+	if c.QRValidity <= 0 {
+		errs = append(errs, errors.New("QR_VALIDITY must be positive"))
+	}
+	if c.QRCapacity < 1 {
+		errs = append(errs, errors.New("QR_CAPACITY must be at least 1"))
+	}
+	if c.QuestionTimer < time.Second {
+		errs = append(errs, errors.New("QUESTION_TIMER must be at least 1s"))
+	}
+	for _, limit := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"LOGIN_LOCKOUT", c.LoginLockout},
+		{"LOGIN_LOCKOUT_MAX", c.LoginLockoutMax},
+	} {
+		if limit.d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", limit.key))
+		}
+	}
+	if c.MaxBodyBytes < 1024 {
+		errs = append(errs, errors.New("MAX_BODY_BYTES must be at least 1024"))
+	}
+	if c.RateLimitStore != "memory" && c.RateLimitStore != "mysql" {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE %q must be memory or mysql", c.RateLimitStore))
+	}
+	if c.LoginMaxFailures < 1 {
+		errs = append(errs, errors.New("LOGIN_MAX_FAILURES must be at least 1"))
+	}
+	if c.LoginLockoutMax < c.LoginLockout {
+		errs = append(errs, errors.New("LOGIN_LOCKOUT_MAX must not be shorter than LOGIN_LOCKOUT"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	for _, f := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file: %w", err))
+		}
+	}
+	if !c.IsDevelopment() {
+		for _, key := range c.InsecureDefaults() {
+			errs = append(errs, fmt.Errorf("%s must be set unless APP_ENV is a development environment (%s)",
+				key, strings.Join(developmentEnvs, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TLS reports whether the server should serve HTTPS.
+func (c *Config) TLS() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// IsProduction reports whether APP_ENV is production.
+func (c *Config) IsProduction() bool {
+	return c.Env == Production
+}
+
+// IsDevelopment reports whether APP_ENV was set to a development value.
+func (c *Config) IsDevelopment() bool {
+	return slices.Contains(developmentEnvs, c.Env)
+}
+
+// InsecureDefaults lists the secrets still set to their built-in defaults.
+func (c *Config) InsecureDefaults() []string {
+	var keys []string
+	for _, s := range settings {
+		if s.secret && c.values[s.key] == s.def {
+			keys = append(keys, s.key)
+		}
+	}
+	return keys
+}
+
+// Setting is one entry of the redacted view.
+type Setting struct {
+	Key    string `json:"key"`
+	Flag   string `json:"flag,omitempty"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Redacted describes every setting with secrets masked and the password
+// stripped from DB_URL, for display to admins.
+func (c *Config) Redacted() map[string]interface{} {
+	view := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		value := c.values[s.key]
+		switch {
+		case s.secret && value != "":
+			value = "[REDACTED]"
+		case s.key == "DB_URL":
+			value = redactDSN(value)
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = SourceDefault
+		}
+		view = append(view, Setting{Key: s.key, Flag: s.flag, Value: value, Source: source})
+	}
+	insecure := c.InsecureDefaults()
+	if insecure == nil {
+		insecure = []string{}
+	}
+	return map[string]interface{}{
+		"env":               c.Env,
+		"file":              c.file,
+		"settings":          view,
+		"insecure_defaults": insecure,
+	}
+}
+
+// redactDSN masks the password in a user:password@tcp(host)/db DSN.
+func redactDSN(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "mysql://")
+	at := strings.LastIndex(dsn, "@")
+	if at < 0 {
+		return dsn
+	}
+	if colon := strings.Index(dsn[:at], ":"); colon >= 0 {
+		return dsn[:colon] + ":[REDACTED]" + dsn[at:]
+	}
+	return dsn
+}
@@ -1,30 +1,23 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"os"
-	"strings"
+	"errors"
+	"sync/atomic"
 
-	"github.com/joho/godotenv"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var DB *sql.DB
 
-// Initialize handles all database setup
-func Initialize() error {
-	// Load .env file
-	err := godotenv.Load("../.env")
-	if err != nil {
-		return err
-	}
+// migrated is set once InitDB has brought the schema up to date.
+var migrated atomic.Bool
 
-	// Get and parse database connection string
-	dbURL := os.Getenv("DB_URL")
-	dbURL = strings.TrimPrefix(dbURL, "mysql://")
+// Initialize connects to the database at dbURL and migrates its schema
+func Initialize(dbURL string) error {
 	if dbURL == "" {
-		log.Fatal("DB_URL not set in .env file")
+		return errors.New("no database URL configured")
 	}
 
 	// Create connection
@@ -39,7 +32,23 @@ func Initialize() error {
 	}
 
 	DB = db
-	return InitDB(db)
+	if err := InitDB(db); err != nil {
+		return err
+	}
+	migrated.Store(true)
+	return nil
+}
+
+// Ready reports whether the database answers a ping and this process has
+// finished migrating its schema.
+func Ready(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not connected")
+	}
+	if !migrated.Load() {
+		return errors.New("schema migrations have not completed")
+	}
+	return DB.PingContext(ctx)
 }
 
 // GetDB returns the global database connection
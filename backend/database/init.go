@@ -3,8 +3,8 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"time"
+	"log/slog"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -54,13 +54,22 @@ func InitDB(db *sql.DB) error {
             venue_id VARCHAR(36),
             level INT NOT NULL,
             topic_id VARCHAR(36) NULL,
+            topic_pinned BOOLEAN DEFAULT FALSE,
             start_time TIMESTAMP NOT NULL,
              qr_group_id VARCHAR(36) NULL,
             end_time DATETIME NOT NULL,
             agenda JSON DEFAULT (JSON_OBJECT()),
             survey_weights JSON DEFAULT (JSON_OBJECT()),
             max_capacity INT DEFAULT 10,
-            status ENUM('pending','active','completed','cancelled') DEFAULT 'pending',
+            moderator_id VARCHAR(36) NULL,
+            agenda_template_id VARCHAR(36) NULL,
+            speaking_mode VARCHAR(10) NOT NULL DEFAULT 'moderated',
+            speaking_turn_cap_seconds INT NULL,
+            survey_started_at DATETIME NULL,
+            survey_end_time DATETIME NULL,
+            survey_finalized_at DATETIME NULL,
+            status ENUM('scheduled','lobby','in_progress','surveying','scoring','published','cancelled','abandoned') NOT NULL DEFAULT 'scheduled',
+            phase VARCHAR(30) NULL,
             created_by VARCHAR(36),
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
             FOREIGN KEY (venue_id) REFERENCES venues(id) ON DELETE CASCADE,
@@ -158,6 +167,7 @@ func InitDB(db *sql.DB) error {
          level INT PRIMARY KEY,
         prep_time INT NOT NULL,
         discussion_time INT NOT NULL,
+        survey_time INT NOT NULL DEFAULT 5,
         penalty_threshold DECIMAL(3,1) NOT NULL,
         allow_override BOOLEAN DEFAULT TRUE,
         updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
@@ -170,16 +180,25 @@ func InitDB(db *sql.DB) error {
     level INT NOT NULL,
     topic_text TEXT NOT NULL,
     prep_materials JSON,
+    category VARCHAR(50) NULL,
+    difficulty VARCHAR(10) NULL,
+    tags JSON,
     is_active BOOLEAN DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     UNIQUE KEY unique_level_topic (level, topic_text(255))
 )`,
 
+`CREATE TABLE IF NOT EXISTS level_topic_categories (
+    level INT NOT NULL,
+    category VARCHAR(50) NOT NULL,
+    PRIMARY KEY (level, category)
+)`,
+
 `CREATE TABLE IF NOT EXISTS session_phase_tracking (
     session_id VARCHAR(36),
     student_id VARCHAR(36),
-    phase ENUM('prep', 'discussion', 'survey') NOT NULL,
+    phase VARCHAR(30) NOT NULL,
     start_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY (session_id, student_id, phase),
     FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
@@ -191,6 +210,8 @@ func InitDB(db *sql.DB) error {
     first_place_points DECIMAL(3,1) DEFAULT 4.0,
     second_place_points DECIMAL(3,1) DEFAULT 3.0,
     third_place_points DECIMAL(3,1) DEFAULT 2.0,
+    speaking_points_per_minute DECIMAL(4,2) DEFAULT 0,
+    speaking_points_cap DECIMAL(4,1) DEFAULT 0,
     level INT DEFAULT 1,
     is_active BOOLEAN DEFAULT TRUE,
     created_by VARCHAR(36),
@@ -218,6 +239,7 @@ func InitDB(db *sql.DB) error {
      median_score DECIMAL(5,2) DEFAULT 0.00,
 deviation FLOAT DEFAULT 0,
 penalty_calculated BOOLEAN DEFAULT FALSE,
+    question_version INT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 /**    Dont remove this ----- >
  CREATE INDEX IF NOT EXISTS idx_survey_results_session_completed ON survey_results (session_id, is_completed) 
@@ -366,10 +388,63 @@ WHERE id = NEW.id`,
     is_active BOOLEAN DEFAULT TRUE,
     level INT DEFAULT 1 ,
     display_order INT DEFAULT 0,
+    current_version INT NOT NULL DEFAULT 1,
+    deleted_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 )`,
 
+`CREATE TABLE IF NOT EXISTS question_versions (
+    id VARCHAR(36) PRIMARY KEY,
+    question_id VARCHAR(36) NOT NULL,
+    version INT NOT NULL,
+    question_text TEXT NOT NULL,
+    weight DECIMAL(3,1) NOT NULL,
+    levels JSON,
+    is_active BOOLEAN NOT NULL,
+    created_by VARCHAR(36) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY unique_question_version (question_id, version)
+)`,
+
+`CREATE TABLE IF NOT EXISTS question_sets (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    level INT NOT NULL,
+    weight_target DECIMAL(5,1) NOT NULL,
+    active_from DATETIME NULL,
+    active_until DATETIME NULL,
+    is_active BOOLEAN DEFAULT FALSE,
+    created_by VARCHAR(36) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_question_sets_level (level, is_active)
+)`,
+
+`CREATE TABLE IF NOT EXISTS question_set_items (
+    set_id VARCHAR(36) NOT NULL,
+    question_id VARCHAR(36) NOT NULL,
+    position INT NOT NULL,
+    weight DECIMAL(3,1) NULL,
+    PRIMARY KEY (set_id, question_id),
+    UNIQUE KEY unique_set_position (set_id, position),
+    FOREIGN KEY (set_id) REFERENCES question_sets(id) ON DELETE CASCADE,
+    FOREIGN KEY (question_id) REFERENCES survey_questions(id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS session_question_snapshots (
+    session_id VARCHAR(36) NOT NULL,
+    question_number INT NOT NULL,
+    question_id VARCHAR(36) NOT NULL,
+    question_version INT NOT NULL,
+    question_text TEXT NOT NULL,
+    weight DECIMAL(3,1) NOT NULL,
+    question_set_id VARCHAR(36) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, question_number),
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE
+)`,
+
 
 `CREATE TABLE IF NOT EXISTS question_levels (
     question_id VARCHAR(36),
@@ -396,11 +471,36 @@ WHERE id = NEW.id`,
     session_id VARCHAR(36) NOT NULL,
     student_id VARCHAR(36) NOT NULL,
     completed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    auto_submitted BOOLEAN NOT NULL DEFAULT FALSE,
     PRIMARY KEY (session_id, student_id),
     FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
     FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE
 );`,
 
+`CREATE TABLE IF NOT EXISTS idempotency_keys (
+    student_id VARCHAR(36) NOT NULL,
+    idem_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT NULL,
+    content_type VARCHAR(100) NULL,
+    response_body MEDIUMBLOB NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (student_id, idem_key),
+    INDEX idx_idempotency_keys_expires (expires_at)
+);`,
+
+`CREATE TABLE IF NOT EXISTS survey_drafts (
+    session_id VARCHAR(36) NOT NULL,
+    responder_id VARCHAR(36) NOT NULL,
+    responses JSON NOT NULL,
+    current_question INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, responder_id),
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (responder_id) REFERENCES student_users(id) ON DELETE CASCADE
+);`,
+
 `CREATE TABLE IF NOT EXISTS survey_timing (
     session_id VARCHAR(36) NOT NULL,
     student_id VARCHAR(36) NOT NULL,
@@ -423,14 +523,243 @@ WHERE id = NEW.id`,
 
 `CREATE TABLE IF NOT EXISTS session_timers (
     session_id VARCHAR(36) PRIMARY KEY,
-    phase ENUM('prep', 'discussion', 'survey') NOT NULL,
+    phase VARCHAR(30) NOT NULL,
     start_time DATETIME NOT NULL,
     duration_seconds INT NOT NULL,
     is_active BOOLEAN DEFAULT TRUE,
+    phase_index INT NOT NULL DEFAULT 0,
+    paused_at DATETIME NULL,
+    accumulated_seconds INT NOT NULL DEFAULT 0,
+    extension_seconds INT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE
 )`,
+
+`CREATE TABLE IF NOT EXISTS agenda_templates (
+    id VARCHAR(36) PRIMARY KEY,
+    level INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    is_active BOOLEAN DEFAULT FALSE,
+    created_by VARCHAR(36),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES admin_users(id) ON DELETE SET NULL,
+    INDEX idx_agenda_templates_level (level, is_active)
+)`,
+
+`CREATE TABLE IF NOT EXISTS agenda_template_phases (
+    id VARCHAR(36) PRIMARY KEY,
+    template_id VARCHAR(36) NOT NULL,
+    phase_order INT NOT NULL,
+    phase_type VARCHAR(30) NOT NULL,
+    label VARCHAR(100),
+    duration_seconds INT NOT NULL DEFAULT 0,
+    per_speaker_seconds INT NULL,
+    FOREIGN KEY (template_id) REFERENCES agenda_templates(id) ON DELETE CASCADE,
+    UNIQUE KEY unique_template_order (template_id, phase_order)
+)`,
+
+`CREATE TABLE IF NOT EXISTS speaking_queue (
+    id VARCHAR(36) PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    student_id VARCHAR(36) NOT NULL,
+    status ENUM('waiting', 'speaking', 'done', 'withdrawn') DEFAULT 'waiting',
+    raised_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    granted_at DATETIME NULL,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    INDEX idx_speaking_queue_session (session_id, status)
+)`,
+
+`CREATE TABLE IF NOT EXISTS speaking_turns (
+    id VARCHAR(36) PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    student_id VARCHAR(36) NOT NULL,
+    queue_id VARCHAR(36),
+    started_at DATETIME NOT NULL,
+    ended_at DATETIME NULL,
+    duration_seconds INT NULL,
+    cap_seconds INT NULL,
+    ended_by VARCHAR(20) NULL,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    INDEX idx_speaking_turns_session (session_id, ended_at)
+)`,
+
+`CREATE TABLE IF NOT EXISTS speaking_stats (
+    session_id VARCHAR(36) NOT NULL,
+    student_id VARCHAR(36) NOT NULL,
+    total_seconds INT NOT NULL DEFAULT 0,
+    turn_count INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, student_id),
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE
+)`,
+
+`CREATE TABLE IF NOT EXISTS session_timer_events (
+    id VARCHAR(36) PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    phase VARCHAR(20) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    seconds INT DEFAULT 0,
+    reason TEXT,
+    actor_id VARCHAR(36) NOT NULL,
+    actor_role VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    INDEX idx_timer_events_session (session_id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS result_appeals (
+    id VARCHAR(36) PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    student_id VARCHAR(36) NOT NULL,
+    reason TEXT NOT NULL,
+    status ENUM('pending', 'upheld', 'penalties_removed', 'rescored') DEFAULT 'pending',
+    decision_notes TEXT,
+    excluded_responder_id VARCHAR(36),
+    reviewed_by VARCHAR(36),
+    reviewed_at DATETIME,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    UNIQUE KEY (session_id, student_id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS appeal_audit_log (
+    id VARCHAR(36) PRIMARY KEY,
+    appeal_id VARCHAR(36) NOT NULL,
+    actor_id VARCHAR(36) NOT NULL,
+    actor_role VARCHAR(20) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    details JSON,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (appeal_id) REFERENCES result_appeals(id) ON DELETE CASCADE,
+    INDEX idx_appeal_audit_appeal (appeal_id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS session_group_assignments (
+    id VARCHAR(36) PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    student_id VARCHAR(36) NOT NULL,
+    venue_id VARCHAR(36) NOT NULL,
+    group_number INT NOT NULL,
+    table_label VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY unique_group_assignment (session_id, student_id),
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    FOREIGN KEY (venue_id) REFERENCES venues(id) ON DELETE CASCADE,
+    INDEX idx_group_assignment_student (student_id, venue_id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS job_runs (
+    job_name VARCHAR(100) PRIMARY KEY,
+    schedule VARCHAR(100) NOT NULL,
+    holder VARCHAR(255) NULL,
+    lease_version BIGINT NOT NULL DEFAULT 0,
+    lease_until DATETIME NULL,
+    next_run_at DATETIME NOT NULL,
+    last_started_at DATETIME NULL,
+    last_finished_at DATETIME NULL,
+    last_duration_ms BIGINT NULL,
+    last_status ENUM('success', 'failed') NULL,
+    last_error TEXT NULL,
+    run_count INT NOT NULL DEFAULT 0,
+    failure_count INT NOT NULL DEFAULT 0
+)`,
+
+`CREATE TABLE IF NOT EXISTS student_notifications (
+    id VARCHAR(36) PRIMARY KEY,
+    student_id VARCHAR(36) NOT NULL,
+    session_id VARCHAR(36) NULL,
+    kind VARCHAR(50) NOT NULL,
+    message TEXT NOT NULL,
+    is_read BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY unique_session_notification (student_id, session_id, kind),
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    INDEX idx_notifications_student (student_id, is_read, created_at)
+)`,
+
+`CREATE TABLE IF NOT EXISTS session_status_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL,
+    from_status VARCHAR(20) NULL,
+    to_status VARCHAR(20) NOT NULL,
+    phase VARCHAR(30) NULL,
+    actor_id VARCHAR(36) NULL,
+    actor_role ENUM('student', 'admin', 'system') NOT NULL,
+    reason VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES gd_sessions(id) ON DELETE CASCADE,
+    INDEX idx_status_history_session (session_id, id)
+)`,
+
+`CREATE TABLE IF NOT EXISTS booking_priority_passes (
+    id VARCHAR(36) PRIMARY KEY,
+    student_id VARCHAR(36) NOT NULL,
+    level INT NOT NULL,
+    source_session_id VARCHAR(36) NULL,
+    reason VARCHAR(255) NULL,
+    expires_at DATETIME NOT NULL,
+    redeemed_at DATETIME NULL,
+    redeemed_session_id VARCHAR(36) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (student_id) REFERENCES student_users(id) ON DELETE CASCADE,
+    FOREIGN KEY (source_session_id) REFERENCES gd_sessions(id) ON DELETE SET NULL,
+    INDEX idx_priority_passes_student (student_id, level, redeemed_at)
+)`,
+
+`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    bucket_key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    INDEX idx_rate_limit_buckets_updated (updated_at)
+)`,
+
+`CREATE TABLE IF NOT EXISTS login_lockouts (
+    account_type ENUM('admin', 'student') NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    failed_count INT NOT NULL DEFAULT 0,
+    last_failed_at DATETIME NOT NULL,
+    locked_until DATETIME NULL,
+    PRIMARY KEY (account_type, account)
+)`,
+
+`CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    account_type ENUM('admin', 'student') NOT NULL,
+    account VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    request_id VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_login_attempts_account (account_type, account, created_at),
+    INDEX idx_login_attempts_ip (ip, created_at),
+    INDEX idx_login_attempts_created (created_at)
+)`,
+
+`CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor_id VARCHAR(36) NULL,
+    actor_role ENUM('admin', 'student', 'system') NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(255) NULL,
+    before_json JSON NULL,
+    after_json JSON NULL,
+    ip VARCHAR(64) NULL,
+    request_id VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_audit_log_created (created_at),
+    INDEX idx_audit_log_actor (actor_id, created_at),
+    INDEX idx_audit_log_entity (entity_type, entity_id, created_at),
+    INDEX idx_audit_log_action (action, created_at)
+)`,
     }
 
     for _, query := range createTables {
@@ -439,6 +768,86 @@ WHERE id = NEW.id`,
         }
     }
 
+    // Columns added after the original tables shipped. CREATE TABLE IF NOT
+    // EXISTS won't touch existing tables, so add them here when missing.
+    columnMigrations := []struct {
+        table, column, definition string
+    }{
+        {"session_timers", "paused_at", "DATETIME NULL"},
+        {"session_timers", "accumulated_seconds", "INT NOT NULL DEFAULT 0"},
+        {"session_timers", "extension_seconds", "INT NOT NULL DEFAULT 0"},
+        {"gd_sessions", "moderator_id", "VARCHAR(36) NULL"},
+        {"gd_sessions", "agenda_template_id", "VARCHAR(36) NULL"},
+        {"session_timers", "phase_index", "INT NOT NULL DEFAULT 0"},
+        {"gd_sessions", "speaking_mode", "VARCHAR(10) NOT NULL DEFAULT 'moderated'"},
+        {"gd_sessions", "speaking_turn_cap_seconds", "INT NULL"},
+        {"ranking_points_config", "speaking_points_per_minute", "DECIMAL(4,2) DEFAULT 0"},
+        {"ranking_points_config", "speaking_points_cap", "DECIMAL(4,1) DEFAULT 0"},
+        {"gd_topics", "category", "VARCHAR(50) NULL"},
+        {"gd_topics", "difficulty", "VARCHAR(10) NULL"},
+        {"gd_topics", "tags", "JSON"},
+        {"survey_questions", "current_version", "INT NOT NULL DEFAULT 1"},
+        {"survey_questions", "deleted_at", "TIMESTAMP NULL"},
+        {"survey_results", "question_version", "INT NULL"},
+        {"session_question_snapshots", "question_set_id", "VARCHAR(36) NULL"},
+        {"gd_sessions", "topic_pinned", "BOOLEAN DEFAULT FALSE"},
+        {"gd_sessions", "survey_started_at", "DATETIME NULL"},
+        {"gd_sessions", "survey_end_time", "DATETIME NULL"},
+        {"gd_sessions", "survey_finalized_at", "DATETIME NULL"},
+        {"survey_completion", "auto_submitted", "BOOLEAN NOT NULL DEFAULT FALSE"},
+        {"gd_rules", "survey_time", "INT NOT NULL DEFAULT 5"},
+        {"gd_sessions", "phase", "VARCHAR(30) NULL"},
+        // The part of penalty_points that came from deviation (bias) checks
+        {"survey_results", "bias_penalty_points", "FLOAT NOT NULL DEFAULT 0"},
+        // The full venue a priority pass holder is waiting for a seat at
+        {"booking_priority_passes", "reserved_venue_id", "VARCHAR(36) NULL"},
+        {"booking_priority_passes", "reserved_at", "DATETIME NULL"},
+    }
+
+    for _, m := range columnMigrations {
+        if err := ensureColumn(db, m.table, m.column, m.definition); err != nil {
+            return fmt.Errorf("error migrating %s.%s: %v", m.table, m.column, err)
+        }
+    }
+
+    // Phase columns started out as ENUM('prep','discussion','survey');
+    // agenda templates need arbitrary phase types.
+    typeMigrations := []struct {
+        table, column, dataType, definition string
+    }{
+        {"session_phase_tracking", "phase", "varchar", "VARCHAR(30) NOT NULL"},
+        {"session_timers", "phase", "varchar", "VARCHAR(30) NOT NULL"},
+    }
+
+    for _, m := range typeMigrations {
+        if err := ensureColumnType(db, m.table, m.column, m.dataType, m.definition); err != nil {
+            return fmt.Errorf("error migrating %s.%s: %v", m.table, m.column, err)
+        }
+    }
+
+    if err := migrateSessionStatuses(db); err != nil {
+        return fmt.Errorf("error migrating session statuses: %v", err)
+    }
+
+    // The audit log is append-only. Creating triggers needs the TRIGGER
+    // privilege (and SUPER with binary logging on); an audit log that can
+    // be rewritten is worse than none, so refuse to start without them.
+    if err := ensureAppendOnly(db, "audit_log"); err != nil {
+        return fmt.Errorf("error making audit_log append-only: %v", err)
+    }
+
+    // Questions created before versioning get their current state as version 1
+    if _, err := db.Exec(`
+        INSERT IGNORE INTO question_versions
+        (id, question_id, version, question_text, weight, levels, is_active)
+        SELECT UUID(), q.id, q.current_version, q.question_text, q.weight,
+               (SELECT JSON_ARRAYAGG(ql.level) FROM question_levels ql WHERE ql.question_id = q.id),
+               q.is_active
+        FROM survey_questions q
+        WHERE NOT EXISTS (SELECT 1 FROM question_versions v WHERE v.question_id = q.id)`); err != nil {
+        return fmt.Errorf("error backfilling question versions: %v", err)
+    }
+
     // Insert sample data with IGNORE to skip existing records
     sampleData := []string{
         // Admin user
@@ -459,7 +868,7 @@ WHERE id = NEW.id`,
 
     for _, query := range sampleData {
         if _, err := db.Exec(query); err != nil {
-            log.Printf("Warning: inserting sample data: %v (this might be expected if data already exists)", err)
+            slog.Warn("Could not insert sample data (expected if it already exists)", "error", err)
         }
     }
 adminPassword := "admin123" 
@@ -474,12 +883,12 @@ _, err = db.Exec(
     string(hashedPassword),
 )
 if err != nil {
-    log.Printf("Warning: inserting admin user: %v", err)
+    slog.Warn("Could not insert admin user", "error", err)
 }
 
 hashedStudentPassword, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
 if err != nil {
-    log.Printf("Error hashing password: %v", err)
+    slog.Error("Error hashing password", "error", err)
     // return
 }
 
@@ -497,20 +906,115 @@ _, err = db.Exec(
     true,
 )
 if err != nil {
-    log.Printf("Error inserting test student: %v", err)
+    slog.Error("Error inserting test student", "error", err)
+}
+
+    return nil
 }
+// migrateSessionStatuses moves gd_sessions from the original
+// pending/active/completed statuses onto the session lifecycle. Active
+// sessions land in the lobby, or on their timer's phase once it has started.
+func migrateSessionStatuses(db *sql.DB) error {
+    var columnType string
+    err := db.QueryRow(`
+        SELECT COLUMN_TYPE
+        FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'gd_sessions' AND COLUMN_NAME = 'status'`).Scan(&columnType)
+    if err != nil {
+        return err
+    }
+    if strings.Contains(columnType, "'scheduled'") {
+        return nil
+    }
 
- go func() {
-        for {
-            time.Sleep(30 * time.Minute) // Run every 30 minutes
-            _, err := db.Exec(`
-                DELETE FROM session_phase_tracking 
-                WHERE start_time < DATE_SUB(NOW(), INTERVAL 30 MINUTE)`)
-            if err != nil {
-                log.Printf("Error cleaning up phase tracking: %v", err)
-            }
+    steps := []string{
+        `ALTER TABLE gd_sessions MODIFY COLUMN status
+            ENUM('pending','active','completed','scheduled','lobby','in_progress','surveying','scoring','published','cancelled','abandoned')
+            NOT NULL DEFAULT 'scheduled'`,
+        `UPDATE gd_sessions s
+        LEFT JOIN session_timers t ON t.session_id = s.id
+        SET s.status = CASE
+                WHEN t.session_id IS NULL THEN 'lobby'
+                WHEN t.phase = 'survey' THEN 'surveying'
+                ELSE 'in_progress'
+            END,
+            s.phase = CASE WHEN t.phase <> 'survey' THEN t.phase END
+        WHERE s.status = 'active'`,
+        `UPDATE gd_sessions SET status = 'scheduled' WHERE status = 'pending'`,
+        `UPDATE gd_sessions SET status = 'published' WHERE status = 'completed'`,
+        `ALTER TABLE gd_sessions MODIFY COLUMN status
+            ENUM('scheduled','lobby','in_progress','surveying','scoring','published','cancelled','abandoned')
+            NOT NULL DEFAULT 'scheduled'`,
+    }
+    for _, step := range steps {
+        if _, err := db.Exec(step); err != nil {
+            return err
         }
-    }()
+    }
+    return nil
+}
 
+// ensureAppendOnly adds triggers rejecting every UPDATE and DELETE on
+// table, unless they already exist.
+func ensureAppendOnly(db *sql.DB, table string) error {
+    for _, event := range []string{"UPDATE", "DELETE"} {
+        name := fmt.Sprintf("%s_no_%s", table, strings.ToLower(event))
+        var exists bool
+        err := db.QueryRow(`
+            SELECT COUNT(*) > 0
+            FROM information_schema.TRIGGERS
+            WHERE TRIGGER_SCHEMA = DATABASE() AND TRIGGER_NAME = ?`, name).Scan(&exists)
+        if err != nil {
+            return err
+        }
+        if exists {
+            continue
+        }
+        _, err = db.Exec(fmt.Sprintf(`
+            CREATE TRIGGER %s BEFORE %s ON %s FOR EACH ROW
+            SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '%s is append-only'`,
+            name, event, table, table))
+        if err != nil {
+            return err
+        }
+    }
     return nil
-}
\ No newline at end of file
+}
+
+// ensureColumn adds column to table unless it already exists.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+    var exists bool
+    err := db.QueryRow(`
+        SELECT COUNT(*) > 0
+        FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+        table, column).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if exists {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+    return err
+}
+
+// ensureColumnType redefines column when its data type isn't dataType.
+func ensureColumnType(db *sql.DB, table, column, dataType, definition string) error {
+    var current string
+    err := db.QueryRow(`
+        SELECT DATA_TYPE
+        FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+        table, column).Scan(&current)
+    if err != nil {
+        return err
+    }
+    if current == dataType {
+        return nil
+    }
+
+    _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, definition))
+    return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"gd/database"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds how long /readyz waits on the database.
+const readinessTimeout = 2 * time.Second
+
+// handleHealthz reports that the process is up. It checks nothing else, so
+// a slow database never gets the process restarted.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the process can serve traffic: the database
+// answers and the schema has been migrated.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := database.Ready(ctx); err != nil {
+		slog.WarnContext(r.Context(), "Readiness check failed", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
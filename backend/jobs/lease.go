@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"gd/database"
+	"math"
+	"time"
+)
+
+// lease identifies one claimed run of a job. The version is bumped on every
+// claim, so a holder whose lease expired can't overwrite the next run's row.
+type lease struct {
+	holder  string
+	version int64
+}
+
+// maxErrorLength keeps last_error within its TEXT column.
+const maxErrorLength = 60000
+
+// secondsUntil is how far the next run after now is, rounded up so a job
+// never falls due before its schedule says.
+func secondsUntil(schedule Schedule, now time.Time) int {
+	return int(math.Ceil(schedule.Next(now).Sub(now).Seconds()))
+}
+
+// registerJob creates the job's row, due at its next scheduled time. Times
+// are computed by MySQL from NOW() so every replica agrees on them whatever
+// its clock or time zone.
+func registerJob(job Job, now time.Time) error {
+	_, err := database.GetDB().Exec(`
+        INSERT INTO job_runs (job_name, schedule, next_run_at)
+        VALUES (?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))
+        ON DUPLICATE KEY UPDATE schedule = VALUES(schedule)`,
+		job.Name, job.Spec, secondsUntil(job.Schedule, now))
+	return err
+}
+
+// acquireLease claims the job for holder if it is due and no other replica
+// holds an unexpired lease on it.
+func acquireLease(job Job, holder string) (lease, bool, error) {
+	db := database.GetDB()
+	result, err := db.Exec(`
+        UPDATE job_runs
+        SET holder = ?,
+            lease_version = lease_version + 1,
+            lease_until = DATE_ADD(NOW(), INTERVAL ? SECOND),
+            last_started_at = NOW()
+        WHERE job_name = ?
+          AND (lease_until IS NULL OR lease_until < NOW())
+          AND next_run_at <= NOW()`,
+		holder, int(math.Ceil(job.Timeout.Seconds())), job.Name)
+	if err != nil {
+		return lease{}, false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return lease{}, false, err
+	}
+
+	l := lease{holder: holder}
+	err = db.QueryRow(`
+        SELECT lease_version FROM job_runs
+        WHERE job_name = ? AND holder = ?`, job.Name, holder).Scan(&l.version)
+	if err != nil {
+		return lease{}, false, err
+	}
+	return l, true, nil
+}
+
+// releaseLease records the outcome of a run and schedules the next one.
+func releaseLease(job Job, l lease, duration time.Duration, runErr error) error {
+	status, lastError, failed := "success", "", 0
+	if runErr != nil {
+		status, lastError, failed = "failed", runErr.Error(), 1
+		if len(lastError) > maxErrorLength {
+			lastError = lastError[:maxErrorLength]
+		}
+	}
+
+	_, err := database.GetDB().Exec(`
+        UPDATE job_runs
+        SET lease_until = NULL,
+            next_run_at = DATE_ADD(NOW(), INTERVAL ? SECOND),
+            last_finished_at = NOW(),
+            last_duration_ms = ?,
+            last_status = ?,
+            last_error = NULLIF(?, ''),
+            run_count = run_count + 1,
+            failure_count = failure_count + ?
+        WHERE job_name = ? AND holder = ? AND lease_version = ?`,
+		secondsUntil(job.Schedule, time.Now()), duration.Milliseconds(), status, lastError, failed,
+		job.Name, l.holder, l.version)
+	return err
+}
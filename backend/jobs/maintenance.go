@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	admin "gd/admin/controllers"
+	"gd/database"
+	student "gd/student/controllers"
+	"log/slog"
+	"time"
+)
+
+// Maintenance returns the jobs every backend replica schedules.
+func Maintenance() []Job {
+	return []Job{
+		NewJob("finalize-survey-deadlines", "@every 15s", time.Minute, student.FinalizeDueSurveys),
+		NewJob("expire-qr-codes", "*/5 * * * *", time.Minute, func(ctx context.Context) error {
+			return admin.CleanupExpiredQRCodes()
+		}),
+		NewJob("deactivate-past-venues", "@hourly", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Deactivated past venues", admin.DeactivatePastVenues)
+		}),
+		NewJob("close-abandoned-sessions", "*/10 * * * *", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Closed abandoned sessions", func() (int64, error) {
+				return student.CloseAbandonedSessions(ctx, 30*time.Minute)
+			})
+		}),
+		NewJob("purge-ready-status", "15 * * * *", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Purged stale ready flags", func() (int64, error) {
+				return student.PurgeStaleReadyStatus(ctx, 6*time.Hour)
+			})
+		}),
+		NewJob("purge-phase-tracking", "*/30 * * * *", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Purged phase tracking rows", func() (int64, error) {
+				return deleteRows(ctx, `
+                    DELETE FROM session_phase_tracking
+                    WHERE start_time < DATE_SUB(NOW(), INTERVAL 30 MINUTE)`)
+			})
+		}),
+		NewJob("purge-idempotency-keys", "45 * * * *", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Purged expired idempotency keys", func() (int64, error) {
+				return deleteRows(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+			})
+		}),
+		NewJob("purge-login-throttling", "20 * * * *", 5*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Purged idle rate limit buckets and stale login failures", func() (int64, error) {
+				buckets, err := deleteRows(ctx, `
+                    DELETE FROM rate_limit_buckets
+                    WHERE updated_at < DATE_SUB(NOW(), INTERVAL 1 DAY)`)
+				if err != nil {
+					return 0, err
+				}
+				lockouts, err := deleteRows(ctx, `
+                    DELETE FROM login_lockouts
+                    WHERE last_failed_at < DATE_SUB(NOW(), INTERVAL 1 DAY)
+                      AND (locked_until IS NULL OR locked_until < NOW())`)
+				return buckets + lockouts, err
+			})
+		}),
+		NewJob("purge-login-attempts", "30 3 * * *", 10*time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Purged old login attempts", func() (int64, error) {
+				return deleteRows(ctx, `
+                    DELETE FROM login_attempts
+                    WHERE created_at < DATE_SUB(NOW(), INTERVAL 90 DAY)`)
+			})
+		}),
+		NewJob("session-reminders", "* * * * *", time.Minute, func(ctx context.Context) error {
+			return logAffected(ctx, "Sent session reminders", func() (int64, error) {
+				return student.SendSessionReminders(ctx, 30*time.Minute)
+			})
+		}),
+	}
+}
+
+// logAffected runs a cleanup and logs how many rows it touched, if any.
+func logAffected(ctx context.Context, message string, run func() (int64, error)) error {
+	affected, err := run()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		slog.InfoContext(ctx, message, "rows", affected)
+	}
+	return nil
+}
+
+func deleteRows(ctx context.Context, query string) (int64, error) {
+	result, err := database.GetDB().ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
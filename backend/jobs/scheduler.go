@@ -0,0 +1,141 @@
+// Package jobs runs periodic maintenance work. Every replica runs a
+// Scheduler over the same job list; a lease row per job in job_runs makes
+// sure each run happens on exactly one of them.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Job is a named unit of periodic work.
+type Job struct {
+	Name     string
+	Spec     string
+	Schedule Schedule
+	// Timeout bounds a run and is how long the lease is held, so a replica
+	// that dies mid-run hands the job over once it passes.
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// NewJob builds a job from a cron spec, panicking on a bad spec since job
+// lists are fixed in code.
+func NewJob(name, spec string, timeout time.Duration, run func(ctx context.Context) error) Job {
+	return Job{Name: name, Spec: spec, Schedule: MustParseSchedule(spec), Timeout: timeout, Run: run}
+}
+
+// pollInterval is how often the scheduler looks for due jobs.
+const pollInterval = 5 * time.Second
+
+// Scheduler runs jobs when they are due and this replica wins their lease.
+type Scheduler struct {
+	jobs   []Job
+	holder string
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler for jobs, identified in job_runs by the
+// host name and process ID.
+func NewScheduler(jobs ...Job) *Scheduler {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &Scheduler{
+		jobs:    jobs,
+		holder:  fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix)),
+		running: make(map[string]bool),
+	}
+}
+
+// Run registers the jobs and runs them as they fall due until ctx is done,
+// then waits for runs in flight to finish.
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, job := range s.jobs {
+		if err := registerJob(job, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Error registering job", "job", job.Name, "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "Job scheduler started", "holder", s.holder, "jobs_count", len(s.jobs))
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch starts every due job this replica can lease and isn't already
+// running.
+func (s *Scheduler) dispatch(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.mu.Lock()
+		busy := s.running[job.Name]
+		s.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		lease, ok, err := acquireLease(job, s.holder)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error acquiring lease for job", "job", job.Name, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		s.running[job.Name] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			s.execute(ctx, job, lease)
+			s.mu.Lock()
+			delete(s.running, job.Name)
+			s.mu.Unlock()
+		}(job)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, job Job, l lease) {
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	started := time.Now()
+	err := runSafely(runCtx, job)
+	duration := time.Since(started)
+	if err != nil {
+		slog.ErrorContext(ctx, "Job failed", "job", job.Name, "duration", duration, "error", err)
+	}
+
+	if err := releaseLease(job, l, duration, err); err != nil {
+		slog.ErrorContext(ctx, "Error recording run of job", "job", job.Name, "error", err)
+	}
+}
+
+// runSafely turns a panicking job into a failed run.
+func runSafely(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
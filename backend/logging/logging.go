@@ -0,0 +1,68 @@
+// Package logging sets up the process-wide structured logger. Lines logged
+// with a request's context carry its request ID, route and caller, so one
+// request can be followed through every handler and service it touches.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default logger at the given level (debug, info, warn
+// or error) and format (json or text), normally LOG_LEVEL and LOG_FORMAT.
+// Anything still written through the standard log package goes through it
+// too, at info level.
+func Setup(level, format string) {
+	slog.SetDefault(New(os.Stderr, level, format))
+}
+
+// New returns a logger writing to w at the named level and format.
+// Unknown levels and formats fall back to info and json.
+func New(w io.Writer, level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level), ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// ParseLevel maps a level name to a slog level, defaulting to info.
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// contextHandler adds the request attributes stored by Middleware and
+// SetUser to every record logged with a request's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		r.AddAttrs(info.attrs()...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in from clients and proxies and
+// back out in every response.
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// requestInfo is shared by everything handling one request. The auth
+// middlewares fill in the caller once they know who it is.
+type requestInfo struct {
+	mu     sync.Mutex
+	id     string
+	method string
+	route  string
+	role   string
+	userID string
+}
+
+func (info *requestInfo) attrs() []slog.Attr {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	attrs := []slog.Attr{
+		slog.String("request_id", info.id),
+		slog.String("method", info.method),
+		slog.String("route", info.route),
+	}
+	if info.userID != "" {
+		attrs = append(attrs, slog.String(info.role+"_id", info.userID))
+	}
+	return attrs
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if ctx == nil {
+		return nil
+	}
+	info, _ := ctx.Value(contextKey{}).(*requestInfo)
+	return info
+}
+
+// Middleware gives each request an ID, reusing a well-formed
+// X-Request-ID from the client, returns it in the response and logs the
+// request once it completes.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		info := &requestInfo{id: id, method: r.Method, route: r.URL.Path}
+		ctx := context.WithValue(r.Context(), contextKey{}, info)
+		w.Header().Set(RequestIDHeader, id)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		case r.Method == http.MethodOptions:
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "Request completed",
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", rec.bytes)
+	})
+}
+
+// SetUser records who is making the request, for every line logged with
+// its context from here on. role is "student" or "admin".
+func SetUser(ctx context.Context, role, id string) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.role, info.userID = role, id
+		info.mu.Unlock()
+	}
+}
+
+// User returns who SetUser recorded as making the request, or "" for both
+// if no one has been.
+func User(ctx context.Context) (role, id string) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.role, info.userID
+	}
+	return "", ""
+}
+
+// RequestID returns the ID Middleware gave the request, or "".
+func RequestID(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// statusRecorder remembers the status and size of a response. It passes
+// flushes and hijacks through for the event stream and websocket handlers.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.status, rec.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values are never logged.
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+\S+`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// redactAttr blanks attributes with sensitive keys and scrubs bearer
+// tokens and JWTs out of every other string, messages included.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	var s string
+	switch v := a.Value.Any().(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		return a
+	}
+	if clean := Redact(s); clean != s || a.Value.Kind() != slog.KindString {
+		return slog.String(a.Key, clean)
+	}
+	return a
+}
+
+// IsSensitiveKey reports whether values under key must never be logged or
+// stored in the clear.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact removes bearer tokens and JWTs from s.
+func Redact(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	return jwtPattern.ReplaceAllString(s, redacted)
+}
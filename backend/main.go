@@ -1,47 +1,103 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"gd/admin/middleware"
 	"gd/admin/routes"
+	"gd/audit"
+	"gd/config"
 	"gd/database"
+	"gd/jobs"
+	"gd/logging"
+	"gd/metrics"
+	"gd/ratelimit"
 	studentRoutes "gd/student/routes"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	if err := database.Initialize(); err != nil {
-		log.Fatal("Database initialization failed:", err)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logging.Setup(cfg.LogLevel, cfg.LogFormat)
+	if insecure := cfg.InsecureDefaults(); len(insecure) > 0 {
+		slog.Warn("Using built-in development secrets; set them before deploying", "keys", insecure, "env", cfg.Env)
+	}
+
+	if err := database.Initialize(cfg.DBURL); err != nil {
+		slog.Error("Database initialization failed", "error", err)
+		os.Exit(1)
 	}
 	defer database.GetDB().Close()
+	metrics.RegisterDBStats(database.GetDB())
+	if cfg.RateLimitStore == "mysql" {
+		ratelimit.SetStore(ratelimit.NewSQLStore(database.GetDB()))
+	}
+
+	// SIGINT or SIGTERM starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Periodic maintenance, leased so each run happens on one replica.
+	// It stops with ctx, once in-flight runs return.
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		jobs.NewScheduler(jobs.Maintenance()...).Run(ctx)
+	}()
 
 	// Parent mux
 	mainMux := http.NewServeMux()
 
+	// Probes and metrics, unauthenticated for the orchestrator and scraper
+	mainMux.HandleFunc("/healthz", handleHealthz)
+	mainMux.HandleFunc("/readyz", handleReadyz)
+	mainMux.Handle("/metrics", metrics.Handler())
+
 	// Admin routes
 	adminRouter := routes.SetupAdminRoutes()
-	mainMux.Handle("/api/gd/admin/", middleware.EnableCORS(adminRouter))
+	mainMux.Handle("/api/gd/admin/", middleware.EnableCORS(audit.Middleware(adminRouter)))
 
 	// Student routes
 	studentRouter := studentRoutes.SetupStudentRoutes()
-	mainMux.Handle("/api/gd/student/", middleware.EnableCORS(studentRouter))
+	mainMux.Handle("/api/gd/student/", middleware.EnableCORS(audit.Attach(studentRouter)))
     
 	// mainMux.HandleFunc("/ws/gd-session/", handleWebSocket)
 	// Default root
 	mainMux.Handle("/", middleware.EnableCORS(http.NotFoundHandler()))
 	
-	// Get port
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8090"
+	srv := newServer(cfg, logging.Middleware(metrics.Middleware(mainMux)))
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- listen(srv, cfg) }()
+	slog.Info("Server starting", "port", cfg.Port, "env", cfg.Env, "tls", cfg.TLS())
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
 	}
+	// A second signal exits at once
+	stop()
 
-	log.Printf("Server starting on :%s...", port)
-	log.Fatal(http.ListenAndServe(":"+port, mainMux))
+	slog.Info("Shutting down", "timeout", cfg.ShutdownTimeout)
+	if err := shutdown(srv, cfg.ShutdownTimeout, jobsDone); err != nil {
+		slog.Warn("Shutdown deadline passed", "error", err)
+	}
+	slog.Info("Server stopped")
 }
 
-
 // package main
 
 // import (
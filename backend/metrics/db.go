@@ -0,0 +1,30 @@
+package metrics
+
+import "database/sql"
+
+// RegisterDBStats exports the connection pool statistics of db.
+func RegisterDBStats(db *sql.DB) {
+	stat := func(value func(sql.DBStats) float64) func() ([]Sample, error) {
+		return func() ([]Sample, error) {
+			return []Sample{{Value: value(db.Stats())}}, nil
+		}
+	}
+	NewGaugeFunc("gd_db_max_open_connections", "Maximum number of open connections to the database.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	NewGaugeFunc("gd_db_open_connections", "Established connections, in use or idle.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	NewGaugeFunc("gd_db_in_use_connections", "Connections currently in use.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	NewGaugeFunc("gd_db_idle_connections", "Idle connections.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	NewCounterFunc("gd_db_wait_count_total", "Connections waited for.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	NewCounterFunc("gd_db_wait_duration_seconds_total", "Time spent waiting for a connection.", nil,
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	NewCounterFunc("gd_db_max_idle_closed_total", "Connections closed because of the idle pool limit.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	NewCounterFunc("gd_db_max_idle_time_closed_total", "Connections closed for being idle too long.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	NewCounterFunc("gd_db_max_lifetime_closed_total", "Connections closed for reaching their maximum lifetime.", nil,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
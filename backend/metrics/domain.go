@@ -0,0 +1,35 @@
+package metrics
+
+// Domain counters, incremented where the work happens.
+var (
+	// Bookings counts venue bookings by result: ok, full, wrong_level,
+	// already_booked, not_found, outside_time or error.
+	Bookings = NewCounterVec("gd_bookings_total", "Venue bookings by result.", "result")
+
+	// Joins counts QR session joins by result: ok, expired, invalid, full,
+	// wrong_level, outside_time, not_found or error.
+	Joins = NewCounterVec("gd_session_joins_total", "QR session joins by result.", "result")
+
+	// SurveySubmissions counts survey answers by kind: response for a final
+	// submit, draft for a partial one, and auto for drafts submitted when
+	// the survey window closed.
+	SurveySubmissions = NewCounterVec("gd_survey_submissions_total", "Survey submissions by kind.", "kind")
+
+	// Penalties counts survey penalties by kind: missing_rank,
+	// missing_response or deviation.
+	Penalties = NewCounterVec("gd_penalties_applied_total", "Survey penalties applied by kind.", "kind")
+
+	// Promotions counts students moved up a level.
+	Promotions = NewCounterVec("gd_promotions_total", "Students promoted to the next level.")
+)
+
+// Abuse protection counters.
+var (
+	// RateLimited counts requests refused by a rate limit, by policy and
+	// scope (ip or account).
+	RateLimited = NewCounterVec("gd_rate_limited_total", "Requests refused by a rate limit.", "policy", "scope")
+
+	// LoginFailures counts failed logins by account type and reason:
+	// unknown_account, bad_password or locked.
+	LoginFailures = NewCounterVec("gd_login_failures_total", "Failed logins by account type and reason.", "account_type", "reason")
+)
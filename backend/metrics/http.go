@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounterVec("gd_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "code")
+	httpDuration = NewHistogramVec("gd_http_request_duration_seconds",
+		"HTTP request latency by method and route.", DefaultBuckets, "method", "route")
+)
+
+// Middleware counts and times requests. Requests are labelled with the
+// ServeMux pattern that handled them, so unknown paths all count as
+// "unmatched" rather than each becoming a series of its own.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &codeRecorder{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		// The innermost ServeMux leaves its pattern on the request
+		route := r.Pattern
+		if route == "" || route == "/" {
+			route = "unmatched"
+		}
+		httpRequests.Inc(r.Method, route, strconv.Itoa(rec.code))
+		httpDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// codeRecorder remembers a response's status code. It passes flushes and
+// hijacks through for the event stream and websocket handlers.
+type codeRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (rec *codeRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.code, rec.wroteHeader = code, true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *codeRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *codeRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *codeRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.code, rec.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (rec *codeRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
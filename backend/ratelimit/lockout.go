@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"gd/config"
+	"gd/database"
+	"gd/logging"
+	"gd/metrics"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Account types that can be locked out.
+const (
+	AdminAccount   = "admin"
+	StudentAccount = "student"
+)
+
+// Reasons recorded for failed logins.
+const (
+	ReasonUnknownAccount = "unknown_account"
+	ReasonBadPassword    = "bad_password"
+	ReasonLocked         = "locked"
+)
+
+// failureWindowSeconds is how long a failed login counts towards a
+// lockout. An account that goes a day without failing starts afresh.
+const failureWindowSeconds = 24 * 60 * 60
+
+// lockoutFor is how long failures consecutive failed logins lock an
+// account: nothing below the limit, then the base lockout doubling with
+// each further failure up to the maximum.
+func lockoutFor(failures int, cfg *config.Config) time.Duration {
+	if failures < cfg.LoginMaxFailures {
+		return 0
+	}
+	lock := cfg.LoginLockout
+	for i := cfg.LoginMaxFailures; i < failures && lock < cfg.LoginLockoutMax; i++ {
+		lock *= 2
+	}
+	return min(lock, cfg.LoginLockoutMax)
+}
+
+// LockedFor reports how much longer the account is locked out, or zero.
+func LockedFor(ctx context.Context, accountType, account string) (time.Duration, error) {
+	var seconds int
+	err := database.GetDB().QueryRowContext(ctx, `
+        SELECT TIMESTAMPDIFF(SECOND, NOW(), locked_until)
+        FROM login_lockouts
+        WHERE account_type = ? AND account = ? AND locked_until > NOW()`,
+		accountType, account).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(max(seconds, 1)) * time.Second, nil
+}
+
+// LoginFailed records a failed login and locks the account once it has
+// failed too often in a row. Failures are logged and kept in
+// login_attempts; errors saving them are logged, not returned, so they
+// never change the response.
+func LoginFailed(r *http.Request, accountType, account, reason string) {
+	ctx := r.Context()
+	ip := ClientIP(r)
+	metrics.LoginFailures.Inc(accountType, reason)
+
+	tx, err := database.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording failed login", "error", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := recordAttempt(ctx, tx, accountType, account, ip, reason); err != nil {
+		slog.ErrorContext(ctx, "Error recording failed login", "error", err)
+		return
+	}
+
+	var failures int
+	var lock time.Duration
+	if reason != ReasonLocked {
+		// failed_count is assigned first so it sees the old last_failed_at
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO login_lockouts (account_type, account, failed_count, last_failed_at)
+            VALUES (?, ?, 1, NOW())
+            ON DUPLICATE KEY UPDATE
+                failed_count = IF(last_failed_at < DATE_SUB(NOW(), INTERVAL ? SECOND), 1, failed_count + 1),
+                last_failed_at = NOW()`,
+			accountType, account, failureWindowSeconds)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+                SELECT failed_count FROM login_lockouts
+                WHERE account_type = ? AND account = ?`, accountType, account).Scan(&failures)
+		}
+		if err == nil {
+			lock = lockoutFor(failures, config.Get())
+		}
+		if err == nil && lock > 0 {
+			_, err = tx.ExecContext(ctx, `
+                UPDATE login_lockouts
+                SET locked_until = DATE_ADD(NOW(), INTERVAL ? SECOND)
+                WHERE account_type = ? AND account = ?`,
+				int(lock.Seconds()), accountType, account)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error updating login lockout", "error", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Error recording failed login", "error", err)
+		return
+	}
+
+	slog.WarnContext(ctx, "Login failed", "account_type", accountType, "account", account,
+		"ip", ip, "reason", reason, "failures", failures)
+	if lock > 0 {
+		slog.WarnContext(ctx, "Account locked out", "account_type", accountType, "account", account,
+			"failures", failures, "locked_for", lock.String())
+	}
+}
+
+func recordAttempt(ctx context.Context, tx *sql.Tx, accountType, account, ip, reason string) error {
+	var requestID interface{}
+	if id := logging.RequestID(ctx); id != "" {
+		requestID = id
+	}
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO login_attempts (account_type, account, ip, reason, request_id)
+        VALUES (?, ?, ?, ?, ?)`, accountType, account, ip, reason, requestID)
+	return err
+}
+
+// LoginSucceeded clears the account's run of failures.
+func LoginSucceeded(ctx context.Context, accountType, account string) {
+	_, err := database.GetDB().ExecContext(ctx, `
+        DELETE FROM login_lockouts WHERE account_type = ? AND account = ?`,
+		accountType, account)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error clearing login failures", "error", err)
+	}
+}
+
+// Unlock lifts an account's lockout and forgets its failures. It reports
+// whether there was anything to clear.
+func Unlock(ctx context.Context, accountType, account string) (bool, error) {
+	result, err := database.GetDB().ExecContext(ctx, `
+        DELETE FROM login_lockouts WHERE account_type = ? AND account = ?`,
+		accountType, account)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// WriteLocked answers a login for a locked account.
+func WriteLocked(w http.ResponseWriter, wait time.Duration) {
+	WriteTooManyRequests(w, wait, "Too many failed login attempts, try again later")
+}
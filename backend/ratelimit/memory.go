@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"gd/config"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops buckets that have refilled.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in this process only.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	// fullAt is when the bucket will have refilled, after which it is the
+	// same as no bucket at all.
+	fullAt time.Time
+}
+
+// NewMemoryStore returns an empty in-process store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket), now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate config.Rate) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(rate.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	tokens, allowed, wait := take(b.tokens, now.Sub(b.updated), rate)
+	b.tokens, b.updated = tokens, now
+	missing := float64(rate.Burst) - tokens
+	b.fullAt = now.Add(time.Duration(missing / float64(rate.Burst) * float64(rate.Per)))
+	return allowed, wait, nil
+}
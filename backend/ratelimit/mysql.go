@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"gd/config"
+	"time"
+)
+
+// SQLStore keeps buckets in rate_limit_buckets, so every replica draws on
+// the same ones. Elapsed time is measured by MySQL, whatever each
+// replica's clock says.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore returns a store backed by db.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Take(ctx context.Context, key string, rate config.Rate) (bool, time.Duration, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT IGNORE INTO rate_limit_buckets (bucket_key, tokens, updated_at)
+        VALUES (?, ?, NOW(6))`, key, rate.Burst)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var tokens float64
+	var elapsedMicros int64
+	err = tx.QueryRowContext(ctx, `
+        SELECT tokens, TIMESTAMPDIFF(MICROSECOND, updated_at, NOW(6))
+        FROM rate_limit_buckets WHERE bucket_key = ? FOR UPDATE`, key).Scan(&tokens, &elapsedMicros)
+	if err != nil {
+		return false, 0, err
+	}
+
+	tokens, allowed, wait := take(tokens, time.Duration(elapsedMicros)*time.Microsecond, rate)
+	_, err = tx.ExecContext(ctx, `
+        UPDATE rate_limit_buckets SET tokens = ?, updated_at = NOW(6)
+        WHERE bucket_key = ?`, tokens, key)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, wait, tx.Commit()
+}
@@ -0,0 +1,190 @@
+// Package ratelimit throttles abusable endpoints with token buckets kept per
+// client address and per account, and locks accounts out after repeated
+// failed logins.
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gd/config"
+	"gd/metrics"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps token buckets. MemoryStore suits a single replica; SQLStore
+// shares buckets between replicas through the database.
+type Store interface {
+	// Take removes a token from the bucket at key, which holds up to
+	// rate.Burst tokens refilled over rate.Per. If the bucket is empty it
+	// reports false and how long until a token is available.
+	Take(ctx context.Context, key string, rate config.Rate) (bool, time.Duration, error)
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = NewMemoryStore()
+)
+
+// SetStore replaces the store used by Throttle.
+func SetStore(s Store) {
+	storeMu.Lock()
+	store = s
+	storeMu.Unlock()
+}
+
+func currentStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// take applies the token bucket to a bucket holding tokens that was last
+// updated elapsed ago, returning its new level.
+func take(tokens float64, elapsed time.Duration, rate config.Rate) (float64, bool, time.Duration) {
+	perSecond := float64(rate.Burst) / rate.Per.Seconds()
+	tokens = math.Min(float64(rate.Burst), tokens+elapsed.Seconds()*perSecond)
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	return tokens, false, wait
+}
+
+// maxKeyLength fits keys in rate_limit_buckets.bucket_key.
+const maxKeyLength = 255
+
+func bucketKey(parts ...string) string {
+	key := strings.Join(parts, ":")
+	if len(key) > maxKeyLength {
+		sum := sha256.Sum256([]byte(key))
+		key = parts[0] + ":" + hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// Policy limits one endpoint by client address and, when Account names
+// one, by account too.
+type Policy struct {
+	// Name prefixes the bucket keys and labels the metrics.
+	Name       string
+	PerIP      config.Rate
+	PerAccount config.Rate
+	// Account identifies who the request is for, or "" if unknown.
+	Account func(r *http.Request) string
+}
+
+// Throttle answers 429 Too Many Requests once a client address or account
+// has used up its bucket. If the store fails, requests are let through.
+func Throttle(p Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if wait, limited := p.check(r.Context(), "ip", ip, p.PerIP); limited {
+			slog.WarnContext(r.Context(), "Rate limited", "policy", p.Name, "scope", "ip", "ip", ip)
+			WriteTooManyRequests(w, wait, "Too many requests, try again later")
+			return
+		}
+		if p.Account != nil {
+			if account := p.Account(r); account != "" {
+				if wait, limited := p.check(r.Context(), "account", account, p.PerAccount); limited {
+					slog.WarnContext(r.Context(), "Rate limited", "policy", p.Name, "scope", "account", "account", account, "ip", ip)
+					WriteTooManyRequests(w, wait, "Too many requests, try again later")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p Policy) check(ctx context.Context, scope, subject string, rate config.Rate) (time.Duration, bool) {
+	ok, wait, err := currentStore().Take(ctx, bucketKey(p.Name, scope, subject), rate)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rate limit store failed; allowing request", "policy", p.Name, "error", err)
+		return 0, false
+	}
+	if !ok {
+		metrics.RateLimited.Inc(p.Name, scope)
+	}
+	return wait, !ok
+}
+
+// WriteTooManyRequests answers 429 with a Retry-After of wait, rounded up
+// to whole seconds.
+func WriteTooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":               message,
+		"retry_after_seconds": seconds,
+	})
+}
+
+// ClientIP is the address the request came from: the first X-Forwarded-For
+// entry when TRUST_PROXY is set, otherwise the connection's peer.
+func ClientIP(r *http.Request) string {
+	if config.Get().TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NormalizeAccount folds a login identifier so "A@x.com " and "a@x.com"
+// share one bucket and one lockout.
+func NormalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+// JSONField identifies the account by a string field of the JSON request
+// body, leaving the body intact for the handler.
+func JSONField(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		body, err := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) != nil {
+			return ""
+		}
+		var value string
+		if json.Unmarshal(fields[name], &value) != nil {
+			return ""
+		}
+		return NormalizeAccount(value)
+	}
+}
+
+// ContextValue identifies the account by a string stored in the request
+// context, such as the student ID set by StudentOnly.
+func ContextValue(key string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		value, _ := r.Context().Value(key).(string)
+		return value
+	}
+}
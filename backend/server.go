@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gd/admin/controllers"
+	"gd/config"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxHeaderTimeout caps how long a client may take to send its headers,
+// however long the whole request is allowed.
+const maxHeaderTimeout = 10 * time.Second
+
+// newServer wraps handler in an http.Server with the configured timeouts
+// and request body limit.
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           limitBody(cfg.MaxBodyBytes, handler),
+		ReadHeaderTimeout: min(cfg.ReadTimeout, maxHeaderTimeout),
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	srv.RegisterOnShutdown(controllers.CloseLiveStreams)
+	return srv
+}
+
+// listen serves until the server is shut down, over TLS if configured.
+func listen(srv *http.Server, cfg *config.Config) error {
+	var err error
+	if cfg.TLS() {
+		err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// limitBody rejects requests declaring a body over n bytes and cuts off
+// any that turn out longer while being read.
+func limitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Request body too large"})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdown stops accepting connections and waits, within timeout, for
+// in-flight requests and background jobs to finish. The jobs are expected
+// to have been told to stop already; jobsDone is closed once they have.
+func shutdown(srv *http.Server, timeout time.Duration, jobsDone <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(ctx); err != nil {
+			errs[0] = errors.Join(errors.New("requests still in flight"), err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		select {
+		case <-jobsDone:
+		case <-ctx.Done():
+			errs[1] = errors.Join(errors.New("background jobs still running"), ctx.Err())
+		}
+	}()
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,516 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// BookedSessionLength is how long a session opened by a booking runs.
+	BookedSessionLength = 2 * time.Hour
+
+	// QRSessionLength is how long a session opened by a QR scan runs.
+	QRSessionLength = time.Hour
+
+	// PriorityPassLifetime is how long a student whose session was
+	// cancelled keeps priority for their next booking.
+	PriorityPassLifetime = 14 * 24 * time.Hour
+)
+
+var (
+	ErrVenueNotFound = errors.New("venue not found")
+	ErrVenueFull     = errors.New("venue is full")
+	ErrSessionFull   = errors.New("this session is full")
+	ErrQRGroupFull   = errors.New("this QR code has reached its capacity limit")
+	ErrAlreadyBooked = errors.New("you have already booked this venue")
+	ErrInvalidQR     = errors.New("invalid QR code format")
+	ErrQRNotFound    = errors.New("invalid or expired QR code")
+	ErrQRInactive    = errors.New("QR code is no longer active")
+	ErrOutsideHours  = errors.New("session is not active at this time")
+)
+
+// LevelMismatchError is returned when a student tries to book or join a
+// venue for a level other than their own.
+type LevelMismatchError struct {
+	StudentLevel int
+	VenueLevel   int
+}
+
+func (e *LevelMismatchError) Error() string {
+	return fmt.Sprintf("student is at level %d but the venue is for level %d", e.StudentLevel, e.VenueLevel)
+}
+
+// ActiveBookingError is returned when a student already holds a booking at
+// the level they are trying to book.
+type ActiveBookingError struct {
+	Level int
+}
+
+func (e *ActiveBookingError) Error() string {
+	return fmt.Sprintf("student already has an active booking for level %d", e.Level)
+}
+
+// Venue is a bookable room.
+type Venue struct {
+	ID       string
+	Level    int
+	Capacity int
+	IsActive bool
+	Hours    VenueHours
+}
+
+// VenueHours is when a venue runs sessions, as configured by admins.
+type VenueHours struct {
+	SessionTiming string
+	AvailableDays string
+	StartTime     string
+	EndTime       string
+}
+
+// QRCode is a venue QR code. Students who scan the same code share a
+// session, up to MaxCapacity of them.
+type QRCode struct {
+	ID           string
+	VenueID      string
+	Data         string
+	QRGroupID    string
+	MaxCapacity  int
+	CurrentUsage int
+	IsActive     bool
+}
+
+// NewSession describes a session to open at a venue.
+type NewSession struct {
+	ID      string
+	VenueID string
+	Level   int
+	Status  SessionStatus
+	// CreatedBy is the student whose booking or scan opened the session.
+	CreatedBy   string
+	QRGroupID   string
+	MaxCapacity int
+	Duration    time.Duration
+}
+
+// Booking is the outcome of a successful booking.
+type Booking struct {
+	SessionID      string
+	VenueID        string
+	BookedSeats    int
+	RemainingSeats int
+	// UsedPriorityPass is true when the student's seat came from their
+	// priority pass.
+	UsedPriorityPass bool
+	// Reserved is true when the venue was full and the student's priority
+	// pass now holds them a seat in the venue's next session. Book still
+	// returns the full error.
+	Reserved bool
+}
+
+// PriorityReservation is a priority pass waiting for a seat at a venue.
+type PriorityReservation struct {
+	PassID    string
+	StudentID string
+}
+
+// SeatRepository is the storage behind booking and joining.
+type SeatRepository interface {
+	// WithSeatLocks runs fn in one transaction, committing only if fn
+	// returns nil.
+	WithSeatLocks(fn func(tx SeatTx) error) error
+
+	StudentLevel(studentID string) (int, error)
+	// Venue returns ErrVenueNotFound if there is no such venue.
+	Venue(venueID string) (Venue, error)
+	// QRCode returns ErrQRNotFound if the code is unknown for the venue.
+	QRCode(qrData, venueID string) (QRCode, error)
+}
+
+// SeatTx is a seat allocation transaction. Locks are taken venue first,
+// then session, then QR code, so that concurrent bookings and joins queue
+// behind one another instead of deadlocking or reading stale counts.
+type SeatTx interface {
+	// LockVenue locks an active venue. It must be the first lock taken, and
+	// returns ErrVenueNotFound if the venue is missing or inactive.
+	LockVenue(venueID string) (Venue, error)
+	LockStudentLevel(studentID string) (int, error)
+	HasActiveBookingAtLevel(studentID string, level int) (bool, error)
+
+	// OpenVenueSession finds the venue's latest unexpired session.
+	OpenVenueSession(venueID string) (sessionID string, found bool, err error)
+	// AssignedGroupSession finds the session group formation placed the
+	// student in at the venue.
+	AssignedGroupSession(studentID, venueID string) (sessionID string, found bool, err error)
+	// QRGroupSession finds the running session for a QR group.
+	QRGroupSession(venueID, qrGroupID string) (sessionID string, found bool, err error)
+	CreateSession(session NewSession) error
+	// EnterLobby moves a scheduled session into the lobby when studentID
+	// arrives. Sessions past scheduled are left as they are.
+	EnterLobby(sessionID, studentID string) error
+
+	IsParticipant(sessionID, studentID string) (bool, error)
+	// VenueSeatsTaken counts students in the venue's unexpired sessions,
+	// leaving out excludeStudentID.
+	VenueSeatsTaken(venueID, excludeStudentID string) (int, error)
+	// LockSessionSeats locks a session and returns its capacity, zero if it
+	// has none of its own, and its participant count.
+	LockSessionSeats(sessionID string) (capacity, taken int, err error)
+	// ClaimQRSeat takes one use of a QR code, returning false if it is full
+	// or inactive.
+	ClaimQRSeat(qrCodeID string) (bool, error)
+	AddParticipant(sessionID, studentID string) error
+	SetCurrentBooking(studentID, sessionID string) error
+
+	// PriorityPass finds and locks an unexpired, unredeemed priority pass
+	// the student holds for the level.
+	PriorityPass(studentID string, level int) (passID string, found bool, err error)
+	RedeemPriorityPass(passID, sessionID string) error
+	// ReservePriorityPass holds the pass for the venue's next session.
+	// Reserving the same venue again keeps the pass's place in line.
+	ReservePriorityPass(passID, venueID string) error
+	// PriorityReservations lists the unexpired, unredeemed passes held for
+	// the venue, earliest reserved first.
+	PriorityReservations(venueID string) ([]PriorityReservation, error)
+
+	ClearPhaseTracking(studentID string) error
+	TrackJoin(sessionID, studentID string) error
+}
+
+// BookingService books students into venues.
+type BookingService interface {
+	// Book takes a seat for the student in the venue's open session,
+	// opening one if there is none. A new session seats students holding a
+	// priority pass for the venue before anyone else.
+	Book(studentID, venueID string) (Booking, error)
+}
+
+// SessionService places students into sessions when they scan a venue QR
+// code.
+type SessionService interface {
+	// Join puts the student in the session for their group or QR code and
+	// returns its ID. Joining again is harmless and takes no extra seat.
+	Join(studentID, qrData string) (string, error)
+}
+
+type bookingService struct {
+	repo SeatRepository
+}
+
+// NewBookingService returns a BookingService backed by repo.
+func NewBookingService(repo SeatRepository) BookingService {
+	return &bookingService{repo: repo}
+}
+
+func (s *bookingService) Book(studentID, venueID string) (Booking, error) {
+	booking := Booking{VenueID: venueID}
+	// fullErr is returned once a reservation made for a full venue has
+	// been committed
+	var fullErr error
+	err := s.repo.WithSeatLocks(func(tx SeatTx) error {
+		// Every booking for this venue waits here, so the session lookup
+		// and seat counts below can't be raced
+		venue, err := tx.LockVenue(venueID)
+		if err != nil {
+			return err
+		}
+
+		level, err := tx.LockStudentLevel(studentID)
+		if err != nil {
+			return err
+		}
+		if level != venue.Level {
+			return &LevelMismatchError{StudentLevel: level, VenueLevel: venue.Level}
+		}
+
+		active, err := tx.HasActiveBookingAtLevel(studentID, venue.Level)
+		if err != nil {
+			return err
+		}
+		if active {
+			return &ActiveBookingError{Level: venue.Level}
+		}
+
+		sessionID, found, err := tx.OpenVenueSession(venueID)
+		if err != nil {
+			return err
+		}
+		// reserved is whether priority pass holders were seated in a new
+		// session, which stands even if this student gets no seat
+		reserved := false
+		if !found {
+			sessionID = uuid.New().String()
+			err := tx.CreateSession(NewSession{
+				ID:          sessionID,
+				VenueID:     venueID,
+				Level:       venue.Level,
+				Status:      StatusScheduled,
+				CreatedBy:   studentID,
+				MaxCapacity: venue.Capacity,
+				Duration:    BookedSessionLength,
+			})
+			if err != nil {
+				return err
+			}
+			slog.Info("Created new session", "session_id", sessionID, "venue_id", venueID)
+
+			booked, err := seatReservations(tx, venue, sessionID)
+			if err != nil {
+				return err
+			}
+			reserved = len(booked) > 0
+			if booked[studentID] > 0 {
+				booking.SessionID = sessionID
+				booking.BookedSeats = booked[studentID]
+				booking.RemainingSeats = max(venue.Capacity-booked[studentID], 0)
+				booking.UsedPriorityPass = true
+				return nil
+			}
+		}
+
+		inSession, err := tx.IsParticipant(sessionID, studentID)
+		if err != nil {
+			return err
+		}
+		if inSession {
+			return ErrAlreadyBooked
+		}
+
+		booked, err := claimSeat(tx, venue, sessionID, "", studentID)
+		if err == ErrVenueFull || err == ErrSessionFull {
+			// A priority pass from a cancelled session can't take a seat
+			// someone else holds, but it puts the student first in line
+			// for the venue's next session
+			passID, found, passErr := tx.PriorityPass(studentID, venue.Level)
+			if passErr != nil {
+				return passErr
+			}
+			if found {
+				if err := tx.ReservePriorityPass(passID, venueID); err != nil {
+					return err
+				}
+				booking.Reserved = true
+			}
+			if !found && !reserved {
+				return err
+			}
+			fullErr = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.AddParticipant(sessionID, studentID); err != nil {
+			return err
+		}
+		if err := tx.SetCurrentBooking(studentID, sessionID); err != nil {
+			slog.Error("Failed to update student booking", "error", err)
+		}
+
+		booking.SessionID = sessionID
+		booking.BookedSeats = booked
+		booking.RemainingSeats = max(venue.Capacity-booked, 0)
+		return nil
+	})
+	if err == nil {
+		err = fullErr
+	}
+	return booking, err
+}
+
+// seatReservations gives the students whose priority passes are held for
+// the venue seats in its new session, in the order they reserved, until it
+// is full. Students who have since moved level or booked elsewhere keep
+// their pass for later. It returns the venue's seats taken after each
+// student seated.
+func seatReservations(tx SeatTx, venue Venue, sessionID string) (map[string]int, error) {
+	reservations, err := tx.PriorityReservations(venue.ID)
+	if err != nil {
+		return nil, err
+	}
+	booked := map[string]int{}
+	for _, res := range reservations {
+		level, err := tx.LockStudentLevel(res.StudentID)
+		if err == ErrStudentNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if level != venue.Level {
+			continue
+		}
+		active, err := tx.HasActiveBookingAtLevel(res.StudentID, venue.Level)
+		if err != nil {
+			return nil, err
+		}
+		if active {
+			continue
+		}
+
+		taken, err := claimSeat(tx, venue, sessionID, "", res.StudentID)
+		if err == ErrVenueFull || err == ErrSessionFull {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.AddParticipant(sessionID, res.StudentID); err != nil {
+			return nil, err
+		}
+		if err := tx.SetCurrentBooking(res.StudentID, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.RedeemPriorityPass(res.PassID, sessionID); err != nil {
+			return nil, err
+		}
+		booked[res.StudentID] = taken
+		slog.Info("Seated priority pass holder", "session_id", sessionID, "student_id", res.StudentID)
+	}
+	return booked, nil
+}
+
+type sessionService struct {
+	repo        SeatRepository
+	withinHours func(VenueHours) bool
+}
+
+// NewSessionService returns a SessionService backed by repo. withinHours
+// reports whether a venue is running sessions right now.
+func NewSessionService(repo SeatRepository, withinHours func(VenueHours) bool) SessionService {
+	return &sessionService{repo: repo, withinHours: withinHours}
+}
+
+func (s *sessionService) Join(studentID, qrData string) (string, error) {
+	var payload struct {
+		VenueID string `json:"venue_id"`
+		Expiry  string `json:"expiry"`
+	}
+	if err := json.Unmarshal([]byte(qrData), &payload); err != nil {
+		return "", ErrInvalidQR
+	}
+
+	level, err := s.repo.StudentLevel(studentID)
+	if err != nil {
+		return "", err
+	}
+	venue, err := s.repo.Venue(payload.VenueID)
+	if err != nil {
+		return "", err
+	}
+	if !s.withinHours(venue.Hours) {
+		return "", ErrOutsideHours
+	}
+	if level != venue.Level {
+		return "", &LevelMismatchError{StudentLevel: level, VenueLevel: venue.Level}
+	}
+
+	qr, err := s.repo.QRCode(qrData, venue.ID)
+	if err != nil {
+		return "", err
+	}
+	if !qr.IsActive {
+		return "", ErrQRInactive
+	}
+
+	var sessionID string
+	err = s.repo.WithSeatLocks(func(tx SeatTx) error {
+		venue, err := tx.LockVenue(venue.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.ClearPhaseTracking(studentID); err != nil {
+			return err
+		}
+
+		// Students placed into a group by group formation join their
+		// group's session, everyone else the session for their QR group
+		var found bool
+		sessionID, found, err = tx.AssignedGroupSession(studentID, venue.ID)
+		if err == nil && !found {
+			sessionID, found, err = tx.QRGroupSession(venue.ID, qr.QRGroupID)
+		}
+		if err != nil {
+			return err
+		}
+		if !found {
+			sessionID = uuid.New().String()
+			err := tx.CreateSession(NewSession{
+				ID:          sessionID,
+				VenueID:     venue.ID,
+				Level:       venue.Level,
+				Status:      StatusLobby,
+				CreatedBy:   studentID,
+				QRGroupID:   qr.QRGroupID,
+				MaxCapacity: qr.MaxCapacity,
+				Duration:    QRSessionLength,
+			})
+			if err != nil {
+				return err
+			}
+			slog.Info("Created new QR group session", "session_id", sessionID, "venue_id", venue.ID, "qr_group_id", qr.QRGroupID)
+		}
+
+		isParticipant, err := tx.IsParticipant(sessionID, studentID)
+		if err != nil {
+			return err
+		}
+		if !isParticipant {
+			// Only a new participant takes a seat, so a retried or repeated
+			// scan doesn't burn QR capacity
+			if _, err := claimSeat(tx, venue, sessionID, qr.ID, studentID); err != nil {
+				return err
+			}
+			if err := tx.AddParticipant(sessionID, studentID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.TrackJoin(sessionID, studentID); err != nil {
+			return err
+		}
+		return tx.EnterLobby(sessionID, studentID)
+	})
+	return sessionID, err
+}
+
+// claimSeat checks and takes a seat for studentID once the venue is locked.
+// The student's own seats at the venue are not counted, since a student
+// holds at most one. qrCodeID may be empty when the seat is not tied to a
+// QR group. It returns the venue's seats taken, including the new one.
+func claimSeat(tx SeatTx, venue Venue, sessionID, qrCodeID, studentID string) (int, error) {
+	taken, err := tx.VenueSeatsTaken(venue.ID, studentID)
+	if err != nil {
+		return 0, err
+	}
+	if taken >= venue.Capacity {
+		return taken, ErrVenueFull
+	}
+
+	capacity, seats, err := tx.LockSessionSeats(sessionID)
+	if err != nil {
+		return taken, err
+	}
+	if capacity == 0 {
+		capacity = venue.Capacity
+	}
+	if seats >= capacity {
+		return taken, ErrSessionFull
+	}
+
+	if qrCodeID != "" {
+		claimed, err := tx.ClaimQRSeat(qrCodeID)
+		if err != nil {
+			return taken, err
+		}
+		if !claimed {
+			return taken, ErrQRGroupFull
+		}
+	}
+
+	return taken + 1, nil
+}
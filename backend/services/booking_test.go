@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func bookingStore(capacity int, students ...string) *Memory {
+	m := NewMemory()
+	m.AddVenue(Venue{ID: "v1", Level: 1, Capacity: capacity, IsActive: true})
+	for _, id := range students {
+		m.AddStudent(id, 1)
+	}
+	return m
+}
+
+// endSession moves a session's end time into the past, so it no longer
+// holds seats at its venue.
+func endSession(t *testing.T, m *Memory, sessionID string) {
+	t.Helper()
+	session, ok := m.Session(sessionID)
+	if !ok {
+		t.Fatalf("session %s not found", sessionID)
+	}
+	session.EndsAt = time.Now().Add(-time.Minute)
+	m.AddSession(session)
+}
+
+func TestBookFillsVenueToCapacity(t *testing.T) {
+	m := bookingStore(2, "s1", "s2", "s3")
+	svc := NewBookingService(m)
+
+	first, err := svc.Book("s1", "v1")
+	if err != nil {
+		t.Fatalf("Book(s1): %v", err)
+	}
+	if first.BookedSeats != 1 || first.RemainingSeats != 1 {
+		t.Errorf("Book(s1) seats = %d booked, %d remaining; want 1, 1", first.BookedSeats, first.RemainingSeats)
+	}
+
+	second, err := svc.Book("s2", "v1")
+	if err != nil {
+		t.Fatalf("Book(s2): %v", err)
+	}
+	if second.SessionID != first.SessionID {
+		t.Errorf("Book(s2) session = %s, want %s", second.SessionID, first.SessionID)
+	}
+	if second.BookedSeats != 2 || second.RemainingSeats != 0 {
+		t.Errorf("Book(s2) seats = %d booked, %d remaining; want 2, 0", second.BookedSeats, second.RemainingSeats)
+	}
+
+	third, err := svc.Book("s3", "v1")
+	if err != ErrVenueFull {
+		t.Fatalf("Book(s3) error = %v, want ErrVenueFull", err)
+	}
+	if third.Reserved {
+		t.Error("Book(s3) reserved a seat without a priority pass")
+	}
+	if got := m.CurrentBooking("s3"); got != "" {
+		t.Errorf("s3 booked into %s after a full venue", got)
+	}
+
+	session, _ := m.Session(first.SessionID)
+	if len(session.Participants) != 2 {
+		t.Errorf("session has %d participants, want 2", len(session.Participants))
+	}
+}
+
+func TestBookRespectsSessionCapacity(t *testing.T) {
+	m := bookingStore(5, "s1")
+	m.AddSession(MemorySession{ID: "sess", VenueID: "v1", Level: 1, Status: StatusScheduled, MaxCapacity: 1, Participants: []string{"x"}})
+
+	_, err := NewBookingService(m).Book("s1", "v1")
+	if err != ErrSessionFull {
+		t.Fatalf("Book error = %v, want ErrSessionFull", err)
+	}
+}
+
+func TestBookRejectsRepeatAndWrongLevel(t *testing.T) {
+	m := bookingStore(5, "s1")
+	m.AddStudent("s2", 2)
+	svc := NewBookingService(m)
+
+	if _, err := svc.Book("s1", "v1"); err != nil {
+		t.Fatalf("Book(s1): %v", err)
+	}
+	var active *ActiveBookingError
+	if _, err := svc.Book("s1", "v1"); !errors.As(err, &active) {
+		t.Errorf("second Book(s1) error = %v, want ActiveBookingError", err)
+	}
+
+	var mismatch *LevelMismatchError
+	if _, err := svc.Book("s2", "v1"); !errors.As(err, &mismatch) {
+		t.Fatalf("Book(s2) error = %v, want LevelMismatchError", err)
+	}
+	if mismatch.StudentLevel != 2 || mismatch.VenueLevel != 1 {
+		t.Errorf("mismatch = %+v, want student level 2, venue level 1", mismatch)
+	}
+}
+
+func TestBookPriorityPassReservesNextSession(t *testing.T) {
+	m := bookingStore(1, "s1", "s2", "s3")
+	m.AddPriorityPass("p2", "s2", 1)
+	svc := NewBookingService(m)
+
+	first, err := svc.Book("s1", "v1")
+	if err != nil {
+		t.Fatalf("Book(s1): %v", err)
+	}
+
+	// A pass can't take a seat someone else already holds
+	booking, err := svc.Book("s2", "v1")
+	if err != ErrVenueFull {
+		t.Fatalf("Book(s2) error = %v, want ErrVenueFull", err)
+	}
+	if !booking.Reserved {
+		t.Fatal("Book(s2) did not reserve the next session")
+	}
+	if got := m.PriorityPassRedeemedIn("p2"); got != "" {
+		t.Fatalf("pass redeemed in %s before a seat was free", got)
+	}
+
+	// The next session seats the pass holder first, even though the
+	// student whose booking opened it misses out
+	endSession(t, m, first.SessionID)
+	if _, err := svc.Book("s3", "v1"); err != ErrVenueFull {
+		t.Fatalf("Book(s3) error = %v, want ErrVenueFull", err)
+	}
+	next := m.CurrentBooking("s2")
+	if next == "" || next == first.SessionID {
+		t.Fatalf("s2 booked into %q, want a new session", next)
+	}
+	if got := m.PriorityPassRedeemedIn("p2"); got != next {
+		t.Errorf("pass redeemed in %q, want %s", got, next)
+	}
+	session, _ := m.Session(next)
+	if len(session.Participants) != 1 || session.Participants[0] != "s2" {
+		t.Errorf("next session participants = %v, want [s2]", session.Participants)
+	}
+}
+
+func TestBookPriorityPassHolderOpensNextSession(t *testing.T) {
+	m := bookingStore(1, "s1", "s2")
+	m.AddPriorityPass("p2", "s2", 1)
+	svc := NewBookingService(m)
+
+	first, err := svc.Book("s1", "v1")
+	if err != nil {
+		t.Fatalf("Book(s1): %v", err)
+	}
+	if _, err := svc.Book("s2", "v1"); err != ErrVenueFull {
+		t.Fatalf("Book(s2) error = %v, want ErrVenueFull", err)
+	}
+
+	endSession(t, m, first.SessionID)
+	booking, err := svc.Book("s2", "v1")
+	if err != nil {
+		t.Fatalf("Book(s2) after the session ended: %v", err)
+	}
+	if !booking.UsedPriorityPass || booking.BookedSeats != 1 {
+		t.Errorf("booking = %+v, want one seat taken with the priority pass", booking)
+	}
+}
+
+const joinQR = `{"venue_id":"v1","expiry":"2099-01-01T00:00:00Z"}`
+
+func joinStore(qr QRCode, students ...string) *Memory {
+	m := bookingStore(10, students...)
+	qr.ID, qr.VenueID, qr.Data, qr.QRGroupID, qr.IsActive = "qr1", "v1", joinQR, "g1", true
+	m.AddQRCode(qr)
+	return m
+}
+
+func alwaysOpen(VenueHours) bool { return true }
+
+func TestJoinTwiceTakesOneSeat(t *testing.T) {
+	m := joinStore(QRCode{MaxCapacity: 5}, "s1")
+	svc := NewSessionService(m, alwaysOpen)
+
+	first, err := svc.Join("s1", joinQR)
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	again, err := svc.Join("s1", joinQR)
+	if err != nil {
+		t.Fatalf("second Join: %v", err)
+	}
+	if again != first {
+		t.Errorf("second Join session = %s, want %s", again, first)
+	}
+
+	session, _ := m.Session(first)
+	if len(session.Participants) != 1 {
+		t.Errorf("session has %d participants, want 1", len(session.Participants))
+	}
+	if session.Status != StatusLobby {
+		t.Errorf("session status = %s, want %s", session.Status, StatusLobby)
+	}
+	qr, _ := m.QRCode(joinQR, "v1")
+	if qr.CurrentUsage != 1 {
+		t.Errorf("QR usage = %d, want 1", qr.CurrentUsage)
+	}
+}
+
+func TestJoinCapacity(t *testing.T) {
+	t.Run("session full", func(t *testing.T) {
+		m := joinStore(QRCode{MaxCapacity: 1}, "s1", "s2")
+		svc := NewSessionService(m, alwaysOpen)
+		if _, err := svc.Join("s1", joinQR); err != nil {
+			t.Fatalf("Join(s1): %v", err)
+		}
+		if _, err := svc.Join("s2", joinQR); err != ErrSessionFull {
+			t.Errorf("Join(s2) error = %v, want ErrSessionFull", err)
+		}
+	})
+
+	t.Run("QR code used up", func(t *testing.T) {
+		m := joinStore(QRCode{MaxCapacity: 2, CurrentUsage: 2}, "s1")
+		_, err := NewSessionService(m, alwaysOpen).Join("s1", joinQR)
+		if err != ErrQRGroupFull {
+			t.Fatalf("Join error = %v, want ErrQRGroupFull", err)
+		}
+		if qr, _ := m.QRCode(joinQR, "v1"); qr.CurrentUsage != 2 {
+			t.Errorf("QR usage = %d, want 2", qr.CurrentUsage)
+		}
+	})
+
+	t.Run("outside hours", func(t *testing.T) {
+		m := joinStore(QRCode{MaxCapacity: 5}, "s1")
+		closed := func(VenueHours) bool { return false }
+		if _, err := NewSessionService(m, closed).Join("s1", joinQR); err != ErrOutsideHours {
+			t.Errorf("Join error = %v, want ErrOutsideHours", err)
+		}
+	})
+}
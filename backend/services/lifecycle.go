@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SessionStatus is where a session is in its lifecycle:
+//
+//	scheduled → lobby → in_progress(phase) → surveying → scoring → published
+//
+// A session that has not finished may be cancelled by an admin or
+// abandoned once it overruns its end time.
+type SessionStatus string
+
+const (
+	StatusScheduled  SessionStatus = "scheduled"
+	StatusLobby      SessionStatus = "lobby"
+	StatusInProgress SessionStatus = "in_progress"
+	StatusSurveying  SessionStatus = "surveying"
+	StatusScoring    SessionStatus = "scoring"
+	StatusPublished  SessionStatus = "published"
+	StatusCancelled  SessionStatus = "cancelled"
+	StatusAbandoned  SessionStatus = "abandoned"
+)
+
+// SessionStatuses lists every status in lifecycle order.
+var SessionStatuses = []SessionStatus{
+	StatusScheduled, StatusLobby, StatusInProgress, StatusSurveying,
+	StatusScoring, StatusPublished, StatusCancelled, StatusAbandoned,
+}
+
+// sessionTransitions lists the statuses each status may move to.
+var sessionTransitions = map[SessionStatus][]SessionStatus{
+	StatusScheduled:  {StatusLobby, StatusInProgress, StatusCancelled, StatusAbandoned},
+	StatusLobby:      {StatusInProgress, StatusCancelled, StatusAbandoned},
+	StatusInProgress: {StatusInProgress, StatusSurveying, StatusCancelled, StatusAbandoned},
+	StatusSurveying:  {StatusScoring, StatusCancelled, StatusAbandoned},
+	StatusScoring:    {StatusPublished},
+}
+
+// Valid reports whether s is a known status.
+func (s SessionStatus) Valid() bool {
+	for _, status := range SessionStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Open reports whether a session in this status still holds its
+// participants' seats.
+func (s SessionStatus) Open() bool {
+	return s == StatusScheduled || s == StatusLobby || s == StatusInProgress
+}
+
+// Final reports whether a session in this status can no longer change.
+func (s SessionStatus) Final() bool {
+	return len(sessionTransitions[s]) == 0
+}
+
+// ErrUnknownStatus is returned for a status outside the lifecycle.
+var ErrUnknownStatus = errors.New("unknown session status")
+
+// TransitionError is returned for a move the lifecycle doesn't allow.
+type TransitionError struct {
+	From, To SessionStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("session cannot move from %s to %s", e.From, e.To)
+}
+
+// ValidateTransition checks a move from one status and phase to another.
+// Only in_progress carries a phase, and a session stays in_progress from
+// one phase to the next.
+func ValidateTransition(from SessionStatus, fromPhase string, to SessionStatus, toPhase string) error {
+	if !to.Valid() {
+		return ErrUnknownStatus
+	}
+	if to == StatusInProgress && toPhase == "" {
+		return fmt.Errorf("%s requires a phase", StatusInProgress)
+	}
+	if from == to && (from != StatusInProgress || fromPhase == toPhase) {
+		return &TransitionError{From: from, To: to}
+	}
+	for _, next := range sessionTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// PhaseStatus is the status a session is in while running an agenda phase.
+func PhaseStatus(phase string) SessionStatus {
+	if phase == "survey" {
+		return StatusSurveying
+	}
+	return StatusInProgress
+}
@@ -0,0 +1,674 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory store implementing every repository in this
+// package, for exercising the services without a database. Writes run one
+// at a time, and a transaction works on a copy of the data that replaces
+// it only on commit, so a failed transaction leaves nothing behind. Reads
+// outside a transaction see the last committed data.
+type Memory struct {
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	state   *memoryState
+}
+
+// MemorySession is a session held by Memory.
+type MemorySession struct {
+	ID           string
+	VenueID      string
+	Level        int
+	Status       SessionStatus
+	QRGroupID    string
+	MaxCapacity  int
+	EndsAt       time.Time
+	Participants []string
+	Questions    []Question
+	SurveyClosed bool
+	created      int
+}
+
+type memoryKey struct {
+	a, b string
+}
+
+type memoryState struct {
+	venues        map[string]Venue
+	studentLevels map[string]int
+	bookings      map[string]string
+	qrCodes       map[memoryKey]QRCode
+	sessions      map[string]MemorySession
+	assignments   map[memoryKey]string
+	rankingPoints map[[2]int]float64
+	joined        map[string]string
+	drafts        map[memoryKey]SurveyDraft
+	scores        map[memoryKey]map[string]QuestionScore
+	completed     map[memoryKey]bool
+	promotions    map[memoryKey]int
+	passes        map[string]memoryPass
+	created       int
+
+	// bias holds each responder's bias penalties, by question and rated
+	// student
+	bias             map[memoryKey]map[memoryKey]float64
+	penaltyThreshold float64
+}
+
+type memoryPass struct {
+	studentID     string
+	level         int
+	redeemedIn    string
+	reservedVenue string
+	reserved      int
+}
+
+// NewMemory returns an empty store.
+func NewMemory() *Memory {
+	return &Memory{state: &memoryState{
+		venues:        map[string]Venue{},
+		studentLevels: map[string]int{},
+		bookings:      map[string]string{},
+		qrCodes:       map[memoryKey]QRCode{},
+		sessions:      map[string]MemorySession{},
+		assignments:   map[memoryKey]string{},
+		rankingPoints: map[[2]int]float64{},
+		joined:        map[string]string{},
+		drafts:        map[memoryKey]SurveyDraft{},
+		scores:        map[memoryKey]map[string]QuestionScore{},
+		completed:     map[memoryKey]bool{},
+		promotions:    map[memoryKey]int{},
+		passes:        map[string]memoryPass{},
+
+		bias: map[memoryKey]map[memoryKey]float64{},
+		// The penalty threshold of the default level rules
+		penaltyThreshold: 2,
+	}}
+}
+
+func (s *memoryState) clone() *memoryState {
+	c := *s
+	c.venues = cloneMap(s.venues)
+	c.studentLevels = cloneMap(s.studentLevels)
+	c.bookings = cloneMap(s.bookings)
+	c.qrCodes = cloneMap(s.qrCodes)
+	c.sessions = make(map[string]MemorySession, len(s.sessions))
+	for id, session := range s.sessions {
+		session.Participants = append([]string(nil), session.Participants...)
+		c.sessions[id] = session
+	}
+	c.assignments = cloneMap(s.assignments)
+	c.rankingPoints = cloneMap(s.rankingPoints)
+	c.joined = cloneMap(s.joined)
+	c.drafts = cloneMap(s.drafts)
+	c.scores = make(map[memoryKey]map[string]QuestionScore, len(s.scores))
+	for key, scores := range s.scores {
+		c.scores[key] = cloneMap(scores)
+	}
+	c.bias = make(map[memoryKey]map[memoryKey]float64, len(s.bias))
+	for key, bias := range s.bias {
+		c.bias[key] = cloneMap(bias)
+	}
+	c.completed = cloneMap(s.completed)
+	c.promotions = cloneMap(s.promotions)
+	c.passes = cloneMap(s.passes)
+	return &c
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// write applies a single change outside any transaction.
+func (m *Memory) write(fn func(s *memoryState)) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(m.state)
+}
+
+// AddVenue adds or replaces a venue.
+func (m *Memory) AddVenue(venue Venue) {
+	m.write(func(s *memoryState) { s.venues[venue.ID] = venue })
+}
+
+// AddStudent adds or replaces a student at a level.
+func (m *Memory) AddStudent(studentID string, level int) {
+	m.write(func(s *memoryState) { s.studentLevels[studentID] = level })
+}
+
+// AddQRCode adds or replaces a venue QR code.
+func (m *Memory) AddQRCode(qr QRCode) {
+	m.write(func(s *memoryState) { s.qrCodes[memoryKey{qr.Data, qr.VenueID}] = qr })
+}
+
+// AddPriorityPass gives a student a priority pass for a level.
+func (m *Memory) AddPriorityPass(passID, studentID string, level int) {
+	m.write(func(s *memoryState) { s.passes[passID] = memoryPass{studentID: studentID, level: level} })
+}
+
+// PriorityPassRedeemedIn returns the session a pass was redeemed for, or
+// "" if it hasn't been.
+func (m *Memory) PriorityPassRedeemedIn(passID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.passes[passID].redeemedIn
+}
+
+// AddSession adds or replaces a session. A zero EndsAt means the session
+// has not expired.
+func (m *Memory) AddSession(session MemorySession) {
+	if session.EndsAt.IsZero() {
+		session.EndsAt = time.Now().Add(BookedSessionLength)
+	}
+	m.write(func(s *memoryState) {
+		s.created++
+		session.created = s.created
+		s.sessions[session.ID] = session
+	})
+}
+
+// AssignGroup places a student in a session, as group formation does.
+func (m *Memory) AssignGroup(studentID, venueID, sessionID string) {
+	m.write(func(s *memoryState) { s.assignments[memoryKey{studentID, venueID}] = sessionID })
+}
+
+// SetRankingPoints configures the points for a rank at a level.
+func (m *Memory) SetRankingPoints(level, rank int, points float64) {
+	m.write(func(s *memoryState) { s.rankingPoints[[2]int{level, rank}] = points })
+}
+
+// SetPenaltyThreshold sets the rating deviation from the median at which
+// RecalculateScores charges a bias penalty.
+func (m *Memory) SetPenaltyThreshold(threshold float64) {
+	m.write(func(s *memoryState) { s.penaltyThreshold = threshold })
+}
+
+// CloseSurvey closes a session's survey window.
+func (m *Memory) CloseSurvey(sessionID string) {
+	m.write(func(s *memoryState) {
+		if session, ok := s.sessions[sessionID]; ok {
+			session.SurveyClosed = true
+			s.sessions[sessionID] = session
+		}
+	})
+}
+
+// Session returns a copy of a session.
+func (m *Memory) Session(sessionID string) (MemorySession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.state.sessions[sessionID]
+	session.Participants = append([]string(nil), session.Participants...)
+	return session, ok
+}
+
+// CurrentBooking returns the session a student last booked.
+func (m *Memory) CurrentBooking(studentID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.bookings[studentID]
+}
+
+func (m *Memory) inTx(fn func(tx *memoryTx) error) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.Lock()
+	tx := &memoryTx{s: m.state.clone()}
+	m.mu.Unlock()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.state = tx.s
+	m.mu.Unlock()
+	return nil
+}
+
+// WithSeatLocks implements SeatRepository.
+func (m *Memory) WithSeatLocks(fn func(tx SeatTx) error) error {
+	return m.inTx(func(tx *memoryTx) error { return fn(tx) })
+}
+
+// WithSurveyTx implements SurveyRepository.
+func (m *Memory) WithSurveyTx(fn func(tx SurveyTx) error) error {
+	return m.inTx(func(tx *memoryTx) error { return fn(tx) })
+}
+
+// RecalculateScores implements SurveyRepository. Bias penalties are worked
+// out afresh from every completed rating, with ScoreBias as in MySQL.
+func (m *Memory) RecalculateScores(sessionID string) {
+	m.write(func(s *memoryState) {
+		var ratings []Rating
+		for key, scores := range s.scores {
+			if key.a != sessionID || !s.completed[key] {
+				continue
+			}
+			delete(s.bias, key)
+			for questionID, score := range scores {
+				for _, ranked := range score.Rankings {
+					ratings = append(ratings, Rating{
+						QuestionID:  questionID,
+						ResponderID: key.b,
+						StudentID:   ranked.StudentID,
+						Score:       ranked.Score,
+					})
+				}
+			}
+		}
+
+		for _, b := range ScoreBias(ratings, s.penaltyThreshold) {
+			if b.Penalty == 0 {
+				continue
+			}
+			responder := memoryKey{sessionID, b.ResponderID}
+			if s.bias[responder] == nil {
+				s.bias[responder] = map[memoryKey]float64{}
+			}
+			s.bias[responder][memoryKey{b.QuestionID, b.StudentID}] = b.Penalty
+		}
+	})
+}
+
+// ExcludeResponder drops a responder's ratings from a session, as an upheld
+// appeal does, along with every bias penalty in the session. Call
+// RecalculateScores afterwards to measure the remaining ratings again.
+func (m *Memory) ExcludeResponder(sessionID, responderID string) {
+	m.write(func(s *memoryState) {
+		delete(s.scores, memoryKey{sessionID, responderID})
+		for key := range s.bias {
+			if key.a == sessionID {
+				delete(s.bias, key)
+			}
+		}
+	})
+}
+
+// StudentLevel implements SeatRepository and PromotionRepository.
+func (m *Memory) StudentLevel(studentID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return (&memoryTx{s: m.state}).LockStudentLevel(studentID)
+}
+
+// Venue implements SeatRepository.
+func (m *Memory) Venue(venueID string) (Venue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	venue, ok := m.state.venues[venueID]
+	if !ok {
+		return Venue{}, ErrVenueNotFound
+	}
+	return venue, nil
+}
+
+// QRCode implements SeatRepository.
+func (m *Memory) QRCode(qrData, venueID string) (QRCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	qr, ok := m.state.qrCodes[memoryKey{qrData, venueID}]
+	if !ok {
+		return QRCode{}, ErrQRNotFound
+	}
+	return qr, nil
+}
+
+// RankingPoints implements RankingPointsRepository.
+func (m *Memory) RankingPoints(level, rank int) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// A configured level scores unlisted ranks as zero
+	for key := range m.state.rankingPoints {
+		if key[0] == level {
+			return m.state.rankingPoints[[2]int{level, rank}], true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// AlreadyPromoted implements PromotionRepository.
+func (m *Memory) AlreadyPromoted(sessionID, studentID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.state.promotions[memoryKey{sessionID, studentID}]
+	return ok, nil
+}
+
+// SurveyCompletion implements PromotionRepository.
+func (m *Memory) SurveyCompletion(sessionID string) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session := m.state.sessions[sessionID]
+	completed := 0
+	for _, studentID := range session.Participants {
+		if m.state.completed[memoryKey{sessionID, studentID}] {
+			completed++
+		}
+	}
+	return completed, len(session.Participants), nil
+}
+
+// Standings implements PromotionRepository. Like the MySQL ranking, a
+// responder's missing-rank penalty comes off every score they gave for
+// that question, a bias penalty comes off the score it was charged on, and
+// only completed responses count. Both rank with RankStandings.
+func (m *Memory) Standings(sessionID string) ([]Standing, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	totals := map[string]float64{}
+	for key, scores := range m.state.scores {
+		if key.a != sessionID || !m.state.completed[key] {
+			continue
+		}
+		for questionID, score := range scores {
+			for _, ranked := range score.Rankings {
+				bias := m.state.bias[key][memoryKey{questionID, ranked.StudentID}]
+				totals[ranked.StudentID] += ranked.Score - float64(score.MissingRanks) - bias
+			}
+		}
+	}
+
+	return RankStandings(totals), nil
+}
+
+// Promote implements PromotionRepository.
+func (m *Memory) Promote(studentID string, newLevel int) (bool, error) {
+	promoted := false
+	m.write(func(s *memoryState) {
+		if level, ok := s.studentLevels[studentID]; ok && level < MaxLevel {
+			s.studentLevels[studentID] = newLevel
+			promoted = true
+		}
+	})
+	return promoted, nil
+}
+
+// RecordPromotion implements PromotionRepository.
+func (m *Memory) RecordPromotion(sessionID, studentID string, rank, oldLevel, newLevel int) error {
+	m.write(func(s *memoryState) { s.promotions[memoryKey{sessionID, studentID}] = rank })
+	return nil
+}
+
+// memoryTx implements SeatTx and SurveyTx over a Memory transaction's copy
+// of the data.
+type memoryTx struct {
+	s *memoryState
+}
+
+func (tx *memoryTx) LockVenue(venueID string) (Venue, error) {
+	venue, ok := tx.s.venues[venueID]
+	if !ok || !venue.IsActive {
+		return Venue{}, ErrVenueNotFound
+	}
+	return venue, nil
+}
+
+func (tx *memoryTx) LockStudentLevel(studentID string) (int, error) {
+	level, ok := tx.s.studentLevels[studentID]
+	if !ok {
+		return 0, ErrStudentNotFound
+	}
+	return level, nil
+}
+
+func (tx *memoryTx) running(session MemorySession) bool {
+	return session.Status.Open() && session.EndsAt.After(time.Now())
+}
+
+func (tx *memoryTx) HasActiveBookingAtLevel(studentID string, level int) (bool, error) {
+	for _, session := range tx.s.sessions {
+		if !tx.running(session) || tx.s.venues[session.VenueID].Level != level {
+			continue
+		}
+		for _, id := range session.Participants {
+			if id == studentID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// latestSession returns the most recently created session that matches.
+func (tx *memoryTx) latestSession(match func(MemorySession) bool) (string, bool) {
+	var latest MemorySession
+	for _, session := range tx.s.sessions {
+		if match(session) && session.created > latest.created {
+			latest = session
+		}
+	}
+	return latest.ID, latest.ID != ""
+}
+
+func (tx *memoryTx) OpenVenueSession(venueID string) (string, bool, error) {
+	id, found := tx.latestSession(func(s MemorySession) bool {
+		return s.VenueID == venueID && tx.running(s)
+	})
+	return id, found, nil
+}
+
+func (tx *memoryTx) AssignedGroupSession(studentID, venueID string) (string, bool, error) {
+	id, ok := tx.s.assignments[memoryKey{studentID, venueID}]
+	if !ok {
+		return "", false, nil
+	}
+	session := tx.s.sessions[id]
+	if !session.Status.Open() {
+		return "", false, nil
+	}
+	return id, true, nil
+}
+
+func (tx *memoryTx) QRGroupSession(venueID, qrGroupID string) (string, bool, error) {
+	id, found := tx.latestSession(func(s MemorySession) bool {
+		return s.VenueID == venueID && s.QRGroupID == qrGroupID &&
+			s.Status.Open()
+	})
+	return id, found, nil
+}
+
+func (tx *memoryTx) CreateSession(session NewSession) error {
+	tx.s.created++
+	tx.s.sessions[session.ID] = MemorySession{
+		ID:          session.ID,
+		VenueID:     session.VenueID,
+		Level:       session.Level,
+		Status:      session.Status,
+		QRGroupID:   session.QRGroupID,
+		MaxCapacity: session.MaxCapacity,
+		EndsAt:      time.Now().Add(session.Duration),
+		created:     tx.s.created,
+	}
+	return nil
+}
+
+func (tx *memoryTx) EnterLobby(sessionID, studentID string) error {
+	session := tx.s.sessions[sessionID]
+	if session.Status == StatusScheduled {
+		session.Status = StatusLobby
+		tx.s.sessions[sessionID] = session
+	}
+	return nil
+}
+
+func (tx *memoryTx) IsParticipant(sessionID, studentID string) (bool, error) {
+	for _, id := range tx.s.sessions[sessionID].Participants {
+		if id == studentID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (tx *memoryTx) VenueSeatsTaken(venueID, excludeStudentID string) (int, error) {
+	students := map[string]bool{}
+	for _, session := range tx.s.sessions {
+		if session.VenueID != venueID || !tx.running(session) {
+			continue
+		}
+		for _, id := range session.Participants {
+			if id != excludeStudentID {
+				students[id] = true
+			}
+		}
+	}
+	return len(students), nil
+}
+
+func (tx *memoryTx) LockSessionSeats(sessionID string) (int, int, error) {
+	session, ok := tx.s.sessions[sessionID]
+	if !ok {
+		return 0, 0, ErrSessionNotFound
+	}
+	return session.MaxCapacity, len(session.Participants), nil
+}
+
+func (tx *memoryTx) ClaimQRSeat(qrCodeID string) (bool, error) {
+	for key, qr := range tx.s.qrCodes {
+		if qr.ID != qrCodeID {
+			continue
+		}
+		if !qr.IsActive || qr.CurrentUsage >= qr.MaxCapacity {
+			return false, nil
+		}
+		qr.CurrentUsage++
+		tx.s.qrCodes[key] = qr
+		return true, nil
+	}
+	return false, nil
+}
+
+func (tx *memoryTx) AddParticipant(sessionID, studentID string) error {
+	session, ok := tx.s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.Participants = append(session.Participants, studentID)
+	tx.s.sessions[sessionID] = session
+	return nil
+}
+
+func (tx *memoryTx) SetCurrentBooking(studentID, sessionID string) error {
+	tx.s.bookings[studentID] = sessionID
+	return nil
+}
+
+func (tx *memoryTx) PriorityPass(studentID string, level int) (string, bool, error) {
+	for id, pass := range tx.s.passes {
+		if pass.studentID == studentID && pass.level == level && pass.redeemedIn == "" {
+			return id, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (tx *memoryTx) RedeemPriorityPass(passID, sessionID string) error {
+	pass := tx.s.passes[passID]
+	pass.redeemedIn = sessionID
+	tx.s.passes[passID] = pass
+	return nil
+}
+
+func (tx *memoryTx) ReservePriorityPass(passID, venueID string) error {
+	pass := tx.s.passes[passID]
+	if pass.reservedVenue != venueID {
+		tx.s.created++
+		pass.reservedVenue, pass.reserved = venueID, tx.s.created
+		tx.s.passes[passID] = pass
+	}
+	return nil
+}
+
+func (tx *memoryTx) PriorityReservations(venueID string) ([]PriorityReservation, error) {
+	var ids []string
+	for id, pass := range tx.s.passes {
+		if pass.reservedVenue == venueID && pass.redeemedIn == "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return tx.s.passes[ids[i]].reserved < tx.s.passes[ids[j]].reserved })
+	reservations := make([]PriorityReservation, len(ids))
+	for i, id := range ids {
+		reservations[i] = PriorityReservation{PassID: id, StudentID: tx.s.passes[id].studentID}
+	}
+	return reservations, nil
+}
+
+func (tx *memoryTx) ClearPhaseTracking(studentID string) error {
+	delete(tx.s.joined, studentID)
+	return nil
+}
+
+func (tx *memoryTx) TrackJoin(sessionID, studentID string) error {
+	tx.s.joined[studentID] = sessionID
+	return nil
+}
+
+func (tx *memoryTx) LockSurvey(sessionID string) (int, bool, error) {
+	session, ok := tx.s.sessions[sessionID]
+	if !ok {
+		return 0, false, ErrSessionNotFound
+	}
+	return session.Level, session.SurveyClosed, nil
+}
+
+func (tx *memoryTx) Draft(sessionID, responderID string) (SurveyDraft, bool, error) {
+	draft, ok := tx.s.drafts[memoryKey{sessionID, responderID}]
+	if !ok {
+		return SurveyDraft{SessionID: sessionID, Responses: map[int]map[int]string{}}, false, nil
+	}
+	return draft, true, nil
+}
+
+func (tx *memoryTx) SaveDraft(sessionID, responderID string, responses map[int]map[int]string, currentQuestion *int) (SurveyDraft, error) {
+	draft, _, _ := tx.Draft(sessionID, responderID)
+	draft.Responses = MergeSurveyResponses(draft.Responses, responses)
+	if currentQuestion != nil {
+		draft.CurrentQuestion = *currentQuestion
+	}
+	draft.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+	tx.s.drafts[memoryKey{sessionID, responderID}] = draft
+	return draft, nil
+}
+
+func (tx *memoryTx) DeleteDraft(sessionID, responderID string) error {
+	delete(tx.s.drafts, memoryKey{sessionID, responderID})
+	return nil
+}
+
+func (tx *memoryTx) Questions(sessionID string) ([]Question, error) {
+	return tx.s.sessions[sessionID].Questions, nil
+}
+
+func (tx *memoryTx) ParticipantCount(sessionID string) (int, error) {
+	return len(tx.s.sessions[sessionID].Participants), nil
+}
+
+func (tx *memoryTx) SaveQuestionScore(sessionID, responderID string, score QuestionScore) error {
+	key := memoryKey{sessionID, responderID}
+	if tx.s.scores[key] == nil {
+		tx.s.scores[key] = map[string]QuestionScore{}
+	}
+	tx.s.scores[key][score.Question.ID] = score
+	return nil
+}
+
+func (tx *memoryTx) AnsweredCount(sessionID, responderID string) (int, error) {
+	return len(tx.s.scores[memoryKey{sessionID, responderID}]), nil
+}
+
+func (tx *memoryTx) MarkCompleted(sessionID, responderID string) error {
+	tx.s.completed[memoryKey{sessionID, responderID}] = true
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"gd/metrics"
+	"log/slog"
+	"sort"
+)
+
+const (
+	// MaxLevel is the highest GD level.
+	MaxLevel = 5
+
+	// PromotionPlaces is how many of a session's top-ranked students move
+	// up a level.
+	PromotionPlaces = 3
+)
+
+// Standing is a student's place in a session's final ranking.
+type Standing struct {
+	StudentID string
+	Score     float64
+	Rank      int
+}
+
+// RankStandings orders students by final score, highest first. Ties share
+// a rank, as with SQL RANK(), and are listed by student ID.
+func RankStandings(scores map[string]float64) []Standing {
+	standings := make([]Standing, 0, len(scores))
+	for studentID, score := range scores {
+		standings = append(standings, Standing{StudentID: studentID, Score: score})
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].StudentID < standings[j].StudentID
+	})
+	for i := range standings {
+		if i > 0 && standings[i].Score == standings[i-1].Score {
+			standings[i].Rank = standings[i-1].Rank
+		} else {
+			standings[i].Rank = i + 1
+		}
+	}
+	return standings
+}
+
+// Progression is the outcome of a promotion check.
+type Progression struct {
+	Promoted        bool
+	AlreadyPromoted bool
+	OldLevel        int
+	NewLevel        int
+	Rank            int
+	AllCompleted    bool
+	Completed       int
+	Total           int
+}
+
+// PromotionRepository is the storage behind PromotionService.
+type PromotionRepository interface {
+	StudentLevel(studentID string) (int, error)
+	AlreadyPromoted(sessionID, studentID string) (bool, error)
+	// SurveyCompletion counts the session's participants and how many of
+	// them have completed the survey.
+	SurveyCompletion(sessionID string) (completed, total int, err error)
+	Standings(sessionID string) ([]Standing, error)
+	// Promote raises the student to newLevel, returning false if they were
+	// already at the top level.
+	Promote(studentID string, newLevel int) (bool, error)
+	RecordPromotion(sessionID, studentID string, rank, oldLevel, newLevel int) error
+}
+
+// PromotionService moves students up a level on the strength of a session.
+type PromotionService interface {
+	// CheckProgression promotes the student if everyone in the session has
+	// completed the survey and they finished in the top places. A student
+	// is promoted at most once per session.
+	CheckProgression(studentID, sessionID string) (Progression, error)
+}
+
+type promotionService struct {
+	repo PromotionRepository
+}
+
+// NewPromotionService returns a PromotionService backed by repo.
+func NewPromotionService(repo PromotionRepository) PromotionService {
+	return &promotionService{repo: repo}
+}
+
+func (s *promotionService) CheckProgression(studentID, sessionID string) (Progression, error) {
+	level, err := s.repo.StudentLevel(studentID)
+	if err != nil {
+		return Progression{}, err
+	}
+	p := Progression{OldLevel: level, NewLevel: level}
+
+	promoted, err := s.repo.AlreadyPromoted(sessionID, studentID)
+	if err != nil {
+		slog.Error("Error checking if student was already promoted", "student_id", studentID, "error", err)
+	}
+	if promoted {
+		p.AlreadyPromoted = true
+		return p, nil
+	}
+
+	p.Completed, p.Total, err = s.repo.SurveyCompletion(sessionID)
+	if err != nil {
+		slog.Error("Error checking survey completion for session", "session_id", sessionID, "error", err)
+		p.Completed, p.Total = 0, 0
+	}
+	p.AllCompleted = p.Total > 0 && p.Completed >= p.Total
+	if !p.AllCompleted {
+		return p, nil
+	}
+
+	standings, err := s.repo.Standings(sessionID)
+	if err != nil {
+		slog.Error("Error ranking session", "session_id", sessionID, "error", err)
+		return p, nil
+	}
+	for _, standing := range standings {
+		if standing.StudentID == studentID {
+			p.Rank = standing.Rank
+			break
+		}
+	}
+	if p.Rank == 0 || p.Rank > PromotionPlaces || level >= MaxLevel {
+		return p, nil
+	}
+
+	newLevel := level + 1
+	p.Promoted, err = s.repo.Promote(studentID, newLevel)
+	if err != nil {
+		slog.Error("Failed to update level for student", "student_id", studentID, "error", err)
+		p.Promoted = false
+	}
+	if !p.Promoted {
+		return p, nil
+	}
+	p.NewLevel = newLevel
+	metrics.Promotions.Inc()
+	if err := s.repo.RecordPromotion(sessionID, studentID, p.Rank, level, newLevel); err != nil {
+		slog.Error("Failed to track promotion", "error", err)
+	}
+	return p, nil
+}
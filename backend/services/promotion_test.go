@@ -0,0 +1,110 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankStandings(t *testing.T) {
+	got := RankStandings(map[string]float64{"d": 2, "b": 5, "c": 5, "a": 9})
+	want := []Standing{
+		{StudentID: "a", Score: 9, Rank: 1},
+		{StudentID: "b", Score: 5, Rank: 2},
+		{StudentID: "c", Score: 5, Rank: 2},
+		{StudentID: "d", Score: 2, Rank: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RankStandings = %v, want %v", got, want)
+	}
+}
+
+func TestCheckProgressionPromotesTopPlaces(t *testing.T) {
+	m, svc := surveyStore(2, 1)
+	submitAll(t, svc)
+	promotion := NewPromotionService(m)
+
+	// Standings are b, c, a, then d
+	tests := []struct {
+		student   string
+		wantRank  int
+		wantLevel int
+	}{
+		{"b", 1, 3},
+		{"c", 2, 3},
+		{"a", 3, 3},
+		{"d", 4, 2},
+	}
+	for _, tt := range tests {
+		p, err := promotion.CheckProgression(tt.student, "sess")
+		if err != nil {
+			t.Fatalf("CheckProgression(%s): %v", tt.student, err)
+		}
+		if p.Rank != tt.wantRank || p.NewLevel != tt.wantLevel || p.Promoted != (tt.wantLevel == 3) {
+			t.Errorf("%s: %+v, want rank %d at level %d", tt.student, p, tt.wantRank, tt.wantLevel)
+		}
+		if level, _ := m.StudentLevel(tt.student); level != tt.wantLevel {
+			t.Errorf("%s is at level %d, want %d", tt.student, level, tt.wantLevel)
+		}
+	}
+
+	// A session promotes a student once
+	p, err := promotion.CheckProgression("b", "sess")
+	if err != nil {
+		t.Fatalf("second CheckProgression: %v", err)
+	}
+	if !p.AlreadyPromoted || p.Promoted {
+		t.Errorf("second check = %+v, want already promoted", p)
+	}
+	if level, _ := m.StudentLevel("b"); level != 3 {
+		t.Errorf("b is at level %d after a second check, want 3", level)
+	}
+}
+
+func TestCheckProgressionSharesThirdPlace(t *testing.T) {
+	m, svc := surveyStore(2, 1)
+	submitAll(t, svc)
+	m.ExcludeResponder("sess", "d")
+	m.RecalculateScores("sess")
+
+	// c and d tie for third and both move up
+	for _, student := range []string{"c", "d"} {
+		p, err := NewPromotionService(m).CheckProgression(student, "sess")
+		if err != nil {
+			t.Fatalf("CheckProgression(%s): %v", student, err)
+		}
+		if !p.Promoted || p.Rank != 3 {
+			t.Errorf("%s: %+v, want promoted from rank 3", student, p)
+		}
+	}
+}
+
+func TestCheckProgressionWaitsForEveryone(t *testing.T) {
+	m, svc := surveyStore(2, 1)
+	submit(t, svc, "a", map[int]string{1: "b", 2: "c", 3: "d"})
+	submit(t, svc, "b", map[int]string{1: "a", 2: "c", 3: "d"})
+	submit(t, svc, "c", map[int]string{1: "a", 2: "b", 3: "d"})
+
+	p, err := NewPromotionService(m).CheckProgression("a", "sess")
+	if err != nil {
+		t.Fatalf("CheckProgression: %v", err)
+	}
+	if p.Promoted || p.AllCompleted || p.Completed != 3 || p.Total != 4 {
+		t.Errorf("progression = %+v, want 3 of 4 completed and no promotion", p)
+	}
+	if level, _ := m.StudentLevel("a"); level != 2 {
+		t.Errorf("a is at level %d, want 2", level)
+	}
+}
+
+func TestCheckProgressionStopsAtMaxLevel(t *testing.T) {
+	m, svc := surveyStore(MaxLevel, 1)
+	submitAll(t, svc)
+
+	p, err := NewPromotionService(m).CheckProgression("b", "sess")
+	if err != nil {
+		t.Fatalf("CheckProgression: %v", err)
+	}
+	if p.Promoted || p.Rank != 1 || p.NewLevel != MaxLevel {
+		t.Errorf("progression = %+v, want rank 1 and no promotion past level %d", p, MaxLevel)
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"log/slog"
+	"math"
+	"sort"
+)
+
+// MaxBiasPenalty caps the penalty for a single rating that strays from
+// the other responders' median.
+const MaxBiasPenalty = 3.0
+
+// RankedScore is the score one responder gave one student for a question.
+type RankedScore struct {
+	Rank      int
+	StudentID string
+	Score     float64
+}
+
+// QuestionScore is a responder's scored rankings for one question.
+type QuestionScore struct {
+	Question Question
+	Rankings []RankedScore
+	// MissingRanks is the penalty, one point per rank, for leaving ranks
+	// out or not numbering them from 1.
+	MissingRanks int
+}
+
+// RankingPointsRepository is the storage behind ScoringService.
+type RankingPointsRepository interface {
+	// RankingPoints returns the configured points for a rank at a level.
+	// found is false when the level has no configuration.
+	RankingPoints(level, rank int) (points float64, found bool, err error)
+}
+
+// ScoringService turns survey rankings into points.
+type ScoringService interface {
+	// RankingPoints is what being ranked at rank is worth at a level.
+	RankingPoints(level, rank int) (float64, error)
+	// ScoreQuestion scores one responder's rankings for a question, where
+	// expectedRanks is how many students they should have ranked.
+	ScoreQuestion(level int, question Question, rankings map[int]string, expectedRanks int) QuestionScore
+}
+
+// defaultRankingPoints are used for levels with no ranking points
+// configuration.
+var defaultRankingPoints = map[int]float64{1: 4, 2: 3, 3: 2}
+
+// ExpectedRanks is how many students each responder ranks per question:
+// everyone in the session but themselves.
+func ExpectedRanks(participants int) int {
+	return max(participants-1, 1)
+}
+
+// Median is the middle score, or the mean of the middle two.
+func Median(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), scores...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// BiasPenalty measures a rating against the median rating its student got
+// for the question. A deviation at or over threshold costs the deviation,
+// up to MaxBiasPenalty; anything less costs nothing.
+func BiasPenalty(score, median, threshold float64) (deviation, penalty float64) {
+	deviation = math.Abs(score - median)
+	if deviation < threshold {
+		return deviation, 0
+	}
+	return deviation, math.Min(deviation, MaxBiasPenalty)
+}
+
+// Rating is one completed rating: the score a responder gave a student
+// for a question. ID identifies the stored rating, where there is one.
+type Rating struct {
+	ID          string
+	QuestionID  string
+	ResponderID string
+	StudentID   string
+	Score       float64
+}
+
+// RatingBias is a rating measured against the median rating its student
+// got for the question.
+type RatingBias struct {
+	Rating
+	Median    float64
+	Deviation float64
+	Penalty   float64
+}
+
+// ScoreBias measures each rating of another student against the median of
+// the non-zero ratings that student got for the question, charging a bias
+// penalty at threshold. Self-ratings, and ratings of a student with no
+// non-zero rating for the question, are left out.
+func ScoreBias(ratings []Rating, threshold float64) []RatingBias {
+	type rated struct{ questionID, studentID string }
+	received := map[rated][]float64{}
+	for _, r := range ratings {
+		if r.ResponderID != r.StudentID && r.Score > 0 {
+			key := rated{r.QuestionID, r.StudentID}
+			received[key] = append(received[key], r.Score)
+		}
+	}
+
+	var biases []RatingBias
+	for _, r := range ratings {
+		median := Median(received[rated{r.QuestionID, r.StudentID}])
+		if r.ResponderID == r.StudentID || median <= 0 {
+			continue
+		}
+		deviation, penalty := BiasPenalty(r.Score, median, threshold)
+		biases = append(biases, RatingBias{Rating: r, Median: median, Deviation: deviation, Penalty: penalty})
+	}
+	return biases
+}
+
+type scoringService struct {
+	repo RankingPointsRepository
+}
+
+// NewScoringService returns a ScoringService backed by repo.
+func NewScoringService(repo RankingPointsRepository) ScoringService {
+	return &scoringService{repo: repo}
+}
+
+func (s *scoringService) RankingPoints(level, rank int) (float64, error) {
+	points, found, err := s.repo.RankingPoints(level, rank)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return defaultRankingPoints[rank], nil
+	}
+	return points, nil
+}
+
+func (s *scoringService) ScoreQuestion(level int, question Question, rankings map[int]string, expectedRanks int) QuestionScore {
+	score := QuestionScore{Question: question}
+
+	if len(rankings) < expectedRanks {
+		score.MissingRanks = expectedRanks - len(rankings)
+	}
+	// Ranks must run from 1 without gaps; a badly numbered ranking costs at
+	// least a point even when enough students were ranked
+	for rank := 1; rank <= expectedRanks; rank++ {
+		if _, ok := rankings[rank]; !ok {
+			score.MissingRanks = max(score.MissingRanks, 1)
+			break
+		}
+	}
+
+	for rank, studentID := range rankings {
+		points, err := s.RankingPoints(level, rank)
+		if err != nil {
+			slog.Error("Error getting ranking points", "error", err)
+			points = 5 - float64(rank)
+		}
+		score.Rankings = append(score.Rankings, RankedScore{
+			Rank:      rank,
+			StudentID: studentID,
+			Score:     points * question.Weight,
+		})
+	}
+	sort.Slice(score.Rankings, func(i, j int) bool {
+		return score.Rankings[i].Rank < score.Rankings[j].Rank
+	})
+	return score
+}
@@ -0,0 +1,21 @@
+// Package services holds the rules for booking venues, joining sessions,
+// scoring surveys and promoting students. Each service reaches storage only
+// through a repository interface, so the same rules run against MySQL (see
+// the student controllers) or against the in-memory Memory store.
+package services
+
+import "errors"
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrStudentNotFound = errors.New("student not found")
+)
+
+// Question is one survey question as frozen for a session.
+type Question struct {
+	Number  int
+	ID      string
+	Version int
+	Text    string
+	Weight  float64
+}
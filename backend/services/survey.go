@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrSurveyClosed is returned for survey writes after the session's survey
+// window has closed.
+var ErrSurveyClosed = errors.New("the survey window for this session has closed")
+
+// SurveyDraft holds a responder's unsubmitted survey answers so the survey
+// can be resumed, on any device, where it was left.
+type SurveyDraft struct {
+	SessionID       string                 `json:"session_id"`
+	Responses       map[int]map[int]string `json:"responses"` // question_number -> rank -> studentID
+	CurrentQuestion int                    `json:"current_question"`
+	UpdatedAt       string                 `json:"updated_at,omitempty"`
+}
+
+// SurveySubmission is a responder's answers to a session's survey.
+type SurveySubmission struct {
+	SessionID   string
+	ResponderID string
+	Responses   map[int]map[int]string
+	// Partial submissions are saved as a draft rather than scored.
+	Partial         bool
+	CurrentQuestion *int
+}
+
+// SurveyReceipt is the outcome of a submission.
+type SurveyReceipt struct {
+	// Draft is set when the submission was saved as a draft.
+	Draft               *SurveyDraft
+	Completed           bool
+	QuestionsAnswered   int
+	TotalQuestions      int
+	IncompletePenalty   float64
+	IncompleteQuestions int
+}
+
+// SurveyRepository is the storage behind SurveyService.
+type SurveyRepository interface {
+	// WithSurveyTx runs fn in one transaction, committing only if fn
+	// returns nil.
+	WithSurveyTx(fn func(tx SurveyTx) error) error
+	// RecalculateScores refreshes a session's averages and deviation
+	// penalties after a responder completes the survey.
+	RecalculateScores(sessionID string)
+}
+
+// SurveyTx is a survey submission transaction.
+type SurveyTx interface {
+	// LockSurvey holds off finalisation of the session's survey until the
+	// transaction ends. It returns ErrSessionNotFound for unknown sessions.
+	LockSurvey(sessionID string) (level int, closed bool, err error)
+
+	Draft(sessionID, responderID string) (draft SurveyDraft, found bool, err error)
+	// SaveDraft merges responses into the responder's draft. A nil
+	// currentQuestion keeps the saved position.
+	SaveDraft(sessionID, responderID string, responses map[int]map[int]string, currentQuestion *int) (SurveyDraft, error)
+	DeleteDraft(sessionID, responderID string) error
+
+	Questions(sessionID string) ([]Question, error)
+	ParticipantCount(sessionID string) (int, error)
+	// SaveQuestionScore replaces the responder's earlier answer to the
+	// question and charges any missing ranks.
+	SaveQuestionScore(sessionID, responderID string, score QuestionScore) error
+	AnsweredCount(sessionID, responderID string) (int, error)
+	MarkCompleted(sessionID, responderID string) error
+}
+
+// SurveyService takes survey answers.
+type SurveyService interface {
+	// Submit saves a partial submission as a draft, or scores a final one
+	// together with the responder's draft.
+	Submit(submission SurveySubmission) (SurveyReceipt, error)
+}
+
+type surveyService struct {
+	repo    SurveyRepository
+	scoring ScoringService
+}
+
+// NewSurveyService returns a SurveyService backed by repo.
+func NewSurveyService(repo SurveyRepository, scoring ScoringService) SurveyService {
+	return &surveyService{repo: repo, scoring: scoring}
+}
+
+func (s *surveyService) Submit(sub SurveySubmission) (SurveyReceipt, error) {
+	var receipt SurveyReceipt
+	err := s.repo.WithSurveyTx(func(tx SurveyTx) error {
+		level, closed, err := tx.LockSurvey(sub.SessionID)
+		if err != nil {
+			return err
+		}
+		if closed {
+			return ErrSurveyClosed
+		}
+
+		if sub.Partial {
+			draft, err := tx.SaveDraft(sub.SessionID, sub.ResponderID, sub.Responses, sub.CurrentQuestion)
+			receipt.Draft = &draft
+			return err
+		}
+
+		questions, err := tx.Questions(sub.SessionID)
+		if err != nil {
+			return err
+		}
+		participants, err := tx.ParticipantCount(sub.SessionID)
+		if err != nil {
+			return err
+		}
+
+		// Answers saved in a draft are submitted too, unless this request
+		// answers the same question again
+		draft, _, err := tx.Draft(sub.SessionID, sub.ResponderID)
+		if err != nil {
+			return err
+		}
+		responses := MergeSurveyResponses(draft.Responses, sub.Responses)
+
+		scores := ScoreSurvey(s.scoring, level, questions, responses, ExpectedRanks(participants))
+		for _, score := range scores {
+			if err := tx.SaveQuestionScore(sub.SessionID, sub.ResponderID, score); err != nil {
+				return err
+			}
+			if score.MissingRanks > 0 {
+				receipt.IncompleteQuestions++
+				receipt.IncompletePenalty += float64(score.MissingRanks)
+			}
+		}
+		if err := tx.DeleteDraft(sub.SessionID, sub.ResponderID); err != nil {
+			return err
+		}
+
+		receipt.TotalQuestions = len(questions)
+		receipt.QuestionsAnswered, err = tx.AnsweredCount(sub.SessionID, sub.ResponderID)
+		if err != nil {
+			return err
+		}
+		if receipt.QuestionsAnswered >= receipt.TotalQuestions {
+			receipt.Completed = true
+			return tx.MarkCompleted(sub.SessionID, sub.ResponderID)
+		}
+		return nil
+	})
+	if err != nil {
+		return SurveyReceipt{}, err
+	}
+
+	if receipt.Completed {
+		s.repo.RecalculateScores(sub.SessionID)
+	}
+	return receipt, nil
+}
+
+// ScoreSurvey scores a responder's answers against a session's questions,
+// in question order. Answers to questions not in the session are dropped.
+func ScoreSurvey(scoring ScoringService, level int, questions []Question, responses map[int]map[int]string, expectedRanks int) []QuestionScore {
+	byNumber := make(map[int]Question, len(questions))
+	for _, q := range questions {
+		byNumber[q.Number] = q
+	}
+
+	var scores []QuestionScore
+	for number, rankings := range responses {
+		q, ok := byNumber[number]
+		if !ok {
+			continue
+		}
+		scores = append(scores, scoring.ScoreQuestion(level, q, rankings, expectedRanks))
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Question.Number < scores[j].Question.Number
+	})
+	return scores
+}
+
+// MergeSurveyResponses overlays newer per-question rankings on saved ones.
+func MergeSurveyResponses(saved, newer map[int]map[int]string) map[int]map[int]string {
+	merged := make(map[int]map[int]string, len(saved)+len(newer))
+	for number, rankings := range saved {
+		merged[number] = rankings
+	}
+	for number, rankings := range newer {
+		merged[number] = rankings
+	}
+	return merged
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestScoreQuestion(t *testing.T) {
+	m := NewMemory()
+	m.SetRankingPoints(2, 1, 10)
+	m.SetRankingPoints(2, 2, 5)
+	scoring := NewScoringService(m)
+	q := Question{Number: 1, ID: "q1", Weight: 1}
+
+	tests := []struct {
+		name        string
+		level       int
+		question    Question
+		rankings    map[int]string
+		expected    int
+		wantScores  []float64
+		wantMissing int
+	}{
+		{"default points", 1, q, map[int]string{1: "a", 2: "b", 3: "c"}, 3, []float64{4, 3, 2}, 0},
+		{"weighted", 1, Question{ID: "q2", Weight: 1.5}, map[int]string{1: "a", 2: "b"}, 2, []float64{6, 4.5}, 0},
+		{"ranks left out", 1, q, map[int]string{1: "a"}, 3, []float64{4}, 2},
+		{"ranks not numbered from 1", 1, q, map[int]string{2: "a", 3: "b"}, 2, []float64{3, 2}, 1},
+		{"configured level", 2, q, map[int]string{1: "a", 2: "b", 3: "c"}, 3, []float64{10, 5, 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := scoring.ScoreQuestion(tt.level, tt.question, tt.rankings, tt.expected)
+			var got []float64
+			for _, ranked := range score.Rankings {
+				got = append(got, ranked.Score)
+			}
+			if !reflect.DeepEqual(got, tt.wantScores) {
+				t.Errorf("scores = %v, want %v", got, tt.wantScores)
+			}
+			if score.MissingRanks != tt.wantMissing {
+				t.Errorf("MissingRanks = %d, want %d", score.MissingRanks, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestBiasPenalty(t *testing.T) {
+	tests := []struct {
+		score, median, threshold float64
+		wantPenalty              float64
+	}{
+		{4, 3, 2, 0},
+		{2, 4, 2, 2},
+		{0.5, 4.5, 2, MaxBiasPenalty},
+	}
+	for _, tt := range tests {
+		if _, got := BiasPenalty(tt.score, tt.median, tt.threshold); got != tt.wantPenalty {
+			t.Errorf("BiasPenalty(%v, %v, %v) = %v, want %v", tt.score, tt.median, tt.threshold, got, tt.wantPenalty)
+		}
+	}
+	if got := Median([]float64{4, 2, 3, 1}); got != 2.5 {
+		t.Errorf("Median = %v, want 2.5", got)
+	}
+}
+
+func TestScoreBias(t *testing.T) {
+	ratings := []Rating{
+		{ID: "1", QuestionID: "q1", ResponderID: "b", StudentID: "a", Score: 4},
+		{ID: "2", QuestionID: "q1", ResponderID: "c", StudentID: "a", Score: 4},
+		{ID: "3", QuestionID: "q1", ResponderID: "d", StudentID: "a", Score: 1},
+		// Self-ratings neither count towards the median nor are measured
+		{ID: "4", QuestionID: "q1", ResponderID: "a", StudentID: "a", Score: 0},
+		// A zero rating is measured but leaves the median alone
+		{ID: "5", QuestionID: "q2", ResponderID: "b", StudentID: "a", Score: 0},
+		{ID: "6", QuestionID: "q2", ResponderID: "c", StudentID: "a", Score: 3},
+		// With no non-zero rating there is no median to measure against
+		{ID: "7", QuestionID: "q1", ResponderID: "a", StudentID: "b", Score: 0},
+	}
+
+	got := map[string][3]float64{}
+	for _, b := range ScoreBias(ratings, 2) {
+		got[b.ID] = [3]float64{b.Median, b.Deviation, b.Penalty}
+	}
+	want := map[string][3]float64{
+		"1": {4, 0, 0},
+		"2": {4, 0, 0},
+		"3": {4, 3, 3},
+		"5": {3, 3, 3},
+		"6": {3, 0, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ScoreBias = %v, want %v", got, want)
+	}
+}
+
+// surveyStore returns a session "sess" of students a, b, c and d at level,
+// with the given number of survey questions.
+func surveyStore(level, questions int) (*Memory, SurveyService) {
+	m := NewMemory()
+	students := []string{"a", "b", "c", "d"}
+	for _, id := range students {
+		m.AddStudent(id, level)
+	}
+	session := MemorySession{ID: "sess", Level: level, Status: StatusInProgress, Participants: students}
+	for n := 1; n <= questions; n++ {
+		session.Questions = append(session.Questions, Question{Number: n, ID: fmt.Sprintf("q%d", n), Weight: 1})
+	}
+	m.AddSession(session)
+	return m, NewSurveyService(m, NewScoringService(m))
+}
+
+func submit(t *testing.T, svc SurveyService, responder string, rankings map[int]string) SurveyReceipt {
+	t.Helper()
+	receipt, err := svc.Submit(SurveySubmission{
+		SessionID:   "sess",
+		ResponderID: responder,
+		Responses:   map[int]map[int]string{1: rankings},
+	})
+	if err != nil {
+		t.Fatalf("Submit(%s): %v", responder, err)
+	}
+	return receipt
+}
+
+// submitAll completes the survey for everyone. Everyone but d agrees on
+// a; d ranks a last, two points under the median, for a bias penalty.
+func submitAll(t *testing.T, svc SurveyService) {
+	t.Helper()
+	submit(t, svc, "a", map[int]string{1: "b", 2: "c", 3: "d"})
+	submit(t, svc, "b", map[int]string{1: "a", 2: "c", 3: "d"})
+	submit(t, svc, "c", map[int]string{1: "a", 2: "b", 3: "d"})
+	submit(t, svc, "d", map[int]string{1: "b", 2: "c", 3: "a"})
+}
+
+func scoresOf(t *testing.T, m *Memory) map[string][2]float64 {
+	t.Helper()
+	standings, err := m.Standings("sess")
+	if err != nil {
+		t.Fatalf("Standings: %v", err)
+	}
+	got := map[string][2]float64{}
+	for _, s := range standings {
+		got[s.StudentID] = [2]float64{s.Score, float64(s.Rank)}
+	}
+	return got
+}
+
+func TestStandingsChargeBiasPenalties(t *testing.T) {
+	m, svc := surveyStore(1, 1)
+	submitAll(t, svc)
+
+	// a is rated 4 + 4 + 2, less d's two point bias penalty
+	want := map[string][2]float64{
+		"b": {11, 1},
+		"c": {9, 2},
+		"a": {8, 3},
+		"d": {6, 4},
+	}
+	if got := scoresOf(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("standings = %v, want %v", got, want)
+	}
+
+	// A higher threshold lets d's rating through
+	m.SetPenaltyThreshold(2.5)
+	m.RecalculateScores("sess")
+	if got := scoresOf(t, m)["a"]; got != [2]float64{10, 2} {
+		t.Errorf("a = %v without the bias penalty, want score 10 at rank 2", got)
+	}
+}
+
+func TestStandingsExcludeResponder(t *testing.T) {
+	m, svc := surveyStore(1, 1)
+	submitAll(t, svc)
+
+	m.ExcludeResponder("sess", "d")
+	m.RecalculateScores("sess")
+
+	want := map[string][2]float64{
+		"a": {8, 1},
+		"b": {7, 2},
+		"c": {6, 3},
+		"d": {6, 3},
+	}
+	if got := scoresOf(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("standings = %v, want %v", got, want)
+	}
+}
+
+func TestStandingsChargeMissingRanks(t *testing.T) {
+	m, svc := surveyStore(1, 1)
+	submit(t, svc, "a", map[int]string{1: "b", 2: "c", 3: "d"})
+	submit(t, svc, "b", map[int]string{1: "a", 2: "c", 3: "d"})
+	submit(t, svc, "c", map[int]string{1: "a", 2: "b", 3: "d"})
+
+	receipt := submit(t, svc, "d", map[int]string{1: "a"})
+	if !receipt.Completed {
+		t.Error("an incomplete ranking did not complete the survey")
+	}
+	if receipt.IncompleteQuestions != 1 || receipt.IncompletePenalty != 2 {
+		t.Errorf("receipt = %+v, want one incomplete question costing 2", receipt)
+	}
+
+	// d's only rating is worth 4, less the two ranks they left out
+	if got := scoresOf(t, m)["a"]; got[0] != 4+4+2 {
+		t.Errorf("a scored %v, want 10", got[0])
+	}
+}
+
+func TestStandingsSkipIncompleteResponders(t *testing.T) {
+	m, svc := surveyStore(1, 2)
+
+	// b answers one of two questions, so their ratings don't count yet
+	receipt := submit(t, svc, "b", map[int]string{1: "a", 2: "c", 3: "d"})
+	if receipt.Completed || receipt.QuestionsAnswered != 1 || receipt.TotalQuestions != 2 {
+		t.Fatalf("receipt = %+v, want 1 of 2 questions answered", receipt)
+	}
+	if got := scoresOf(t, m); len(got) != 0 {
+		t.Errorf("standings = %v, want none", got)
+	}
+
+	receipt, err := svc.Submit(SurveySubmission{
+		SessionID:   "sess",
+		ResponderID: "b",
+		Responses:   map[int]map[int]string{2: {1: "c", 2: "a", 3: "d"}},
+	})
+	if err != nil || !receipt.Completed {
+		t.Fatalf("Submit = %+v, %v; want completed", receipt, err)
+	}
+	want := map[string][2]float64{"a": {7, 1}, "c": {7, 1}, "d": {4, 3}}
+	if got := scoresOf(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("standings = %v, want %v", got, want)
+	}
+}
+
+func TestSubmitDrafts(t *testing.T) {
+	_, svc := surveyStore(1, 2)
+
+	current := 2
+	receipt, err := svc.Submit(SurveySubmission{
+		SessionID:       "sess",
+		ResponderID:     "a",
+		Responses:       map[int]map[int]string{1: {1: "b", 2: "c", 3: "d"}},
+		Partial:         true,
+		CurrentQuestion: &current,
+	})
+	if err != nil {
+		t.Fatalf("partial Submit: %v", err)
+	}
+	if receipt.Draft == nil || receipt.Draft.CurrentQuestion != 2 || receipt.Completed {
+		t.Fatalf("receipt = %+v, want a draft at question 2", receipt)
+	}
+
+	// The final submission carries the drafted answer with it
+	receipt, err = svc.Submit(SurveySubmission{
+		SessionID:   "sess",
+		ResponderID: "a",
+		Responses:   map[int]map[int]string{2: {1: "c", 2: "b", 3: "d"}},
+	})
+	if err != nil || !receipt.Completed || receipt.QuestionsAnswered != 2 {
+		t.Errorf("Submit = %+v, %v; want both questions answered", receipt, err)
+	}
+}
+
+func TestSubmitAfterSurveyCloses(t *testing.T) {
+	m, svc := surveyStore(1, 1)
+	m.CloseSurvey("sess")
+	_, err := svc.Submit(SurveySubmission{
+		SessionID:   "sess",
+		ResponderID: "a",
+		Responses:   map[int]map[int]string{1: {1: "b", 2: "c", 3: "d"}},
+	})
+	if err != ErrSurveyClosed {
+		t.Errorf("Submit error = %v, want ErrSurveyClosed", err)
+	}
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// AgendaPhaseTypes are the phase kinds an agenda template may contain.
+var AgendaPhaseTypes = map[string]bool{
+	"prep":               true,
+	"opening_statements": true,
+	"discussion":         true,
+	"rebuttal":           true,
+	"summary":            true,
+	"survey":             true,
+}
+
+// AgendaPhase is one step of a session agenda. Phases with per-speaker turns
+// and no fixed duration last one turn per participant.
+type AgendaPhase struct {
+	Order             int    `json:"order"`
+	Type              string `json:"type"`
+	Label             string `json:"label"`
+	DurationSeconds   int    `json:"duration_seconds"`
+	PerSpeakerSeconds int    `json:"per_speaker_seconds,omitempty"`
+}
+
+// SessionAgenda is the resolved list of phases for a session. TemplateID is
+// empty when the phases come from the legacy gd_sessions.agenda JSON.
+type SessionAgenda struct {
+	TemplateID string        `json:"template_id,omitempty"`
+	Phases     []AgendaPhase `json:"phases"`
+}
+
+// ResolveSessionAgenda returns the phases a session runs through. A session
+// uses the template pinned to it, else the active template for its level,
+// else the prep/discussion/survey flow timed by its level's rules.
+func ResolveSessionAgenda(exec dbExecutor, sessionID string) (SessionAgenda, error) {
+	var agenda SessionAgenda
+	var level int
+	var agendaJSON []byte
+	var templateID sql.NullString
+	err := exec.QueryRow(`
+        SELECT level, agenda, agenda_template_id
+        FROM gd_sessions WHERE id = ?`, sessionID).Scan(&level, &agendaJSON, &templateID)
+	if err != nil {
+		return agenda, err
+	}
+
+	if !templateID.Valid {
+		err = exec.QueryRow(`
+            SELECT id FROM agenda_templates
+            WHERE level = ? AND is_active = TRUE
+            ORDER BY updated_at DESC
+            LIMIT 1`, level).Scan(&templateID)
+		if err != nil && err != sql.ErrNoRows {
+			return agenda, fmt.Errorf("error finding agenda template: %v", err)
+		}
+	}
+
+	if templateID.Valid {
+		phases, err := LoadAgendaTemplatePhases(exec, templateID.String)
+		if err != nil {
+			return agenda, err
+		}
+		if len(phases) > 0 {
+			agenda.TemplateID = templateID.String
+			agenda.Phases = phases
+		}
+	}
+
+	if len(agenda.Phases) == 0 {
+		levelRules, err := LoadLevelRules(exec, level)
+		if err != nil {
+			return agenda, fmt.Errorf("error loading level rules: %v", err)
+		}
+		agenda.Phases = rulesAgendaPhases(applySessionOverrides(levelRules, agendaJSON))
+	}
+
+	// Size per-speaker rounds by the people actually in the session
+	for i, phase := range agenda.Phases {
+		if phase.DurationSeconds > 0 || phase.PerSpeakerSeconds <= 0 {
+			continue
+		}
+		var speakers int
+		exec.QueryRow(`
+            SELECT COUNT(*) FROM session_participants
+            WHERE session_id = ? AND is_dummy = FALSE`, sessionID).Scan(&speakers)
+		if speakers < 1 {
+			speakers = 1
+		}
+		agenda.Phases[i].DurationSeconds = phase.PerSpeakerSeconds * speakers
+	}
+
+	return agenda, nil
+}
+
+// LoadAgendaTemplatePhases returns a template's phases in running order.
+func LoadAgendaTemplatePhases(exec dbExecutor, templateID string) ([]AgendaPhase, error) {
+	rows, err := exec.Query(`
+        SELECT phase_order, phase_type, COALESCE(label, ''), duration_seconds, COALESCE(per_speaker_seconds, 0)
+        FROM agenda_template_phases
+        WHERE template_id = ?
+        ORDER BY phase_order`, templateID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading agenda phases: %v", err)
+	}
+	defer rows.Close()
+
+	var phases []AgendaPhase
+	for rows.Next() {
+		var p AgendaPhase
+		if err := rows.Scan(&p.Order, &p.Type, &p.Label, &p.DurationSeconds, &p.PerSpeakerSeconds); err != nil {
+			return nil, err
+		}
+		phases = append(phases, p)
+	}
+	return phases, rows.Err()
+}
+
+// rulesAgendaPhases builds the original three-phase flow from a session's
+// rules.
+func rulesAgendaPhases(rules SessionRules) []AgendaPhase {
+	return []AgendaPhase{
+		{Order: 1, Type: "prep", Label: "Preparation", DurationSeconds: rules.PrepTime * 60},
+		{Order: 2, Type: "discussion", Label: "Discussion", DurationSeconds: rules.DiscussionTime * 60},
+		{Order: 3, Type: "survey", Label: "Survey", DurationSeconds: rules.SurveyTime * 60},
+	}
+}
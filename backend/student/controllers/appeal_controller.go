@@ -0,0 +1,482 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/services"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx so scoring helpers
+// can run standalone or inside a caller's transaction.
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type Appeal struct {
+	ID                  string `json:"id"`
+	SessionID           string `json:"session_id"`
+	StudentID           string `json:"student_id"`
+	Reason              string `json:"reason"`
+	Status              string `json:"status"`
+	DecisionNotes       string `json:"decision_notes,omitempty"`
+	ExcludedResponderID string `json:"excluded_responder_id,omitempty"`
+	ReviewedBy          string `json:"reviewed_by,omitempty"`
+	ReviewedAt          string `json:"reviewed_at,omitempty"`
+	CreatedAt           string `json:"created_at"`
+}
+
+// RankedStudent is one row of a session's standings.
+type RankedStudent struct {
+	StudentID    string  `json:"student_id"`
+	CurrentLevel int     `json:"current_level"`
+	FinalScore   float64 `json:"final_score"`
+	Rank         int     `json:"rank"`
+}
+
+// PromotionChange describes how a recompute affected one student's level.
+type PromotionChange struct {
+	StudentID string `json:"student_id"`
+	Action    string `json:"action"` // promoted, reverted, rank_updated, revert_skipped
+	Rank      int    `json:"rank"`
+	OldLevel  int    `json:"old_level"`
+	NewLevel  int    `json:"new_level"`
+}
+
+func FileAppeal(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
+	var req struct {
+		SessionID string `json:"session_id"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.SessionID == "" || req.Reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id and reason are required"})
+		return
+	}
+
+	var isParticipant bool
+	err := database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM session_participants
+            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
+        )`, req.SessionID, studentID).Scan(&isParticipant)
+	if err != nil || !isParticipant {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized for this session"})
+		return
+	}
+
+	// An appeal only makes sense once there is a scored result to dispute
+	var hasResults bool
+	err = database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM survey_results
+            WHERE session_id = ? AND student_id = ? AND is_completed = 1
+        )`, req.SessionID, studentID).Scan(&hasResults)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error checking results for appeal", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if !hasResults {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Results for this session are not available yet"})
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	appealID := uuid.New().String()
+	_, err = tx.Exec(`
+        INSERT INTO result_appeals (id, session_id, student_id, reason, status)
+        VALUES (?, ?, ?, ?, 'pending')`,
+		appealID, req.SessionID, studentID, req.Reason)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "You have already filed an appeal for this session"})
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error creating appeal", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to file appeal"})
+		return
+	}
+
+	if err := RecordAppealAudit(tx, appealID, studentID, "student", "filed", map[string]interface{}{
+		"session_id": req.SessionID,
+		"reason":     req.Reason,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing appeal audit log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to file appeal"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to file appeal"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "pending",
+		"appeal_id": appealID,
+	})
+}
+
+func GetMyAppeals(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
+	rows, err := database.GetDB().Query(`
+        SELECT id, session_id, student_id, reason, status,
+               COALESCE(decision_notes, ''), COALESCE(excluded_responder_id, ''),
+               COALESCE(reviewed_by, ''), COALESCE(reviewed_at, ''), created_at
+        FROM result_appeals
+        WHERE student_id = ?
+        ORDER BY created_at DESC`, studentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching appeals", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	appeals, err := ScanAppeals(rows)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error scanning appeals", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(appeals)
+}
+
+// ScanAppeals reads rows selected in the column order used by GetMyAppeals.
+func ScanAppeals(rows *sql.Rows) ([]Appeal, error) {
+	appeals := []Appeal{}
+	for rows.Next() {
+		var a Appeal
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.StudentID, &a.Reason, &a.Status,
+			&a.DecisionNotes, &a.ExcludedResponderID, &a.ReviewedBy, &a.ReviewedAt, &a.CreatedAt); err != nil {
+			return appeals, err
+		}
+		appeals = append(appeals, a)
+	}
+	return appeals, rows.Err()
+}
+
+// RecordAppealAudit appends an entry to appeal_audit_log. It must be called
+// inside the same transaction as the change it describes.
+func RecordAppealAudit(exec dbExecutor, appealID, actorID, actorRole, action string, details map[string]interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`
+        INSERT INTO appeal_audit_log (id, appeal_id, actor_id, actor_role, action, details)
+        VALUES (UUID(), ?, ?, ?, ?, ?)`,
+		appealID, actorID, actorRole, action, string(detailsJSON))
+	return err
+}
+
+// RankSessionStudents returns the standings for a session: survey score less
+// penalties, including those for questions left unanswered when the survey
+// closed, plus any speaking bonus, with ties sharing a rank. Promotions and
+// appeals both rank through here, and the results page adds up the same
+// penalties.
+func RankSessionStudents(exec dbExecutor, sessionID string) ([]RankedStudent, error) {
+	rows, err := exec.Query(`
+        SELECT sr.student_id, su.current_gd_level,
+               SUM(sr.weighted_score - sr.penalty_points) AS final_score
+        FROM survey_results sr
+        JOIN student_users su ON sr.student_id = su.id
+        WHERE sr.session_id = ? AND sr.is_completed = 1
+        GROUP BY sr.student_id, su.current_gd_level`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := map[string]float64{}
+	levels := map[string]int{}
+	for rows.Next() {
+		var studentID string
+		var level int
+		var score float64
+		if err := rows.Scan(&studentID, &level, &score); err != nil {
+			return nil, err
+		}
+		scores[studentID] = score
+		levels[studentID] = level
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	missing, err := missingResponsePenalties(exec, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// Levels that score speaking time add it on top of the survey score
+	speaking, err := GetSpeakingStats(exec, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	for studentID := range scores {
+		scores[studentID] += speaking[studentID].Bonus - missing[studentID]
+	}
+
+	var standings []RankedStudent
+	for _, s := range services.RankStandings(scores) {
+		standings = append(standings, RankedStudent{
+			StudentID:    s.StudentID,
+			CurrentLevel: levels[s.StudentID],
+			FinalScore:   s.Score,
+			Rank:         s.Rank,
+		})
+	}
+	return standings, nil
+}
+
+// missingResponsePenalties totals each responder's penalties for questions
+// they left unanswered when the survey closed.
+func missingResponsePenalties(exec dbExecutor, sessionID string) (map[string]float64, error) {
+	rows, err := exec.Query(`
+        SELECT student_id, SUM(penalty_points)
+        FROM survey_penalties
+        WHERE session_id = ?
+        GROUP BY student_id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	penalties := map[string]float64{}
+	for rows.Next() {
+		var studentID string
+		var points float64
+		if err := rows.Scan(&studentID, &points); err != nil {
+			return nil, err
+		}
+		penalties[studentID] = points
+	}
+	return penalties, rows.Err()
+}
+
+// ExcludeResponder removes one responder's ratings from a session and re-runs
+// averages, medians and bias penalties without them. Incomplete-ranking
+// penalties already charged at submission time are kept.
+func ExcludeResponder(tx *sql.Tx, sessionID, responderID string) (int64, error) {
+	result, err := tx.Exec(`
+        DELETE FROM survey_results
+        WHERE session_id = ? AND responder_id = ?`,
+		sessionID, responderID)
+	if err != nil {
+		return 0, fmt.Errorf("error removing responder ratings: %v", err)
+	}
+	removed, _ := result.RowsAffected()
+
+	// Strip exactly the bias penalties calculatePenalties added, whatever
+	// the threshold is now, so they can be recalculated against the new
+	// medians
+	_, err = tx.Exec(`
+        UPDATE survey_results
+        SET penalty_points = GREATEST(0, penalty_points - bias_penalty_points),
+            bias_penalty_points = 0,
+            is_biased = penalty_points > 0,
+            average_score = 0,
+            median_score = 0,
+            deviation = 0,
+            penalty_calculated = FALSE
+        WHERE session_id = ?`,
+		sessionID)
+	if err != nil {
+		return removed, fmt.Errorf("error resetting penalties: %v", err)
+	}
+
+	return removed, nil
+}
+
+// RecomputeSessionPromotions brings student_promotions and current_gd_level
+// in line with the current standings of a session. Students who dropped out
+// of the top 3 are reverted and newly qualifying students are promoted.
+func RecomputeSessionPromotions(ctx context.Context, tx *sql.Tx, sessionID string) ([]PromotionChange, error) {
+	var totalParticipants, completedCount int
+	err := tx.QueryRow(`
+        SELECT
+            COUNT(DISTINCT sp.student_id),
+            COUNT(DISTINCT sc.student_id)
+        FROM session_participants sp
+        LEFT JOIN survey_completion sc ON sp.session_id = sc.session_id AND sp.student_id = sc.student_id
+        WHERE sp.session_id = ? AND sp.is_dummy = FALSE`,
+		sessionID).Scan(&totalParticipants, &completedCount)
+	if err != nil {
+		return nil, fmt.Errorf("error checking survey completion: %v", err)
+	}
+	if totalParticipants == 0 || completedCount < totalParticipants {
+		// Promotions only happen once every survey is in
+		return nil, nil
+	}
+
+	var sessionLevel int
+	if err := tx.QueryRow("SELECT level FROM gd_sessions WHERE id = ?", sessionID).Scan(&sessionLevel); err != nil {
+		return nil, fmt.Errorf("error getting session level: %v", err)
+	}
+
+	standings, err := RankSessionStudents(tx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error ranking students: %v", err)
+	}
+
+	type promotion struct {
+		OldLevel int
+		NewLevel int
+	}
+	existing := make(map[string]promotion)
+	rows, err := tx.Query(`
+        SELECT student_id, old_level, new_level
+        FROM student_promotions
+        WHERE session_id = ?`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting promotions: %v", err)
+	}
+	for rows.Next() {
+		var studentID string
+		var p promotion
+		if err := rows.Scan(&studentID, &p.OldLevel, &p.NewLevel); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existing[studentID] = p
+	}
+	rows.Close()
+
+	var changes []PromotionChange
+	eligible := make(map[string]bool)
+	for _, s := range standings {
+		if s.Rank < 1 || s.Rank > 3 {
+			continue
+		}
+		eligible[s.StudentID] = true
+
+		if _, ok := existing[s.StudentID]; ok {
+			if _, err := tx.Exec(`
+                UPDATE student_promotions SET rankings = ?
+                WHERE session_id = ? AND student_id = ?`,
+				s.Rank, sessionID, s.StudentID); err != nil {
+				return nil, fmt.Errorf("error updating promotion rank: %v", err)
+			}
+			changes = append(changes, PromotionChange{StudentID: s.StudentID, Action: "rank_updated",
+				Rank: s.Rank, OldLevel: existing[s.StudentID].OldLevel, NewLevel: existing[s.StudentID].NewLevel})
+			continue
+		}
+
+		// Only promote students still sitting at the level this session was run for
+		if s.CurrentLevel != sessionLevel || s.CurrentLevel >= 5 {
+			continue
+		}
+		newLevel := s.CurrentLevel + 1
+		result, err := tx.Exec(`
+            UPDATE student_users
+            SET current_gd_level = ?
+            WHERE id = ? AND current_gd_level = ?`,
+			newLevel, s.StudentID, s.CurrentLevel)
+		if err != nil {
+			return nil, fmt.Errorf("error promoting student %s: %v", s.StudentID, err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`
+            INSERT INTO student_promotions
+            (id, student_id, session_id, old_level, new_level, rankings, promoted_at)
+            VALUES (UUID(), ?, ?, ?, ?, ?, NOW())`,
+			s.StudentID, sessionID, s.CurrentLevel, newLevel, s.Rank); err != nil {
+			return nil, fmt.Errorf("error tracking promotion: %v", err)
+		}
+		if err := auditScoring(ctx, tx, audit.Entry{
+			Action: "student.promote", EntityType: "student", EntityID: s.StudentID,
+			Before: map[string]int{"level": s.CurrentLevel},
+			After:  map[string]interface{}{"level": newLevel, "session_id": sessionID, "rank": s.Rank},
+		}); err != nil {
+			return nil, fmt.Errorf("error auditing promotion: %v", err)
+		}
+		changes = append(changes, PromotionChange{StudentID: s.StudentID, Action: "promoted",
+			Rank: s.Rank, OldLevel: s.CurrentLevel, NewLevel: newLevel})
+	}
+
+	for studentID, p := range existing {
+		if eligible[studentID] {
+			continue
+		}
+		// Revert only if the student hasn't moved on since this promotion
+		result, err := tx.Exec(`
+            UPDATE student_users
+            SET current_gd_level = ?
+            WHERE id = ? AND current_gd_level = ?`,
+			p.OldLevel, studentID, p.NewLevel)
+		if err != nil {
+			return nil, fmt.Errorf("error reverting promotion for %s: %v", studentID, err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			slog.Warn("Not reverting promotion: level has changed since", "student_id", studentID, "session_id", sessionID)
+			changes = append(changes, PromotionChange{StudentID: studentID, Action: "revert_skipped",
+				OldLevel: p.OldLevel, NewLevel: p.NewLevel})
+			continue
+		}
+		if _, err := tx.Exec(`
+            DELETE FROM student_promotions
+            WHERE session_id = ? AND student_id = ?`,
+			sessionID, studentID); err != nil {
+			return nil, fmt.Errorf("error removing promotion for %s: %v", studentID, err)
+		}
+		if err := auditScoring(ctx, tx, audit.Entry{
+			Action: "student.promotion_revert", EntityType: "student", EntityID: studentID,
+			Before: map[string]interface{}{"level": p.NewLevel, "session_id": sessionID},
+			After:  map[string]int{"level": p.OldLevel},
+		}); err != nil {
+			return nil, fmt.Errorf("error auditing promotion revert: %v", err)
+		}
+		changes = append(changes, PromotionChange{StudentID: studentID, Action: "reverted",
+			OldLevel: p.NewLevel, NewLevel: p.OldLevel})
+	}
+
+	return changes, nil
+}
+
+// RescoreSession recalculates averages, medians and bias penalties for a
+// session inside tx. Call ExcludeResponder first so the previous bias
+// penalties are cleared.
+func RescoreSession(ctx context.Context, tx *sql.Tx, sessionID string) error {
+	return calculatePenaltiesInTransaction(ctx, tx, sessionID)
+}
@@ -6,8 +6,9 @@ import (
 	"net/http"
 	"gd/student/utils"
 	"gd/database"
+	"gd/ratelimit"
 	"golang.org/x/crypto/bcrypt"
-	"log"
+	"log/slog"
 )
 
 type StudentLoginRequest struct {
@@ -31,7 +32,16 @@ func StudentLogin(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    log.Printf("Login attempt for: %s", req.Email)
+    slog.InfoContext(r.Context(), "Login attempt", "email", req.Email)
+
+    account := ratelimit.NormalizeAccount(req.Email)
+    if wait, err := ratelimit.LockedFor(r.Context(), ratelimit.StudentAccount, account); err != nil {
+        slog.ErrorContext(r.Context(), "Error checking login lockout", "error", err)
+    } else if wait > 0 {
+        ratelimit.LoginFailed(r, ratelimit.StudentAccount, account, ratelimit.ReasonLocked)
+        ratelimit.WriteLocked(w, wait)
+        return
+    }
     
     var student StudentData
     
@@ -43,34 +53,36 @@ func StudentLogin(w http.ResponseWriter, r *http.Request) {
     ).Scan(&student.ID, &student.PasswordHash, &student.Level, &student.RollNumber)
 
     if err != nil {
-        log.Printf("Database error for %s: %v", req.Email, err)
         if err == sql.ErrNoRows {
+            ratelimit.LoginFailed(r, ratelimit.StudentAccount, account, ratelimit.ReasonUnknownAccount)
             w.WriteHeader(http.StatusUnauthorized)
             json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
         } else {
+            slog.ErrorContext(r.Context(), "Database error during login", "email", req.Email, "error", err)
             w.WriteHeader(http.StatusInternalServerError)
             json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error: " + err.Error()})
         }
         return
     }
 
-    log.Printf("Found student: %s", student.ID)
+    slog.DebugContext(r.Context(), "Found student", "student_id", student.ID)
     
     // Compare password
     err = bcrypt.CompareHashAndPassword([]byte(student.PasswordHash), []byte(req.Password))
     if err != nil {
-        log.Printf("Password mismatch for %s", req.Email)
+        ratelimit.LoginFailed(r, ratelimit.StudentAccount, account, ratelimit.ReasonBadPassword)
         w.WriteHeader(http.StatusUnauthorized)
         json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
         return
     }
+    ratelimit.LoginSucceeded(r.Context(), ratelimit.StudentAccount, account)
 
-    log.Printf("Found student: %s, level: %d, roll_number: %s", student.ID, student.Level, student.RollNumber.String)
+    slog.DebugContext(r.Context(), "Student authenticated", "student_id", student.ID, "level", student.Level, "roll_number", student.RollNumber.String)
     
     // Generate JWT token
     token, err := jwt.GenerateStudentToken(student.ID, student.Level)
     if err != nil {
-        log.Printf("Token generation error: %v", err)
+        slog.ErrorContext(r.Context(), "Token generation error", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
         return
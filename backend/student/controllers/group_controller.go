@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"log/slog"
+	"net/http"
+)
+
+type GroupPeer struct {
+	StudentID  string `json:"student_id"`
+	FullName   string `json:"full_name"`
+	Department string `json:"department"`
+}
+
+// GetMyGroup returns the group, table and peers the student was placed with
+// for their upcoming or running session.
+func GetMyGroup(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
+	var group struct {
+		SessionID   string      `json:"session_id"`
+		VenueID     string      `json:"venue_id"`
+		VenueName   string      `json:"venue_name"`
+		GroupNumber int         `json:"group_number"`
+		TableLabel  string      `json:"table_label"`
+		StartTime   string      `json:"start_time"`
+		Status      string      `json:"status"`
+		Members     []GroupPeer `json:"members"`
+	}
+	err := database.GetDB().QueryRow(`
+        SELECT ga.session_id, ga.venue_id, v.name, ga.group_number, ga.table_label,
+               s.start_time, s.status
+        FROM session_group_assignments ga
+        JOIN gd_sessions s ON ga.session_id = s.id
+        JOIN venues v ON ga.venue_id = v.id
+        WHERE ga.student_id = ? AND s.status IN `+OpenStatusesSQL+`
+        ORDER BY ga.created_at DESC
+        LIMIT 1`, studentID).Scan(&group.SessionID, &group.VenueID, &group.VenueName,
+		&group.GroupNumber, &group.TableLabel, &group.StartTime, &group.Status)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "No group assigned yet"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching group for student", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	rows, err := database.GetDB().Query(`
+        SELECT su.id, su.full_name, su.department
+        FROM session_group_assignments ga
+        JOIN student_users su ON ga.student_id = su.id
+        WHERE ga.session_id = ?
+        ORDER BY su.full_name`, group.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching group members", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	group.Members = []GroupPeer{}
+	for rows.Next() {
+		var p GroupPeer
+		if err := rows.Scan(&p.StudentID, &p.FullName, &p.Department); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning group member", "error", err)
+			continue
+		}
+		group.Members = append(group.Members, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+)
+
+// LevelRules are the defaults every session at a level runs with, kept in
+// gd_rules. Times are in minutes.
+type LevelRules struct {
+	Level            int     `json:"level"`
+	PrepTime         int     `json:"prep_time"`
+	DiscussionTime   int     `json:"discussion_time"`
+	SurveyTime       int     `json:"survey_time"`
+	PenaltyThreshold float64 `json:"penalty_threshold"`
+	AllowOverride    bool    `json:"allow_override"`
+	// IsDefault is true when the level has no gd_rules row of its own.
+	IsDefault bool `json:"is_default"`
+}
+
+// DefaultLevelRules apply to levels with no gd_rules row.
+var DefaultLevelRules = LevelRules{
+	PrepTime:         2,
+	DiscussionTime:   20,
+	SurveyTime:       5,
+	PenaltyThreshold: 2.0,
+	AllowOverride:    true,
+	IsDefault:        true,
+}
+
+// SessionRules are the rules a session runs with: its level's rules, with
+// the times in the session's agenda laid over them when the level allows.
+type SessionRules struct {
+	LevelRules
+	SessionID string `json:"session_id"`
+	// Overridden is true when the session's agenda changed any time.
+	Overridden bool `json:"overridden"`
+}
+
+// LoadLevelRules returns the rules for a level, falling back to
+// DefaultLevelRules.
+func LoadLevelRules(exec dbExecutor, level int) (LevelRules, error) {
+	rules := LevelRules{Level: level}
+	err := exec.QueryRow(`
+        SELECT prep_time, discussion_time, survey_time, penalty_threshold, COALESCE(allow_override, TRUE)
+        FROM gd_rules WHERE level = ?`, level).Scan(
+		&rules.PrepTime, &rules.DiscussionTime, &rules.SurveyTime, &rules.PenaltyThreshold, &rules.AllowOverride)
+	if err == sql.ErrNoRows {
+		rules = DefaultLevelRules
+		rules.Level = level
+		return rules, nil
+	}
+	return rules, err
+}
+
+// ResolveSessionRules returns the rules for a session. It returns
+// sql.ErrNoRows if the session does not exist.
+func ResolveSessionRules(exec dbExecutor, sessionID string) (SessionRules, error) {
+	var level int
+	var agendaJSON []byte
+	err := exec.QueryRow(`
+        SELECT level, agenda FROM gd_sessions WHERE id = ?`, sessionID).Scan(&level, &agendaJSON)
+	if err != nil {
+		return SessionRules{}, err
+	}
+
+	levelRules, err := LoadLevelRules(exec, level)
+	if err != nil {
+		return SessionRules{}, err
+	}
+	rules := applySessionOverrides(levelRules, agendaJSON)
+	rules.SessionID = sessionID
+	return rules, nil
+}
+
+// applySessionOverrides lays the minutes in a session's agenda JSON over
+// its level's rules. Times missing from the agenda keep the level's value,
+// and nothing is overridden when the level doesn't allow it.
+func applySessionOverrides(levelRules LevelRules, agendaJSON []byte) SessionRules {
+	rules := SessionRules{LevelRules: levelRules}
+	if !levelRules.AllowOverride || len(agendaJSON) == 0 {
+		return rules
+	}
+
+	var agenda struct {
+		PrepTime   int `json:"prep_time"`
+		Discussion int `json:"discussion"`
+		Survey     int `json:"survey"`
+	}
+	if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
+		slog.Error("Error parsing agenda JSON", "error", err)
+		return rules
+	}
+
+	for _, o := range []struct {
+		value  int
+		target *int
+	}{
+		{agenda.PrepTime, &rules.PrepTime},
+		{agenda.Discussion, &rules.DiscussionTime},
+		{agenda.Survey, &rules.SurveyTime},
+	} {
+		if o.value > 0 && o.value != *o.target {
+			*o.target = o.value
+			rules.Overridden = true
+		}
+	}
+	return rules
+}
+
+// sessionPenaltyThreshold is the rating deviation from the median at which
+// a responder is penalised for bias in a session.
+func sessionPenaltyThreshold(exec dbExecutor, sessionID string) float64 {
+	rules, err := ResolveSessionRules(exec, sessionID)
+	if err != nil {
+		slog.Error("Error resolving rules for session, using default penalty threshold", "session_id", sessionID, "error", err)
+		return DefaultLevelRules.PenaltyThreshold
+	}
+	return rules.PenaltyThreshold
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"gd/database"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Notification is a message for a student, such as a session reminder.
+type Notification struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id,omitempty"`
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	IsRead    bool   `json:"is_read"`
+	CreatedAt string `json:"created_at"`
+}
+
+// SendSessionReminders notifies the participants of every scheduled session
+// starting within lead. Each participant is reminded once per session. It
+// returns the number of reminders sent.
+func SendSessionReminders(ctx context.Context, lead time.Duration) (int64, error) {
+	result, err := database.GetDB().ExecContext(ctx, `
+        INSERT IGNORE INTO student_notifications (id, student_id, session_id, kind, message)
+        SELECT UUID(), sp.student_id, s.id, 'session_reminder',
+               CONCAT('Your group discussion at ', v.name, ' starts at ', DATE_FORMAT(s.start_time, '%H:%i'))
+        FROM gd_sessions s
+        JOIN venues v ON s.venue_id = v.id
+        JOIN session_participants sp ON sp.session_id = s.id AND sp.is_dummy = FALSE
+        WHERE s.status = 'scheduled'
+          AND s.start_time > NOW()
+          AND s.start_time <= DATE_ADD(NOW(), INTERVAL ? SECOND)`,
+		int(lead.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Notify sends a student a notification about a session. A student gets
+// at most one notification of each kind per session.
+func Notify(exec dbExecutor, studentID, sessionID, kind, message string) error {
+	_, err := exec.Exec(`
+        INSERT IGNORE INTO student_notifications (id, student_id, session_id, kind, message)
+        VALUES (UUID(), ?, ?, ?, ?)`, studentID, sessionID, kind, message)
+	return err
+}
+
+// GetNotifications returns the student's notifications, newest first.
+// ?unread=true limits them to unread ones.
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	rows, err := database.GetDB().Query(`
+        SELECT id, COALESCE(session_id, ''), kind, message, is_read, created_at
+        FROM student_notifications
+        WHERE student_id = ? AND (? = FALSE OR is_read = FALSE)
+        ORDER BY created_at DESC
+        LIMIT 100`, studentID, unreadOnly)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching notifications", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.SessionID, &n.Kind, &n.Message, &n.IsRead, &n.CreatedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning notification", "error", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// MarkNotificationsRead marks the given notifications read, or all of the
+// student's notifications when no IDs are sent.
+func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	db := database.GetDB()
+	var marked int64
+	if len(req.IDs) == 0 {
+		result, err := db.Exec(`
+            UPDATE student_notifications SET is_read = TRUE
+            WHERE student_id = ? AND is_read = FALSE`, studentID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marking notifications read", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update notifications"})
+			return
+		}
+		marked, _ = result.RowsAffected()
+	} else {
+		for _, id := range req.IDs {
+			result, err := db.Exec(`
+                UPDATE student_notifications SET is_read = TRUE
+                WHERE id = ? AND student_id = ? AND is_read = FALSE`, id, studentID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error marking notification read", "notification_id", id, "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update notifications"})
+				return
+			}
+			affected, _ := result.RowsAffected()
+			marked += affected
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"marked": marked})
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"gd/services"
+	"log/slog"
+	"net/http"
+)
+
+// PriorityPass puts a student whose session was cancelled first in line
+// for a seat at their level. Booking a full venue with one reserves the
+// student a seat in that venue's next session.
+type PriorityPass struct {
+	ID              string  `json:"id"`
+	Level           int     `json:"level"`
+	SourceSessionID *string `json:"source_session_id"`
+	Reason          *string `json:"reason"`
+	ReservedVenueID *string `json:"reserved_venue_id"`
+	ExpiresAt       string  `json:"expires_at"`
+	CreatedAt       string  `json:"created_at"`
+}
+
+// IssuePriorityPass gives a student priority for their next booking at
+// level. It lasts services.PriorityPassLifetime and is used up once it
+// gets them a seat.
+func IssuePriorityPass(exec dbExecutor, studentID string, level int, sourceSessionID, reason string) error {
+	_, err := exec.Exec(`
+        INSERT INTO booking_priority_passes
+        (id, student_id, level, source_session_id, reason, expires_at)
+        VALUES (UUID(), ?, ?, NULLIF(?, ''), NULLIF(?, ''), DATE_ADD(NOW(), INTERVAL ? SECOND))`,
+		studentID, level, sourceSessionID, reason, int(services.PriorityPassLifetime.Seconds()))
+	return err
+}
+
+// GetPriorityPasses lists the student's unused, unexpired priority passes.
+func GetPriorityPasses(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
+	rows, err := database.GetDB().Query(`
+        SELECT id, level, source_session_id, reason, reserved_venue_id, expires_at, created_at
+        FROM booking_priority_passes
+        WHERE student_id = ? AND redeemed_at IS NULL AND expires_at > NOW()
+        ORDER BY expires_at`, studentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching priority passes", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	passes := []PriorityPass{}
+	for rows.Next() {
+		var p PriorityPass
+		var source, reason, reserved sql.NullString
+		if err := rows.Scan(&p.ID, &p.Level, &source, &reason, &reserved, &p.ExpiresAt, &p.CreatedAt); err != nil {
+			slog.ErrorContext(r.Context(), "Error scanning priority pass", "error", err)
+			continue
+		}
+		p.SourceSessionID = nullableString(source)
+		p.Reason = nullableString(reason)
+		p.ReservedVenueID = nullableString(reserved)
+		passes = append(passes, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passes)
+}
@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"gd/database"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 )
@@ -39,7 +39,7 @@ func GetStudentProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Fetching profile for student: %s", studentID)
+	slog.DebugContext(r.Context(), "Fetching profile")
 
 	var profile StudentProfile
 	
@@ -63,7 +63,7 @@ func GetStudentProfile(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err != nil {
-		log.Printf("Database error for student %s: %v", studentID, err)
+		slog.ErrorContext(r.Context(), "Database error for student", "error", err)
 		if err == sql.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Student profile not found"})
@@ -134,8 +134,10 @@ func GetStudentSessionHistory(w http.ResponseWriter, r *http.Request) {
             COALESCE(SUM(sr.weighted_score), 0) as raw_score,
             (SELECT COUNT(*) FROM survey_results sr2 
              WHERE sr2.session_id = s.id AND sr2.responder_id = ?) as questions_answered,
-            (SELECT COUNT(*) FROM survey_questions 
-             WHERE level = s.level AND is_active = TRUE) as total_questions,
+            COALESCE(NULLIF((SELECT COUNT(*) FROM session_question_snapshots sqs
+                             WHERE sqs.session_id = s.id), 0),
+                     (SELECT COUNT(*) FROM survey_questions 
+                      WHERE level = s.level AND is_active = TRUE)) as total_questions,
             (SELECT COUNT(*) FROM session_participants sp2 
              WHERE sp2.session_id = s.id AND sp2.is_dummy = FALSE) as total_participants,
             (SELECT RANK() OVER (ORDER BY SUM(sr3.weighted_score - sr3.penalty_points) DESC) 
@@ -153,7 +155,7 @@ func GetStudentSessionHistory(w http.ResponseWriter, r *http.Request) {
     `, studentID, studentID, studentID, studentID)
 
     if err != nil {
-        log.Printf("Database error fetching session history: %v", err)
+        slog.ErrorContext(r.Context(), "Database error fetching session history", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fetch session history"})
         return
@@ -195,7 +197,7 @@ func GetStudentSessionHistory(w http.ResponseWriter, r *http.Request) {
         )
 
         if err != nil {
-            log.Printf("Error scanning session history: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning session history", "error", err)
             continue
         }
 
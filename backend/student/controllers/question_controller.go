@@ -2,7 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -13,67 +13,50 @@ func GetQuestionsForStudent(w http.ResponseWriter, r *http.Request) {
     levelStr := r.URL.Query().Get("level")
     studentID := r.Context().Value("studentID").(string)
     
-    log.Printf("GetQuestionsForStudent called with level: %s, studentID: %s", levelStr, studentID)
+    slog.DebugContext(r.Context(), "Fetching questions for student", "level_str", levelStr)
     
     level, err := strconv.Atoi(levelStr)
     if err != nil {
-        log.Printf("Invalid level parameter: %s", levelStr)
+        slog.WarnContext(r.Context(), "Invalid level parameter", "level_str", levelStr)
         w.WriteHeader(http.StatusBadRequest)
         json.NewEncoder(w).Encode(map[string]string{"error": "Invalid level"})
         return
     }
 
-    log.Printf("Querying questions for level: %d", level)
-    
-    // FIXED: Enhanced query with better error handling
-    rows, err := database.GetDB().Query(`
-        SELECT id, question_text, weight 
-        FROM survey_questions 
-        WHERE level = ? AND is_active = 1
-        ORDER BY created_at`, level)
+    // Sessions are scored against their question snapshot, so serve that
+    sessionID := r.URL.Query().Get("session_id")
+    if sessionID != "" {
+        snapshot, err := SessionQuestions(database.GetDB(), sessionID)
+        if err != nil {
+            slog.ErrorContext(r.Context(), "Error loading session questions", "error", err)
+        }
+        if len(snapshot) > 0 {
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(shuffleQuestionsWithSeed(sessionQuestionMaps(snapshot), studentQuestionSeed(studentID, sessionID)))
+            return
+        }
+    }
+
+    slog.DebugContext(r.Context(), "Querying questions for level", "level", level)
     
+    levelQuestions, setID, err := LevelQuestions(database.GetDB(), level)
     if err != nil {
-        log.Printf("Database error: %v", err)
+        slog.ErrorContext(r.Context(), "Database error", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
     }
-    defer rows.Close()
-
-    var questions []map[string]interface{}
-    var questionCount int
-    
-    for rows.Next() {
-        var question struct {
-            ID     string
-            Text   string
-            Weight float64
-        }
-        if err := rows.Scan(&question.ID, &question.Text, &question.Weight); err != nil {
-            log.Printf("Error scanning row: %v", err)
-            continue
-        }
-        questions = append(questions, map[string]interface{}{
-            "id":     question.ID,
-            "text":   question.Text,
-            "weight": question.Weight,
-        })
-        questionCount++
-        
-        log.Printf("Found question: ID=%s, Text=%s, Weight=%.1f", 
-            question.ID, question.Text, question.Weight)
-    }
-
-    // Check for any errors during iteration
-    if err := rows.Err(); err != nil {
-        log.Printf("Row iteration error: %v", err)
+    if setID != "" {
+        slog.DebugContext(r.Context(), "Using question set", "set_id", setID, "level", level)
     }
+    questions := sessionQuestionMaps(levelQuestions)
+    questionCount := len(questions)
 
-    log.Printf("Total questions found for level %d: %d", level, questionCount)
+    slog.DebugContext(r.Context(), "Questions found for level", "level", level, "question_count", questionCount)
 
     // If no questions found, use defaults
     if len(questions) == 0 {
-        log.Printf("No questions found for level %d, using fallback questions", level)
+        slog.WarnContext(r.Context(), "No questions found for level, using fallback questions", "level", level)
         
         // Debug: Check what's actually in the database
         debugRows, debugErr := database.GetDB().Query(`
@@ -90,12 +73,11 @@ func GetQuestionsForStudent(w http.ResponseWriter, r *http.Request) {
                 var dbLevel int
                 var isActive bool
                 if err := debugRows.Scan(&id, &text, &weight, &dbLevel, &isActive); err == nil {
-                    log.Printf("DB Question: ID=%s, Text=%s, Level=%d, Active=%t", 
-                        id, text, dbLevel, isActive)
+                    slog.DebugContext(r.Context(), "Question in database", "question_id", id, "text", text, "db_level", dbLevel, "is_active", isActive)
                     debugCount++
                 }
             }
-            log.Printf("Total questions in database: %d", debugCount)
+            slog.DebugContext(r.Context(), "Total questions in database", "question_count", debugCount)
         }
         
         questions = []map[string]interface{}{
@@ -106,19 +88,15 @@ func GetQuestionsForStudent(w http.ResponseWriter, r *http.Request) {
     }
 
     // Create a consistent but user-specific shuffle seed
-    sessionID := r.URL.Query().Get("session_id")
-    shuffleSeed := studentID
-    if sessionID != "" {
-        shuffleSeed += sessionID
-    }
+    shuffleSeed := studentQuestionSeed(studentID, sessionID)
 
-    log.Printf("Shuffling %d questions with seed: %s", len(questions), shuffleSeed)
+    slog.DebugContext(r.Context(), "Shuffling questions", "questions_count", len(questions), "shuffle_seed", shuffleSeed)
 
     // Shuffle questions using a consistent seed for this user
     shuffledQuestions := shuffleQuestionsWithSeed(questions, shuffleSeed)
 
     w.Header().Set("Content-Type", "application/json")
     if err := json.NewEncoder(w).Encode(shuffledQuestions); err != nil {
-        log.Printf("Error encoding response: %v", err)
+        slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
     }
 }
\ No newline at end of file
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SessionQuestion is one survey question as frozen for a session. Number is
+// the 1-based question_number students submit rankings against.
+type SessionQuestion struct {
+	Number  int     `json:"question_number"`
+	ID      string  `json:"id"`
+	Version int     `json:"version"`
+	Text    string  `json:"text"`
+	Weight  float64 `json:"weight"`
+}
+
+// SessionQuestions returns the survey questions a session is scored
+// against. The first call snapshots the level's current questions; later
+// edits to the question bank do not change an existing snapshot. It returns
+// no questions, and takes no snapshot, if the level has none.
+func SessionQuestions(exec dbExecutor, sessionID string) ([]SessionQuestion, error) {
+	questions, err := loadSessionSnapshot(exec, sessionID)
+	if err != nil || len(questions) > 0 {
+		return questions, err
+	}
+
+	var level int
+	if err := exec.QueryRow("SELECT level FROM gd_sessions WHERE id = ?", sessionID).Scan(&level); err != nil {
+		return nil, err
+	}
+
+	current, setID, err := LevelQuestions(exec, level)
+	if err != nil {
+		return nil, err
+	}
+	var questionSetID interface{}
+	if setID != "" {
+		questionSetID = setID
+	}
+
+	// Concurrent first requests race here; whichever snapshot lands first wins
+	for _, q := range current {
+		_, err := exec.Exec(`
+            INSERT IGNORE INTO session_question_snapshots
+            (session_id, question_number, question_id, question_version, question_text, weight, question_set_id)
+            VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			sessionID, q.Number, q.ID, q.Version, q.Text, q.Weight, questionSetID)
+		if err != nil {
+			return nil, fmt.Errorf("error saving question snapshot: %v", err)
+		}
+	}
+	return loadSessionSnapshot(exec, sessionID)
+}
+
+// LevelQuestions returns the questions a new session at this level would
+// get, in question_number order. It uses the level's active question set
+// when one is in its activation window, and otherwise every active question
+// tagged with the level. setID is empty in the second case.
+func LevelQuestions(exec dbExecutor, level int) (questions []SessionQuestion, setID string, err error) {
+	err = exec.QueryRow(`
+        SELECT id FROM question_sets
+        WHERE level = ? AND is_active = TRUE
+          AND (active_from IS NULL OR active_from <= NOW())
+          AND (active_until IS NULL OR active_until > NOW())
+        ORDER BY active_from DESC, updated_at DESC
+        LIMIT 1`, level).Scan(&setID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", fmt.Errorf("error finding question set: %v", err)
+	}
+
+	var rows *sql.Rows
+	if setID != "" {
+		rows, err = exec.Query(`
+            SELECT q.id, q.current_version, q.question_text, COALESCE(i.weight, q.weight)
+            FROM question_set_items i
+            JOIN survey_questions q ON q.id = i.question_id
+            WHERE i.set_id = ? AND q.deleted_at IS NULL
+            ORDER BY i.position`, setID)
+	} else {
+		rows, err = exec.Query(`
+            SELECT q.id, q.current_version, q.question_text, q.weight
+            FROM survey_questions q
+            WHERE q.is_active = TRUE AND q.deleted_at IS NULL
+              AND (q.level = ? OR EXISTS (
+                  SELECT 1 FROM question_levels ql
+                  WHERE ql.question_id = q.id AND ql.level = ?))
+            ORDER BY q.display_order, q.created_at`, level, level)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading questions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		q := SessionQuestion{Number: len(questions) + 1}
+		if err := rows.Scan(&q.ID, &q.Version, &q.Text, &q.Weight); err != nil {
+			return nil, "", err
+		}
+		questions = append(questions, q)
+	}
+	return questions, setID, rows.Err()
+}
+
+// PreviewStudentQuestions returns the questions a student at this level
+// would see, in the order GetQuestionsForStudent serves them.
+func PreviewStudentQuestions(exec dbExecutor, level int, studentID, sessionID string) ([]map[string]interface{}, string, error) {
+	questions, setID, err := LevelQuestions(exec, level)
+	if err != nil {
+		return nil, "", err
+	}
+	return shuffleQuestionsWithSeed(sessionQuestionMaps(questions), studentQuestionSeed(studentID, sessionID)), setID, nil
+}
+
+// studentQuestionSeed is the shuffle seed GetQuestionsForStudent uses, so
+// each student gets a stable order within a session.
+func studentQuestionSeed(studentID, sessionID string) string {
+	return studentID + sessionID
+}
+
+func loadSessionSnapshot(exec dbExecutor, sessionID string) ([]SessionQuestion, error) {
+	rows, err := exec.Query(`
+        SELECT question_number, question_id, question_version, question_text, weight
+        FROM session_question_snapshots
+        WHERE session_id = ?
+        ORDER BY question_number`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading question snapshot: %v", err)
+	}
+	defer rows.Close()
+
+	var questions []SessionQuestion
+	for rows.Next() {
+		var q SessionQuestion
+		if err := rows.Scan(&q.Number, &q.ID, &q.Version, &q.Text, &q.Weight); err != nil {
+			return nil, err
+		}
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+// sessionQuestionMaps converts a snapshot into the map shape the survey
+// endpoints return.
+func sessionQuestionMaps(questions []SessionQuestion) []map[string]interface{} {
+	maps := make([]map[string]interface{}, len(questions))
+	for i, q := range questions {
+		maps[i] = map[string]interface{}{
+			"id":              q.ID,
+			"text":            q.Text,
+			"weight":          q.Weight,
+			"version":         q.Version,
+			"question_number": q.Number,
+		}
+	}
+	return maps
+}
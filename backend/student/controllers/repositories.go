@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/metrics"
+	"gd/services"
+	"log/slog"
+)
+
+// The handlers in this package reach booking, joining, survey scoring and
+// promotion through these services, which run against MySQL.
+var (
+	scoringService = services.NewScoringService(mysqlStore{})
+	bookingService = services.NewBookingService(mysqlStore{})
+	sessionService = services.NewSessionService(mysqlStore{}, venueRunning)
+)
+
+// surveyService and promotionService write audit entries, so they are made
+// for each request to carry its context.
+func surveyService(ctx context.Context) services.SurveyService {
+	return services.NewSurveyService(mysqlStore{ctx: ctx}, scoringService)
+}
+
+func promotionService(ctx context.Context) services.PromotionService {
+	return services.NewPromotionService(mysqlStore{ctx: ctx})
+}
+
+func venueRunning(hours services.VenueHours) bool {
+	return isWithinSessionTime(hours.SessionTiming, hours.AvailableDays, hours.StartTime, hours.EndTime)
+}
+
+// mysqlStore implements the service repositories on the shared database.
+// ctx is the request it works for; a zero mysqlStore works for none.
+type mysqlStore struct {
+	ctx context.Context
+}
+
+func (s mysqlStore) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// mysqlTx implements the services' transaction interfaces on a *sql.Tx.
+type mysqlTx struct {
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+func (s mysqlStore) inTx(fn func(mysqlTx) error) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(mysqlTx{tx: tx, ctx: s.context()}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s mysqlStore) WithSeatLocks(fn func(tx services.SeatTx) error) error {
+	return s.inTx(func(tx mysqlTx) error { return fn(tx) })
+}
+
+func (s mysqlStore) WithSurveyTx(fn func(tx services.SurveyTx) error) error {
+	return s.inTx(func(tx mysqlTx) error { return fn(tx) })
+}
+
+func (mysqlStore) StudentLevel(studentID string) (int, error) {
+	var level int
+	err := database.GetDB().QueryRow(`
+        SELECT current_gd_level FROM student_users WHERE id = ?`, studentID).Scan(&level)
+	if err == sql.ErrNoRows {
+		return 0, services.ErrStudentNotFound
+	}
+	return level, err
+}
+
+func (mysqlStore) Venue(venueID string) (services.Venue, error) {
+	venue := services.Venue{ID: venueID}
+	err := database.GetDB().QueryRow(`
+        SELECT level, capacity, is_active, COALESCE(session_timing, ''), COALESCE(available_days, ''),
+               COALESCE(start_time, ''), COALESCE(end_time, '')
+        FROM venues WHERE id = ?`, venueID).Scan(&venue.Level, &venue.Capacity, &venue.IsActive,
+		&venue.Hours.SessionTiming, &venue.Hours.AvailableDays, &venue.Hours.StartTime, &venue.Hours.EndTime)
+	if err == sql.ErrNoRows {
+		return venue, services.ErrVenueNotFound
+	}
+	return venue, err
+}
+
+func (mysqlStore) QRCode(qrData, venueID string) (services.QRCode, error) {
+	qr := services.QRCode{VenueID: venueID, Data: qrData}
+	err := database.GetDB().QueryRow(`
+        SELECT id, max_capacity, current_usage, is_active, qr_group_id
+        FROM venue_qr_codes
+        WHERE qr_data = ? AND venue_id = ?`, qrData, venueID).Scan(
+		&qr.ID, &qr.MaxCapacity, &qr.CurrentUsage, &qr.IsActive, &qr.QRGroupID)
+	if err == sql.ErrNoRows {
+		return qr, services.ErrQRNotFound
+	}
+	return qr, err
+}
+
+func (mysqlStore) RankingPoints(level, rank int) (float64, bool, error) {
+	var points float64
+	err := database.GetDB().QueryRow(`
+        SELECT
+            CASE ?
+                WHEN 1 THEN first_place_points
+                WHEN 2 THEN second_place_points
+                WHEN 3 THEN third_place_points
+                ELSE 0
+            END as points
+        FROM ranking_points_config
+        WHERE level = ? AND is_active = TRUE`,
+		rank, level).Scan(&points)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error getting ranking points: %v", err)
+	}
+	return points, true, nil
+}
+
+func (s mysqlStore) RecalculateScores(sessionID string) {
+	recalculateSurveyScores(s.context(), sessionID)
+}
+
+func (mysqlStore) AlreadyPromoted(sessionID, studentID string) (bool, error) {
+	var promoted bool
+	err := database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM student_promotions
+            WHERE session_id = ? AND student_id = ?
+        )`, sessionID, studentID).Scan(&promoted)
+	return promoted, err
+}
+
+func (mysqlStore) SurveyCompletion(sessionID string) (int, int, error) {
+	var total, completed int
+	err := database.GetDB().QueryRow(`
+        SELECT
+            COUNT(DISTINCT sp.student_id) as total_participants,
+            COUNT(DISTINCT sc.student_id) as completed_count
+        FROM session_participants sp
+        LEFT JOIN survey_completion sc ON sp.session_id = sc.session_id AND sp.student_id = sc.student_id
+        WHERE sp.session_id = ? AND sp.is_dummy = FALSE`,
+		sessionID).Scan(&total, &completed)
+	return completed, total, err
+}
+
+func (mysqlStore) Standings(sessionID string) ([]services.Standing, error) {
+	ranked, err := RankSessionStudents(database.GetDB(), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	standings := make([]services.Standing, len(ranked))
+	for i, r := range ranked {
+		standings[i] = services.Standing{StudentID: r.StudentID, Score: r.FinalScore, Rank: r.Rank}
+	}
+	return standings, nil
+}
+
+func (mysqlStore) Promote(studentID string, newLevel int) (bool, error) {
+	result, err := database.GetDB().Exec(`
+        UPDATE student_users
+        SET current_gd_level = ?
+        WHERE id = ? AND current_gd_level < ?`,
+		newLevel, studentID, services.MaxLevel)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s mysqlStore) RecordPromotion(sessionID, studentID string, rank, oldLevel, newLevel int) error {
+	return trackStudentPromotion(s.context(), sessionID, studentID, rank, oldLevel, newLevel)
+}
+
+func (m mysqlTx) LockSurvey(sessionID string) (int, bool, error) {
+	// The shared lock holds off FinalizeSurvey until the submission commits
+	var level int
+	var closed bool
+	err := m.tx.QueryRow(`
+        SELECT s.level, `+surveyClosedSQL+`
+        FROM gd_sessions s WHERE s.id = ?
+        FOR SHARE`, sessionID).Scan(&level, &closed)
+	if err == sql.ErrNoRows {
+		return 0, false, services.ErrSessionNotFound
+	}
+	return level, closed, err
+}
+
+func (m mysqlTx) Draft(sessionID, responderID string) (services.SurveyDraft, bool, error) {
+	return loadSurveyDraft(m.tx, sessionID, responderID)
+}
+
+func (m mysqlTx) SaveDraft(sessionID, responderID string, responses map[int]map[int]string, currentQuestion *int) (services.SurveyDraft, error) {
+	return saveSurveyDraft(m.tx, sessionID, responderID, responses, currentQuestion)
+}
+
+func (m mysqlTx) DeleteDraft(sessionID, responderID string) error {
+	return deleteSurveyDraft(m.tx, sessionID, responderID)
+}
+
+func (m mysqlTx) Questions(sessionID string) ([]services.Question, error) {
+	snapshot, err := SessionQuestions(m.tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return serviceQuestions(snapshot), nil
+}
+
+func (m mysqlTx) ParticipantCount(sessionID string) (int, error) {
+	var count int
+	err := m.tx.QueryRow(`
+        SELECT COUNT(DISTINCT student_id)
+        FROM session_participants
+        WHERE session_id = ? AND is_dummy = FALSE`, sessionID).Scan(&count)
+	return count, err
+}
+
+func (m mysqlTx) SaveQuestionScore(sessionID, responderID string, score services.QuestionScore) error {
+	return writeQuestionScore(m.ctx, m.tx, sessionID, responderID, score)
+}
+
+func (m mysqlTx) AnsweredCount(sessionID, responderID string) (int, error) {
+	var answered int
+	err := m.tx.QueryRow(`
+        SELECT COUNT(DISTINCT question_id)
+        FROM survey_results
+        WHERE session_id = ? AND responder_id = ?`, sessionID, responderID).Scan(&answered)
+	return answered, err
+}
+
+func (m mysqlTx) MarkCompleted(sessionID, responderID string) error {
+	_, err := m.tx.Exec(`
+        INSERT INTO survey_completion (session_id, student_id, completed_at)
+        VALUES (?, ?, NOW())
+        ON DUPLICATE KEY UPDATE completed_at = NOW()`, sessionID, responderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.tx.Exec(`
+        UPDATE survey_results
+        SET is_completed = 1
+        WHERE session_id = ? AND responder_id = ?`, sessionID, responderID)
+	if err != nil {
+		slog.Error("Error updating survey_results completion status", "error", err)
+	}
+	return nil
+}
+
+// serviceQuestions converts a session's question snapshot for the services.
+func serviceQuestions(snapshot []SessionQuestion) []services.Question {
+	questions := make([]services.Question, len(snapshot))
+	for i, q := range snapshot {
+		questions[i] = services.Question(q)
+	}
+	return questions
+}
+
+// writeQuestionScore replaces a responder's rankings for one question with
+// a scored answer, and charges the responder a point per missing rank.
+func writeQuestionScore(ctx context.Context, tx *sql.Tx, sessionID, responderID string, score services.QuestionScore) error {
+	q := score.Question
+	_, err := tx.Exec(`
+        DELETE FROM survey_results
+        WHERE session_id = ? AND responder_id = ? AND question_id = ?`,
+		sessionID, responderID, q.ID)
+	if err != nil {
+		return fmt.Errorf("error clearing previous responses: %v", err)
+	}
+
+	for _, ranked := range score.Rankings {
+		_, err = tx.Exec(`
+            INSERT INTO survey_results
+            (id, session_id, student_id, responder_id, question_id, question_version, ranks, score, weighted_score, is_current_session, is_completed)
+            VALUES (UUID(), ?, ?, ?, ?, ?, ?, ?, ?, 1, 0)`,
+			sessionID, ranked.StudentID, responderID, q.ID, q.Version, ranked.Rank, ranked.Score, ranked.Score)
+		if err != nil {
+			return err
+		}
+	}
+
+	if score.MissingRanks > 0 {
+		metrics.Penalties.Inc("missing_rank")
+		slog.Info("Applying penalty for missing ranks", "missing_ranks", score.MissingRanks, "responder_id", responderID, "question_id", q.ID)
+		_, err := tx.Exec(`
+            UPDATE survey_results
+            SET penalty_points = penalty_points + ?,
+                is_biased = TRUE,
+                penalty_calculated = TRUE
+            WHERE session_id = ? AND responder_id = ? AND question_id = ?`,
+			float64(score.MissingRanks), sessionID, responderID, q.ID)
+		if err != nil {
+			slog.Error("Error applying incomplete ranking penalty", "error", err)
+		} else if err := auditScoring(ctx, tx, audit.Entry{
+			Action: "score.missing_rank_penalty", EntityType: "session", EntityID: sessionID,
+			After: map[string]interface{}{
+				"responder_id": responderID, "question_id": q.ID, "missing_ranks": score.MissingRanks,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"context"
+	"gd/audit"
+)
+
+// auditScoring records an automated scoring or promotion step in the
+// audit log, attributed to the system whoever's request set it off. ctx is
+// that request's, if any, so the entry keeps its IP and request ID.
+func auditScoring(ctx context.Context, exec audit.Execer, e audit.Entry) error {
+	e.ActorRole, e.ActorID = audit.RoleSystem, ""
+	return audit.Record(ctx, exec, e)
+}
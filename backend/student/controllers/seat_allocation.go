@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gd/services"
+	"log/slog"
+	"net/http"
+)
+
+// MySQL side of seat allocation. Locks are taken in a fixed order, venue
+// first, then session, then QR code, so that concurrent bookings and QR
+// joins queue behind one another rather than deadlocking or reading stale
+// counts.
+
+func (m mysqlTx) LockVenue(venueID string) (services.Venue, error) {
+	venue := services.Venue{ID: venueID, IsActive: true}
+	err := m.tx.QueryRow(`
+        SELECT level, capacity, COALESCE(session_timing, ''), COALESCE(available_days, ''),
+               COALESCE(start_time, ''), COALESCE(end_time, '')
+        FROM venues
+        WHERE id = ? AND is_active = TRUE
+        FOR UPDATE`, venueID).Scan(&venue.Level, &venue.Capacity,
+		&venue.Hours.SessionTiming, &venue.Hours.AvailableDays, &venue.Hours.StartTime, &venue.Hours.EndTime)
+	if err == sql.ErrNoRows {
+		return venue, services.ErrVenueNotFound
+	}
+	return venue, err
+}
+
+func (m mysqlTx) LockStudentLevel(studentID string) (int, error) {
+	var level int
+	err := m.tx.QueryRow("SELECT current_gd_level FROM student_users WHERE id = ? FOR UPDATE", studentID).Scan(&level)
+	if err == sql.ErrNoRows {
+		return 0, services.ErrStudentNotFound
+	}
+	return level, err
+}
+
+func (m mysqlTx) HasActiveBookingAtLevel(studentID string, level int) (bool, error) {
+	var active bool
+	err := m.tx.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1
+            FROM session_participants sp
+            JOIN gd_sessions s ON sp.session_id = s.id
+            JOIN venues v ON s.venue_id = v.id
+            WHERE sp.student_id = ?
+              AND s.status IN `+OpenStatusesSQL+`
+              AND s.end_time > NOW()
+              AND v.level = ?)`, studentID, level).Scan(&active)
+	return active, err
+}
+
+// firstSessionID runs a query for at most one session ID.
+func (m mysqlTx) firstSessionID(query string, args ...interface{}) (string, bool, error) {
+	var sessionID string
+	err := m.tx.QueryRow(query, args...).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return sessionID, err == nil, err
+}
+
+func (m mysqlTx) OpenVenueSession(venueID string) (string, bool, error) {
+	return m.firstSessionID(`
+        SELECT id FROM gd_sessions
+        WHERE venue_id = ? AND status IN `+OpenStatusesSQL+`
+          AND end_time > NOW()
+        ORDER BY created_at DESC LIMIT 1`, venueID)
+}
+
+func (m mysqlTx) AssignedGroupSession(studentID, venueID string) (string, bool, error) {
+	return m.firstSessionID(`
+        SELECT s.id FROM session_group_assignments ga
+        JOIN gd_sessions s ON ga.session_id = s.id
+        WHERE ga.student_id = ? AND ga.venue_id = ? AND s.status IN `+OpenStatusesSQL+`
+        ORDER BY ga.created_at DESC LIMIT 1`, studentID, venueID)
+}
+
+func (m mysqlTx) QRGroupSession(venueID, qrGroupID string) (string, bool, error) {
+	return m.firstSessionID(`
+        SELECT id FROM gd_sessions
+        WHERE venue_id = ? AND qr_group_id = ? AND status IN `+OpenStatusesSQL+`
+        ORDER BY created_at DESC LIMIT 1`, venueID, qrGroupID)
+}
+
+func (m mysqlTx) CreateSession(session services.NewSession) error {
+	_, err := m.tx.Exec(`
+        INSERT INTO gd_sessions
+        (id, venue_id, status, start_time, end_time, level, qr_group_id, max_capacity)
+        VALUES (?, ?, ?, NOW(), DATE_ADD(NOW(), INTERVAL ? SECOND), ?, ?, ?)`,
+		session.ID, session.VenueID, session.Status, int(session.Duration.Seconds()), session.Level,
+		sql.NullString{String: session.QRGroupID, Valid: session.QRGroupID != ""}, session.MaxCapacity)
+	if err != nil {
+		return err
+	}
+	return RecordStatusChange(m.tx, session.ID, "", Transition{
+		To: session.Status, ActorID: session.CreatedBy, ActorRole: ActorStudent})
+}
+
+func (m mysqlTx) EnterLobby(sessionID, studentID string) error {
+	var status services.SessionStatus
+	err := m.tx.QueryRow(`
+        SELECT status FROM gd_sessions WHERE id = ? FOR UPDATE`, sessionID).Scan(&status)
+	if err != nil || status != services.StatusScheduled {
+		return err
+	}
+	return TransitionSession(m.tx, sessionID, Transition{
+		To: services.StatusLobby, ActorID: studentID, ActorRole: ActorStudent})
+}
+
+func (m mysqlTx) IsParticipant(sessionID, studentID string) (bool, error) {
+	var isParticipant bool
+	err := m.tx.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM session_participants
+            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
+        )`, sessionID, studentID).Scan(&isParticipant)
+	return isParticipant, err
+}
+
+func (m mysqlTx) VenueSeatsTaken(venueID, excludeStudentID string) (int, error) {
+	var taken int
+	err := m.tx.QueryRow(`
+        SELECT COUNT(DISTINCT sp.student_id)
+        FROM session_participants sp
+        JOIN gd_sessions s ON sp.session_id = s.id
+        WHERE s.venue_id = ? AND sp.is_dummy = FALSE AND sp.student_id <> ?
+          AND s.status IN `+OpenStatusesSQL+`
+          AND s.end_time > NOW()`, venueID, excludeStudentID).Scan(&taken)
+	return taken, err
+}
+
+func (m mysqlTx) LockSessionSeats(sessionID string) (int, int, error) {
+	var capacity, taken int
+	err := m.tx.QueryRow(`
+        SELECT COALESCE(s.max_capacity, 0),
+               (SELECT COUNT(*) FROM session_participants sp
+                WHERE sp.session_id = s.id AND sp.is_dummy = FALSE)
+        FROM gd_sessions s WHERE s.id = ?
+        FOR UPDATE`, sessionID).Scan(&capacity, &taken)
+	if err == sql.ErrNoRows {
+		return 0, 0, services.ErrSessionNotFound
+	}
+	return capacity, taken, err
+}
+
+func (m mysqlTx) ClaimQRSeat(qrCodeID string) (bool, error) {
+	result, err := m.tx.Exec(`
+        UPDATE venue_qr_codes
+        SET current_usage = current_usage + 1
+        WHERE id = ? AND is_active = TRUE AND current_usage < max_capacity`, qrCodeID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (m mysqlTx) AddParticipant(sessionID, studentID string) error {
+	_, err := m.tx.Exec(`
+        INSERT INTO session_participants
+        (id, session_id, student_id, is_dummy)
+        VALUES (UUID(), ?, ?, FALSE)`, sessionID, studentID)
+	return err
+}
+
+func (m mysqlTx) SetCurrentBooking(studentID, sessionID string) error {
+	_, err := m.tx.Exec(`
+        UPDATE student_users
+        SET current_booking = ?
+        WHERE id = ?`, sessionID, studentID)
+	return err
+}
+
+func (m mysqlTx) PriorityPass(studentID string, level int) (string, bool, error) {
+	var passID string
+	err := m.tx.QueryRow(`
+        SELECT id FROM booking_priority_passes
+        WHERE student_id = ? AND level = ? AND redeemed_at IS NULL AND expires_at > NOW()
+        ORDER BY expires_at
+        LIMIT 1
+        FOR UPDATE`, studentID, level).Scan(&passID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return passID, err == nil, err
+}
+
+func (m mysqlTx) RedeemPriorityPass(passID, sessionID string) error {
+	_, err := m.tx.Exec(`
+        UPDATE booking_priority_passes
+        SET redeemed_at = NOW(), redeemed_session_id = ?
+        WHERE id = ?`, sessionID, passID)
+	return err
+}
+
+func (m mysqlTx) ReservePriorityPass(passID, venueID string) error {
+	_, err := m.tx.Exec(`
+        UPDATE booking_priority_passes
+        SET reserved_at = IF(reserved_venue_id <=> ?, reserved_at, NOW()),
+            reserved_venue_id = ?
+        WHERE id = ?`, venueID, venueID, passID)
+	return err
+}
+
+func (m mysqlTx) PriorityReservations(venueID string) ([]services.PriorityReservation, error) {
+	rows, err := m.tx.Query(`
+        SELECT id, student_id FROM booking_priority_passes
+        WHERE reserved_venue_id = ? AND redeemed_at IS NULL AND expires_at > NOW()
+        ORDER BY reserved_at, id
+        FOR UPDATE`, venueID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []services.PriorityReservation
+	for rows.Next() {
+		var res services.PriorityReservation
+		if err := rows.Scan(&res.PassID, &res.StudentID); err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+	return reservations, rows.Err()
+}
+
+func (m mysqlTx) ClearPhaseTracking(studentID string) error {
+	_, err := m.tx.Exec(`
+        DELETE FROM session_phase_tracking
+        WHERE student_id = ?`, studentID)
+	return err
+}
+
+// TrackJoin marks the student's QR code as scanned.
+func (m mysqlTx) TrackJoin(sessionID, studentID string) error {
+	_, err := m.tx.Exec(`
+        INSERT INTO session_phase_tracking
+        (session_id, student_id, phase, start_time)
+        VALUES (?, ?, 'prep', NOW())`, sessionID, studentID)
+	return err
+}
+
+// seatResult labels the outcome of a booking or join for metrics.
+func seatResult(err error) string {
+	var mismatch *services.LevelMismatchError
+	var active *services.ActiveBookingError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &mismatch):
+		return "wrong_level"
+	case errors.As(err, &active), err == services.ErrAlreadyBooked:
+		return "already_booked"
+	case err == services.ErrVenueFull, err == services.ErrSessionFull, err == services.ErrQRGroupFull:
+		return "full"
+	case err == services.ErrQRNotFound, err == services.ErrQRInactive:
+		return "expired"
+	case err == services.ErrInvalidQR:
+		return "invalid"
+	case err == services.ErrOutsideHours:
+		return "outside_time"
+	case err == services.ErrVenueNotFound:
+		return "not_found"
+	default:
+		return "error"
+	}
+}
+
+// writeSeatError maps booking and join errors to HTTP responses.
+func writeSeatError(w http.ResponseWriter, err error, fallback string) {
+	status, message := http.StatusInternalServerError, fallback
+	switch err {
+	case services.ErrVenueNotFound:
+		status, message = http.StatusNotFound, "Venue not found"
+	case services.ErrVenueFull:
+		status, message = http.StatusConflict, "Venue is full"
+	case services.ErrSessionFull:
+		status, message = http.StatusConflict, "This session is full"
+	case services.ErrQRGroupFull:
+		status, message = http.StatusForbidden, "This QR code has reached its capacity limit"
+	case services.ErrInvalidQR:
+		status, message = http.StatusBadRequest, "Invalid QR code format"
+	case services.ErrQRNotFound:
+		status, message = http.StatusUnauthorized, "Invalid or expired QR code"
+	case services.ErrQRInactive:
+		status, message = http.StatusUnauthorized, "QR code is no longer active"
+	case services.ErrOutsideHours:
+		status, message = http.StatusForbidden, "Session is not active at this time. Please join during scheduled session hours."
+	case services.ErrStudentNotFound:
+		message = "Failed to verify student level"
+	default:
+		slog.Error("Seat allocation error", "error", err)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -2,12 +2,17 @@ package controllers
 
 import (
 	// "bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gd/config"
+	"gd/audit"
 	"gd/database"
-	"log"
-	"math"
+	"gd/metrics"
+	"gd/services"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"sort"
@@ -16,7 +21,6 @@ import (
 	"time"
 
 	// "strings"
-	"github.com/google/uuid"
 )
 
 type SessionDetails struct {
@@ -81,13 +85,13 @@ func UpdateReadyStatus(w http.ResponseWriter, r *http.Request) {
         req.SessionID, studentID, req.IsReady)
     
     if err != nil {
-        log.Printf("Error updating ready status: %v", err)
+        slog.ErrorContext(r.Context(), "Error updating ready status", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update ready status"})
         return
     }
     
-    log.Printf("Updated ready status for student %s in session %s to %t", studentID, req.SessionID, req.IsReady)
+    slog.InfoContext(r.Context(), "Updated ready status", "session_id", req.SessionID, "is_ready", req.IsReady)
     
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
@@ -109,7 +113,7 @@ func CheckAllReady(w http.ResponseWriter, r *http.Request) {
         WHERE session_id = ? AND is_dummy = FALSE`, sessionID).Scan(&totalParticipants)
     
     if err != nil {
-        log.Printf("Error getting total participants: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting total participants", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -124,13 +128,13 @@ func CheckAllReady(w http.ResponseWriter, r *http.Request) {
         WHERE srs.session_id = ? AND srs.is_ready = TRUE AND sp.is_dummy = FALSE`, sessionID).Scan(&readyParticipants)
     
     if err != nil {
-        log.Printf("Error getting ready participants: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting ready participants", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
     }
     
-    log.Printf("CheckAllReady - Session: %s, Ready: %d, Total: %d", sessionID, readyParticipants, totalParticipants)
+    slog.DebugContext(r.Context(), "Checked ready status", "session_id", sessionID, "ready_participants", readyParticipants, "total_participants", totalParticipants)
     
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
@@ -174,7 +178,7 @@ func GetReadyStatus(w http.ResponseWriter, r *http.Request) {
         ORDER BY srs.updated_at DESC`, sessionID)
     
     if err != nil {
-        log.Printf("Error getting ready status: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting ready status", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get ready status"})
         return
@@ -211,7 +215,7 @@ func GetSessionDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	studentID := r.Context().Value("studentID").(string)
-	log.Printf("Fetching session %s for student %s", sessionID, studentID)
+	slog.DebugContext(r.Context(), "Fetching session", "session_id", sessionID)
 
 	// First verify the student is part of this session
 	var isParticipant bool
@@ -222,14 +226,14 @@ func GetSessionDetails(w http.ResponseWriter, r *http.Request) {
         )`, sessionID, studentID).Scan(&isParticipant)
 
 	if err != nil {
-		log.Printf("Database error checking participant: %v", err)
+		slog.ErrorContext(r.Context(), "Database error checking participant", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
 	}
 
 	if !isParticipant {
-		log.Printf("Student %s not authorized for session %s", studentID, sessionID)
+		slog.WarnContext(r.Context(), "Student not authorized for session", "session_id", sessionID)
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized to view this session"})
 		return
@@ -242,18 +246,19 @@ func GetSessionDetails(w http.ResponseWriter, r *http.Request) {
 		topic        sql.NullString
 		agendaJSON   []byte
 		startTimeStr string
+		level        int
 	)
 
 	err = database.GetDB().QueryRow(`
-        SELECT s.id, v.name, s.topic, s.agenda, s.start_time
+        SELECT s.id, v.name, s.topic, s.agenda, s.start_time, s.level
         FROM gd_sessions s
         JOIN venues v ON s.venue_id = v.id
         WHERE s.id = ?`, sessionID).Scan(
-		&id, &venue, &topic, &agendaJSON, &startTimeStr,
+		&id, &venue, &topic, &agendaJSON, &startTimeStr, &level,
 	)
 
 	if err != nil {
-		log.Printf("Database error fetching session: %v", err)
+		slog.ErrorContext(r.Context(), "Database error fetching session", "error", err)
 		if err == sql.ErrNoRows {
 			w.WriteHeader(http.StatusNotFound)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
@@ -267,363 +272,75 @@ func GetSessionDetails(w http.ResponseWriter, r *http.Request) {
 	// Parse start_time from string
 	startTime, err := time.Parse("2006-01-02 15:04:05", startTimeStr)
 	if err != nil {
-		log.Printf("Error parsing start_time: %v", err)
+		slog.ErrorContext(r.Context(), "Error parsing start_time", "error", err)
 		startTime = time.Now() // Fallback to current time if parsing fails
 	}
 
-	// Parse agenda with defaults
-	var agenda struct {
-		PrepTime   int `json:"prep_time"`
-		Discussion int `json:"discussion"`
-		Survey     int `json:"survey"`
-	}
-
-	// Set default values
-	agenda.PrepTime = 1
-	agenda.Discussion = 1
-	agenda.Survey = 1
-
-	if len(agendaJSON) > 0 {
-		if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
-			log.Printf("Error parsing agenda JSON: %v", err)
-			// Use defaults if parsing fails
-		// } else {
-		// 	// Ensure values are in minutes (not seconds)
-		// 	if agenda.Discussion > 5 { // If somehow seconds got stored
-		// 		agenda.Discussion = agenda.Discussion / 5
-		// 	}
-		}
+	levelRules, err := LoadLevelRules(database.GetDB(), level)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading level rules", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
 	}
+	rules := applySessionOverrides(levelRules, agendaJSON)
 
 	response := map[string]interface{}{
 		"id":              id,
 		"venue":           venue,
 		"topic":           topic.String,
-		"prep_time":       agenda.PrepTime,
-		"discussion_time": agenda.Discussion,
-		"survey_time":     agenda.Survey,
+		"prep_time":       rules.PrepTime,
+		"discussion_time": rules.DiscussionTime,
+		"survey_time":     rules.SurveyTime,
 		"start_time":      startTime,
 	}
 
-	log.Printf("Successfully fetched session %s", sessionID)
+	slog.DebugContext(r.Context(), "Fetched session", "session_id", sessionID)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding session response: %v", err)
+		slog.ErrorContext(r.Context(), "Error encoding session response", "error", err)
 	}
 }
 
 ////////////////
 
 func JoinSession(w http.ResponseWriter, r *http.Request) {
-	log.Println("JoinSession endpoint hit")
-	var qrCapacity struct {
-		ID           string
-		MaxCapacity  int
-		CurrentUsage int
-		IsActive     bool
-		QRGroupID    string
-	}
-
 	var request struct {
 		QRData string `json:"qr_data"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		log.Printf("JoinSession decode error: %v", err)
+		slog.WarnContext(r.Context(), "JoinSession decode error", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
 		return
 	}
 
 	if request.QRData == "" {
-		log.Println("Empty QR data received")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "QR data is required"})
 		return
 	}
 
 	studentID := r.Context().Value("studentID").(string)
-	log.Printf("JoinSession request for student %s", studentID)
-
-   
-
-	// Parse QR data
-	var qrPayload struct {
-		VenueID string `json:"venue_id"`
-		Expiry  string `json:"expiry"`
-	}
-	if err := json.Unmarshal([]byte(request.QRData), &qrPayload); err != nil {
-		log.Printf("QR data parsing error: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid QR code format"})
-		return
-	}
-
-	log.Printf("QR payload parsed - VenueID: %s, Expiry: %s", qrPayload.VenueID, qrPayload.Expiry)
-
-
-     var studentLevel int
-    err := database.GetDB().QueryRow(`
-        SELECT current_gd_level FROM student_users WHERE id = ?`, studentID).Scan(&studentLevel)
-    if err != nil {
-        log.Printf("Error getting student level: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to verify student level"})
-        return
-    }
-
-
- var venueTiming struct {
-        SessionTiming   sql.NullString
-        AvailableDays   sql.NullString
-        StartTime       sql.NullString
-        EndTime         sql.NullString
-    }
-    
-    err = database.GetDB().QueryRow(`
-        SELECT session_timing, available_days, start_time, end_time 
-        FROM venues WHERE id = ?`, qrPayload.VenueID).Scan(
-            &venueTiming.SessionTiming, &venueTiming.AvailableDays, 
-            &venueTiming.StartTime, &venueTiming.EndTime)
-    
-    if err != nil {
-        log.Printf("Error getting venue timing: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to verify session timing"})
-        return
-    }
-    
-    sessionTiming := ""
-    if venueTiming.SessionTiming.Valid {
-        sessionTiming = venueTiming.SessionTiming.String
-    }
-    
-    availableDays := ""
-    if venueTiming.AvailableDays.Valid {
-        availableDays = venueTiming.AvailableDays.String
-    }
-    
-    startTime := ""
-    if venueTiming.StartTime.Valid {
-        startTime = venueTiming.StartTime.String
-    }
-    
-    endTime := ""
-    if venueTiming.EndTime.Valid {
-        endTime = venueTiming.EndTime.String
-    }
-
-    // ADD DEBUG LOGGING HERE
-    log.Printf("VENUE TIMING DEBUG - SessionTiming: %s", sessionTiming)
-    log.Printf("VENUE TIMING DEBUG - AvailableDays: %s", availableDays)
-    log.Printf("VENUE TIMING DEBUG - StartTime: %s", startTime)
-    log.Printf("VENUE TIMING DEBUG - EndTime: %s", endTime)
-
-    if !isWithinSessionTime(sessionTiming, availableDays, startTime, endTime) {
-        log.Printf("Student %s tried to join session outside allowed time", studentID)
-        w.WriteHeader(http.StatusForbidden)
-        json.NewEncoder(w).Encode(map[string]string{
-            "error": "Session is not active at this time. Please join during scheduled session hours.",
-        })
-        return
-    }
-    
-
-    // Get venue level from QR code's venue
-    var venueLevel int
-    err = database.GetDB().QueryRow(`
-        SELECT level FROM venues WHERE id = ?`, qrPayload.VenueID).Scan(&venueLevel)
-    if err != nil {
-        log.Printf("Error getting venue level: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to verify venue level"})
-        return
-    }
-
-    // Check if student level matches venue level
-    if studentLevel != venueLevel {
-        log.Printf("Student level %d does not match venue level %d", studentLevel, venueLevel)
-        w.WriteHeader(http.StatusForbidden)
-        json.NewEncoder(w).Encode(map[string]string{
-            "error": fmt.Sprintf("You can only join sessions for your current level (Level %d)", studentLevel),
-        })
-        return
-    }
-
-
-
-	// Verify QR code against database and get QR details
-	err = database.GetDB().QueryRow(`
-        SELECT id, max_capacity, current_usage, is_active, qr_group_id
-        FROM venue_qr_codes 
-        WHERE qr_data = ? AND venue_id = ?`,
-		request.QRData, qrPayload.VenueID).Scan(&qrCapacity.ID, &qrCapacity.MaxCapacity,
-		&qrCapacity.CurrentUsage, &qrCapacity.IsActive, &qrCapacity.QRGroupID)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No active QR code found for venue %s", qrPayload.VenueID)
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or expired QR code"})
-		} else {
-			log.Printf("QR capacity check error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "QR code validation failed"})
-		}
-		return
-	}
 
-	if !qrCapacity.IsActive {
-		log.Printf("QR code is not active")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "QR code is no longer active"})
-		return
-	}
-
-	if qrCapacity.CurrentUsage >= qrCapacity.MaxCapacity {
-		log.Printf("QR code is full: %d/%d", qrCapacity.CurrentUsage, qrCapacity.MaxCapacity)
+	sessionID, err := sessionService.Join(studentID, request.QRData)
+	metrics.Joins.Inc(seatResult(err))
+	var mismatch *services.LevelMismatchError
+	if errors.As(err, &mismatch) {
 		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(map[string]string{"error": "This QR code has reached its capacity limit"})
-		return
-	}
-
-	// Increment QR usage
-	_, err = database.GetDB().Exec(`
-        UPDATE venue_qr_codes 
-        SET current_usage = current_usage + 1 
-        WHERE id = ?`,
-		qrCapacity.ID)
-
-	if err != nil {
-		log.Printf("Failed to update QR usage: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to join session"})
-		return
-	}
-
-	// Find or create session for this specific QR group
-	var sessionID string
-	tx, err := database.GetDB().Begin()
-	if err != nil {
-		log.Printf("Failed to begin transaction: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-	defer tx.Rollback()
-
-	// First clear any old phase tracking for this student
-	_, err = tx.Exec(`
-        DELETE FROM session_phase_tracking 
-        WHERE student_id = ?`,
-		studentID)
-	if err != nil {
-		log.Printf("Failed to clear old phase tracking: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to join session"})
-		return
-	}
-
-	// First check if there's an active session for this QR group
-	err = tx.QueryRow(`
-        SELECT id FROM gd_sessions 
-        WHERE venue_id = ? AND qr_group_id = ? AND status IN ('pending', 'active')
-        ORDER BY created_at DESC LIMIT 1`,
-		qrPayload.VenueID, qrCapacity.QRGroupID).Scan(&sessionID)
-
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Database error finding venue session: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	// If no session exists, create one with the QR group ID
-	if err == sql.ErrNoRows {
-		sessionID = uuid.New().String()
-		_, err = tx.Exec(`
-            INSERT INTO gd_sessions 
-            (id, venue_id, status, start_time, end_time, level, qr_group_id) 
-            VALUES (?, ?, 'active', NOW(), DATE_ADD(NOW(), INTERVAL 1 HOUR), 
-                   (SELECT level FROM venues WHERE id = ?), ?)`,
-			sessionID, qrPayload.VenueID, qrPayload.VenueID, qrCapacity.QRGroupID)
-		if err != nil {
-			log.Printf("Failed to create session: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create session"})
-			return
-		}
-		log.Printf("Created new session %s for venue %s with QR group %s", sessionID, qrPayload.VenueID, qrCapacity.QRGroupID)
-	}
-
-	// Check if student is already in this session
-	var isParticipant bool
-	err = tx.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM session_participants 
-            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
-        )`, sessionID, studentID).Scan(&isParticipant)
-
-	if err != nil {
-		log.Printf("Database error checking participation: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	if !isParticipant {
-		// Add student to session
-		_, err = tx.Exec(`
-            INSERT INTO session_participants 
-            (id, session_id, student_id, is_dummy) 
-            VALUES (UUID(), ?, ?, FALSE)`,
-			sessionID, studentID)
-
-		if err != nil {
-			log.Printf("Failed to add participant: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to join session"})
-			return
-		}
-		log.Printf("Added student %s to session %s as participant", studentID, sessionID)
-	}
-
-	// Add phase tracking (marks QR code scanned)
-	_, err = tx.Exec(`
-        INSERT INTO session_phase_tracking 
-        (session_id, student_id, phase, start_time)
-        VALUES (?, ?, 'prep', NOW())`,
-		sessionID, studentID)
-
-	if err != nil {
-		log.Printf("Failed to update phase tracking: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update session phase"})
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": fmt.Sprintf("You can only join sessions for your current level (Level %d)", mismatch.StudentLevel),
+		})
 		return
 	}
-	log.Printf("Updated phase tracking for student %s in session %s", studentID, sessionID)
-
-	// Update session status to active if not already
-	_, err = tx.Exec(`
-        UPDATE gd_sessions 
-        SET status = 'active' 
-        WHERE id = ? AND status = 'pending'`,
-		sessionID)
 	if err != nil {
-		log.Printf("Failed to activate session: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to activate session"})
+		slog.WarnContext(r.Context(), "Student could not join session", "error", err)
+		writeSeatError(w, err, "Failed to join session")
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to join session"})
-		return
-	}
-
-	log.Printf("Successfully joined session %s for student %s", sessionID, studentID)
+	slog.InfoContext(r.Context(), "Joined session", "session_id", sessionID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":     "joined",
@@ -637,18 +354,9 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
         return true
     }
     
-    // Get IST location
-    loc, err := time.LoadLocation("Asia/Kolkata")
-    if err != nil {
-        loc = time.Local
-    }
+    // Venue timings are written in the configured time zone
+    loc := config.Get().Location
     now := time.Now().In(loc)
-    
-    log.Printf("TIMING DEBUG - Current IST: %v", now)
-    log.Printf("TIMING DEBUG - Session Timing: %s", sessionTiming)
-    log.Printf("TIMING DEBUG - Available Days: %s", availableDays)
-    log.Printf("TIMING DEBUG - Start Time: %s", startTime)
-    log.Printf("TIMING DEBUG - End Time: %s", endTime)
 
     // Parse session timing if available (this takes priority)
     if sessionTiming != "" {
@@ -657,8 +365,6 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
             datePart := strings.TrimSpace(parts[0])
             timeRange := strings.TrimSpace(parts[1])
             
-            log.Printf("TIMING DEBUG - Date Part: %s, Time Range: %s", datePart, timeRange)
-            
             // Parse date in DD/MM/YYYY format
             dateParts := strings.Split(datePart, "/")
             if len(dateParts) == 3 {
@@ -671,10 +377,7 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
                     sessionDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
                     today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
                     
-                    log.Printf("TIMING DEBUG - Session Date: %v, Today: %v", sessionDate, today)
-                    
                     if !sessionDate.Equal(today) {
-                        log.Printf("TIMING DEBUG - Session date %v doesn't match today %v", sessionDate, today)
                         return false
                     }
                     
@@ -684,8 +387,6 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
                         startTimeStr := strings.TrimSpace(timeParts[0])
                         endTimeStr := strings.TrimSpace(timeParts[1])
                         
-                        log.Printf("TIMING DEBUG - Start Time String: %s, End Time String: %s", startTimeStr, endTimeStr)
-                        
                         // Parse start and end times
                         startHour, startMinute, err1 := parse12HourTime(startTimeStr)
                         endHour, endMinute, err2 := parse12HourTime(endTimeStr)
@@ -694,14 +395,10 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
                             // Create time objects for today with parsed hours/minutes
                             sessionStart := time.Date(now.Year(), now.Month(), now.Day(), startHour, startMinute, 0, 0, loc)
                             sessionEnd := time.Date(now.Year(), now.Month(), now.Day(), endHour, endMinute, 0, 0, loc)
-                            
-                            log.Printf("TIMING DEBUG - Session Start: %v, Session End: %v, Now: %v", 
-                                sessionStart, sessionEnd, now)
-                            log.Printf("TIMING DEBUG - Is within time: %v", now.After(sessionStart) && now.Before(sessionEnd))
-                            
                             return now.After(sessionStart) && now.Before(sessionEnd)
                         } else {
-                            log.Printf("TIMING DEBUG - Error parsing times: %v, %v", err1, err2)
+                            slog.Warn("Could not parse venue session timing", "session_timing", sessionTiming,
+                                "start_error", err1, "end_error", err2)
                         }
                     }
                 }
@@ -720,10 +417,6 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
                 break
             }
         }
-        
-        log.Printf("TIMING DEBUG - Current Day: %s, Allowed Days: %v, Day Allowed: %v", 
-            currentDay, days, dayAllowed)
-        
         if !dayAllowed {
             return false
         }
@@ -738,22 +431,15 @@ func isWithinSessionTime(sessionTiming, availableDays, startTime, endTime string
             // Convert to today's date with local timezone
             startToday := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, loc)
             endToday := time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, loc)
-            
-            log.Printf("TIMING DEBUG - Fallback Start: %v, Fallback End: %v, Now: %v", 
-                startToday, endToday, now)
-            log.Printf("TIMING DEBUG - Fallback within time: %v", now.After(startToday) && now.Before(endToday))
-            
             return now.After(startToday) && now.Before(endToday)
         }
     }
 
-    log.Printf("TIMING DEBUG - Defaulting to allowed (no timing restrictions could be validated)")
     return true // Default to allowed if timing validation fails
 }
 
 func parse12HourTime(timeStr string) (int, int, error) {
     timeStr = strings.TrimSpace(timeStr)
-    log.Printf("PARSING 12H TIME: %s", timeStr)
     
     // Handle various time formats
     var timePart, period string
@@ -801,8 +487,6 @@ func parse12HourTime(timeStr string) (int, int, error) {
     if period == "AM" && hour == 12 {
         hour = 0
     }
-    
-    log.Printf("PARSED TIME: %s -> %02d:%02d (24h)", timeStr, hour, minute)
     return hour, minute, nil
 }
 
@@ -848,342 +532,86 @@ func GetVenuesForStudent(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(venues)
 }
 
-func getSessionParticipantCount(sessionID string) (int, error) {
-    var participantCount int
-    err := database.GetDB().QueryRow(`
-        SELECT COUNT(DISTINCT student_id)
-        FROM session_participants 
-        WHERE session_id = ? AND is_dummy = FALSE`,
-        sessionID).Scan(&participantCount)
-    
-    if err != nil {
-        return 0, err
-    }
-    return participantCount, nil
-}
-
 func SubmitSurvey(w http.ResponseWriter, r *http.Request) {
     studentID := r.Context().Value("studentID").(string)
-    log.Printf("SubmitSurvey started for student %s", studentID)
+    slog.InfoContext(r.Context(), "Survey submission started")
 
     var req struct {
         SessionID string                 `json:"session_id"`
         Responses map[int]map[int]string `json:"responses"` // question_number -> rank -> studentID
         IsPartial bool                   `json:"is_partial"`
         IsFinal   bool                   `json:"is_final"`
+        // Only used for partial submits, which are saved as a draft
+        CurrentQuestion *int `json:"current_question"`
     }
 
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("Survey decode error: %v", err)
+        slog.WarnContext(r.Context(), "Survey decode error", "error", err)
         w.WriteHeader(http.StatusBadRequest)
         json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
         return
     }
 
-    tx, err := database.GetDB().Begin()
-    if err != nil {
-        log.Printf("Failed to begin transaction: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+    // Partial answers go to the draft store; only a final submit is scored
+    partial := req.IsPartial && !req.IsFinal
+    receipt, err := surveyService(r.Context()).Submit(services.SurveySubmission{
+        SessionID:       req.SessionID,
+        ResponderID:     studentID,
+        Responses:       req.Responses,
+        Partial:         partial,
+        CurrentQuestion: req.CurrentQuestion,
+    })
+    switch {
+    case err == services.ErrSurveyClosed:
+        slog.WarnContext(r.Context(), "Rejected late survey submission", "session_id", req.SessionID)
+        w.WriteHeader(http.StatusConflict)
+        json.NewEncoder(w).Encode(map[string]string{"error": ErrSurveyClosed.Error()})
         return
-    }
-    defer tx.Rollback()
-
-    // Get session level first
-    var sessionLevel int
-    err = tx.QueryRow("SELECT level FROM gd_sessions WHERE id = ?", req.SessionID).Scan(&sessionLevel)
-    if err != nil {
-        log.Printf("Error getting session level: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+    case err == services.ErrSessionNotFound:
+        w.WriteHeader(http.StatusNotFound)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
         return
-    }
-
-    // Get all active questions for this level with their IDs and weights, ordered by display_order
-    rows, err := tx.Query(`
-        SELECT id, weight 
-        FROM survey_questions 
-        WHERE level = ? AND is_active = TRUE 
-        ORDER BY display_order`,
-        sessionLevel)
-    if err != nil {
-        log.Printf("Error getting questions: %v", err)
+    case err != nil && partial:
+        slog.ErrorContext(r.Context(), "Error saving survey draft", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save draft"})
         return
-    }
-    defer rows.Close()
-
-    // Create a mapping of question number to question ID and weight
-    questionMappings := make(map[int]struct {
-        ID     string
-        Weight float64
-    })
-
-    index := 1
-    for rows.Next() {
-        var questionID string
-        var weight float64
-        if err := rows.Scan(&questionID, &weight); err != nil {
-            continue
-        }
-        questionMappings[index] = struct {
-            ID     string
-            Weight float64
-        }{
-            ID:     questionID,
-            Weight: weight,
-        }
-        log.Printf("Question %d: ID=%s, Weight=%.2f", index, questionID, weight)
-        index++
-    }
-
-    totalQuestions := len(questionMappings)
-    log.Printf("Total questions for level %d: %d", sessionLevel, totalQuestions)
-
-    // Get participant count to determine expected ranks
-    participantCount, err := getSessionParticipantCount(req.SessionID)
-    if err != nil {
-        log.Printf("Error getting participant count: %v, using default 3", err)
-        participantCount = 4 // Default for your example (john, jane, jobe, karl)
-    }
-    
-    // Expected ranks: should rank ALL other participants (excluding self)
-    expectedRanks := participantCount - 1
-    if expectedRanks < 1 {
-        expectedRanks = 1
-    }
-    log.Printf("Session %s has %d participants, expecting %d ranks per question", 
-        req.SessionID, participantCount, expectedRanks)
-
-    // Track incomplete rankings for penalty calculation
-    incompleteRankings := make(map[string]int) // question_id -> missing_ranks_count
-
-    // Process each question response
-    for questionNumber, rankings := range req.Responses {
-        // Get question mapping
-        questionMapping, exists := questionMappings[questionNumber]
-        if !exists {
-            log.Printf("Question mapping not found for question number %d, skipping", questionNumber)
-            continue
-        }
-
-        log.Printf("Processing question %d with ID %s and weight %.2f", questionNumber, questionMapping.ID, questionMapping.Weight)
-
-        // Clear previous responses for this question and responder if any
-        _, err = tx.Exec(`
-            DELETE FROM survey_results 
-            WHERE session_id = ? AND responder_id = ? AND question_id = ?`,
-            req.SessionID, studentID, questionMapping.ID)
-        if err != nil {
-            log.Printf("Error clearing previous responses: %v", err)
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-            return
-        }
-
-        // Check if rankings are complete (should rank ALL other participants)
-        actualRanks := len(rankings)
-        if actualRanks < expectedRanks {
-            missingRanks := expectedRanks - actualRanks
-            incompleteRankings[questionMapping.ID] = missingRanks
-            log.Printf("Student %s has incomplete rankings for question %s: %d/%d ranks", 
-                studentID, questionMapping.ID, actualRanks, expectedRanks)
-        }
-
-        // Check for valid rank assignments (ranks should be 1, 2, 3, etc. without gaps)
-        assignedRanks := make(map[int]bool)
-        for rank := range rankings {
-            assignedRanks[rank] = true
-        }
-
-        // Check if all ranks from 1 to expectedRanks are assigned
-        hasAllRanks := true
-        for i := 1; i <= expectedRanks; i++ {
-            if !assignedRanks[i] {
-                hasAllRanks = false
-                break
-            }
-        }
-
-        if !hasAllRanks {
-            // Additional penalty for not assigning proper rank numbers (e.g., missing rank 1)
-            if incompleteRankings[questionMapping.ID] == 0 {
-                incompleteRankings[questionMapping.ID] = 1
-            }
-            log.Printf("Student %s didn't assign proper rank numbers for question %s", 
-                studentID, questionMapping.ID)
-        }
-
-        // Save new rankings with proper question_id foreign key
-        for rank, rankedStudentID := range rankings {
-            // Get base points from configurable ranking points
-            basePoints, err := getRankingPoints(sessionLevel, rank)
-            if err != nil {
-                log.Printf("Error getting ranking points: %v", err)
-                // Fallback to default calculation if config not found
-                basePoints = 5 - float64(rank) // 1st=4, 2nd=3, 3rd=2
-            }
-
-            // Calculate final score as points × weight
-            finalScore := basePoints * questionMapping.Weight
-
-            log.Printf("Saving: responder %s ranked student %s as rank %d with score %.2f for question %s",
-                studentID, rankedStudentID, rank, finalScore, questionMapping.ID)
-
-            _, err = tx.Exec(`
-                INSERT INTO survey_results 
-                (id, session_id, student_id, responder_id, question_id, ranks, score, weighted_score, is_current_session, is_completed)
-                VALUES (UUID(), ?, ?, ?, ?, ?, ?, ?, 1, 0)`,
-                req.SessionID, rankedStudentID, studentID, questionMapping.ID, rank, finalScore, finalScore)
-            if err != nil {
-                tx.Rollback()
-                log.Printf("Error saving survey response: %v", err)
-                w.WriteHeader(http.StatusInternalServerError)
-                json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save survey response"})
-                return
-            }
-        }
-    }
-
-    // Apply penalties for incomplete rankings (1 point per missing rank)
-    totalPenalty := 0.0
-    for questionID, missingRanks := range incompleteRankings {
-        penaltyPoints := float64(missingRanks) // 1 point per missing rank
-        totalPenalty += penaltyPoints
-        
-        log.Printf("Applying penalty of %.1f points for %d missing ranks in question %s", 
-            penaltyPoints, missingRanks, questionID)
-        
-        // Apply penalty to the responder (student who didn't complete rankings)
-        _, err = tx.Exec(`
-            UPDATE survey_results 
-            SET penalty_points = penalty_points + ?,
-                is_biased = TRUE,
-                penalty_calculated = TRUE
-            WHERE session_id = ? AND responder_id = ? AND question_id = ?`,
-            penaltyPoints, req.SessionID, studentID, questionID)
-        
-        if err != nil {
-            log.Printf("Error applying incomplete ranking penalty: %v", err)
-            // Continue with other penalties instead of failing
-        }
-    }
-
-    if totalPenalty > 0 {
-        log.Printf("Total penalty of %.1f points applied to student %s for incomplete rankings", 
-            totalPenalty, studentID)
-    }
-
-    // Check if ALL questions have been answered by counting responses
-    var answeredQuestionsCount int
-    err = tx.QueryRow(`
-        SELECT COUNT(DISTINCT question_id) 
-        FROM survey_results 
-        WHERE session_id = ? AND responder_id = ?`,
-        req.SessionID, studentID).Scan(&answeredQuestionsCount)
-
-    if err != nil {
-        log.Printf("Error counting answered questions: %v", err)
+    case err != nil:
+        slog.ErrorContext(r.Context(), "Error saving survey response", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save survey response"})
         return
     }
 
-    log.Printf("Student %s has answered %d out of %d questions", studentID, answeredQuestionsCount, totalQuestions)
-
-    // Only mark as completed if ALL questions are answered
-    if answeredQuestionsCount >= totalQuestions {
-        log.Printf("All questions completed for student %s in session %s", studentID, req.SessionID)
-
-        // Mark survey as completed in survey_completion table
-        _, err = tx.Exec(`
-            INSERT INTO survey_completion (session_id, student_id, completed_at)
-            VALUES (?, ?, NOW())
-            ON DUPLICATE KEY UPDATE completed_at = NOW()`,
-            req.SessionID, studentID)
-
-        if err != nil {
-            log.Printf("Error marking survey completion: %v", err)
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to mark survey completion"})
-            return
-        }
-
-        // Update all survey_results records for this student to mark as completed
-        _, err = tx.Exec(`
-            UPDATE survey_results 
-            SET is_completed = 1 
-            WHERE session_id = ? AND responder_id = ?`,
-            req.SessionID, studentID)
-        if err != nil {
-            log.Printf("Error updating survey_results completion status: %v", err)
-            // Don't fail the whole request for this
-        }
-
-        log.Printf("Survey marked as completed for student %s in session %s", studentID, req.SessionID)
-    } else {
-        log.Printf("Survey not yet completed for student %s (%d/%d questions)",
-            studentID, answeredQuestionsCount, totalQuestions)
-    }
-
-    if err := tx.Commit(); err != nil {
-        log.Printf("Error committing transaction: %v", err)
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save survey"})
+    w.Header().Set("Content-Type", "application/json")
+    if receipt.Draft != nil {
+        metrics.SurveySubmissions.Inc("draft")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "status":    "draft_saved",
+            "completed": false,
+            "draft":     receipt.Draft,
+        })
         return
     }
 
-if answeredQuestionsCount >= totalQuestions {
-    log.Printf("All questions completed, calculating averages for session %s", req.SessionID)
-    
-    // Get all question IDs for this session
-    var questionIDs []string
-    rows, err := database.GetDB().Query(`
-        SELECT DISTINCT question_id 
-        FROM survey_results 
-        WHERE session_id = ? AND is_completed = 1`,
-        req.SessionID)
-    if err != nil {
-        log.Printf("Error getting question IDs: %v", err)
-    } else {
-        defer rows.Close()
-        for rows.Next() {
-            var questionID string
-            if err := rows.Scan(&questionID); err != nil {
-                continue
-            }
-            questionIDs = append(questionIDs, questionID)
-        }
-        
-        // Calculate averages for each question FIRST
-        for _, questionID := range questionIDs {
-            if err := calculateQuestionAverages(req.SessionID, questionID); err != nil {
-                log.Printf("Error calculating averages for question %s: %v", questionID, err)
-            }
-        }
-        
-        // THEN calculate penalties
-        if err := calculatePenalties(req.SessionID); err != nil {
-            log.Printf("Error calculating penalties: %v", err)
-        }
-    }
-}
-
-    log.Printf("Successfully processed survey submission for student %s in session %s",
-        studentID, req.SessionID)
-    w.Header().Set("Content-Type", "application/json")
+    metrics.SurveySubmissions.Inc("response")
+    slog.InfoContext(r.Context(), "Survey progress", "questions_answered", receipt.QuestionsAnswered, "total_questions", receipt.TotalQuestions, "session_id", req.SessionID)
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "status":             "success",
-        "completed":          answeredQuestionsCount >= totalQuestions,
-        "questions_answered": answeredQuestionsCount,
-        "total_questions":    totalQuestions,
-        "incomplete_penalty": totalPenalty,
-        "incomplete_questions": len(incompleteRankings),
+        "status":               "success",
+        "completed":            receipt.Completed,
+        "questions_answered":   receipt.QuestionsAnswered,
+        "total_questions":      receipt.TotalQuestions,
+        "incomplete_penalty":   receipt.IncompletePenalty,
+        "incomplete_questions": receipt.IncompleteQuestions,
     })
 }
 
+// UpdateSessionStatus lets a participant move their session into the
+// lobby. Every later status follows from the session timer, the survey
+// deadline or an admin.
 func UpdateSessionStatus(w http.ResponseWriter, r *http.Request) {
+	studentID := r.Context().Value("studentID").(string)
+
 	var req struct {
 		SessionID string `json:"sessionId"`
 		Status    string `json:"status"`
@@ -1195,29 +623,28 @@ func UpdateSessionStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"lobby":     true,
-		"active":    true,
-		"completed": true,
-	}
-	if !validStatuses[req.Status] {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid status"})
+	if services.SessionStatus(req.Status) != services.StatusLobby {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Students can only move a session into the lobby"})
 		return
 	}
 
-	_, err := database.GetDB().Exec(`
-        UPDATE gd_sessions 
-        SET status = ?
-        WHERE id = ?`,
-		req.Status, req.SessionID)
+	var isParticipant bool
+	err := database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM session_participants
+            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
+        )`, req.SessionID, studentID).Scan(&isParticipant)
+	if err != nil || !isParticipant {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized for this session"})
+		return
+	}
 
+	err = ChangeSessionStatus(req.SessionID, Transition{
+		To: services.StatusLobby, ActorID: studentID, ActorRole: ActorStudent})
 	if err != nil {
-		log.Printf("Failed to update session status: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update session status"})
+		WriteTransitionError(w, err, "Failed to update session status")
 		return
 	}
 
@@ -1226,14 +653,20 @@ func UpdateSessionStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func calculatePenalties(sessionID string) error {
+func calculatePenalties(ctx context.Context, sessionID string) error {
     tx, err := database.GetDB().Begin()
     if err != nil {
         return fmt.Errorf("failed to begin transaction: %v", err)
     }
     defer tx.Rollback()
 
-     var questionIDs []string
+    if err := calculatePenaltiesInTransaction(ctx, tx, sessionID); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func calculatePenaltiesInTransaction(ctx context.Context, tx *sql.Tx, sessionID string) error {
     rows, err := tx.Query(`
         SELECT DISTINCT question_id 
         FROM survey_results 
@@ -1249,119 +682,121 @@ func calculatePenalties(sessionID string) error {
         if err := rows.Scan(&questionID); err != nil {
             continue
         }
-        questionIDs = append(questionIDs, questionID)
-        
         // Calculate averages for this question
         if err := calculateQuestionAveragesInTransaction(tx, sessionID, questionID); err != nil {
-            log.Printf("Error calculating averages for question %s: %v", questionID, err)
+            slog.Error("Error calculating averages for question", "question_id", questionID, "error", err)
         }
     }
 
 
-    // Check if penalties already calculated
-    var penaltiesCalculated bool
-    err = tx.QueryRow(`
-        SELECT COUNT(*) > 0 
-        FROM survey_results 
-        WHERE session_id = ? AND penalty_calculated = TRUE
-        LIMIT 1`, sessionID).Scan(&penaltiesCalculated)
-    
+    // Bias penalties are worked out afresh from every completed rating, so
+    // strip those charged last time, leaving missing-rank penalties alone
+    _, err = tx.Exec(`
+        UPDATE survey_results
+        SET penalty_points = GREATEST(0, penalty_points - bias_penalty_points),
+            bias_penalty_points = 0,
+            is_biased = penalty_points > 0,
+            median_score = 0,
+            deviation = 0
+        WHERE session_id = ?`, sessionID)
     if err != nil {
-        return fmt.Errorf("error checking penalties: %v", err)
-    }
-    
-    if penaltiesCalculated {
-        log.Printf("Penalties already calculated for session %s", sessionID)
-        return tx.Commit()
+        return fmt.Errorf("error resetting bias penalties: %v", err)
     }
 
-    // First, calculate MEDIAN scores instead of averages to reduce outlier impact
-    // Get all question IDs for this session
-  
-    // Calculate median score for each student per question
-    for _, questionID := range questionIDs {
-        if err := calculateQuestionMedians(tx, sessionID, questionID); err != nil {
-            log.Printf("Error calculating medians for question %s: %v", questionID, err)
-        }
-    }
-
-    // Now calculate penalties based on deviation from median
     rows, err = tx.Query(`
-        SELECT id, student_id, responder_id, score, median_score, question_id
-        FROM survey_results 
-        WHERE session_id = ? AND is_completed = 1 
-        AND responder_id != student_id
-        AND median_score > 0`,
+        SELECT id, question_id, responder_id, student_id, score
+        FROM survey_results
+        WHERE session_id = ? AND is_completed = 1`,
         sessionID)
     if err != nil {
         return fmt.Errorf("error getting ratings: %v", err)
     }
-    defer rows.Close()
-
-    var processedCount, penaltyCount int
+    var ratings []services.Rating
     for rows.Next() {
-        var id, studentID, responderID, questionID string
-        var score, medianScore float64
-        
-        if err := rows.Scan(&id, &studentID, &responderID, &score, &medianScore, &questionID); err != nil {
-            log.Printf("Error scanning row: %v", err)
-            continue
+        var rating services.Rating
+        if err := rows.Scan(&rating.ID, &rating.QuestionID, &rating.ResponderID, &rating.StudentID, &rating.Score); err != nil {
+            rows.Close()
+            return fmt.Errorf("error scanning rating: %v", err)
         }
-        
-        processedCount++
-        
-        // Calculate deviation from median (more robust to outliers)
-        deviation := math.Abs(score - medianScore)
-        log.Printf("Rating: %s -> %s: score=%.2f, median=%.2f, deviation=%.2f", 
-            responderID, studentID, score, medianScore, deviation)
-        
-        // Apply penalty only for significant deviations (>= 2.0 points)
-        if deviation >= 2.0 {
-            penaltyCount++
-            log.Printf("APPLYING PENALTY: %s rated %s with deviation %.2f from median", 
-                responderID, studentID, deviation)
-            
-            // Apply penalty proportional to deviation
-            penaltyPoints := math.Min(deviation, 3.0) // Cap penalty at 3 points
-            
-            _, err := tx.Exec(`
-                UPDATE survey_results 
-                SET penalty_points = penalty_points + ?,
-                    deviation = ?,
-                    is_biased = TRUE,
-                    penalty_calculated = TRUE
-                WHERE id = ?`,
-                penaltyPoints, deviation, id)
-            if err != nil {
-                return fmt.Errorf("error applying penalty: %v", err)
-            }
-        } else {
-            // Mark as penalty calculated but no penalty - ensure deviation is set
+        ratings = append(ratings, rating)
+    }
+    rows.Close()
+
+    // Deviation is measured from the median rather than the average, as
+    // it is more robust to outliers, at the threshold set by the session's
+    // level rules
+    penaltyThreshold := sessionPenaltyThreshold(tx, sessionID)
+    biases := services.ScoreBias(ratings, penaltyThreshold)
+
+    type rated struct{ questionID, studentID string }
+    medians := map[rated]float64{}
+    for _, b := range biases {
+        medians[rated{b.QuestionID, b.StudentID}] = b.Median
+    }
+    for key, median := range medians {
+        _, err := tx.Exec(`
+            UPDATE survey_results
+            SET median_score = ?
+            WHERE session_id = ? AND question_id = ? AND student_id = ?
+            AND is_completed = 1`,
+            median, sessionID, key.questionID, key.studentID)
+        if err != nil {
+            return fmt.Errorf("error updating median: %v", err)
+        }
+    }
+
+    var penalties []map[string]interface{}
+    for _, b := range biases {
+        slog.Debug("Rating compared with median", "responder_id", b.ResponderID, "student_id", b.StudentID, "score", b.Score, "median_score", b.Median, "deviation", b.Deviation)
+        if b.Penalty == 0 {
             _, err := tx.Exec(`
-                UPDATE survey_results 
-                SET penalty_calculated = TRUE,
-                    deviation = ?
-                WHERE id = ?`,
-                deviation, id)
+                UPDATE survey_results SET deviation = ? WHERE id = ?`,
+                b.Deviation, b.ID)
             if err != nil {
-                return fmt.Errorf("error updating penalty status: %v", err)
+                return fmt.Errorf("error updating deviation: %v", err)
             }
+            continue
         }
+
+        metrics.Penalties.Inc("deviation")
+        slog.Info("Applying deviation penalty", "responder_id", b.ResponderID, "student_id", b.StudentID, "deviation", b.Deviation)
+        _, err := tx.Exec(`
+            UPDATE survey_results 
+            SET penalty_points = penalty_points + ?,
+                bias_penalty_points = ?,
+                deviation = ?,
+                is_biased = TRUE
+            WHERE id = ?`,
+            b.Penalty, b.Penalty, b.Deviation, b.ID)
+        if err != nil {
+            return fmt.Errorf("error applying penalty: %v", err)
+        }
+        penalties = append(penalties, map[string]interface{}{
+            "result_id": b.ID, "student_id": b.StudentID, "responder_id": b.ResponderID,
+            "question_id": b.QuestionID, "deviation": b.Deviation, "penalty_points": b.Penalty,
+        })
     }
 
-    // FIX: Ensure ALL records have deviation calculated, even if median_score is 0
     _, err = tx.Exec(`
-        UPDATE survey_results 
-        SET deviation = 0, penalty_calculated = TRUE
-        WHERE session_id = ? AND deviation IS NULL`,
+        UPDATE survey_results SET penalty_calculated = TRUE
+        WHERE session_id = ? AND is_completed = 1`,
         sessionID)
     if err != nil {
-        log.Printf("Warning: Could not set default deviation values: %v", err)
+        return fmt.Errorf("error marking penalties calculated: %v", err)
     }
 
-    log.Printf("Penalty calculation complete: Processed %d ratings, applied %d penalties", 
-        processedCount, penaltyCount)
-    return tx.Commit()
+    if len(penalties) > 0 {
+        err = auditScoring(ctx, tx, audit.Entry{
+            Action: "score.deviation_penalties", EntityType: "session", EntityID: sessionID,
+            After: map[string]interface{}{"threshold": penaltyThreshold, "penalties": penalties},
+        })
+        if err != nil {
+            return fmt.Errorf("error auditing penalties: %v", err)
+        }
+    }
+
+    slog.Info("Penalty calculation complete", "processed_count", len(biases), "penalty_count", len(penalties))
+    return nil
 }
 
 func calculateQuestionAveragesInTransaction(tx *sql.Tx, sessionID, questionID string) error {
@@ -1423,66 +858,6 @@ func calculateQuestionAveragesInTransaction(tx *sql.Tx, sessionID, questionID st
 }
 
 
-func calculateQuestionMedians(tx *sql.Tx, sessionID, questionID string) error {
-    // Get all scores for this question, excluding self-ratings
-    rows, err := tx.Query(`
-        SELECT student_id, score 
-        FROM survey_results 
-        WHERE session_id = ? AND question_id = ? AND responder_id != student_id
-        AND is_completed = 1 AND score > 0
-        ORDER BY student_id, score`,
-        sessionID, questionID)
-    if err != nil {
-        return err
-    }
-    defer rows.Close()
-
-    // Group scores by student
-    studentScores := make(map[string][]float64)
-    for rows.Next() {
-        var studentID string
-        var score float64
-        if err := rows.Scan(&studentID, &score); err != nil {
-            continue
-        }
-        studentScores[studentID] = append(studentScores[studentID], score)
-    }
-
-    // Calculate median for each student and update database
-    for studentID, scores := range studentScores {
-        if len(scores) == 0 {
-            continue
-        }
-
-        // Sort scores to find median
-        sort.Float64s(scores)
-        var median float64
-        
-        if len(scores)%2 == 0 {
-            // Even number of scores: average of two middle values
-            median = (scores[len(scores)/2-1] + scores[len(scores)/2]) / 2
-        } else {
-            // Odd number of scores: middle value
-            median = scores[len(scores)/2]
-        }
-
-        // Update median score in database
-        _, err := tx.Exec(`
-            UPDATE survey_results 
-            SET median_score = ?
-            WHERE session_id = ? AND question_id = ? AND student_id = ?
-            AND is_completed = 1`,
-            median, sessionID, questionID, studentID)
-        if err != nil {
-            return err
-        }
-    }
-
-    return nil
-}
-
-
-
 func calculateQuestionAverages(sessionID, questionID string) error {
     tx, err := database.GetDB().Begin()
     if err != nil {
@@ -1560,14 +935,14 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         )`, sessionID, studentID).Scan(&isParticipant)
     
     if err != nil {
-        log.Printf("Database error checking participant: %v", err)
+        slog.ErrorContext(r.Context(), "Database error checking participant", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
     }
 
     if !isParticipant {
-        log.Printf("Student %s not authorized for session %s", studentID, sessionID)
+        slog.WarnContext(r.Context(), "Student not authorized for session", "session_id", sessionID)
         w.WriteHeader(http.StatusForbidden)
         json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized to view these results"})
         return
@@ -1587,7 +962,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         sessionID)
     
     if err != nil {
-        log.Printf("Error getting participants: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting participants", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -1614,7 +989,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         }
     }
 
-    log.Printf("Found %d participants for session %s", len(participants), sessionID)
+    slog.DebugContext(r.Context(), "Found participants", "participants_count", len(participants), "session_id", sessionID)
 
     // Create a map to store scores for each student
     studentScores := make(map[string]*struct {
@@ -1642,20 +1017,22 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         }{}
     }
 
-    // Get raw scores and penalties for each student
+    // Get raw scores and penalties for each student; deviations at or over
+    // the session's threshold are bias penalties
+    penaltyThreshold := sessionPenaltyThreshold(database.GetDB(), sessionID)
     rows, err = database.GetDB().Query(`
         SELECT 
             student_id,
             SUM(weighted_score) as total_score,
             SUM(penalty_points) as total_penalty,
-            COUNT(CASE WHEN deviation >= 2.0 AND is_biased THEN 1 END) as biased_questions,
-            COUNT(CASE WHEN deviation < 2.0 AND penalty_points > 0 THEN 1 END) as incomplete_questions
+            COUNT(CASE WHEN deviation >= ? AND is_biased THEN 1 END) as biased_questions,
+            COUNT(CASE WHEN deviation < ? AND penalty_points > 0 THEN 1 END) as incomplete_questions
         FROM survey_results 
         WHERE session_id = ? AND is_completed = 1
-        GROUP BY student_id`, sessionID)
+        GROUP BY student_id`, penaltyThreshold, penaltyThreshold, sessionID)
     
     if err != nil {
-        log.Printf("Error getting survey responses: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting survey responses", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -1668,7 +1045,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         var biasedQuestions, incompleteQuestions int
         
         if err := rows.Scan(&studentID, &totalScore, &totalPenalty, &biasedQuestions, &incompleteQuestions); err != nil {
-            log.Printf("Error scanning survey results: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning survey results", "error", err)
             continue
         }
         
@@ -1681,6 +1058,30 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    // Penalties for questions left unanswered when the survey closed
+    rows, err = database.GetDB().Query(`
+        SELECT student_id, SUM(penalty_points)
+        FROM survey_penalties
+        WHERE session_id = ?
+        GROUP BY student_id`, sessionID)
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error getting missing response penalties", "error", err)
+    } else {
+        defer rows.Close()
+        for rows.Next() {
+            var studentID string
+            var penalty float64
+            if err := rows.Scan(&studentID, &penalty); err != nil {
+                continue
+            }
+            if studentData, exists := studentScores[studentID]; exists {
+                studentData.IncompletePenalty += penalty
+                studentData.TotalPenalty += penalty
+                studentData.FinalScore -= penalty
+            }
+        }
+    }
+
     // Get first place counts
     rows, err = database.GetDB().Query(`
         SELECT student_id, COUNT(*) as first_places
@@ -1689,7 +1090,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         GROUP BY student_id`, sessionID)
     
     if err != nil {
-        log.Printf("Error getting first places: %v", err)
+        slog.ErrorContext(r.Context(), "Error getting first places", "error", err)
     } else {
         defer rows.Close()
         for rows.Next() {
@@ -1704,6 +1105,12 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         }
     }
 
+    speaking, err := GetSpeakingStats(database.GetDB(), sessionID)
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error getting speaking stats", "error", err)
+        speaking = map[string]SpeakingStat{}
+    }
+
     // Prepare results for sorting
     type StudentResult struct {
         ID                  string
@@ -1717,6 +1124,7 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
         FirstPlaces         int
         BiasedQuestions     int
         IncompleteQuestions int
+        Speaking            SpeakingStat
     }
     
     var sortedResults []StudentResult
@@ -1730,10 +1138,11 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
             BiasPenalty:         data.BiasPenalty,
             IncompletePenalty:   data.IncompletePenalty,
             TotalPenalty:        data.TotalPenalty,
-            FinalScore:          data.FinalScore,  // Use the calculated final score
+            FinalScore:          data.FinalScore + speaking[id].Bonus,  // Use the calculated final score
             FirstPlaces:         data.FirstPlaces,
             BiasedQuestions:     data.BiasedQuestions,
             IncompleteQuestions: data.IncompleteQuestions,
+            Speaking:            speaking[id],
         })
     }
 
@@ -1760,10 +1169,13 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
             "first_places":         r.FirstPlaces,
             "biased_questions":     r.BiasedQuestions,
             "incomplete_questions": r.IncompleteQuestions,
+            "speaking_seconds":     r.Speaking.TotalSeconds,
+            "speaking_turns":       r.Speaking.TurnCount,
+            "speaking_bonus":       fmt.Sprintf("%.2f", r.Speaking.Bonus),
         })
     }
 
-    log.Printf("Returning %d results for session %s", len(response), sessionID)
+    slog.DebugContext(r.Context(), "Returning results", "response_count", len(response), "session_id", sessionID)
     
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
@@ -2036,19 +1448,19 @@ func GetResults(w http.ResponseWriter, r *http.Request) {
 // }
 
 
-func updateStudentLevel(sessionID string) error {
-    log.Printf("=== START updateStudentLevel for session %s ===", sessionID)
-    defer log.Printf("=== END updateStudentLevel for session %s ===", sessionID)
+func updateStudentLevel(ctx context.Context, sessionID string) error {
+    slog.Debug("Updating student levels", "session_id", sessionID)
+    defer slog.Debug("Finished updating student levels", "session_id", sessionID)
     
     tx, err := database.GetDB().Begin()
     if err != nil {
-        log.Printf("ERROR: Failed to begin transaction: %v", err)
+        slog.Error("Failed to begin transaction", "error", err)
         return fmt.Errorf("failed to begin transaction: %v", err)
     }
     defer tx.Rollback()
 
     // Get all participants with their current levels and final scores
-    log.Printf("Querying student scores for session %s", sessionID)
+    slog.Debug("Querying student scores", "session_id", sessionID)
     rows, err := tx.Query(`
         SELECT 
             sr.student_id,
@@ -2062,7 +1474,7 @@ func updateStudentLevel(sessionID string) error {
         sessionID)
     
     if err != nil {
-        log.Printf("ERROR: Failed to get student scores: %v", err)
+        slog.Error("Failed to get student scores", "error", err)
         return fmt.Errorf("error getting student scores: %v", err)
     }
     defer rows.Close()
@@ -2077,31 +1489,30 @@ func updateStudentLevel(sessionID string) error {
     for rows.Next() {
         var result StudentResult
         if err := rows.Scan(&result.StudentID, &result.CurrentLevel, &result.FinalScore); err != nil {
-            log.Printf("WARNING: Error scanning row: %v", err)
+            slog.Warn("Error scanning row", "error", err)
             continue
         }
         results = append(results, result)
-        log.Printf("Student %s: current_level=%d, final_score=%.2f", 
-            result.StudentID, result.CurrentLevel, result.FinalScore)
+        slog.Debug("Student score", "student_id", result.StudentID, "current_level", result.CurrentLevel, "final_score", result.FinalScore)
     }
 
     if len(results) == 0 {
-        log.Printf("WARNING: No results found for session %s", sessionID)
+        slog.Warn("No results found for session", "session_id", sessionID)
         return tx.Commit()
     }
 
-    log.Printf("Found %d students with completed surveys", len(results))
+    slog.Debug("Found students with completed surveys", "results_count", len(results))
 
     // Only promote top 3 students who are NOT already at max level (5)
     promotedCount := 0
     for i, result := range results {
         if promotedCount >= 3 {
-            log.Printf("Stopping promotion - already promoted 3 students")
+            slog.Info("Stopping promotion - already promoted 3 students")
             break // Only promote top 3
         }
         
-        log.Printf("Processing rank %d: student %s (current level: %d)", 
-            i+1, result.StudentID, result.CurrentLevel)
+        slog.Debug("Processing rank", "rank", i+1, "student_id", result.StudentID,
+            "current_level", result.CurrentLevel)
         
         // Check if student is eligible for promotion (not at max level)
         if result.CurrentLevel < 5 {
@@ -2110,11 +1521,11 @@ func updateStudentLevel(sessionID string) error {
                 newLevel := result.CurrentLevel + 1
                 if newLevel > 5 {
                     newLevel = 5
-                    log.Printf("Capping level at 5 for student %s", result.StudentID)
+                    slog.Info("Capping level at 5", "student_id", result.StudentID)
                 }
                 
-                log.Printf("Promoting student %s from level %d to level %d (rank %d)", 
-                    result.StudentID, result.CurrentLevel, newLevel, i+1)
+                slog.Info("Promoting student", "student_id", result.StudentID,
+                    "current_level", result.CurrentLevel, "new_level", newLevel, "rank", i+1)
                 
                 execResult, err := tx.Exec(`
                     UPDATE student_users 
@@ -2123,58 +1534,51 @@ func updateStudentLevel(sessionID string) error {
                     newLevel, result.StudentID)
                 
                 if err != nil {
-                    log.Printf("ERROR: Failed to update level for student %s: %v", 
-                        result.StudentID, err)
+                    slog.Error("Failed to update level for student", "student_id", result.StudentID, "error", err)
                     continue
                 }
                 
                 rowsAffected, err := execResult.RowsAffected()
                 if err != nil {
-                    log.Printf("ERROR: Failed to get rows affected for student %s: %v", 
-                        result.StudentID, err)
+                    slog.Error("Failed to get rows affected for student", "student_id", result.StudentID, "error", err)
                     continue
                 }
                 
                 if rowsAffected > 0 {
                     // Track the promotion
-                    err = trackStudentPromotion(sessionID, result.StudentID, i+1, result.CurrentLevel, newLevel)
+                    err = trackStudentPromotion(ctx, sessionID, result.StudentID, i+1, result.CurrentLevel, newLevel)
                     if err != nil {
-                        log.Printf("ERROR: Failed to track promotion for student %s: %v", 
-                            result.StudentID, err)
+                        slog.Error("Failed to track promotion for student", "student_id", result.StudentID, "error", err)
                     }
                     
-                    log.Printf("SUCCESS: Promoted student %s from level %d to %d (rows affected: %d)", 
-                        result.StudentID, result.CurrentLevel, newLevel, rowsAffected)
+                    slog.Info("Promoted student", "student_id", result.StudentID, "current_level", result.CurrentLevel, "new_level", newLevel, "rows_affected", rowsAffected)
                     promotedCount++
                 } else {
-                    log.Printf("WARNING: No rows affected for student %s - may already be at level 5", 
-                        result.StudentID)
+                    slog.Warn("No rows affected promoting student - may already be at level 5", "student_id", result.StudentID)
                 }
             } else {
-                log.Printf("Student %s is rank %d (not in top 3), skipping promotion", 
-                    result.StudentID, i+1)
+                slog.Debug("Student not in top 3, skipping promotion", "student_id", result.StudentID,
+                    "rank", i+1)
             }
         } else {
-            log.Printf("Student %s is already at max level (%d), skipping promotion", 
-                result.StudentID, result.CurrentLevel)
+            slog.Info("Student is already at max level, skipping promotion", "student_id", result.StudentID, "current_level", result.CurrentLevel)
         }
     }
 
-    log.Printf("Total students promoted: %d", promotedCount)
+    slog.Info("Total students promoted", "promoted_count", promotedCount)
     
     if err := tx.Commit(); err != nil {
-        log.Printf("ERROR: Failed to commit transaction: %v", err)
+        slog.Error("Failed to commit transaction", "error", err)
         return err
     }
     
-    log.Printf("Transaction committed successfully")
+    slog.Debug("Transaction committed successfully")
     return nil
 }
 
 
-func trackStudentPromotion(sessionID, studentID string, rank int, oldLevel, newLevel int) error {
-    log.Printf("Tracking promotion: student=%s, session=%s, rank=%d, old=%d, new=%d",
-        studentID, sessionID, rank, oldLevel, newLevel)
+func trackStudentPromotion(ctx context.Context, sessionID, studentID string, rank int, oldLevel, newLevel int) error {
+    slog.Debug("Tracking promotion", "student_id", studentID, "session_id", sessionID, "rank", rank, "old_level", oldLevel, "new_level", newLevel)
     
     _, err := database.GetDB().Exec(`
         INSERT INTO student_promotions 
@@ -2184,10 +1588,15 @@ func trackStudentPromotion(sessionID, studentID string, rank int, oldLevel, newL
         studentID, sessionID, oldLevel, newLevel, rank)
     
     if err != nil {
-        log.Printf("ERROR: Failed to track promotion: %v", err)
+        slog.Error("Failed to track promotion", "error", err)
+        return err
     }
     
-    return err
+    return auditScoring(ctx, database.GetDB(), audit.Entry{
+        Action: "student.promote", EntityType: "student", EntityID: studentID,
+        Before: map[string]int{"level": oldLevel},
+        After:  map[string]interface{}{"level": newLevel, "session_id": sessionID, "rank": rank},
+    })
 }
 
 
@@ -2195,168 +1604,45 @@ func CheckLevelProgression(w http.ResponseWriter, r *http.Request) {
     studentID := r.Context().Value("studentID").(string)
     sessionID := r.URL.Query().Get("session_id")
 
-    log.Printf("=== START CheckLevelProgression for student %s, session %s ===", studentID, sessionID)
-    defer log.Printf("=== END CheckLevelProgression for student %s, session %s ===", studentID, sessionID)
-
     if sessionID == "" {
-        log.Printf("ERROR: session_id is required")
         w.WriteHeader(http.StatusBadRequest)
         json.NewEncoder(w).Encode(map[string]string{"error": "session_id is required"})
         return
     }
 
-    // Get student's current level
-    var currentLevel int
-    err := database.GetDB().QueryRow(`
-        SELECT current_gd_level FROM student_users WHERE id = ?`, studentID).Scan(&currentLevel)
-    
+    progression, err := promotionService(r.Context()).CheckProgression(studentID, sessionID)
     if err != nil {
-        log.Printf("ERROR: Failed to get current level for student %s: %v", studentID, err)
+        slog.ErrorContext(r.Context(), "Failed to check level progression for student", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
     }
 
-    log.Printf("Student %s current level: %d", studentID, currentLevel)
-
-    // Check if student was already promoted in this session
-    var alreadyPromoted bool
-    var newLevel int
-    err = database.GetDB().QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM student_promotions 
-            WHERE session_id = ? AND student_id = ?
-        )`, sessionID, studentID).Scan(&alreadyPromoted)
-
-    if err != nil {
-        log.Printf("WARNING: Error checking if already promoted: %v", err)
-        alreadyPromoted = false
-    }
+    slog.DebugContext(r.Context(), "Level progression", "session_id", sessionID, "promoted", progression.Promoted, "old_level", progression.OldLevel, "new_level", progression.NewLevel, "rank", progression.Rank)
 
-    if alreadyPromoted {
-        log.Printf("Student %s already promoted in session %s, skipping", studentID, sessionID)
-        // Return current level without promoting again
-        w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Content-Type", "application/json")
+    if progression.AlreadyPromoted {
         json.NewEncoder(w).Encode(map[string]interface{}{
-            "promoted":      false,
-            "old_level":     currentLevel,
-            "new_level":     currentLevel,
-            "rank":          0,
-            "session_id":    sessionID,
-            "student_id":    studentID,
+            "promoted":         false,
+            "old_level":        progression.OldLevel,
+            "new_level":        progression.NewLevel,
+            "rank":             0,
+            "session_id":       sessionID,
+            "student_id":       studentID,
             "already_promoted": true,
         })
         return
     }
-
-    // Check if ALL surveys are completed for this session
-    var totalParticipants, completedCount int
-    err = database.GetDB().QueryRow(`
-        SELECT 
-            COUNT(DISTINCT sp.student_id) as total_participants,
-            COUNT(DISTINCT sc.student_id) as completed_count
-        FROM session_participants sp
-        LEFT JOIN survey_completion sc ON sp.session_id = sc.session_id AND sp.student_id = sc.student_id
-        WHERE sp.session_id = ? AND sp.is_dummy = FALSE`,
-        sessionID).Scan(&totalParticipants, &completedCount)
-    
-    if err != nil {
-        log.Printf("WARNING: Error checking survey completion: %v", err)
-        completedCount = 0
-        totalParticipants = 0
-    }
-
-    log.Printf("Survey completion: %d/%d participants completed", completedCount, totalParticipants)
-
-    promoted := false
-    newLevel = currentLevel
-    var rank int
-
-    // Only check ranking if ALL surveys are completed
-    if completedCount >= totalParticipants && totalParticipants > 0 {
-        log.Printf("All surveys completed, checking ranking...")
-        
-        // Check if student was in top 3 and should be promoted
-        err = database.GetDB().QueryRow(`
-            SELECT ranking FROM (
-                SELECT 
-                    student_id,
-                    RANK() OVER (ORDER BY SUM(weighted_score - penalty_points) DESC) as ranking
-                FROM survey_results 
-                WHERE session_id = ? AND is_completed = 1
-                GROUP BY student_id
-            ) as ranks
-            WHERE student_id = ?`,
-            sessionID, studentID).Scan(&rank)
-
-        if err != nil {
-            log.Printf("WARNING: Error getting rank for student %s: %v", studentID, err)
-            rank = 0
-        } else {
-            log.Printf("Student %s rank: %d", studentID, rank)
-        }
-
-        // Only promote if in top 3 AND not already at max level AND all surveys completed
-        if err == nil && rank <= 3 && rank > 0 && currentLevel < 5 {
-            newLevel = currentLevel + 1
-            if newLevel > 5 {
-                newLevel = 5
-            }
-            promoted = true
-            
-            log.Printf("Attempting to promote student %s from level %d to level %d", 
-                studentID, currentLevel, newLevel)
-            
-            // Update level in database
-            result, err := database.GetDB().Exec(`
-                UPDATE student_users 
-                SET current_gd_level = ? 
-                WHERE id = ? AND current_gd_level < 5`,
-                newLevel, studentID)
-            
-            if err != nil {
-                log.Printf("ERROR: Failed to update level for student %s: %v", studentID, err)
-                promoted = false
-                newLevel = currentLevel
-            } else {
-                rowsAffected, _ := result.RowsAffected()
-                promoted = rowsAffected > 0
-                if !promoted {
-                    log.Printf("WARNING: No rows affected for student %s - may already be at level 5", studentID)
-                    newLevel = currentLevel
-                } else {
-                    log.Printf("SUCCESS: Updated student %s level to %d (rows affected: %d)", 
-                        studentID, newLevel, rowsAffected)
-                    
-                    // Track the promotion
-                    err = trackStudentPromotion(sessionID, studentID, rank, currentLevel, newLevel)
-                    if err != nil {
-                        log.Printf("ERROR: Failed to track promotion: %v", err)
-                    }
-                }
-            }
-        } else {
-            log.Printf("Student not eligible for promotion - rank: %d, current level: %d, error: %v", 
-                rank, currentLevel, err)
-        }
-    } else {
-        log.Printf("Surveys not completed yet, skipping promotion check")
-    }
-
-    log.Printf("Final result - promoted: %t, old_level: %d, new_level: %d, rank: %d", 
-        promoted, currentLevel, newLevel, rank)
-
-    w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "promoted":      promoted,
-        "old_level":     currentLevel,
-        "new_level":     newLevel,
-        "rank":          rank,
+        "promoted":      progression.Promoted,
+        "old_level":     progression.OldLevel,
+        "new_level":     progression.NewLevel,
+        "rank":          progression.Rank,
         "session_id":    sessionID,
         "student_id":    studentID,
-        "all_completed": completedCount >= totalParticipants && totalParticipants > 0,
-        "completed":     completedCount,
-        "total":         totalParticipants,
+        "all_completed": progression.AllCompleted,
+        "completed":     progression.Completed,
+        "total":         progression.Total,
     })
 }
 
@@ -2368,9 +1654,9 @@ func CalculateSessionPenalties(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    err := calculatePenalties(sessionID)
+    err := calculatePenalties(r.Context(), sessionID)
     if err != nil {
-        log.Printf("Error calculating penalties: %v", err)
+        slog.ErrorContext(r.Context(), "Error calculating penalties", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to calculate penalties"})
         return
@@ -2418,24 +1704,13 @@ func clearCompletedBookings(sessionID string) error {
             studentID, sessionID)
         
         if err != nil {
-            log.Printf("Error clearing booking for student %s: %v", studentID, err)
+            slog.Error("Error clearing booking for student", "student_id", studentID, "error", err)
             // Continue with other students instead of failing
         } else {
-            log.Printf("Cleared booking for student %s after session completion", studentID)
+            slog.Debug("Cleared booking after session completion", "student_id", studentID)
         }
     }
 
-    // Also mark the session as completed if not already
-    _, err = tx.Exec(`
-        UPDATE gd_sessions 
-        SET status = 'completed', end_time = NOW()
-        WHERE id = ? AND status != 'completed'`,
-        sessionID)
-    
-    if err != nil {
-        log.Printf("Error marking session as completed: %v", err)
-    }
-
     return tx.Commit()
 }
 
@@ -2449,209 +1724,48 @@ func BookVenue(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request"})
 		return
 	}
-	req.StudentID = studentID
-
-	// Start transaction
-	tx, err := database.GetDB().Begin()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-	defer tx.Rollback()
-
-    // Check venue details and get any active session
-    var venueLevel int
-    var activeSessionID sql.NullString
-    var sessionEndTime sql.NullString
-    err = tx.QueryRow(`
-        SELECT v.level,
-               s.id,
-               s.end_time
-        FROM venues v 
-        LEFT JOIN gd_sessions s ON v.id = s.venue_id 
-            AND s.status IN ('pending', 'active', 'lobby')
-            AND s.end_time > NOW()  -- Only get non-expired sessions
-        WHERE v.id = ? AND v.is_active = TRUE
-        ORDER BY s.created_at DESC LIMIT 1`, req.VenueID).Scan(&venueLevel, &activeSessionID, &sessionEndTime)
-
-    if err != nil {
-        if err == sql.ErrNoRows {
-            w.WriteHeader(http.StatusNotFound)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Venue not found"})
-        } else {
-            log.Printf("Error checking venue: %v", err)
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-        }
-        return
-    }
-
-    // If there's an active session, check if it's expired
-    if activeSessionID.Valid && sessionEndTime.Valid {
-        endTime, err := time.Parse("2006-01-02 15:04:05", sessionEndTime.String)
-        if err == nil && (endTime.Before(time.Now()) || endTime.Equal(time.Now())) {
-            w.WriteHeader(http.StatusGone)
-            json.NewEncoder(w).Encode(map[string]string{"error": "This venue session has expired"})
-            return
-        }
-    }
-
-	var studentLevel int
-	err = tx.QueryRow("SELECT current_gd_level FROM student_users WHERE id = ?", studentID).Scan(&studentLevel)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to verify student level"})
-		return
-	}
 
-	// Check if student is trying to book a venue of their level
-	if studentLevel != venueLevel {
+	booking, err := bookingService.Book(studentID, req.VenueID)
+	metrics.Bookings.Inc(seatResult(err))
+	var mismatch *services.LevelMismatchError
+	var active *services.ActiveBookingError
+	switch {
+	case errors.As(err, &mismatch):
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": fmt.Sprintf("You can only book venues for your current level (Level %d)", studentLevel),
+			"error": fmt.Sprintf("You can only book venues for your current level (Level %d)", mismatch.StudentLevel),
 		})
 		return
-	}
-
-	// Check if student already has an active booking for THIS SPECIFIC LEVEL
-	var activeBookingCount int
-	err = tx.QueryRow(`
-        SELECT COUNT(*) 
-        FROM session_participants sp
-        JOIN gd_sessions s ON sp.session_id = s.id
-        JOIN venues v ON s.venue_id = v.id
-        WHERE sp.student_id = ? 
-          AND s.status IN ('pending', 'active', 'lobby')
-          AND s.end_time > NOW()  -- Only count non-expired sessions
-          AND v.level = ?`, // Only check for bookings at the same level
-		studentID, venueLevel).Scan(&activeBookingCount)
-
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	if activeBookingCount > 0 {
+	case errors.As(err, &active):
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": fmt.Sprintf("You already have an active booking for Level %d. Complete or cancel it before booking another venue at this level", venueLevel),
+			"error": fmt.Sprintf("You already have an active booking for Level %d. Complete or cancel it before booking another venue at this level", active.Level),
 		})
 		return
-	}
-
-	// Check venue capacity based on non-expired sessions only
-	var capacity, booked int
-	err = tx.QueryRow(`
-        SELECT v.capacity, 
-               (SELECT COUNT(*) FROM session_participants sp 
-                JOIN gd_sessions s ON sp.session_id = s.id 
-                WHERE s.venue_id = v.id 
-                AND s.status IN ('pending', 'active', 'lobby')
-                AND s.end_time > NOW()) as booked  -- Only count non-expired sessions
-        FROM venues v 
-        WHERE v.id = ? AND v.is_active = TRUE`, req.VenueID).Scan(&capacity, &booked)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Venue not found"})
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		}
-		return
-	}
-
-	// Check capacity
-	if booked >= capacity {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Venue is full"})
-		return
-	}
-
-	// Create a new session if needed or use existing non-expired session
-	var sessionID string
-	if activeSessionID.Valid {
-        sessionID = activeSessionID.String
-        log.Printf("Using existing session %s for venue %s", sessionID, req.VenueID)
-    } else {
-        // Create new session with proper 2-hour duration
-        sessionID = uuid.New().String()
-        _, err = tx.Exec(`
-            INSERT INTO gd_sessions 
-            (id, venue_id, status, start_time, end_time, level) 
-            VALUES (?, ?, 'pending', NOW(), DATE_ADD(NOW(), INTERVAL 2 HOUR), ?)`,
-            sessionID, req.VenueID, venueLevel)
-        if err != nil {
-            log.Printf("Failed to create session: %v", err)
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create session"})
-            return
-        }
-        log.Printf("Created new session %s for venue %s with 2-hour duration", sessionID, req.VenueID)
-    }
-
-	// Check if student is already in this specific session
-	var isAlreadyInSession bool
-	err = tx.QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM session_participants 
-            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
-        )`, sessionID, req.StudentID).Scan(&isAlreadyInSession)
-
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	if isAlreadyInSession {
+	case err == services.ErrAlreadyBooked:
 		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(map[string]string{"error": "You have already booked this venue"})
 		return
-	}
-
-	_, err = tx.Exec(`
-        INSERT INTO session_participants 
-        (id, session_id, student_id, is_dummy) 
-        VALUES (UUID(), ?, ?, FALSE)`,
-		sessionID, req.StudentID)
-
-	if err != nil {
-		log.Printf("Failed to add participant: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Booking failed"})
+	case err != nil && booking.Reserved:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":             "Venue is full. Your priority pass has reserved you a seat in its next session",
+			"priority_reserved": true,
+		})
 		return
-	}
-
-	// Update student's current booking
-	_, err = tx.Exec(`
-        UPDATE student_users 
-        SET current_booking = ? 
-        WHERE id = ?`,
-		sessionID, studentID)
-	
-	if err != nil {
-		log.Printf("Failed to update student booking: %v", err)
-	}
-
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Booking failed"})
+	case err != nil:
+		writeSeatError(w, err, "Booking failed")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":          "booked",
-		"session_id":      sessionID,
-		"venue_id":        req.VenueID,
-		"booked_seats":    booked + 1,
-		"remaining_seats": capacity - (booked + 1),
+		"session_id":      booking.SessionID,
+		"venue_id":        booking.VenueID,
+		"booked_seats":    booking.BookedSeats,
+		"remaining_seats": booking.RemainingSeats,
+		"priority_pass":   booking.UsedPriorityPass,
 	})
 }
 
@@ -2664,11 +1778,8 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get current time in IST
-    loc, err := time.LoadLocation("Asia/Kolkata")
-    if err != nil {
-        loc = time.Local
-    }
+    // Get current time in the venue time zone
+    loc := config.Get().Location
     now := time.Now().In(loc)
 
     // Get all venues with session information
@@ -2685,7 +1796,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
                 FROM session_participants sp 
                 JOIN gd_sessions s ON sp.session_id = s.id 
                 WHERE s.venue_id = v.id 
-                AND s.status IN ('pending', 'active', 'lobby')
+                AND s.status IN `+OpenStatusesSQL+`
                 AND s.end_time > CONVERT_TZ(NOW(), 'SYSTEM', '+05:30')
             ), 0) as booked,
             -- Get the most recent active session's end time
@@ -2693,7 +1804,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
                 SELECT s.end_time 
                 FROM gd_sessions s 
                 WHERE s.venue_id = v.id 
-                AND s.status IN ('pending', 'active', 'lobby')
+                AND s.status IN `+OpenStatusesSQL+`
                 AND s.end_time > CONVERT_TZ(NOW(), 'SYSTEM', '+05:30')
                 ORDER BY s.created_at DESC 
                 LIMIT 1
@@ -2702,7 +1813,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
             EXISTS(
                 SELECT 1 FROM gd_sessions s 
                 WHERE s.venue_id = v.id 
-                AND s.status IN ('pending', 'active', 'lobby')
+                AND s.status IN `+OpenStatusesSQL+`
                 AND s.end_time > CONVERT_TZ(NOW(), 'SYSTEM', '+05:30')
             ) as has_active_session
         FROM venues v 
@@ -2711,7 +1822,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
         ORDER BY v.name`, level)
 
     if err != nil {
-        log.Printf("Database error: %v", err)
+        slog.ErrorContext(r.Context(), "Database error", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -2736,7 +1847,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
         if err := rows.Scan(&venue.ID, &venue.Name, &venue.Capacity,
             &venue.SessionTiming, &venue.TableDetails, &venue.Level, 
             &venue.Booked, &venue.SessionEndTime, &venue.HasActiveSession); err != nil {
-            log.Printf("Error scanning venue row: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning venue row", "error", err)
             continue
         }
 
@@ -2804,8 +1915,7 @@ func GetAvailableSessions(w http.ResponseWriter, r *http.Request) {
                             // Check if session has ended (is in the past)
                             isExpired = sessionEndTime.Before(now)
                             
-                            log.Printf("Venue %s: SessionEnd=%v, Now=%v, Expired=%v", 
-                                venue.Name, sessionEndTime, now, isExpired)
+                            slog.DebugContext(r.Context(), "Venue expiry check", "venue", venue.Name, "session_end_time", sessionEndTime, "now", now, "is_expired", isExpired)
                         }
                     }
                 }
@@ -2851,12 +1961,12 @@ func CheckBooking(w http.ResponseWriter, r *http.Request) {
             JOIN gd_sessions s ON sp.session_id = s.id
             WHERE sp.student_id = ? 
             AND s.venue_id = ? 
-            AND s.status IN ('pending', 'active', 'lobby')
+            AND s.status IN `+OpenStatusesSQL+`
             AND s.end_time > CONVERT_TZ(NOW(), 'SYSTEM', '+05:30')  -- Only non-expired sessions
         )`, studentID, venueID).Scan(&isBooked)
 
     if err != nil {
-        log.Printf("Database error checking booking: %v", err)
+        slog.ErrorContext(r.Context(), "Database error checking booking", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -2889,7 +1999,7 @@ func CancelBooking(w http.ResponseWriter, r *http.Request) {
 	result, err := tx.Exec(`
         DELETE sp FROM session_participants sp
         JOIN gd_sessions s ON sp.session_id = s.id
-        WHERE sp.student_id = ? AND s.venue_id = ? AND s.status IN ('pending', 'lobby')`,
+        WHERE sp.student_id = ? AND s.venue_id = ? AND s.status IN ('scheduled', 'lobby')`,
 		studentID, req.VenueID)
 
 	if err != nil {
@@ -2915,7 +2025,7 @@ func CancelBooking(w http.ResponseWriter, r *http.Request) {
 		studentID, req.VenueID)
 	
 	if err != nil {
-		log.Printf("Failed to clear student booking: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to clear student booking", "error", err)
 		// Don't fail the cancellation if this fails
 	}
 
@@ -2942,7 +2052,7 @@ func GetUserBookings(w http.ResponseWriter, r *http.Request) {
         FROM session_participants sp
         JOIN gd_sessions s ON sp.session_id = s.id
         JOIN venues v ON s.venue_id = v.id
-        WHERE sp.student_id = ? AND s.status IN ('pending', 'active', 'lobby')
+        WHERE sp.student_id = ? AND s.status IN `+OpenStatusesSQL+`
         ORDER BY s.start_time DESC`,
 		studentID)
 
@@ -3012,7 +2122,7 @@ func GetSessionParticipants(w http.ResponseWriter, r *http.Request) {
         sessionID)
 
     if err != nil {
-        log.Printf("Database error fetching participants: %v", err)
+        slog.ErrorContext(r.Context(), "Database error fetching participants", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]interface{}{
             "error": "Database error",
@@ -3031,7 +2141,7 @@ func GetSessionParticipants(w http.ResponseWriter, r *http.Request) {
             ProfileImage string
         }
         if err := rows.Scan(&participant.ID, &participant.FullName, &participant.Department, &participant.ProfileImage); err != nil {
-            log.Printf("Error scanning participant: %v", err)
+            slog.ErrorContext(r.Context(), "Error scanning participant", "error", err)
             continue
         }
 
@@ -3055,7 +2165,7 @@ func GetSessionParticipants(w http.ResponseWriter, r *http.Request) {
         })
     }
 
-    log.Printf("Returning %d participants for session %s", len(participants), sessionID)
+    slog.DebugContext(r.Context(), "Returning participants", "participants_count", len(participants), "session_id", sessionID)
 
     json.NewEncoder(w).Encode(map[string]interface{}{
         "data": participants,
@@ -3085,7 +2195,7 @@ func CheckSurveyCompletion(w http.ResponseWriter, r *http.Request) {
 		sessionID).Scan(&totalParticipants)
 
 	if err != nil {
-		log.Printf("Error getting QR-scanned participants: %v", err)
+		slog.ErrorContext(r.Context(), "Error getting QR-scanned participants", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
@@ -3101,14 +2211,13 @@ func CheckSurveyCompletion(w http.ResponseWriter, r *http.Request) {
 		sessionID).Scan(&completedCount)
 
 	if err != nil {
-		log.Printf("Error getting completed count: %v", err)
+		slog.ErrorContext(r.Context(), "Error getting completed count", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
 	}
 
-	log.Printf("Completion check - Session: %s, QR-Scanned: %d, Completed: %d",
-		sessionID, totalParticipants, completedCount)
+	slog.DebugContext(r.Context(), "Completion check", "session_id", sessionID, "qr_scanned", totalParticipants, "completed_count", completedCount)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3164,7 +2273,7 @@ func clearSessionReadyStatus(sessionID string) error {
         return fmt.Errorf("error clearing session ready status: %v", err)
     }
 
-    log.Printf("Cleared session_ready_status for session %s", sessionID)
+    slog.Debug("Cleared session ready status", "session_id", sessionID)
     
     return tx.Commit()
 }
@@ -3196,19 +2305,7 @@ func GetSessionTimer(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    var (
-        phase          string
-        startTimeStr   string
-        duration       int
-        isActive       bool
-    )
-
-    err = database.GetDB().QueryRow(`
-        SELECT phase, start_time, duration_seconds, is_active
-        FROM session_timers 
-        WHERE session_id = ? AND is_active = TRUE
-    `, sessionID).Scan(&phase, &startTimeStr, &duration, &isActive)
-
+    timer, err := GetTimerState(sessionID)
     if err != nil {
         if err == sql.ErrNoRows {
             w.WriteHeader(http.StatusNotFound)
@@ -3220,24 +2317,14 @@ func GetSessionTimer(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Parse start time
-    startTime, err := time.Parse("2006-01-02 15:04:05", startTimeStr)
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Invalid timer data"})
-        return
-    }
-
-    // Calculate remaining time
-    elapsed := time.Since(startTime).Seconds()
-    remaining := math.Max(0, float64(duration)-elapsed)
-
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "phase": phase,
-        "remaining_seconds": int(remaining),
-        "total_seconds": duration,
-        "is_active": isActive,
+        "phase": timer.Phase,
+        "remaining_seconds": timer.RemainingSeconds,
+        "total_seconds": timer.TotalSeconds,
+        "is_active": timer.IsActive,
+        "is_paused": timer.IsPaused,
+        "extension_seconds": timer.ExtensionSeconds,
     })
 }
 
@@ -3268,95 +2355,99 @@ func CompleteSessionPhase(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get current phase
-    var currentPhase string
-    err = database.GetDB().QueryRow(`
-        SELECT phase FROM session_timers WHERE session_id = ? AND is_active = TRUE
-    `, req.SessionID).Scan(&currentPhase)
-
+    nextPhase, nextDuration, err := AdvanceSessionPhase(req.SessionID, studentID, ActorStudent)
     if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "No active timer found"})
+        if err == sql.ErrNoRows {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": "No active timer found"})
+            return
+        }
+        WriteTransitionError(w, err, "Failed to start next phase")
         return
     }
 
-    // Get session agenda from admin configuration
-    var agendaJSON []byte
-    err = database.GetDB().QueryRow(`
-        SELECT agenda FROM gd_sessions WHERE id = ?
-    `, req.SessionID).Scan(&agendaJSON)
+    w.Header().Set("Content-Type", "application/json")
+    if nextPhase == "" {
+        json.NewEncoder(w).Encode(map[string]string{"status": "session_completed"})
+        return
+    }
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "status": "phase_completed",
+        "next_phase": nextPhase,
+        "duration_seconds": nextDuration,
+    })
+}
 
+// AdvanceSessionPhase moves the session timer on to the next phase of the
+// agenda, and the session's status with it. It returns an empty phase once
+// the last phase has finished and sql.ErrNoRows when the session has no
+// active timer.
+func AdvanceSessionPhase(sessionID, actorID, actorRole string) (string, int, error) {
+    tx, err := database.GetDB().Begin()
     if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get session configuration"})
-        return
+        return "", 0, err
     }
+    defer tx.Rollback()
 
-    // Parse agenda with defaults
-    var agenda struct {
-        PrepTime   int `json:"prep_time"`
-        Discussion int `json:"discussion"`
-        Survey     int `json:"survey"`
+    // Get current phase
+    var phaseIndex int
+    err = tx.QueryRow(`
+        SELECT phase_index FROM session_timers WHERE session_id = ? AND is_active = TRUE
+        FOR UPDATE
+    `, sessionID).Scan(&phaseIndex)
+    if err != nil {
+        return "", 0, err
     }
-    
-    // Set default values
-    agenda.PrepTime = 1
-    agenda.Discussion = 1
-    agenda.Survey = 1
 
-    if len(agendaJSON) > 0 {
-        if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
-            log.Printf("Error parsing agenda JSON: %v", err)
-            // Use defaults if parsing fails
-        }
+    agenda, err := ResolveSessionAgenda(tx, sessionID)
+    if err != nil {
+        return "", 0, fmt.Errorf("failed to get session configuration: %v", err)
     }
 
-    // Determine next phase and duration based on admin config
-    var nextPhase string
-    var nextDuration int
-    switch currentPhase {
-    case "prep":
-        nextPhase = "discussion"
-        nextDuration = agenda.Discussion * 60 // Convert minutes to seconds
-    case "discussion":
-        nextPhase = "survey"
-        nextDuration = agenda.Survey * 60 // Convert minutes to seconds
-    default:
+    nextIndex := phaseIndex + 1
+    if nextIndex >= len(agenda.Phases) {
         // End of session
-        _, err = database.GetDB().Exec(`
-            UPDATE session_timers SET is_active = FALSE WHERE session_id = ?
-        `, req.SessionID)
-        
+        _, err = tx.Exec(`
+            UPDATE session_timers SET is_active = FALSE, paused_at = NULL WHERE session_id = ?
+        `, sessionID)
         if err != nil {
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to end session"})
-            return
+            return "", 0, fmt.Errorf("failed to end session: %v", err)
+        }
+        // An agenda without a survey phase still ends in the survey
+        err = TransitionSession(tx, sessionID, Transition{
+            To: services.StatusSurveying, ActorID: actorID, ActorRole: actorRole})
+        if err != nil {
+            return "", 0, err
         }
+        return "", 0, tx.Commit()
+    }
+    next := agenda.Phases[nextIndex]
 
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{"status": "session_completed"})
-        return
+    err = TransitionSession(tx, sessionID, Transition{
+        To: services.PhaseStatus(next.Type), Phase: next.Type, ActorID: actorID, ActorRole: actorRole})
+    if err != nil {
+        return "", 0, err
     }
 
     // Start next phase timer
-    _, err = database.GetDB().Exec(`
+    _, err = tx.Exec(`
         UPDATE session_timers 
-        SET phase = ?, start_time = NOW(), duration_seconds = ?, updated_at = NOW()
+        SET phase = ?, phase_index = ?, start_time = NOW(), duration_seconds = ?, paused_at = NULL,
+            accumulated_seconds = 0, extension_seconds = 0, updated_at = NOW()
         WHERE session_id = ?
-    `, nextPhase, nextDuration, req.SessionID)
-
+    `, next.Type, nextIndex, next.DurationSeconds, sessionID)
     if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start next phase"})
-        return
+        return "", 0, err
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]interface{}{
-        "status": "phase_completed",
-        "next_phase": nextPhase,
-        "duration_seconds": nextDuration,
-    })
+    // The survey deadline is the survey phase's end
+    if next.Type == "survey" {
+        if err := OpenSurveyWindow(tx, sessionID, next.DurationSeconds); err != nil {
+            return "", 0, fmt.Errorf("failed to open survey window: %v", err)
+        }
+    }
+
+    return next.Type, next.DurationSeconds, tx.Commit()
 }
 
 // Also update StartSessionTimer to use admin config for initial prep time
@@ -3389,39 +2480,15 @@ func StartSessionTimer(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get session agenda from admin configuration for prep time
-    var agendaJSON []byte
-    err = database.GetDB().QueryRow(`
-        SELECT agenda FROM gd_sessions WHERE id = ?
-    `, req.SessionID).Scan(&agendaJSON)
-
-    if err != nil {
+    agenda, err := ResolveSessionAgenda(database.GetDB(), req.SessionID)
+    if err != nil || len(agenda.Phases) == 0 {
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get session configuration"})
         return
     }
 
-    // Parse agenda with defaults
-    var agenda struct {
-        PrepTime   int `json:"prep_time"`
-        Discussion int `json:"discussion"`
-        Survey     int `json:"survey"`
-    }
-    
-    // Set default values
-    agenda.PrepTime = 2
-    agenda.Discussion = 20
-    agenda.Survey = 5
-
-    if len(agendaJSON) > 0 {
-        if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
-            log.Printf("Error parsing agenda JSON: %v", err)
-            // Use defaults if parsing fails
-        }
-    }
-
-    // Use admin-configured prep time for initial phase
-    prepDuration := agenda.PrepTime * 60 // Convert minutes to seconds
+    // The session starts on the first phase of its agenda
+    firstPhase := agenda.Phases[0]
 
     tx, err := database.GetDB().Begin()
     if err != nil {
@@ -3431,17 +2498,43 @@ func StartSessionTimer(w http.ResponseWriter, r *http.Request) {
     }
     defer tx.Rollback()
 
+    // Pin the template so activating another one for the level mid-session
+    // doesn't change this session's phases
+    if agenda.TemplateID != "" {
+        _, err = tx.Exec(`
+            UPDATE gd_sessions SET agenda_template_id = ?
+            WHERE id = ? AND agenda_template_id IS NULL
+        `, agenda.TemplateID, req.SessionID)
+        if err != nil {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start timer"})
+            return
+        }
+    }
+
+    err = TransitionSession(tx, req.SessionID, Transition{
+        To: services.PhaseStatus(firstPhase.Type), Phase: firstPhase.Type,
+        ActorID: studentID, ActorRole: ActorStudent})
+    if err != nil {
+        WriteTransitionError(w, err, "Failed to start timer")
+        return
+    }
+
     // Insert or update timer with admin-configured duration
     _, err = tx.Exec(`
-        INSERT INTO session_timers (session_id, phase, start_time, duration_seconds, is_active)
-        VALUES (?, ?, NOW(), ?, TRUE)
+        INSERT INTO session_timers (session_id, phase, phase_index, start_time, duration_seconds, is_active)
+        VALUES (?, ?, 0, NOW(), ?, TRUE)
         ON DUPLICATE KEY UPDATE 
             phase = VALUES(phase),
+            phase_index = VALUES(phase_index),
             start_time = VALUES(start_time),
             duration_seconds = VALUES(duration_seconds),
             is_active = VALUES(is_active),
+            paused_at = NULL,
+            accumulated_seconds = 0,
+            extension_seconds = 0,
             updated_at = NOW()
-    `, req.SessionID, "prep", prepDuration)
+    `, req.SessionID, firstPhase.Type, firstPhase.DurationSeconds)
 
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
@@ -3449,12 +2542,20 @@ func StartSessionTimer(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if firstPhase.Type == "survey" {
+        if err := OpenSurveyWindow(tx, req.SessionID, firstPhase.DurationSeconds); err != nil {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start timer"})
+            return
+        }
+    }
+
     // Update phase tracking
     _, err = tx.Exec(`
         INSERT INTO session_phase_tracking (session_id, student_id, phase, start_time)
         VALUES (?, ?, ?, NOW())
         ON DUPLICATE KEY UPDATE phase = VALUES(phase), start_time = VALUES(start_time)
-    `, req.SessionID, studentID, "prep")
+    `, req.SessionID, studentID, firstPhase.Type)
 
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
@@ -3471,8 +2572,8 @@ func StartSessionTimer(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
         "status": "timer_started",
-        "phase": "prep",
-        "duration_seconds": prepDuration,
+        "phase": firstPhase.Type,
+        "duration_seconds": firstPhase.DurationSeconds,
     })
 }
 
@@ -3500,42 +2601,26 @@ func GetSessionConfiguration(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Get session agenda from admin configuration
-    var agendaJSON []byte
-    err = database.GetDB().QueryRow(`
-        SELECT agenda FROM gd_sessions WHERE id = ?
-    `, sessionID).Scan(&agendaJSON)
-
+    agenda, err := ResolveSessionAgenda(database.GetDB(), sessionID)
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get session configuration"})
         return
     }
 
-    // Parse agenda with defaults
-    var agenda struct {
-        PrepTime   int `json:"prep_time"`
-        Discussion int `json:"discussion"`
-        Survey     int `json:"survey"`
-    }
-    
-    // Set default values
-    agenda.PrepTime = 2
-    agenda.Discussion = 20
-    agenda.Survey = 5
-
-    if len(agendaJSON) > 0 {
-        if err := json.Unmarshal(agendaJSON, &agenda); err != nil {
-            log.Printf("Error parsing agenda JSON: %v", err)
-            // Use defaults if parsing fails
-        }
+    // Minute totals per phase type, kept for clients built on the fixed flow
+    minutes := map[string]int{}
+    for _, phase := range agenda.Phases {
+        minutes[phase.Type] += phase.DurationSeconds / 60
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]interface{}{
-        "prep_time": agenda.PrepTime,
-        "discussion_time": agenda.Discussion,
-        "survey_time": agenda.Survey,
+        "prep_time": minutes["prep"],
+        "discussion_time": minutes["discussion"],
+        "survey_time": minutes["survey"],
+        "template_id": agenda.TemplateID,
+        "phases": agenda.Phases,
     })
 }
 
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"gd/database"
+	"gd/metrics"
+	"gd/services"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// OpenStatusesSQL is the SQL list of statuses whose sessions still hold
+	// their participants' seats.
+	OpenStatusesSQL = "('scheduled', 'lobby', 'in_progress')"
+
+	// LiveStatusesSQL is the SQL list of statuses of sessions under way.
+	LiveStatusesSQL = "('lobby', 'in_progress', 'surveying')"
+)
+
+func init() {
+	metrics.NewGaugeFunc("gd_active_sessions", "Sessions under way, by agenda phase.",
+		[]string{"phase"}, activeSessionSamples)
+}
+
+// activeSessionSamples counts live sessions by phase. Lobby and surveying
+// sessions report their status as the phase.
+func activeSessionSamples() ([]metrics.Sample, error) {
+	db := database.GetDB()
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.Query(`
+        SELECT IF(status = 'in_progress', COALESCE(phase, 'unknown'), status), COUNT(*)
+        FROM gd_sessions
+        WHERE status IN ` + LiveStatusesSQL + `
+        GROUP BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []metrics.Sample
+	for rows.Next() {
+		var phase string
+		var count float64
+		if err := rows.Scan(&phase, &count); err != nil {
+			return nil, err
+		}
+		samples = append(samples, metrics.Sample{LabelValues: []string{phase}, Value: count})
+	}
+	return samples, rows.Err()
+}
+
+// Who changed a session's status, as recorded in session_status_history.
+const (
+	ActorStudent = "student"
+	ActorAdmin   = "admin"
+	ActorSystem  = "system"
+)
+
+// StatusChange is one entry in a session's status history.
+type StatusChange struct {
+	ID         int64   `json:"id"`
+	SessionID  string  `json:"session_id"`
+	FromStatus *string `json:"from_status"`
+	ToStatus   string  `json:"to_status"`
+	Phase      *string `json:"phase"`
+	ActorID    *string `json:"actor_id"`
+	ActorRole  string  `json:"actor_role"`
+	Reason     *string `json:"reason"`
+	CreatedAt  string  `json:"created_at"`
+}
+
+// Transition is a requested status change.
+type Transition struct {
+	To services.SessionStatus
+	// Phase is the agenda phase for in_progress and ignored otherwise.
+	Phase     string
+	ActorID   string
+	ActorRole string
+	Reason    string
+}
+
+// TransitionSession is the one place a session's status changes. It locks
+// the session, checks the move against the lifecycle and records it in
+// session_status_history. Moving a session to the status and phase it is
+// already in does nothing, so every client may report the same change.
+// It returns sql.ErrNoRows if the session does not exist, and a
+// *services.TransitionError if the lifecycle forbids the move.
+func TransitionSession(exec dbExecutor, sessionID string, t Transition) error {
+	if t.To != services.StatusInProgress {
+		t.Phase = ""
+	}
+
+	var from services.SessionStatus
+	var fromPhase string
+	err := exec.QueryRow(`
+        SELECT status, COALESCE(phase, '') FROM gd_sessions WHERE id = ? FOR UPDATE`,
+		sessionID).Scan(&from, &fromPhase)
+	if err != nil {
+		return err
+	}
+	if from == t.To && fromPhase == t.Phase {
+		return nil
+	}
+	if err := services.ValidateTransition(from, fromPhase, t.To, t.Phase); err != nil {
+		return err
+	}
+
+	_, err = exec.Exec(`
+        UPDATE gd_sessions SET status = ?, phase = NULLIF(?, '') WHERE id = ?`,
+		t.To, t.Phase, sessionID)
+	if err != nil {
+		return err
+	}
+	return RecordStatusChange(exec, sessionID, string(from), t)
+}
+
+// ChangeSessionStatus runs TransitionSession in a transaction of its own.
+func ChangeSessionStatus(sessionID string, t Transition) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TransitionSession(tx, sessionID, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordStatusChange appends to the session's status history. Callers
+// creating a session record its first status with from empty; every later
+// change goes through TransitionSession.
+func RecordStatusChange(exec dbExecutor, sessionID, from string, t Transition) error {
+	_, err := exec.Exec(`
+        INSERT INTO session_status_history
+        (session_id, from_status, to_status, phase, actor_id, actor_role, reason)
+        VALUES (?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''))`,
+		sessionID, from, t.To, t.Phase, t.ActorID, t.ActorRole, t.Reason)
+	return err
+}
+
+// StatusHistoryFilter narrows a status history query. Empty fields match
+// everything.
+type StatusHistoryFilter struct {
+	SessionID string
+	ToStatus  string
+	ActorRole string
+	Limit     int
+}
+
+// SessionStatusHistory returns status changes matching filter. A single
+// session's history comes oldest first; otherwise the latest changes come
+// first.
+func SessionStatusHistory(exec dbExecutor, filter StatusHistoryFilter) ([]StatusChange, error) {
+	query := `
+        SELECT id, session_id, from_status, to_status, phase, actor_id, actor_role, reason, created_at
+        FROM session_status_history
+        WHERE 1 = 1`
+	var args []interface{}
+	for _, f := range []struct{ column, value string }{
+		{"session_id", filter.SessionID},
+		{"to_status", filter.ToStatus},
+		{"actor_role", filter.ActorRole},
+	} {
+		if f.value != "" {
+			query += " AND " + f.column + " = ?"
+			args = append(args, f.value)
+		}
+	}
+	if filter.SessionID != "" {
+		query += " ORDER BY id"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		var from, phase, actorID, reason sql.NullString
+		if err := rows.Scan(&c.ID, &c.SessionID, &from, &c.ToStatus, &phase, &actorID,
+			&c.ActorRole, &reason, &c.CreatedAt); err != nil {
+			return history, err
+		}
+		c.FromStatus = nullableString(from)
+		c.Phase = nullableString(phase)
+		c.ActorID = nullableString(actorID)
+		c.Reason = nullableString(reason)
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+func nullableString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// WriteTransitionError maps a failed status change to an HTTP response.
+func WriteTransitionError(w http.ResponseWriter, err error, fallback string) {
+	var transitionErr *services.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": transitionErr.Error()})
+	case err == sql.ErrNoRows:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+	default:
+		slog.Error("Session status change failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fallback})
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"gd/database"
+	"gd/services"
+	"log/slog"
+	"time"
+)
+
+// CloseAbandonedSessions marks sessions abandoned when they are still
+// waiting or running more than grace after their end time, or stuck in a
+// survey that was never opened. Their timers stop and their participants'
+// bookings are released. It returns the number of sessions closed.
+func CloseAbandonedSessions(ctx context.Context, grace time.Duration) (int64, error) {
+	rows, err := database.GetDB().QueryContext(ctx, `
+        SELECT id FROM gd_sessions
+        WHERE (status IN `+OpenStatusesSQL+` OR (status = 'surveying' AND survey_end_time IS NULL))
+          AND end_time < DATE_SUB(NOW(), INTERVAL ? SECOND)`, int(grace.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			sessionIDs = append(sessionIDs, id)
+		}
+	}
+	rows.Close()
+
+	var closed int64
+	for _, id := range sessionIDs {
+		if err := abandonSession(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "Error abandoning session", "session_id", id, "error", err)
+			continue
+		}
+		closed++
+	}
+	return closed, nil
+}
+
+func abandonSession(ctx context.Context, sessionID string) error {
+	tx, err := database.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = TransitionSession(tx, sessionID, Transition{
+		To: services.StatusAbandoned, ActorRole: ActorSystem, Reason: "session overran its end time"})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE session_timers SET is_active = FALSE, paused_at = NULL
+        WHERE session_id = ?`, sessionID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE student_users SET current_booking = NULL
+        WHERE current_booking = ?`, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeStaleReadyStatus deletes ready flags for sessions that have left the
+// lobby, and any flag untouched for longer than maxAge.
+func PurgeStaleReadyStatus(ctx context.Context, maxAge time.Duration) (int64, error) {
+	result, err := database.GetDB().ExecContext(ctx, `
+        DELETE rs FROM session_ready_status rs
+        JOIN gd_sessions s ON rs.session_id = s.id
+        WHERE s.status NOT IN ('scheduled', 'lobby')
+           OR rs.updated_at < DATE_SUB(NOW(), INTERVAL ? SECOND)`,
+		int(maxAge.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
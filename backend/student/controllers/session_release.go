@@ -0,0 +1,71 @@
+package controllers
+
+// SessionParticipants returns the students in a session, in the order
+// they joined. Dummy participants are left out.
+func SessionParticipants(exec dbExecutor, sessionID string) ([]string, error) {
+	rows, err := exec.Query(`
+        SELECT student_id FROM session_participants
+        WHERE session_id = ? AND is_dummy = FALSE
+        ORDER BY joined_at, student_id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var students []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return students, err
+		}
+		students = append(students, id)
+	}
+	return students, rows.Err()
+}
+
+// ReleaseSeat takes a student out of a session: their seat, ready flag,
+// phase tracking and group assignment go, their current booking is cleared
+// if it points at the session, and the use they took of the session's QR
+// code is given back.
+func ReleaseSeat(exec dbExecutor, sessionID, studentID string) error {
+	result, err := exec.Exec(`
+        DELETE FROM session_participants
+        WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE`,
+		sessionID, studentID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		if _, err := exec.Exec(`
+            UPDATE venue_qr_codes q
+            JOIN gd_sessions s ON s.venue_id = q.venue_id AND s.qr_group_id = q.qr_group_id
+            SET q.current_usage = q.current_usage - 1
+            WHERE s.id = ? AND q.current_usage > 0`, sessionID); err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		"DELETE FROM session_participants WHERE session_id = ? AND student_id = ?",
+		"DELETE FROM session_ready_status WHERE session_id = ? AND student_id = ?",
+		"DELETE FROM session_phase_tracking WHERE session_id = ? AND student_id = ?",
+		"DELETE FROM session_group_assignments WHERE session_id = ? AND student_id = ?",
+		"UPDATE student_users SET current_booking = NULL WHERE current_booking = ? AND id = ?",
+	} {
+		if _, err := exec.Exec(query, sessionID, studentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StopSessionActivity stops a session's timers and clears its ready flags.
+func StopSessionActivity(exec dbExecutor, sessionID string) error {
+	if _, err := exec.Exec(`
+        UPDATE session_timers SET is_active = FALSE, paused_at = NULL
+        WHERE session_id = ?`, sessionID); err != nil {
+		return err
+	}
+	_, err := exec.Exec("DELETE FROM session_ready_status WHERE session_id = ?", sessionID)
+	return err
+}
@@ -0,0 +1,530 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gd/database"
+	"log/slog"
+	"math"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// speakingPhases are the agenda phases in which the floor can be given.
+var speakingPhases = map[string]bool{
+	"opening_statements": true,
+	"discussion":         true,
+	"rebuttal":           true,
+	"summary":            true,
+}
+
+// SpeakingStat is one participant's speaking record for a session.
+type SpeakingStat struct {
+	StudentID    string  `json:"student_id"`
+	TotalSeconds int     `json:"total_seconds"`
+	TurnCount    int     `json:"turn_count"`
+	Bonus        float64 `json:"bonus"`
+}
+
+type speakingSettings struct {
+	Mode       string
+	CapSeconds int
+}
+
+func RaiseHand(w http.ResponseWriter, r *http.Request) {
+	handleSpeakingAction(w, r, func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error) {
+		var queued bool
+		err := tx.QueryRow(`
+            SELECT EXISTS(
+                SELECT 1 FROM speaking_queue
+                WHERE session_id = ? AND student_id = ? AND status IN ('waiting', 'speaking')
+            )`, sessionID, studentID).Scan(&queued)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if queued {
+			return http.StatusConflict, fmt.Errorf("already in the speaking queue")
+		}
+
+		_, err = tx.Exec(`
+            INSERT INTO speaking_queue (id, session_id, student_id, status)
+            VALUES (?, ?, ?, 'waiting')`,
+			uuid.New().String(), sessionID, studentID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	})
+}
+
+func WithdrawHand(w http.ResponseWriter, r *http.Request) {
+	handleSpeakingAction(w, r, func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error) {
+		result, err := tx.Exec(`
+            UPDATE speaking_queue SET status = 'withdrawn'
+            WHERE session_id = ? AND student_id = ? AND status = 'waiting'`,
+			sessionID, studentID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return http.StatusNotFound, fmt.Errorf("not waiting in the speaking queue")
+		}
+		return 0, nil
+	})
+}
+
+// GrantFloor ends the current turn and hands the floor to student_id, or to
+// the next person in the queue when none is given. Moderator only.
+func GrantFloor(w http.ResponseWriter, r *http.Request) {
+	handleSpeakingAction(w, r, func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error) {
+		if !isSessionModerator(sessionID, studentID) {
+			return http.StatusForbidden, fmt.Errorf("only the session moderator can grant the floor")
+		}
+		if err := endCurrentTurn(tx, sessionID, "moderator"); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		granted, err := grantFloor(tx, sessionID, req.StudentID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !granted {
+			return http.StatusNotFound, fmt.Errorf("no matching student is waiting to speak")
+		}
+		return 0, nil
+	})
+}
+
+// StopSpeaking ends the current turn. The speaker or the moderator may call it.
+func StopSpeaking(w http.ResponseWriter, r *http.Request) {
+	handleSpeakingAction(w, r, func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error) {
+		var speakerID string
+		err := tx.QueryRow(`
+            SELECT student_id FROM speaking_turns
+            WHERE session_id = ? AND ended_at IS NULL`, sessionID).Scan(&speakerID)
+		if err == sql.ErrNoRows {
+			return http.StatusNotFound, fmt.Errorf("nobody has the floor")
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		endedBy := "self"
+		if speakerID != studentID {
+			if !isSessionModerator(sessionID, studentID) {
+				return http.StatusForbidden, fmt.Errorf("only the speaker or the moderator can end this turn")
+			}
+			endedBy = "moderator"
+		}
+		if err := endCurrentTurn(tx, sessionID, endedBy); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	})
+}
+
+// UpdateSpeakingSettings switches between moderated and auto mode and sets
+// the per-turn cap. Moderator only.
+func UpdateSpeakingSettings(w http.ResponseWriter, r *http.Request) {
+	handleSpeakingAction(w, r, func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error) {
+		if !isSessionModerator(sessionID, studentID) {
+			return http.StatusForbidden, fmt.Errorf("only the session moderator can change speaking settings")
+		}
+		if req.Mode != "moderated" && req.Mode != "auto" {
+			return http.StatusBadRequest, fmt.Errorf("mode must be moderated or auto")
+		}
+		if req.TurnCapSeconds < 0 {
+			return http.StatusBadRequest, fmt.Errorf("turn_cap_seconds cannot be negative")
+		}
+
+		var capSeconds interface{}
+		if req.TurnCapSeconds > 0 {
+			capSeconds = req.TurnCapSeconds
+		}
+		_, err := tx.Exec(`
+            UPDATE gd_sessions SET speaking_mode = ?, speaking_turn_cap_seconds = ?
+            WHERE id = ?`, req.Mode, capSeconds, sessionID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	})
+}
+
+func GetSpeakingQueue(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	studentID := r.Context().Value("studentID").(string)
+
+	if !isActiveParticipant(sessionID, studentID) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized for this session"})
+		return
+	}
+
+	// Apply any turn cap that has run out since the last request
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := lockSpeakingSession(tx, sessionID); err != nil {
+		slog.ErrorContext(r.Context(), "Error locking session for speaking queue", "session_id", sessionID, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	state, err := speakingState(tx, sessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading speaking queue", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+type speakingRequest struct {
+	SessionID      string `json:"session_id"`
+	StudentID      string `json:"student_id"`
+	Mode           string `json:"mode"`
+	TurnCapSeconds int    `json:"turn_cap_seconds"`
+}
+
+// handleSpeakingAction runs action in a transaction holding the session row
+// lock, after expiring capped turns, and replies with the updated queue.
+func handleSpeakingAction(w http.ResponseWriter, r *http.Request, action func(tx *sql.Tx, sessionID, studentID string, req speakingRequest) (int, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req speakingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	studentID := r.Context().Value("studentID").(string)
+	if !isActiveParticipant(req.SessionID, studentID) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized for this session"})
+		return
+	}
+
+	timer, err := GetTimerState(req.SessionID)
+	if err != nil || !speakingPhases[timer.Phase] {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "The floor is only open during speaking phases"})
+		return
+	}
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := lockSpeakingSession(tx, req.SessionID); err != nil {
+		slog.ErrorContext(r.Context(), "Error locking session for speaking queue", "session_id", req.SessionID, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	if status, err := action(tx, req.SessionID, studentID, req); err != nil {
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Error updating speaking queue for session", "session_id", req.SessionID, "error", err)
+			err = fmt.Errorf("failed to update speaking queue")
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// In auto mode the floor passes to the next in line as soon as it is free
+	var mode string
+	if err := tx.QueryRow("SELECT speaking_mode FROM gd_sessions WHERE id = ?", req.SessionID).Scan(&mode); err != nil {
+		slog.ErrorContext(r.Context(), "Error reading speaking mode", "error", err)
+	}
+	if mode == "auto" {
+		if _, err := grantFloorIfFree(tx, req.SessionID); err != nil {
+			slog.ErrorContext(r.Context(), "Error granting floor automatically", "error", err)
+		}
+	}
+
+	state, err := speakingState(tx, req.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading speaking queue", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func isActiveParticipant(sessionID, studentID string) bool {
+	var isParticipant bool
+	err := database.GetDB().QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM session_participants
+            WHERE session_id = ? AND student_id = ? AND is_dummy = FALSE
+        )`, sessionID, studentID).Scan(&isParticipant)
+	return err == nil && isParticipant
+}
+
+// lockSpeakingSession serialises queue changes for a session and ends any
+// turn that has run past the cap. Caps are enforced lazily here rather than
+// by a background timer.
+func lockSpeakingSession(tx *sql.Tx, sessionID string) (speakingSettings, error) {
+	var settings speakingSettings
+	var capSeconds sql.NullInt64
+	err := tx.QueryRow(`
+        SELECT speaking_mode, speaking_turn_cap_seconds
+        FROM gd_sessions WHERE id = ?
+        FOR UPDATE`, sessionID).Scan(&settings.Mode, &capSeconds)
+	if err != nil {
+		return settings, err
+	}
+	settings.CapSeconds = int(capSeconds.Int64)
+
+	_, err = tx.Exec(`
+        UPDATE speaking_turns
+        SET ended_at = DATE_ADD(started_at, INTERVAL cap_seconds SECOND),
+            duration_seconds = cap_seconds,
+            ended_by = 'cap'
+        WHERE session_id = ? AND ended_at IS NULL AND cap_seconds IS NOT NULL
+          AND DATE_ADD(started_at, INTERVAL cap_seconds SECOND) <= NOW()`, sessionID)
+	if err != nil {
+		return settings, err
+	}
+	if err := syncSpeakingRecords(tx, sessionID); err != nil {
+		return settings, err
+	}
+
+	if settings.Mode == "auto" {
+		if _, err := grantFloorIfFree(tx, sessionID); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+func endCurrentTurn(tx *sql.Tx, sessionID, endedBy string) error {
+	_, err := tx.Exec(`
+        UPDATE speaking_turns
+        SET ended_at = NOW(),
+            duration_seconds = TIMESTAMPDIFF(SECOND, started_at, NOW()),
+            ended_by = ?
+        WHERE session_id = ? AND ended_at IS NULL`, endedBy, sessionID)
+	if err != nil {
+		return err
+	}
+	return syncSpeakingRecords(tx, sessionID)
+}
+
+// syncSpeakingRecords closes queue entries whose turn has ended and
+// rebuilds speaking_stats from the finished turns.
+func syncSpeakingRecords(tx *sql.Tx, sessionID string) error {
+	_, err := tx.Exec(`
+        UPDATE speaking_queue q
+        SET q.status = 'done'
+        WHERE q.session_id = ? AND q.status = 'speaking'
+          AND NOT EXISTS (
+              SELECT 1 FROM speaking_turns t
+              WHERE t.session_id = q.session_id AND t.student_id = q.student_id AND t.ended_at IS NULL
+          )`, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO speaking_stats (session_id, student_id, total_seconds, turn_count)
+        SELECT session_id, student_id, SUM(duration_seconds), COUNT(*)
+        FROM speaking_turns
+        WHERE session_id = ? AND ended_at IS NOT NULL
+        GROUP BY session_id, student_id
+        ON DUPLICATE KEY UPDATE
+            total_seconds = VALUES(total_seconds),
+            turn_count = VALUES(turn_count)`, sessionID)
+	return err
+}
+
+func grantFloorIfFree(tx *sql.Tx, sessionID string) (bool, error) {
+	var speaking bool
+	err := tx.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM speaking_turns WHERE session_id = ? AND ended_at IS NULL
+        )`, sessionID).Scan(&speaking)
+	if err != nil || speaking {
+		return false, err
+	}
+	return grantFloor(tx, sessionID, "")
+}
+
+// grantFloor starts a turn for studentID, or for the longest-waiting person
+// in the queue when studentID is empty. The floor must already be free.
+func grantFloor(tx *sql.Tx, sessionID, studentID string) (bool, error) {
+	var queueID string
+	query := `
+        SELECT id, student_id FROM speaking_queue
+        WHERE session_id = ? AND status = 'waiting'`
+	args := []interface{}{sessionID}
+	if studentID != "" {
+		query += " AND student_id = ?"
+		args = append(args, studentID)
+	}
+	query += " ORDER BY raised_at LIMIT 1"
+
+	err := tx.QueryRow(query, args...).Scan(&queueID, &studentID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+        UPDATE speaking_queue SET status = 'speaking', granted_at = NOW()
+        WHERE id = ?`, queueID); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO speaking_turns (id, session_id, student_id, queue_id, started_at, cap_seconds)
+        SELECT ?, ?, ?, ?, NOW(), speaking_turn_cap_seconds
+        FROM gd_sessions WHERE id = ?`,
+		uuid.New().String(), sessionID, studentID, queueID, sessionID)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func speakingState(exec dbExecutor, sessionID string) (map[string]interface{}, error) {
+	var mode string
+	var capSeconds sql.NullInt64
+	if err := exec.QueryRow(`
+        SELECT speaking_mode, speaking_turn_cap_seconds
+        FROM gd_sessions WHERE id = ?`, sessionID).Scan(&mode, &capSeconds); err != nil {
+		return nil, err
+	}
+
+	var current map[string]interface{}
+	var speakerID string
+	var elapsed int
+	var turnCap sql.NullInt64
+	err := exec.QueryRow(`
+        SELECT student_id, TIMESTAMPDIFF(SECOND, started_at, NOW()), cap_seconds
+        FROM speaking_turns
+        WHERE session_id = ? AND ended_at IS NULL`, sessionID).Scan(&speakerID, &elapsed, &turnCap)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		current = map[string]interface{}{
+			"student_id":      speakerID,
+			"elapsed_seconds": elapsed,
+		}
+		if turnCap.Valid {
+			current["remaining_seconds"] = int(math.Max(0, float64(int(turnCap.Int64)-elapsed)))
+		}
+	}
+
+	rows, err := exec.Query(`
+        SELECT student_id, raised_at
+        FROM speaking_queue
+        WHERE session_id = ? AND status = 'waiting'
+        ORDER BY raised_at`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queue := []map[string]interface{}{}
+	for rows.Next() {
+		var studentID, raisedAt string
+		if err := rows.Scan(&studentID, &raisedAt); err != nil {
+			return nil, err
+		}
+		queue = append(queue, map[string]interface{}{
+			"student_id": studentID,
+			"raised_at":  raisedAt,
+			"position":   len(queue) + 1,
+		})
+	}
+
+	stats, err := GetSpeakingStats(exec, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"session_id":       sessionID,
+		"mode":             mode,
+		"turn_cap_seconds": capSeconds.Int64,
+		"current_speaker":  current,
+		"queue":            queue,
+		"stats":            stats,
+	}, nil
+}
+
+// GetSpeakingStats returns finished-turn speaking totals for a session keyed
+// by student, with the scoring bonus configured for the session's level.
+func GetSpeakingStats(exec dbExecutor, sessionID string) (map[string]SpeakingStat, error) {
+	var pointsPerMinute, pointsCap float64
+	err := exec.QueryRow(`
+        SELECT COALESCE(rpc.speaking_points_per_minute, 0), COALESCE(rpc.speaking_points_cap, 0)
+        FROM gd_sessions s
+        LEFT JOIN ranking_points_config rpc ON rpc.level = s.level AND rpc.is_active = TRUE
+        WHERE s.id = ?`, sessionID).Scan(&pointsPerMinute, &pointsCap)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := exec.Query(`
+        SELECT student_id, total_seconds, turn_count
+        FROM speaking_stats
+        WHERE session_id = ?`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]SpeakingStat)
+	for rows.Next() {
+		var s SpeakingStat
+		if err := rows.Scan(&s.StudentID, &s.TotalSeconds, &s.TurnCount); err != nil {
+			return nil, err
+		}
+		if pointsPerMinute > 0 {
+			s.Bonus = float64(s.TotalSeconds) / 60 * pointsPerMinute
+			if pointsCap > 0 && s.Bonus > pointsCap {
+				s.Bonus = pointsCap
+			}
+		}
+		stats[s.StudentID] = s
+	}
+	return stats, rows.Err()
+}
@@ -6,13 +6,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"gd/config"
 	"gd/database"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 )
 
+// StartSurveyTimer opens the session's survey window for the length of the
+// survey phase in its agenda. The deadline is kept on the server; calling
+// it again does not restart the clock.
 func StartSurveyTimer(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session_id")
 	if sessionID == "" {
@@ -21,45 +25,58 @@ func StartSurveyTimer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set timer for 30 seconds per question * number of questions
-	_, err := database.GetDB().Exec(`
-		UPDATE gd_sessions 
-		SET survey_end_time = DATE_ADD(NOW(), INTERVAL 30 SECOND)
-		WHERE id = ?`, sessionID)
-	
+	seconds, err := surveyPhaseSeconds(database.GetDB(), sessionID)
+	if err == nil {
+		err = OpenSurveyWindow(database.GetDB(), sessionID, seconds)
+	}
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Error opening survey window for session", "session_id", sessionID, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start timer"})
 		return
 	}
 
+	window, err := GetSurveyWindow(database.GetDB(), sessionID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "timer_started"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "timer_started",
+		"remaining_seconds": window.RemainingSeconds,
+		"total_seconds":     window.TotalSeconds,
+	})
 }
 
 func CheckSurveyTimeout(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session_id")
-	// studentID := r.Context().Value("studentID").(string)
 
-	var surveyEndTime time.Time
-	err := database.GetDB().QueryRow(`
-		SELECT survey_end_time FROM gd_sessions WHERE id = ?`, sessionID).Scan(&surveyEndTime)
-	
+	window, err := GetSurveyWindow(database.GetDB(), sessionID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
 	}
 
-	remaining := time.Until(surveyEndTime).Seconds()
-	if remaining < 0 {
-		remaining = 0
+	// Don't wait for the watcher if a client notices the deadline first
+	if window.IsClosed && !window.IsFinalized {
+		if _, err := FinalizeSurvey(r.Context(), sessionID); err != nil {
+			slog.ErrorContext(r.Context(), "Error finalising survey for session", "session_id", sessionID, "error", err)
+		} else {
+			window.IsFinalized = true
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"remaining_seconds": remaining,
-		"is_timed_out":      remaining <= 0,
+		"remaining_seconds": window.RemainingSeconds,
+		"total_seconds":     window.TotalSeconds,
+		"is_started":        window.IsStarted,
+		"is_timed_out":      window.IsClosed,
+		"is_finalized":      window.IsFinalized,
 	})
 }
 
@@ -93,7 +110,8 @@ func ApplySurveyPenalties(w http.ResponseWriter, r *http.Request) {
 		SET sr.penalty_points = CASE
 			WHEN sr.score < (averages.avg_score - 2) THEN 1
 			ELSE 0
-		END
+		END,
+		sr.bias_penalty_points = 0
 		WHERE sr.session_id = ?`, sessionID, sessionID)
 	
 	if err != nil {
@@ -134,6 +152,9 @@ func ApplySurveyPenalties(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "penalties_applied"})
 }
 
+// StartQuestionTimer gives a question an equal share of the survey window,
+// ending no later than the window itself. Starting the first question opens
+// the window if nothing has yet.
 func StartQuestionTimer(w http.ResponseWriter, r *http.Request) {
     var req struct {
         SessionID  string `json:"session_id"`
@@ -145,12 +166,48 @@ func StartQuestionTimer(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Always set exactly 30 seconds for each question
-    _, err := database.GetDB().Exec(`
+    db := database.GetDB()
+    seconds, err := surveyPhaseSeconds(db, req.SessionID)
+    if err == nil {
+        err = OpenSurveyWindow(db, req.SessionID, seconds)
+    }
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error opening survey window for session", "session_id", req.SessionID, "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start timer"})
+        return
+    }
+
+    window, err := GetSurveyWindow(db, req.SessionID)
+    if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+        return
+    }
+    if window.IsClosed {
+        w.WriteHeader(http.StatusConflict)
+        json.NewEncoder(w).Encode(map[string]string{"error": ErrSurveyClosed.Error()})
+        return
+    }
+
+    questions, err := SessionQuestions(db, req.SessionID)
+    if err != nil {
+        slog.ErrorContext(r.Context(), "Error loading survey questions", "error", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to start timer"})
+        return
+    }
+    perQuestion := window.TotalSeconds
+    if len(questions) > 0 {
+        perQuestion = window.TotalSeconds / len(questions)
+    }
+
+    _, err = db.Exec(`
         INSERT INTO question_timers (session_id, question_id, end_time)
-        VALUES (?, ?, DATE_ADD(NOW(), INTERVAL 30 SECOND))
-        ON DUPLICATE KEY UPDATE end_time = DATE_ADD(NOW(), INTERVAL 30 SECOND)`,
-        req.SessionID, req.QuestionID)
+        SELECT ?, ?, LEAST(DATE_ADD(NOW(), INTERVAL ? SECOND), survey_end_time)
+        FROM gd_sessions WHERE id = ?
+        ON DUPLICATE KEY UPDATE end_time = VALUES(end_time)`,
+        req.SessionID, req.QuestionID, perQuestion, req.SessionID)
     
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
@@ -159,7 +216,10 @@ func StartQuestionTimer(w http.ResponseWriter, r *http.Request) {
     }
 
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "timer_started"})
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "status":           "timer_started",
+        "duration_seconds": min(perQuestion, window.RemainingSeconds),
+    })
 }
 
 func CheckQuestionTimeout(w http.ResponseWriter, r *http.Request) {
@@ -168,7 +228,7 @@ func CheckQuestionTimeout(w http.ResponseWriter, r *http.Request) {
 
     // Default response if any error occurs
     defaultResponse := map[string]interface{}{
-        "remaining_seconds": 30,
+        "remaining_seconds": int(config.Get().QuestionTimer.Seconds()),
         "is_timed_out":      false,
     }
 
@@ -202,11 +262,14 @@ func CheckQuestionTimeout(w http.ResponseWriter, r *http.Request) {
     })
 }
 
+// ApplyQuestionPenalty acknowledges a client reporting that a question was
+// skipped or timed out. It charges nothing: unanswered questions are
+// penalised on the server when the survey window closes (see
+// FinalizeSurvey), so a question is charged once, by one rule.
 func ApplyQuestionPenalty(w http.ResponseWriter, r *http.Request) {
     var req struct {
         SessionID  string `json:"session_id"`
         QuestionID int    `json:"question_id"`
-        StudentID  string `json:"student_id"`
     }
     
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -215,41 +278,8 @@ func ApplyQuestionPenalty(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Check if penalty already exists
-    var exists bool
-    err := database.GetDB().QueryRow(`
-        SELECT EXISTS(
-            SELECT 1 FROM survey_penalties 
-            WHERE session_id = ? AND question_id = ? AND student_id = ?
-        )`, req.SessionID, req.QuestionID, req.StudentID).Scan(&exists)
-    
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-        return
-    }
-
-    if exists {
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(map[string]string{"status": "penalty_already_applied"})
-        return
-    }
-
-    // Apply penalty (0.5 points per question timeout)
-    _, err = database.GetDB().Exec(`
-        INSERT INTO survey_penalties 
-        (id, session_id, student_id, question_id, penalty_points)
-        VALUES (UUID(), ?, ?, ?, 0.5)`,
-        req.SessionID, req.StudentID, req.QuestionID)
-    
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to apply penalty"})
-        return
-    }
-
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "penalty_applied"})
+    json.NewEncoder(w).Encode(map[string]string{"status": "penalty_deferred"})
 }
 
 func HandleSurveyTimeout(sessionID string, studentID string, questionID int) error {
@@ -292,13 +322,31 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
     sessionID := r.URL.Query().Get("session_id")
     studentID := r.URL.Query().Get("student_id")
     
-    fmt.Printf("GetSurveyQuestions called with level=%s, sessionID=%s, studentID=%s\n", levelStr, sessionID, studentID)
+    slog.DebugContext(r.Context(), "Fetching survey questions", "level", levelStr, "session_id", sessionID, "student_id", studentID)
     
     level, err := strconv.Atoi(levelStr)
     if err != nil || level < 1 {
         level = 1
     }
 
+    // Sessions are scored against their question snapshot, so serve that
+    if sessionID != "" {
+        snapshot, err := SessionQuestions(database.GetDB(), sessionID)
+        if err != nil {
+            slog.ErrorContext(r.Context(), "Error loading session questions", "error", err)
+        }
+        if len(snapshot) > 0 {
+            questions := sessionQuestionMaps(snapshot)
+            seed := sessionID
+            if studentID != "" {
+                seed = sessionID + "-" + studentID
+            }
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(shuffleQuestionsWithSeed(questions, seed))
+            return
+        }
+    }
+
     // Get questions for the specified level - FIXED SQL QUERY
     rows, err := database.GetDB().Query(`
         SELECT id, question_text, weight 
@@ -307,7 +355,7 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
         ORDER BY created_at`, level)
     
     if err != nil {
-        fmt.Printf("Database error: %v\n", err)
+        slog.ErrorContext(r.Context(), "Error fetching survey questions", "error", err)
         w.WriteHeader(http.StatusInternalServerError)
         json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
         return
@@ -322,7 +370,7 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
             Weight float64
         }
         if err := rows.Scan(&question.ID, &question.Text, &question.Weight); err != nil {
-            fmt.Printf("Row scanning error: %v\n", err)
+            slog.ErrorContext(r.Context(), "Error scanning survey question", "error", err)
             continue
         }
         questions = append(questions, map[string]interface{}{
@@ -333,11 +381,11 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
     }
 
     // Check if we got any questions
-    fmt.Printf("Found %d questions for level %d in database\n", len(questions), level)
+    slog.DebugContext(r.Context(), "Found survey questions", "count", len(questions), "level", level)
     
     // If no questions found for this level, try to get default level 1 questions
     if len(questions) == 0 && level != 1 {
-        fmt.Printf("No questions found for level %d, trying level 1\n", level)
+        slog.DebugContext(r.Context(), "No survey questions for level, trying level 1", "level", level)
         rows, err := database.GetDB().Query(`
             SELECT id, question_text, weight 
             FROM survey_questions
@@ -365,7 +413,7 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
     
     // If still no questions found, return default questions
     if len(questions) == 0 {
-        fmt.Println("No questions found in database, using fallback questions")
+        slog.DebugContext(r.Context(), "No survey questions in database, using fallback questions")
         questions = []map[string]interface{}{
             {"id": "q1", "text": "Clarity of arguments", "weight": 1.0},
             {"id": "q2", "text": "Contribution to discussion", "weight": 1.0},
@@ -376,13 +424,13 @@ func GetSurveyQuestions(w http.ResponseWriter, r *http.Request) {
     // Shuffle questions based on both session ID AND student ID for unique ordering per student
     if sessionID != "" && studentID != "" {
         uniqueSeed := sessionID + "-" + studentID
-        fmt.Printf("Shuffling with unique seed: %s\n", uniqueSeed)
+        slog.DebugContext(r.Context(), "Shuffling survey questions", "seed", uniqueSeed)
         questions = shuffleQuestionsWithSeed(questions, uniqueSeed)
     } else if sessionID != "" {
-        fmt.Printf("Shuffling with session seed: %s\n", sessionID)
+        slog.DebugContext(r.Context(), "Shuffling survey questions", "seed", sessionID)
         questions = shuffleQuestionsWithSeed(questions, sessionID)
     } else {
-        fmt.Println("No seed provided for shuffling")
+        slog.DebugContext(r.Context(), "No seed provided for shuffling survey questions")
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -465,41 +513,6 @@ func CalculateFinalResults(sessionID string) (map[string]float64, error) {
     return results, nil
 }
 
-func getRankingPoints(level, rank int) (float64, error) {
-    var points float64
-    
-    err := database.GetDB().QueryRow(`
-        SELECT 
-            CASE ?
-                WHEN 1 THEN first_place_points
-                WHEN 2 THEN second_place_points  
-                WHEN 3 THEN third_place_points
-                ELSE 0
-            END as points
-        FROM ranking_points_config 
-        WHERE level = ? AND is_active = TRUE`,
-        rank, level).Scan(&points)
-    
-    if err != nil {
-        if err == sql.ErrNoRows {
-            // Return default values if no config found
-            switch rank {
-            case 1:
-                return 4.0, nil
-            case 2:
-                return 3.0, nil
-            case 3:
-                return 2.0, nil
-            default:
-                return 0.0, nil
-            }
-        }
-        return 0.0, fmt.Errorf("error getting ranking points: %v", err)
-    }
-    
-    return points, nil
-}
-
 func shuffleQuestionsWithSeed(questions []map[string]interface{}, seed string) []map[string]interface{} {
     // Convert seed to a numeric value
     seedValue := 0
@@ -553,7 +566,7 @@ func checkAndUpdateStudentLevel(studentID string, sessionID string) error {
             return fmt.Errorf("error promoting student: %v", err)
         }
         
-        log.Printf("Student %s promoted to next level (rank %d)", studentID, rank)
+        slog.Info("Student promoted to next level", "student_id", studentID, "rank", rank)
     }
 
     return nil
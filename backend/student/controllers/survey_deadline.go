@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gd/audit"
+	"gd/database"
+	"gd/metrics"
+	"gd/services"
+	"log/slog"
+)
+
+// ErrSurveyClosed is returned for survey writes after the session's survey
+// window has closed.
+var ErrSurveyClosed = services.ErrSurveyClosed
+
+const (
+	// surveyGraceSeconds absorbs network latency for answers sent just
+	// before the deadline.
+	surveyGraceSeconds = 5
+
+	// defaultSurveySeconds is used when a session's agenda has no survey phase.
+	defaultSurveySeconds = 5 * 60
+)
+
+// surveyClosedSQL is true for a session (aliased s) whose survey window has
+// passed its deadline plus grace, or has already been finalised. A survey
+// phase whose timer is paused stays open until it is resumed.
+var surveyClosedSQL = fmt.Sprintf(`(s.survey_finalized_at IS NOT NULL OR (
+        s.survey_end_time IS NOT NULL
+        AND NOW() > DATE_ADD(s.survey_end_time, INTERVAL %d SECOND)
+        AND NOT EXISTS (
+            SELECT 1 FROM session_timers t
+            WHERE t.session_id = s.id AND t.is_active = TRUE
+              AND t.phase = 'survey' AND t.paused_at IS NOT NULL)))`, surveyGraceSeconds)
+
+// SurveyWindow is the server-owned survey deadline for a session.
+type SurveyWindow struct {
+	IsStarted        bool `json:"is_started"`
+	IsClosed         bool `json:"is_closed"`
+	IsFinalized      bool `json:"is_finalized"`
+	TotalSeconds     int  `json:"total_seconds"`
+	RemainingSeconds int  `json:"remaining_seconds"`
+}
+
+// surveyPhaseSeconds is the length of the survey phase in the session's
+// agenda.
+func surveyPhaseSeconds(exec dbExecutor, sessionID string) (int, error) {
+	agenda, err := ResolveSessionAgenda(exec, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(agenda.Phases) - 1; i >= 0; i-- {
+		if agenda.Phases[i].Type == "survey" && agenda.Phases[i].DurationSeconds > 0 {
+			return agenda.Phases[i].DurationSeconds, nil
+		}
+	}
+	return defaultSurveySeconds, nil
+}
+
+// OpenSurveyWindow starts the session's survey clock and moves the session
+// into surveying. It does nothing if the window is already open, so every
+// client may call it.
+func OpenSurveyWindow(exec dbExecutor, sessionID string, seconds int) error {
+	var open bool
+	err := exec.QueryRow(`
+        SELECT survey_end_time IS NOT NULL FROM gd_sessions WHERE id = ? FOR UPDATE`,
+		sessionID).Scan(&open)
+	if err != nil || open {
+		return err
+	}
+
+	err = TransitionSession(exec, sessionID, Transition{
+		To: services.StatusSurveying, ActorRole: ActorSystem, Reason: "survey window opened"})
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`
+        UPDATE gd_sessions
+        SET survey_started_at = NOW(), survey_end_time = DATE_ADD(NOW(), INTERVAL ? SECOND)
+        WHERE id = ? AND survey_end_time IS NULL`, seconds, sessionID)
+	return err
+}
+
+// syncSurveyWindow moves the survey deadline to match a running survey
+// phase timer after it has been resumed or extended.
+func syncSurveyWindow(exec dbExecutor, sessionID string) error {
+	_, err := exec.Exec(`
+        UPDATE gd_sessions s
+        JOIN session_timers t ON t.session_id = s.id
+        SET s.survey_end_time = DATE_ADD(NOW(), INTERVAL GREATEST(
+            t.duration_seconds + t.extension_seconds - t.accumulated_seconds
+                - TIMESTAMPDIFF(SECOND, t.start_time, NOW()), 0) SECOND)
+        WHERE s.id = ? AND t.is_active = TRUE AND t.phase = 'survey'
+          AND t.paused_at IS NULL AND s.survey_finalized_at IS NULL`, sessionID)
+	return err
+}
+
+// GetSurveyWindow reports the session's survey deadline as measured by the
+// database clock.
+func GetSurveyWindow(exec dbExecutor, sessionID string) (SurveyWindow, error) {
+	var window SurveyWindow
+	var total, remaining sql.NullInt64
+	err := exec.QueryRow(`
+        SELECT s.survey_end_time IS NOT NULL, `+surveyClosedSQL+`, s.survey_finalized_at IS NOT NULL,
+               TIMESTAMPDIFF(SECOND, s.survey_started_at, s.survey_end_time),
+               GREATEST(TIMESTAMPDIFF(SECOND, NOW(), s.survey_end_time), 0)
+        FROM gd_sessions s WHERE s.id = ?`, sessionID).Scan(
+		&window.IsStarted, &window.IsClosed, &window.IsFinalized, &total, &remaining)
+	window.TotalSeconds = int(total.Int64)
+	window.RemainingSeconds = int(remaining.Int64)
+	return window, err
+}
+
+// recordMissingResponsePenalty charges a responder for a question they did
+// not answer. Repeated calls keep the larger penalty rather than adding up.
+func recordMissingResponsePenalty(ctx context.Context, exec dbExecutor, sessionID, studentID string, questionNumber int, points float64) error {
+	_, err := exec.Exec(`
+        INSERT INTO survey_penalties (id, session_id, student_id, question_id, penalty_points)
+        VALUES (UUID(), ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE penalty_points = GREATEST(penalty_points, VALUES(penalty_points))`,
+		sessionID, studentID, questionNumber, points)
+	if err != nil {
+		return err
+	}
+	metrics.Penalties.Inc("missing_response")
+	return auditScoring(ctx, exec, audit.Entry{
+		Action: "score.missing_response_penalty", EntityType: "session", EntityID: sessionID,
+		After: map[string]interface{}{
+			"student_id": studentID, "question_number": questionNumber, "penalty_points": points,
+		},
+	})
+}
+
+// FinalizeSurvey closes a session's survey once its deadline has passed.
+// Every participant who has not completed the survey has their draft
+// answers submitted as final and is penalised for each question
+// left unanswered. It returns how many responders were auto-submitted, and
+// zero if the survey is still open or was already finalised.
+func FinalizeSurvey(ctx context.Context, sessionID string) (int, error) {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var level int
+	var closed, finalized bool
+	err = tx.QueryRow(`
+        SELECT s.level, `+surveyClosedSQL+`, s.survey_finalized_at IS NOT NULL
+        FROM gd_sessions s WHERE s.id = ?
+        FOR UPDATE`, sessionID).Scan(&level, &closed, &finalized)
+	if err != nil {
+		return 0, err
+	}
+	if !closed || finalized {
+		return 0, nil
+	}
+
+	snapshot, err := SessionQuestions(tx, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`
+        SELECT DISTINCT sp.student_id FROM session_participants sp
+        LEFT JOIN survey_completion sc ON sc.session_id = sp.session_id AND sc.student_id = sp.student_id
+        WHERE sp.session_id = ? AND sp.is_dummy = FALSE AND sc.student_id IS NULL`, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	var pending []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, id)
+	}
+	rows.Close()
+
+	var participantCount int
+	err = tx.QueryRow(`
+        SELECT COUNT(DISTINCT student_id) FROM session_participants
+        WHERE session_id = ? AND is_dummy = FALSE`, sessionID).Scan(&participantCount)
+	if err != nil {
+		return 0, err
+	}
+	expectedRanks := services.ExpectedRanks(participantCount)
+	// An unanswered question costs what leaving every rank blank would
+	missingPoints := float64(expectedRanks)
+	questions := serviceQuestions(snapshot)
+
+	for _, studentID := range pending {
+		// A draft is newer than any rows already scored, since a final
+		// submit clears it
+		draft, found, err := loadSurveyDraft(tx, sessionID, studentID)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			for _, score := range services.ScoreSurvey(scoringService, level, questions, draft.Responses, expectedRanks) {
+				if err := writeQuestionScore(ctx, tx, sessionID, studentID, score); err != nil {
+					return 0, fmt.Errorf("error submitting survey draft: %v", err)
+				}
+			}
+			if err := deleteSurveyDraft(tx, sessionID, studentID); err != nil {
+				return 0, err
+			}
+		}
+
+		answered := make(map[string]bool)
+		answerRows, err := tx.Query(`
+            SELECT DISTINCT question_id FROM survey_results
+            WHERE session_id = ? AND responder_id = ?`, sessionID, studentID)
+		if err != nil {
+			return 0, err
+		}
+		for answerRows.Next() {
+			var questionID string
+			if err := answerRows.Scan(&questionID); err != nil {
+				answerRows.Close()
+				return 0, err
+			}
+			answered[questionID] = true
+		}
+		answerRows.Close()
+
+		for _, q := range snapshot {
+			if answered[q.ID] {
+				continue
+			}
+			if err := recordMissingResponsePenalty(ctx, tx, sessionID, studentID, q.Number, missingPoints); err != nil {
+				return 0, fmt.Errorf("error recording missing response penalty: %v", err)
+			}
+		}
+
+		if _, err := tx.Exec(`
+            UPDATE survey_results SET is_completed = 1
+            WHERE session_id = ? AND responder_id = ?`, sessionID, studentID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`
+            INSERT IGNORE INTO survey_completion (session_id, student_id, completed_at, auto_submitted)
+            VALUES (?, ?, NOW(), TRUE)`, sessionID, studentID); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+        UPDATE gd_sessions SET survey_finalized_at = NOW() WHERE id = ?`, sessionID); err != nil {
+		return 0, err
+	}
+	err = auditScoring(ctx, tx, audit.Entry{
+		Action: "survey.finalize", EntityType: "session", EntityID: sessionID,
+		After: map[string]interface{}{
+			"auto_submitted": pending, "missing_response_points": missingPoints,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = TransitionSession(tx, sessionID, Transition{
+		To: services.StatusScoring, ActorRole: ActorSystem, Reason: "survey deadline passed"})
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	metrics.SurveySubmissions.Add(float64(len(pending)), "auto")
+
+	return len(pending), publishResults(ctx, sessionID)
+}
+
+// publishResults scores a session whose survey has been finalised,
+// publishes its results and releases its participants' bookings.
+func publishResults(ctx context.Context, sessionID string) error {
+	recalculateSurveyScores(ctx, sessionID)
+	err := ChangeSessionStatus(sessionID, Transition{
+		To: services.StatusPublished, ActorRole: ActorSystem, Reason: "scores calculated"})
+	if err != nil {
+		return err
+	}
+	if err := clearCompletedBookings(sessionID); err != nil {
+		slog.Error("Error clearing bookings for session", "session_id", sessionID, "error", err)
+	}
+	return nil
+}
+
+// recalculateSurveyScores refreshes the per-question averages and the
+// deviation penalties for a session's completed responses.
+func recalculateSurveyScores(ctx context.Context, sessionID string) {
+	rows, err := database.GetDB().Query(`
+        SELECT DISTINCT question_id
+        FROM survey_results
+        WHERE session_id = ? AND is_completed = 1`,
+		sessionID)
+	if err != nil {
+		slog.Error("Error getting question IDs", "error", err)
+		return
+	}
+	var questionIDs []string
+	for rows.Next() {
+		var questionID string
+		if err := rows.Scan(&questionID); err != nil {
+			continue
+		}
+		questionIDs = append(questionIDs, questionID)
+	}
+	rows.Close()
+
+	// Averages first; penalties are measured against them
+	for _, questionID := range questionIDs {
+		if err := calculateQuestionAverages(sessionID, questionID); err != nil {
+			slog.Error("Error calculating averages for question", "question_id", questionID, "error", err)
+		}
+	}
+	if err := calculatePenalties(ctx, sessionID); err != nil {
+		slog.Error("Error calculating penalties", "error", err)
+	}
+}
+
+// FinalizeDueSurveys finalises every survey whose window has closed, and
+// publishes any session left in scoring by an interrupted finalisation.
+func FinalizeDueSurveys(ctx context.Context) error {
+	rows, err := database.GetDB().QueryContext(ctx, `
+        SELECT s.id FROM gd_sessions s
+        WHERE s.status = 'scoring' AND s.survey_finalized_at IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("error finding unpublished sessions: %v", err)
+	}
+	var unpublished []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			unpublished = append(unpublished, id)
+		}
+	}
+	rows.Close()
+	for _, id := range unpublished {
+		if err := publishResults(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "Error publishing results for session", "session_id", id, "error", err)
+		}
+	}
+
+	rows, err = database.GetDB().QueryContext(ctx, `
+        SELECT s.id FROM gd_sessions s
+        WHERE s.status = 'surveying' AND s.survey_end_time IS NOT NULL
+          AND s.survey_finalized_at IS NULL AND `+surveyClosedSQL)
+	if err != nil {
+		return fmt.Errorf("error finding expired surveys: %v", err)
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			sessionIDs = append(sessionIDs, id)
+		}
+	}
+	rows.Close()
+
+	failed := 0
+	for _, id := range sessionIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		submitted, err := FinalizeSurvey(ctx, id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error finalising survey for session", "session_id", id, "error", err)
+			failed++
+			continue
+		}
+		slog.InfoContext(ctx, "Finalised survey", "session_id", id, "auto_submitted", submitted)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to finalise %d of %d surveys", failed, len(sessionIDs))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"gd/database"
+	"gd/services"
+	"log/slog"
+	"net/http"
+)
+
+// loadSurveyDraft returns the responder's draft for a session. found is
+// false, with an empty draft, when none has been saved.
+func loadSurveyDraft(exec dbExecutor, sessionID, responderID string) (draft services.SurveyDraft, found bool, err error) {
+	draft = services.SurveyDraft{SessionID: sessionID, Responses: map[int]map[int]string{}}
+	var responsesJSON []byte
+	err = exec.QueryRow(`
+        SELECT responses, current_question, updated_at
+        FROM survey_drafts
+        WHERE session_id = ? AND responder_id = ?`, sessionID, responderID).Scan(
+		&responsesJSON, &draft.CurrentQuestion, &draft.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return draft, false, nil
+	}
+	if err != nil {
+		return draft, false, err
+	}
+	if len(responsesJSON) > 0 {
+		if err := json.Unmarshal(responsesJSON, &draft.Responses); err != nil {
+			slog.Error("Error parsing survey draft", "responder_id", responderID, "session_id", sessionID, "error", err)
+		}
+	}
+	return draft, true, nil
+}
+
+// saveSurveyDraft merges answers into the responder's draft. Questions not
+// in responses keep their saved rankings; a nil currentQuestion keeps the
+// saved position.
+func saveSurveyDraft(tx *sql.Tx, sessionID, responderID string, responses map[int]map[int]string, currentQuestion *int) (services.SurveyDraft, error) {
+	saved := map[int]map[int]string{}
+	var savedJSON []byte
+	var savedQuestion int
+	// Lock the draft so two devices saving at once don't drop each other's answers
+	err := tx.QueryRow(`
+        SELECT responses, current_question FROM survey_drafts
+        WHERE session_id = ? AND responder_id = ?
+        FOR UPDATE`, sessionID, responderID).Scan(&savedJSON, &savedQuestion)
+	if err != nil && err != sql.ErrNoRows {
+		return services.SurveyDraft{}, err
+	}
+	if len(savedJSON) > 0 {
+		json.Unmarshal(savedJSON, &saved)
+	}
+
+	if currentQuestion != nil {
+		savedQuestion = *currentQuestion
+	}
+	responsesJSON, err := json.Marshal(services.MergeSurveyResponses(saved, responses))
+	if err != nil {
+		return services.SurveyDraft{}, err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO survey_drafts (session_id, responder_id, responses, current_question)
+        VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE responses = VALUES(responses), current_question = VALUES(current_question)`,
+		sessionID, responderID, responsesJSON, savedQuestion)
+	if err != nil {
+		return services.SurveyDraft{}, err
+	}
+
+	draft, _, err := loadSurveyDraft(tx, sessionID, responderID)
+	return draft, err
+}
+
+func deleteSurveyDraft(exec dbExecutor, sessionID, responderID string) error {
+	_, err := exec.Exec(`
+        DELETE FROM survey_drafts
+        WHERE session_id = ? AND responder_id = ?`, sessionID, responderID)
+	return err
+}
+
+// GetSurveyDraft returns the calling student's saved answers for a session.
+func GetSurveyDraft(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "session_id is required"})
+		return
+	}
+	studentID := r.Context().Value("studentID").(string)
+
+	draft, found, err := loadSurveyDraft(database.GetDB(), sessionID, studentID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading survey draft", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	var completed bool
+	database.GetDB().QueryRow(`
+        SELECT EXISTS(SELECT 1 FROM survey_completion WHERE session_id = ? AND student_id = ?)`,
+		sessionID, studentID).Scan(&completed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draft":     draft,
+		"has_draft": found,
+		"completed": completed,
+	})
+}
+
+// SaveSurveyDraft stores the calling student's answers so far without
+// scoring them.
+func SaveSurveyDraft(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SessionID       string                 `json:"session_id"`
+		Responses       map[int]map[int]string `json:"responses"`
+		CurrentQuestion *int                   `json:"current_question"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+	studentID := r.Context().Value("studentID").(string)
+
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var isParticipant, surveyClosed bool
+	err = tx.QueryRow(`
+        SELECT EXISTS(
+                   SELECT 1 FROM session_participants
+                   WHERE session_id = s.id AND student_id = ? AND is_dummy = FALSE),
+               `+surveyClosedSQL+`
+        FROM gd_sessions s WHERE s.id = ?
+        FOR SHARE`, studentID, req.SessionID).Scan(&isParticipant, &surveyClosed)
+	if err == sql.ErrNoRows || (err == nil && !isParticipant) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not authorized for this session"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+	if surveyClosed {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": ErrSurveyClosed.Error()})
+		return
+	}
+
+	draft, err := saveSurveyDraft(tx, req.SessionID, studentID, req.Responses, req.CurrentQuestion)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving survey draft", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save draft"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save draft"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gd/database"
+	"log/slog"
+	"net/http"
+)
+
+var (
+	ErrTimerNotFound    = errors.New("no active timer found")
+	ErrTimerPaused      = errors.New("timer is already paused")
+	ErrTimerNotPaused   = errors.New("timer is not paused")
+	ErrInvalidExtension = errors.New("extension must be a positive number of seconds")
+)
+
+// TimerState is the current state of a session's phase timer.
+type TimerState struct {
+	Phase            string `json:"phase"`
+	PhaseIndex       int    `json:"phase_index"`
+	PhaseLabel       string `json:"phase_label"`
+	PhaseCount       int    `json:"phase_count"`
+	RemainingSeconds int    `json:"remaining_seconds"`
+	TotalSeconds     int    `json:"total_seconds"`
+	ElapsedSeconds   int    `json:"elapsed_seconds"`
+	ExtensionSeconds int    `json:"extension_seconds"`
+	IsActive         bool   `json:"is_active"`
+	IsPaused         bool   `json:"is_paused"`
+
+	// Set for phases run as timed turns, one speaker after another
+	PerSpeakerSeconds       int `json:"per_speaker_seconds,omitempty"`
+	SpeakerTurn             int `json:"speaker_turn,omitempty"`
+	SpeakerRemainingSeconds int `json:"speaker_remaining_seconds,omitempty"`
+}
+
+// timerElapsedSQL is the elapsed time of the current phase: the time banked
+// by earlier running segments plus the segment in progress, if any.
+const timerElapsedSQL = `accumulated_seconds +
+    CASE WHEN paused_at IS NULL THEN TIMESTAMPDIFF(SECOND, start_time, NOW()) ELSE 0 END`
+
+// GetTimerState reads the active timer for a session. Elapsed time is
+// measured by the database so it agrees with the NOW() used to start phases,
+// and stops counting while the timer is paused.
+func GetTimerState(sessionID string) (TimerState, error) {
+	var timer TimerState
+	err := database.GetDB().QueryRow(`
+        SELECT phase, phase_index, duration_seconds + extension_seconds, extension_seconds,
+               is_active, paused_at IS NOT NULL, `+timerElapsedSQL+`
+        FROM session_timers
+        WHERE session_id = ? AND is_active = TRUE`,
+		sessionID).Scan(&timer.Phase, &timer.PhaseIndex, &timer.TotalSeconds, &timer.ExtensionSeconds,
+		&timer.IsActive, &timer.IsPaused, &timer.ElapsedSeconds)
+	if err != nil {
+		return timer, err
+	}
+
+	if timer.TotalSeconds > timer.ElapsedSeconds {
+		timer.RemainingSeconds = timer.TotalSeconds - timer.ElapsedSeconds
+	}
+
+	agenda, err := ResolveSessionAgenda(database.GetDB(), sessionID)
+	if err != nil {
+		slog.Error("Error resolving agenda for session", "session_id", sessionID, "error", err)
+		return timer, nil
+	}
+	timer.PhaseCount = len(agenda.Phases)
+	if timer.PhaseIndex < len(agenda.Phases) {
+		phase := agenda.Phases[timer.PhaseIndex]
+		timer.PhaseLabel = phase.Label
+		if phase.PerSpeakerSeconds > 0 {
+			timer.PerSpeakerSeconds = phase.PerSpeakerSeconds
+			timer.SpeakerTurn = timer.ElapsedSeconds/phase.PerSpeakerSeconds + 1
+			timer.SpeakerRemainingSeconds = phase.PerSpeakerSeconds - timer.ElapsedSeconds%phase.PerSpeakerSeconds
+		}
+	}
+	return timer, nil
+}
+
+// PauseTimer stops the clock for a session, banking the time run so far.
+func PauseTimer(sessionID, actorID, actorRole, reason string) error {
+	return changeTimer(sessionID, actorID, actorRole, "pause", reason, 0, func(tx *sql.Tx, paused bool) error {
+		if paused {
+			return ErrTimerPaused
+		}
+		_, err := tx.Exec(`
+            UPDATE session_timers
+            SET accumulated_seconds = accumulated_seconds + TIMESTAMPDIFF(SECOND, start_time, NOW()),
+                paused_at = NOW()
+            WHERE session_id = ?`, sessionID)
+		return err
+	})
+}
+
+// ResumeTimer restarts a paused clock from where it stopped.
+func ResumeTimer(sessionID, actorID, actorRole, reason string) error {
+	return changeTimer(sessionID, actorID, actorRole, "resume", reason, 0, func(tx *sql.Tx, paused bool) error {
+		if !paused {
+			return ErrTimerNotPaused
+		}
+		_, err := tx.Exec(`
+            UPDATE session_timers
+            SET start_time = NOW(), paused_at = NULL
+            WHERE session_id = ?`, sessionID)
+		return err
+	})
+}
+
+// ExtendTimer adds seconds to the current phase. It works whether or not
+// the timer is paused.
+func ExtendTimer(sessionID, actorID, actorRole, reason string, seconds int) error {
+	if seconds <= 0 {
+		return ErrInvalidExtension
+	}
+	return changeTimer(sessionID, actorID, actorRole, "extend", reason, seconds, func(tx *sql.Tx, paused bool) error {
+		_, err := tx.Exec(`
+            UPDATE session_timers
+            SET extension_seconds = extension_seconds + ?
+            WHERE session_id = ?`, seconds, sessionID)
+		return err
+	})
+}
+
+// changeTimer locks the session's active timer, applies change and records
+// the event in session_timer_events.
+func changeTimer(sessionID, actorID, actorRole, action, reason string, seconds int, change func(tx *sql.Tx, paused bool) error) error {
+	tx, err := database.GetDB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var phase string
+	var paused bool
+	err = tx.QueryRow(`
+        SELECT phase, paused_at IS NOT NULL
+        FROM session_timers
+        WHERE session_id = ? AND is_active = TRUE
+        FOR UPDATE`, sessionID).Scan(&phase, &paused)
+	if err == sql.ErrNoRows {
+		return ErrTimerNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := change(tx, paused); err != nil {
+		return err
+	}
+
+	// Keep the survey deadline in step with a resumed or extended survey
+	// phase; surveyClosedSQL keeps it open while paused
+	if phase == "survey" {
+		if err := syncSurveyWindow(tx, sessionID); err != nil {
+			return fmt.Errorf("error updating survey window: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO session_timer_events
+        (id, session_id, phase, action, seconds, reason, actor_id, actor_role)
+        VALUES (UUID(), ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, phase, action, seconds, reason, actorID, actorRole)
+	if err != nil {
+		return fmt.Errorf("error logging timer event: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// isSessionModerator reports whether studentID moderates sessionID.
+func isSessionModerator(sessionID, studentID string) bool {
+	var moderatorID sql.NullString
+	err := database.GetDB().QueryRow(`
+        SELECT moderator_id FROM gd_sessions WHERE id = ?`, sessionID).Scan(&moderatorID)
+	if err != nil {
+		return false
+	}
+	return moderatorID.Valid && moderatorID.String == studentID
+}
+
+// timerControlRequest is shared by the moderator pause, resume and extend
+// endpoints.
+type timerControlRequest struct {
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+	Seconds   int    `json:"seconds"`
+}
+
+func PauseSessionTimer(w http.ResponseWriter, r *http.Request) {
+	handleTimerControl(w, r, func(req timerControlRequest, studentID string) error {
+		return PauseTimer(req.SessionID, studentID, "moderator", req.Reason)
+	})
+}
+
+func ResumeSessionTimer(w http.ResponseWriter, r *http.Request) {
+	handleTimerControl(w, r, func(req timerControlRequest, studentID string) error {
+		return ResumeTimer(req.SessionID, studentID, "moderator", req.Reason)
+	})
+}
+
+func ExtendSessionTimer(w http.ResponseWriter, r *http.Request) {
+	handleTimerControl(w, r, func(req timerControlRequest, studentID string) error {
+		return ExtendTimer(req.SessionID, studentID, "moderator", req.Reason, req.Seconds)
+	})
+}
+
+func handleTimerControl(w http.ResponseWriter, r *http.Request, apply func(req timerControlRequest, studentID string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req timerControlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request format"})
+		return
+	}
+
+	studentID := r.Context().Value("studentID").(string)
+	if !isSessionModerator(req.SessionID, studentID) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Only the session moderator can control the timer"})
+		return
+	}
+
+	if err := apply(req, studentID); err != nil {
+		WriteTimerError(w, err)
+		return
+	}
+
+	timer, err := GetTimerState(req.SessionID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading timer after update", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timer)
+}
+
+// WriteTimerError maps timer control errors to HTTP responses.
+func WriteTimerError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrTimerNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrTimerPaused, ErrTimerNotPaused:
+		w.WriteHeader(http.StatusConflict)
+	case ErrInvalidExtension:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		slog.Error("Error updating session timer", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update timer"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -3,7 +3,7 @@ package controllers
 import (
 	"database/sql"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -28,17 +28,19 @@ func GetTopicForLevel(w http.ResponseWriter, r *http.Request) {
 	var topicText string
 	var prepMaterialsJSON []byte
 	
-	err = database.GetDB().QueryRow(`
-		SELECT topic_text, prep_materials 
-		FROM gd_topics 
-		WHERE level = ? 
-		ORDER BY RAND() 
-		LIMIT 1`,
-		level,
-	).Scan(&topicText, &prepMaterialsJSON)
+	studentID, _ := r.Context().Value("studentID").(string)
+	topicID, err := pickTopic(database.GetDB(), level, []string{studentID}, "")
+	if err == nil {
+		err = database.GetDB().QueryRow(`
+			SELECT topic_text, prep_materials 
+			FROM gd_topics 
+			WHERE id = ?`,
+			topicID,
+		).Scan(&topicText, &prepMaterialsJSON)
+	}
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == ErrNoTopics || err == sql.ErrNoRows {
 			// Return a default topic if none found
 			defaultTopic := map[string]interface{}{
 				"topic_text": "Discuss the impact of technology on modern education",
@@ -53,7 +55,7 @@ func GetTopicForLevel(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(defaultTopic)
 			return
 		}
-		log.Printf("Error fetching topic: %v", err)
+		slog.ErrorContext(r.Context(), "Error fetching topic", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fetch topic"})
 		return
@@ -87,25 +89,6 @@ func GetTopicForLevel(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func assignTopicToSession(sessionID string, level int) error {
-    var topicID string
-    err := database.GetDB().QueryRow(`
-        SELECT id FROM gd_topics 
-        WHERE level = ? AND is_active = TRUE 
-        ORDER BY RAND() LIMIT 1
-    `, level).Scan(&topicID)
-    
-    if err != nil {
-        return err
-    }
-    
-    _, err = database.GetDB().Exec(`
-        UPDATE gd_sessions SET topic_id = ? WHERE id = ?
-    `, topicID, sessionID)
-    
-    return err
-}
-
 func GetSessionTopic(w http.ResponseWriter, r *http.Request) {
     sessionID := r.URL.Query().Get("session_id")
     if sessionID == "" {
@@ -129,7 +112,7 @@ func GetSessionTopic(w http.ResponseWriter, r *http.Request) {
 
     if err != nil {
         if err == sql.ErrNoRows {
-            // Fallback to random topic by level
+            // No topic yet, so pick one nobody in the session has discussed
             var sessionLevel int
             err := database.GetDB().QueryRow(`
                 SELECT level FROM gd_sessions WHERE id = ?
@@ -141,15 +124,16 @@ func GetSessionTopic(w http.ResponseWriter, r *http.Request) {
                 return
             }
             
-            // Get random topic for the session level
-            err = database.GetDB().QueryRow(`
-                SELECT topic_text, prep_materials, level 
-                FROM gd_topics 
-                WHERE level = ? AND is_active = TRUE 
-                ORDER BY RAND() LIMIT 1
-            `, sessionLevel).Scan(&topicText, &prepMaterialsJSON, &level)
+            topicID, err := AssignSessionTopic(database.GetDB(), sessionID)
+            if err == nil {
+                err = database.GetDB().QueryRow(`
+                    SELECT topic_text, prep_materials, level 
+                    FROM gd_topics WHERE id = ?
+                `, topicID).Scan(&topicText, &prepMaterialsJSON, &level)
+            }
             
             if err != nil {
+                slog.ErrorContext(r.Context(), "Error assigning topic to session", "session_id", sessionID, "error", err)
                 // Ultimate fallback
                 topicText = "Discuss the impact of technology on modern education"
                 prepMaterialsJSON = []byte("{}")
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ErrNoTopics is returned when a level has no active topic to pick from.
+var ErrNoTopics = fmt.Errorf("no active topics for this level")
+
+// topicCategoryFilter restricts a topic query to the categories configured
+// for the level, if any are configured.
+const topicCategoryFilter = `
+          AND (NOT EXISTS (SELECT 1 FROM level_topic_categories WHERE level = t.level)
+               OR t.category IN (SELECT category FROM level_topic_categories WHERE level = t.level))`
+
+// pickTopic returns the least-used active topic for a level that none of
+// the given students has discussed before. When every topic has been seen
+// it falls back to the least-used topic overall.
+func pickTopic(exec dbExecutor, level int, studentIDs []string, excludeSessionID string) (string, error) {
+	args := []interface{}{level}
+	seenFilter := ""
+	if len(studentIDs) > 0 {
+		seenFilter = `
+          AND t.id NOT IN (
+              SELECT s.topic_id FROM gd_sessions s
+              JOIN session_participants sp ON sp.session_id = s.id
+              WHERE s.topic_id IS NOT NULL AND s.id <> ?
+                AND sp.is_dummy = FALSE
+                AND sp.student_id IN (?` + strings.Repeat(",?", len(studentIDs)-1) + `))`
+		args = append(args, excludeSessionID)
+		for _, id := range studentIDs {
+			args = append(args, id)
+		}
+	}
+
+	query := `
+        SELECT t.id FROM gd_topics t
+        LEFT JOIN gd_sessions used ON used.topic_id = t.id
+        WHERE t.level = ? AND t.is_active = TRUE` + topicCategoryFilter + `%s
+        GROUP BY t.id
+        ORDER BY COUNT(used.id), RAND()
+        LIMIT 1`
+
+	var topicID string
+	err := exec.QueryRow(fmt.Sprintf(query, seenFilter), args...).Scan(&topicID)
+	if err == sql.ErrNoRows && seenFilter != "" {
+		err = exec.QueryRow(fmt.Sprintf(query, ""), level).Scan(&topicID)
+	}
+	if err == sql.ErrNoRows {
+		return "", ErrNoTopics
+	}
+	return topicID, err
+}
+
+// AssignSessionTopic gives a session its topic if it has none yet and
+// returns the topic ID. A topic already on the session, pinned by an admin
+// or chosen earlier, is kept.
+func AssignSessionTopic(exec dbExecutor, sessionID string) (string, error) {
+	var current sql.NullString
+	var level int
+	err := exec.QueryRow(`
+        SELECT topic_id, level FROM gd_sessions WHERE id = ?`, sessionID).Scan(&current, &level)
+	if err != nil {
+		return "", err
+	}
+	if current.Valid {
+		return current.String, nil
+	}
+
+	rows, err := exec.Query(`
+        SELECT student_id FROM session_participants
+        WHERE session_id = ? AND is_dummy = FALSE`, sessionID)
+	if err != nil {
+		return "", err
+	}
+	var studentIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", err
+		}
+		studentIDs = append(studentIDs, id)
+	}
+	rows.Close()
+
+	topicID, err := pickTopic(exec, level, studentIDs, sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	// Another participant may have assigned a topic in the meantime; keep theirs
+	if _, err := exec.Exec(`
+        UPDATE gd_sessions SET topic_id = ?
+        WHERE id = ? AND topic_id IS NULL`, topicID, sessionID); err != nil {
+		return "", err
+	}
+	err = exec.QueryRow("SELECT topic_id FROM gd_sessions WHERE id = ?", sessionID).Scan(&topicID)
+	return topicID, err
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gd/database"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	// IdempotencyKeyHeader names the header clients use to mark retries of
+	// the same request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyTTLHours is how long a stored response is replayed.
+	idempotencyTTLHours = 24
+
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodyBytes  = 1 << 20
+)
+
+// Idempotent replays the stored response for a retried request that carries
+// the same Idempotency-Key as an earlier one from the same student, instead
+// of running the handler again. Reusing a key for a different request is
+// rejected. Requests without the header run as usual. It must sit inside
+// StudentOnly so the student is known.
+func Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if key == "" || r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodyBytes+1))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+			return
+		}
+		if len(body) > maxIdempotentBodyBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Request body is too large"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		studentID, _ := r.Context().Value("studentID").(string)
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n" + string(body)))
+		requestHash := hex.EncodeToString(sum[:])
+
+		claimed, err := claimIdempotencyKey(studentID, key, requestHash)
+		if err != nil {
+			slog.Error("Error claiming idempotency key", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+			return
+		}
+		if !claimed {
+			replayIdempotentResponse(w, studentID, key, requestHash)
+			return
+		}
+
+		// A panicking handler must not leave the key in flight, or every
+		// retry would get a 409 until it expires
+		defer func() {
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(studentID, key)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Server errors aren't stored, so the client can retry them
+		if rec.status >= http.StatusInternalServerError {
+			releaseIdempotencyKey(studentID, key)
+			return
+		}
+		_, err = database.GetDB().Exec(`
+            UPDATE idempotency_keys
+            SET status_code = ?, content_type = ?, response_body = ?
+            WHERE student_id = ? AND idem_key = ?`,
+			rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), studentID, key)
+		if err != nil {
+			slog.Error("Error storing idempotent response", "error", err)
+		}
+	})
+}
+
+// claimIdempotencyKey records the key as in flight. It returns false if the
+// key is already held by an unexpired request.
+func claimIdempotencyKey(studentID, key, requestHash string) (bool, error) {
+	db := database.GetDB()
+	if _, err := db.Exec(`
+        DELETE FROM idempotency_keys
+        WHERE student_id = ? AND idem_key = ? AND expires_at <= NOW()`, studentID, key); err != nil {
+		return false, err
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO idempotency_keys (student_id, idem_key, request_hash, expires_at)
+        VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? HOUR))`,
+		studentID, key, requestHash, idempotencyTTLHours)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseIdempotencyKey forgets a key so that the request can be retried.
+func releaseIdempotencyKey(studentID, key string) {
+	if _, err := database.GetDB().Exec(`
+        DELETE FROM idempotency_keys WHERE student_id = ? AND idem_key = ?`, studentID, key); err != nil {
+		slog.Error("Error releasing idempotency key", "error", err)
+	}
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, studentID, key, requestHash string) {
+	var storedHash, contentType string
+	var status *int
+	var body []byte
+	err := database.GetDB().QueryRow(`
+        SELECT request_hash, status_code, COALESCE(content_type, ''), response_body
+        FROM idempotency_keys
+        WHERE student_id = ? AND idem_key = ?`, studentID, key).Scan(&storedHash, &status, &contentType, &body)
+	if err != nil {
+		slog.Error("Error loading idempotent response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		return
+	}
+
+	if storedHash != requestHash {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if status == nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "A request with this Idempotency-Key is still being processed"})
+		return
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*status)
+	w.Write(body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
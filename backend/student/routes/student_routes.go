@@ -3,6 +3,8 @@ package routes
 import (
 	"fmt"
 	s "gd/admin/controllers"
+	"gd/config"
+	"gd/ratelimit"
 	"gd/student/controllers"
 	"gd/student/middleware"
 	"net/http"
@@ -18,30 +20,45 @@ func StudentLogin(w http.ResponseWriter, r *http.Request) {
 
 func SetupStudentRoutes() *http.ServeMux {
 	router := http.NewServeMux()
+	cfg := config.Get()
+	loginLimit := ratelimit.Policy{
+		Name:       "student_login",
+		PerIP:      cfg.LoginRateIP,
+		PerAccount: cfg.LoginRateAccount,
+		Account:    ratelimit.JSONField("email"),
+	}
+	// Guessed QR payloads are throttled per device and per student
+	joinLimit := ratelimit.Policy{
+		Name:       "session_join",
+		PerIP:      cfg.JoinRateIP,
+		PerAccount: cfg.JoinRateAccount,
+		Account:    ratelimit.ContextValue("studentID"),
+	}
 
 	// Serve static files (uploads)
 	uploadsDir := filepath.Join(getProjectRoot(), "uploads")
 	router.Handle(baseurl+"/uploads/", http.StripPrefix("/uploads/", 
 		http.FileServer(http.Dir(uploadsDir))))
 	// Auth
-	router.Handle(baseurl+"/login", http.HandlerFunc(controllers.StudentLogin))
+	router.Handle(baseurl+"/login", ratelimit.Throttle(loginLimit,
+		http.HandlerFunc(controllers.StudentLogin)))
 	// Profile
 	router.Handle(baseurl+"/profile", middleware.StudentOnly(
 		http.HandlerFunc(controllers.GetStudentProfile)))
 	// Session Management
 	router.Handle(baseurl+"/sessions", middleware.StudentOnly(
 		http.HandlerFunc(controllers.GetAvailableSessions)))
-	router.Handle(baseurl+"/sessions/book", middleware.StudentOnly(
-		http.HandlerFunc(controllers.BookVenue))) // Add this line
-	router.Handle(baseurl+"/sessions/join", middleware.StudentOnly(
-		http.HandlerFunc(controllers.JoinSession)))
+	router.Handle(baseurl+"/sessions/book", middleware.StudentOnly(middleware.Idempotent(
+		http.HandlerFunc(controllers.BookVenue))))
+	router.Handle(baseurl+"/sessions/join", middleware.StudentOnly(ratelimit.Throttle(joinLimit,
+		middleware.Idempotent(http.HandlerFunc(controllers.JoinSession)))))
 	router.Handle(baseurl+"/session", middleware.StudentOnly(
 		http.HandlerFunc(controllers.GetSessionDetails)))
 	router.Handle(baseurl+"/topic",
 		middleware.StudentOnly(http.HandlerFunc(controllers.GetTopicForLevel)))
 	// Survey System
-	router.Handle(baseurl+"/survey", middleware.StudentOnly(
-		http.HandlerFunc(controllers.SubmitSurvey)))
+	router.Handle(baseurl+"/survey", middleware.StudentOnly(middleware.Idempotent(
+		http.HandlerFunc(controllers.SubmitSurvey))))
 router.Handle(baseurl+"/session/topic", middleware.StudentOnly(
     http.HandlerFunc(controllers.GetSessionTopic)))
 	// Results
@@ -69,6 +86,17 @@ router.Handle(baseurl+"/session/topic", middleware.StudentOnly(
 		http.HandlerFunc(controllers.CheckSurveyCompletion)))
 	router.Handle(baseurl+"/survey/mark-completed", middleware.StudentOnly(
 		http.HandlerFunc(controllers.MarkSurveyCompleted)))
+	router.Handle(baseurl+"/survey/draft", middleware.StudentOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetSurveyDraft(w, r)
+			case http.MethodPut, http.MethodPost:
+				controllers.SaveSurveyDraft(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
 	router.Handle(baseurl+"/feedback", middleware.StudentOnly(
 		http.HandlerFunc(controllers.SubmitFeedback)))
 	router.Handle(baseurl+"/session/rules", middleware.StudentOnly(
@@ -98,6 +126,26 @@ router.Handle(baseurl+"/session/timer/start", middleware.StudentOnly(
     http.HandlerFunc(controllers.StartSessionTimer)))
 router.Handle(baseurl+"/session/timer", middleware.StudentOnly(
     http.HandlerFunc(controllers.GetSessionTimer)))
+router.Handle(baseurl+"/session/timer/pause", middleware.StudentOnly(
+    http.HandlerFunc(controllers.PauseSessionTimer)))
+router.Handle(baseurl+"/session/timer/resume", middleware.StudentOnly(
+    http.HandlerFunc(controllers.ResumeSessionTimer)))
+router.Handle(baseurl+"/session/timer/extend", middleware.StudentOnly(
+    http.HandlerFunc(controllers.ExtendSessionTimer)))
+router.Handle(baseurl+"/session/speaking", middleware.StudentOnly(
+    http.HandlerFunc(controllers.GetSpeakingQueue)))
+router.Handle(baseurl+"/session/speaking/raise", middleware.StudentOnly(
+    http.HandlerFunc(controllers.RaiseHand)))
+router.Handle(baseurl+"/session/speaking/withdraw", middleware.StudentOnly(
+    http.HandlerFunc(controllers.WithdrawHand)))
+router.Handle(baseurl+"/session/speaking/grant", middleware.StudentOnly(
+    http.HandlerFunc(controllers.GrantFloor)))
+router.Handle(baseurl+"/session/speaking/stop", middleware.StudentOnly(
+    http.HandlerFunc(controllers.StopSpeaking)))
+router.Handle(baseurl+"/session/speaking/settings", middleware.StudentOnly(
+    http.HandlerFunc(controllers.UpdateSpeakingSettings)))
+router.Handle(baseurl+"/session/group", middleware.StudentOnly(
+    http.HandlerFunc(controllers.GetMyGroup)))
 router.Handle(baseurl+"/session/phase/complete", middleware.StudentOnly(
     http.HandlerFunc(controllers.CompleteSessionPhase)))
 router.Handle(baseurl+"/session/configuration", middleware.StudentOnly(
@@ -111,6 +159,29 @@ router.Handle(baseurl+"/session/configuration", middleware.StudentOnly(
     http.HandlerFunc(controllers.GetVenuesForStudent)))
 	router.Handle(baseurl+"/session/check-all-ready", middleware.StudentOnly(
 		http.HandlerFunc(controllers.CheckAllReady)))
+	router.Handle(baseurl+"/appeals", middleware.StudentOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				controllers.GetMyAppeals(w, r)
+			case http.MethodPost:
+				controllers.FileAppeal(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		})))
+	router.Handle(baseurl+"/notifications", middleware.StudentOnly(
+		http.HandlerFunc(controllers.GetNotifications)))
+	router.Handle(baseurl+"/notifications/read", middleware.StudentOnly(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			controllers.MarkNotificationsRead(w, r)
+		})))
+	router.Handle(baseurl+"/priority-passes", middleware.StudentOnly(
+		http.HandlerFunc(controllers.GetPriorityPasses)))
 	
 	return router
 }
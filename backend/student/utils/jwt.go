@@ -1,15 +1,18 @@
 package jwt
 
 import (
-	// "log"
-	"log"
-	"os"
+	"gd/config"
+	"log/slog"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var secret = []byte(os.Getenv("JWT_SECRET_STUDENT"))
+// secret is looked up on every call, as config.Load runs after this
+// package is initialised.
+func secret() []byte {
+	return []byte(config.Get().StudentJWTSecret)
+}
 
 type StudentClaims struct {
 	UserID string `json:"user_id"`
@@ -33,12 +36,10 @@ func GenerateStudentToken(id string, level int) (string, error) {
     }
     
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    tokenString, err := token.SignedString(secret)
+    tokenString, err := token.SignedString(secret())
     if err != nil {
         return "", err
     }
-    
-    log.Printf("Generated token for user %s (level %d)", id, level)
     return tokenString, nil
 }
 
@@ -47,41 +48,22 @@ func VerifyStudentToken(tokenString string) (*StudentClaims, error) {
         return nil, jwt.ErrInvalidKey
     }
 
-    // Add debug log
-    log.Printf("Verifying token: %s", tokenString)
-
     token, err := jwt.ParseWithClaims(tokenString, &StudentClaims{}, func(t *jwt.Token) (interface{}, error) {
         // Verify the signing method
         if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-            log.Printf("Unexpected signing method: %v", t.Header["alg"])
+            slog.Warn("Unexpected token signing method", "alg", t.Header["alg"])
             return nil, jwt.ErrSignatureInvalid
         }
-        return secret, nil
+        return secret(), nil
     })
     
     if err != nil {
-        log.Printf("Token verification error: %v", err)
         return nil, err
     }
     
     if claims, ok := token.Claims.(*StudentClaims); ok && token.Valid {
-        log.Printf("Token valid for user: %s, role: %s", claims.UserID, claims.Role)
         return claims, nil
     }
     
-    log.Println("Invalid token claims")
     return nil, jwt.ErrInvalidKey
 }
-
-
-func init() {
-    secretStr := os.Getenv("JWT_SECRET_STUDENT")
-    if secretStr == "" {
-        secretStr = "password123" // Fallback for development
-        log.Println("WARNING: Using default JWT secret - configure JWT_SECRET_STUDENT for production")
-    }
-    secret = []byte(secretStr)
-    log.Printf("JWT secret initialized (length: %d)", len(secret))
-}
-
-
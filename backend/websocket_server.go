@@ -2,7 +2,7 @@ package main
 
 import (
 	// "encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
@@ -45,7 +45,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.ErrorContext(r.Context(), "WebSocket upgrade error", "error", err)
 		return
 	}
 	defer ws.Close()
@@ -104,7 +104,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		var msg Message
 		err := ws.ReadJSON(&msg)
 		if err != nil {
-			log.Printf("Read error: %v", err)
+			slog.ErrorContext(r.Context(), "Read error", "error", err)
 			break
 		}
 
@@ -190,7 +190,7 @@ func broadcastToSession(sessionID string, msg Message) {
 	for client := range session.Clients {
 		err := client.WriteJSON(msg)
 		if err != nil {
-			log.Printf("Broadcast error: %v", err)
+			slog.Error("Broadcast error", "error", err)
 			client.Close()
 			delete(session.Clients, client)
 		}
@@ -295,7 +295,7 @@ export default function SessionConfig() {
                 </>
               )}
               <Text style={styles.sessionStatus}>
-                Status: {session.status || 'pending'}
+                Status: {session.status || 'scheduled'}
               </Text>
             </View>
           ))